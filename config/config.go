@@ -40,11 +40,41 @@ var LocalFileDir string
 // Maple Juice file directories
 var JobManagerFileDir string // for storing partitioned maple input files
 var NodeManagerFileDir string
+var CacheFileDir string	// side files shipped to every task of a job
 
 var TemplateFileDir string		// maple juice executable templates used by SQL layer
+var LocalRunnerFileDir string	// stands in for SDFS when running Maple Juice jobs in local mode
+var HookFileDir string			// completion hook commands installed by the node operator, run for jobs submitted by this node
 
-var MapleTaskNum int = 1		// number of worker for sql query execution
-var JuiceTaskNum int = 1		// number of worker for sql query execution
+var MapleTaskNum int = 1		// number of worker for sql query execution, 0 lets the job manager pick (auto)
+var JuiceTaskNum int = 1		// number of worker for sql query execution, 0 lets the job manager pick (auto)
+var BroadcastJoinThresholdMB int = 16	// join inputs up to this size are shipped to every maple task instead of shuffled, 0 disables
+var SqlMalformedRows string = "fail"	// skip, fail or error: query input rows that are not valid CSV or do not match the header are dropped, fail the query or go to an error file
+
+// auto task count tuning
+var MapleSplitSizeMB int = 64		// target size of maple input splits
+var JuiceTaskInputSizeMB int = 64	// target amount of intermediate data read by a juice task
+var WorkerTaskSlots int = 2			// number of tasks a worker runs concurrently before it is considered busy
+
+// Maple Juice job defaults, can be overridden per job
+var MapleTaskTimeoutMinutes int = 5		// per maple task attempt
+var JuiceTaskTimeoutMinutes int = 5		// per juice task attempt
+var ExecutionTimeoutMinutes int = 10	// wall clock limit for executables run by worker nodes
+var JobTimeoutMinutes int = 10			// how long a job submission waits for completion
+var TaskMaxRetryNum int = 5				// maximum number of retry for each maple/juice task
+var RetryBackoffBaseMillis int = 1000	// delay before the first retry, doubled for every further retry
+var RetryBackoffMaxMillis int = 30000
+
+// sandbox for user executables, limits can be overridden per job and 0 means unlimited
+var SandboxFileDir string		// per task working directories
+var WorkspaceFileDir string		// per job workspaces of executables packaged as Go module archives
+var ExecutableArchiveLimitMB int = 512	// most bytes unpacked from an executable archive
+var SandboxUser string			// run executables as this unprivileged user if set, requires running as root
+var SandboxCgroupDir string		// delegated cgroup v2 directory to create per task cgroups in, if set
+var TaskMemoryLimitMB int = 0
+var TaskCpuLimitSeconds int = 0
+var TaskFileSizeLimitMB int = 0
+var TaskProcessLimit int = 0
 
 
 func InitConfig() {
@@ -147,26 +177,59 @@ func InitConfig() {
 			FileReceivePort = port
 
 		case "MAPLE_TASK_NUM":
-			num, err := strconv.Atoi(kv[1])
-			if err != nil {
-				log.Fatal("Error loading maple task num")
+			MapleTaskNum = loadTaskNum(kv[1], "maple task num")
+		case "JUICE_TASK_NUM":
+			JuiceTaskNum = loadTaskNum(kv[1], "juice task num")
+		case "BROADCAST_JOIN_THRESHOLD_MB":
+			BroadcastJoinThresholdMB = loadNonNegativeInt(kv[1], "broadcast join threshold")
+		case "SQL_MALFORMED_ROWS":
+			if kv[1] != "skip" && kv[1] != "fail" && kv[1] != "error" {
+				log.Fatalf("Invalid malformed row policy %s", kv[1])
 			}
-			MapleTaskNum = num
+			SqlMalformedRows = kv[1]
+		case "MAPLE_SPLIT_SIZE_MB":
+			MapleSplitSizeMB = loadPositiveInt(kv[1], "maple split size")
+		case "JUICE_TASK_INPUT_SIZE_MB":
+			JuiceTaskInputSizeMB = loadPositiveInt(kv[1], "juice task input size")
+		case "WORKER_TASK_SLOTS":
+			WorkerTaskSlots = loadPositiveInt(kv[1], "worker task slots")
 
-		case "JUICE_TASK_NUM":
+		case "MAPLE_TASK_TIMEOUT_MINUTES":
+			MapleTaskTimeoutMinutes = loadPositiveInt(kv[1], "maple task timeout")
+		case "JUICE_TASK_TIMEOUT_MINUTES":
+			JuiceTaskTimeoutMinutes = loadPositiveInt(kv[1], "juice task timeout")
+		case "EXECUTION_TIMEOUT_MINUTES":
+			ExecutionTimeoutMinutes = loadPositiveInt(kv[1], "executable execution timeout")
+		case "JOB_TIMEOUT_MINUTES":
+			JobTimeoutMinutes = loadPositiveInt(kv[1], "job timeout")
+		case "TASK_MAX_RETRY_NUM":
 			num, err := strconv.Atoi(kv[1])
-			if err != nil {
-				log.Fatal("Error loading juice task num")
+			if err != nil || num < 0 {
+				log.Fatal("Error loading task max retry num")
 			}
-			JuiceTaskNum = num
+			TaskMaxRetryNum = num
+		case "RETRY_BACKOFF_BASE_MILLIS":
+			RetryBackoffBaseMillis = loadPositiveInt(kv[1], "retry backoff base")
+		case "RETRY_BACKOFF_MAX_MILLIS":
+			RetryBackoffMaxMillis = loadPositiveInt(kv[1], "retry backoff max")
+
+		case "EXECUTABLE_ARCHIVE_LIMIT_MB":
+			ExecutableArchiveLimitMB = loadPositiveInt(kv[1], "executable archive limit")
+		case "SANDBOX_USER":
+			SandboxUser = strings.TrimSpace(kv[1])
+		case "SANDBOX_CGROUP_DIR":
+			SandboxCgroupDir = strings.TrimSpace(kv[1])
+		case "TASK_MEMORY_LIMIT_MB":
+			TaskMemoryLimitMB = loadNonNegativeInt(kv[1], "task memory limit")
+		case "TASK_CPU_LIMIT_SECONDS":
+			TaskCpuLimitSeconds = loadNonNegativeInt(kv[1], "task cpu limit")
+		case "TASK_FILE_SIZE_LIMIT_MB":
+			TaskFileSizeLimitMB = loadNonNegativeInt(kv[1], "task file size limit")
+		case "TASK_PROCESS_LIMIT":
+			TaskProcessLimit = loadNonNegativeInt(kv[1], "task process limit")
 		}
 	}
-	Homedir = homeDir
-	SdfsFileDir = Homedir + "/sdfs/"
-	LocalFileDir = homeDir + "/local/"
-	JobManagerFileDir = homeDir + "/mr_job_manager/"
-	NodeManagerFileDir = homeDir + "/mr_node_manager/"
-	TemplateFileDir = homeDir + "/sql_template/"
+	initFileDirs(homeDir)
 
 
 	if FileReceivePort == 0 {
@@ -176,6 +239,53 @@ func InitConfig() {
 	PrintConfig()
 }
 
+func loadPositiveInt(value string, name string) int {
+	num, err := strconv.Atoi(value)
+	if err != nil || num <= 0 {
+		log.Fatalf("Error loading %s", name)
+	}
+	return num
+}
+
+// positive number of tasks or auto (0)
+func loadTaskNum(value string, name string) int {
+	if strings.TrimSpace(value) == "auto" {
+		return 0
+	}
+	return loadPositiveInt(value, name)
+}
+
+func loadNonNegativeInt(value string, name string) int {
+	num, err := strconv.Atoi(value)
+	if err != nil || num < 0 {
+		log.Fatalf("Error loading %s", name)
+	}
+	return num
+}
+
+// only sets up file directories, used by local mode where no cluster service is started
+func InitLocalConfig() {
+	homeDir, homeDirErr := os.UserHomeDir()
+	if homeDirErr != nil {
+		log.Fatal("Error loading configs: cannot locate home directory", homeDirErr)
+	}
+	initFileDirs(homeDir)
+}
+
+func initFileDirs(homeDir string) {
+	Homedir = homeDir
+	SdfsFileDir = Homedir + "/sdfs/"
+	LocalFileDir = homeDir + "/local/"
+	JobManagerFileDir = homeDir + "/mr_job_manager/"
+	NodeManagerFileDir = homeDir + "/mr_node_manager/"
+	CacheFileDir = homeDir + "/mr_cache/"
+	SandboxFileDir = homeDir + "/mr_sandbox/"
+	WorkspaceFileDir = homeDir + "/mr_workspace/"
+	TemplateFileDir = homeDir + "/sql_template/"
+	LocalRunnerFileDir = homeDir + "/mr_local/"
+	HookFileDir = homeDir + "/mr_hooks/"
+}
+
 func PrintConfig() {
 
 	configStr := fmt.Sprintf(
@@ -193,7 +303,26 @@ func PrintConfig() {
 			"RPC_SERVER_PORT: %d\n"+
 			"FILE_RECEIVE_PORT: %d\n" + 
 			"MAPLE_TASK_NUM: %d\n"+
-			"JUICE_TASK_NUM: %d\n",
+			"JUICE_TASK_NUM: %d\n"+
+			"BROADCAST_JOIN_THRESHOLD_MB: %d\n"+
+			"SQL_MALFORMED_ROWS: %s\n"+
+			"MAPLE_SPLIT_SIZE_MB: %d\n"+
+			"JUICE_TASK_INPUT_SIZE_MB: %d\n"+
+			"WORKER_TASK_SLOTS: %d\n"+
+			"MAPLE_TASK_TIMEOUT_MINUTES: %d\n"+
+			"JUICE_TASK_TIMEOUT_MINUTES: %d\n"+
+			"EXECUTION_TIMEOUT_MINUTES: %d\n"+
+			"JOB_TIMEOUT_MINUTES: %d\n"+
+			"TASK_MAX_RETRY_NUM: %d\n"+
+			"RETRY_BACKOFF_BASE_MILLIS: %d\n"+
+			"RETRY_BACKOFF_MAX_MILLIS: %d\n"+
+			"EXECUTABLE_ARCHIVE_LIMIT_MB: %d\n"+
+			"SANDBOX_USER: %s\n"+
+			"SANDBOX_CGROUP_DIR: %s\n"+
+			"TASK_MEMORY_LIMIT_MB: %d\n"+
+			"TASK_CPU_LIMIT_SECONDS: %d\n"+
+			"TASK_FILE_SIZE_LIMIT_MB: %d\n"+
+			"TASK_PROCESS_LIMIT: %d\n",
 
 		MembershipServicePort,
 		MembershipProtocol,
@@ -210,6 +339,25 @@ func PrintConfig() {
 		FileReceivePort,
 		MapleTaskNum,
 		JuiceTaskNum,
+		BroadcastJoinThresholdMB,
+		SqlMalformedRows,
+		MapleSplitSizeMB,
+		JuiceTaskInputSizeMB,
+		WorkerTaskSlots,
+		MapleTaskTimeoutMinutes,
+		JuiceTaskTimeoutMinutes,
+		ExecutionTimeoutMinutes,
+		JobTimeoutMinutes,
+		TaskMaxRetryNum,
+		RetryBackoffBaseMillis,
+		RetryBackoffMaxMillis,
+		ExecutableArchiveLimitMB,
+		SandboxUser,
+		SandboxCgroupDir,
+		TaskMemoryLimitMB,
+		TaskCpuLimitSeconds,
+		TaskFileSizeLimitMB,
+		TaskProcessLimit,
 	)
 
 	log.Printf("\n---Config loaded---\n%s-------------------\n", configStr)
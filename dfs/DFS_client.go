@@ -79,6 +79,9 @@ func getFile(args []string) error {
 	if util.FileInSdfsFolder(remoteFileName) {
 		// if file is on local machine's sdfs folder, simply execute a cp command
 		err := util.CopyFileFromSdfsToLocal(remoteFileName, localFileName)
+		if err == nil {
+			err = decompressLocalCopy(remoteFileName, localFileName)
+		}
 		if err == nil {
 			log.Print("Done\n\n")
 		}
@@ -88,6 +91,14 @@ func getFile(args []string) error {
 	return SDFSGetFile(remoteFileName, localFileName, RECEIVER_SDFS_CLIENT)
 }
 
+// decompress a copy of a local replica with the codec recorded in the file metadata, as a fetch would
+func decompressLocalCopy(remoteFileName string, localFileName string) error {
+	response, err := SDFSListFile(remoteFileName)
+	if err != nil {
+		return err
+	}
+	return util.DecompressFile(config.LocalFileDir+localFileName, response.Master.Codec)
+}
 
 func putFile(args []string){
 	if len(args) != 2 {
@@ -11,6 +11,7 @@ import (
 	"net/rpc"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -25,14 +26,66 @@ const (
 )
 
 // fetch one file from SDFS and overwrite local file if one exisits
+// a file compressed by the job that wrote it is decompressed with the codec recorded in its metadata
 func SDFSGetFile(remoteFileName string, localFileName string, receiverTag uint8) error {
-	return sdfsFetch(remoteFileName, localFileName, receiverTag, WRITE_MODE_TRUNCATE)
+	codec, err := sdfsFetch(remoteFileName, localFileName, receiverTag, WRITE_MODE_TRUNCATE)
+	if err != nil || !util.IsCompressionEnabled(codec) {
+		return err
+	}
+
+	folder, err := receiverFolder(receiverTag)
+	if err != nil {
+		return err
+	}
+	return util.DecompressFile(folder+localFileName, codec)
+}
+
+// first maxBytes bytes of an SDFS file, read from its file master without fetching the whole file. A file
+// compressed by the job that wrote it is decompressed as far as the bytes read allow. complete tells whether
+// the whole file was read
+func SDFSReadFileHead(remoteFileName string, maxBytes int) ([]byte, bool, error) {
+	fileMetadata := &DfsResponse{}
+	err := queryMetadataService(FILE_GET, remoteFileName, fileMetadata)
+	if err != nil {
+		return nil, false, err
+	}
+	master := fileMetadata.Master
+	if master.FileStatus != util.COMPLETE {
+		return nil, false, errors.New("Cannot read sdfs file: file upload is in progress, please wait and retry later")
+	}
+
+	client := util.Dial(util.NodeIdToIP(master.NodeId), config.RpcServerPort)
+	if client == nil {
+		return nil, false, errors.New("Cannot connect to the file master of " + remoteFileName)
+	}
+	defer client.Close()
+
+	reply := &ReadHeadReply{}
+	err = client.Call("FileService.ReadFileHead", &ReadHeadArgs{SdfsFilename: remoteFileName, MaxBytes: maxBytes}, reply)
+	if err != nil {
+		return nil, false, err
+	}
+	content, err := util.DecompressHead(reply.Content, master.Codec)
+	return content, reply.Complete, err
 }
 
 // fetch multiple files from SDFS and concat into one local file
+// each file is decompressed with the codec recorded in its metadata before being appended
 func SDFSFetchAndConcat(remoteFileNames []string, localFileName string, receiverTag uint8) error {
+	folder, err := receiverFolder(receiverTag)
+	if err != nil {
+		return err
+	}
+
+	partFileName := localFileName + ".part"
+	defer os.Remove(folder + partFileName)
+
 	for _, remoteFile := range remoteFileNames {
-		err := sdfsFetch(remoteFile, localFileName, receiverTag, WRITE_MODE_APPEND)
+		codec, err := sdfsFetch(remoteFile, partFileName, receiverTag, WRITE_MODE_TRUNCATE)
+		if err != nil {
+			return err
+		}
+		err = util.AppendDecompressed(folder+partFileName, folder+localFileName, codec)
 		if err != nil {
 			return err
 		}
@@ -59,14 +112,17 @@ func SDFSFetchAndConcatWithPrefix(prefix string, localFileName string, receiverT
 		return err 
 	}
 
+	// name order, e.g. outputs of a sort job are concatenated in global order
+	sort.Strings(*matchedFiles)
 	err = SDFSFetchAndConcat(*matchedFiles, localFileName, receiverTag)
 	return err 
 }
 
-// fetch one file from SDFS, blocks until an error/completion/timeout is reached
-func sdfsFetch(remoteFileName string, localFileName string, receiverTag uint8, writeMode uint8) error {
+// fetch one file from SDFS as stored, blocks until an error/completion/timeout is reached
+// returns the compression codec recorded for the file
+func sdfsFetch(remoteFileName string, localFileName string, receiverTag uint8, writeMode uint8) (string, error) {
 	if len(localFileName) == 0 || len(remoteFileName) == 0  || (writeMode != WRITE_MODE_APPEND && writeMode != WRITE_MODE_TRUNCATE) {
-		return errors.New("Invalid parameteres for DFS GET command")
+		return "", errors.New("Invalid parameteres for DFS GET command")
 	}
 
 	fileMetadata := &DfsResponse{}
@@ -76,7 +132,7 @@ func sdfsFetch(remoteFileName string, localFileName string, receiverTag uint8, w
 	for {
 		err := queryMetadataService(FILE_GET, remoteFileName, fileMetadata)
 		if err != nil {
-			return err
+			return "", err
 		}
 	
 		master = fileMetadata.Master
@@ -85,7 +141,7 @@ func sdfsFetch(remoteFileName string, localFileName string, receiverTag uint8, w
 				maxWaitRound--
 				time.Sleep(1 * time.Second)
 			} else {
-				return errors.New("Cannot fetch sdfs file: file upload is in progress, please wait and retry later")
+				return "", errors.New("Cannot fetch sdfs file: file upload is in progress, please wait and retry later")
 			}
 		} else {
 			break
@@ -98,7 +154,7 @@ func sdfsFetch(remoteFileName string, localFileName string, receiverTag uint8, w
 
 	client, err := rpc.DialHTTP("tcp", fmt.Sprintf("%s:%d", fileMasterIP, port))
 	if (err != nil) {
-		return err
+		return "", err
 	}
 
 	transmissionId := transmissionIdGenerator.NewTransmissionId(remoteFileName)
@@ -116,7 +172,7 @@ func sdfsFetch(remoteFileName string, localFileName string, receiverTag uint8, w
 	responseErr := client.Call("FileService.ReadFile", getArgs, &reply)
 
 	if responseErr != nil {
-		return responseErr
+		return "", responseErr
 	}
 
 	timeout := time.After(180 * time.Second)
@@ -124,10 +180,10 @@ func sdfsFetch(remoteFileName string, localFileName string, receiverTag uint8, w
 	for {
 		select {
 		case <-timeout:
-			return errors.New("SDFS GET timeout")
+			return "", errors.New("SDFS GET timeout")
 		default:
 			if FileTransmissionProgressTracker.IsLocalCompleted(transmissionId) {
-				return nil
+				return master.Codec, nil
 			}
 		}
 		time.Sleep(1 * time.Second)
@@ -136,6 +192,11 @@ func sdfsFetch(remoteFileName string, localFileName string, receiverTag uint8, w
 
 
 func SDFSPutFile(remoteFileName string, localFilePath string) (*DfsResponse, error) {
+	return SDFSPutCompressedFile(remoteFileName, localFilePath, util.COMPRESSION_NONE)
+}
+
+// put a local file already compressed with codec, the codec is recorded in the file metadata so fetches decompress it
+func SDFSPutCompressedFile(remoteFileName string, localFilePath string, codec string) (*DfsResponse, error) {
 	if len(localFilePath) == 0 || len(remoteFileName) == 0 {
 		return nil, errors.New("Invalid parameteres for DFS PUT command")
 	}
@@ -164,6 +225,7 @@ func SDFSPutFile(remoteFileName string, localFilePath string) (*DfsResponse, err
 	putArgs := &RWArgs{
 		SdfsFilename: remoteFileName,
 		ClientAddr: util.NodeIdToIP(membership.SelfNodeId),
+		Codec: codec,
 	}
 
 	transmissionId := "" 
@@ -241,6 +303,29 @@ func SDFSListFile(remoteFileName string) (*DfsResponse, error) {
 	return fileMetadata, nil
 }
 
+// size of an SDFS file in bytes as last reported by its master, waits for an upload in progress like GET
+func SDFSFileSize(remoteFileName string) (int64, error) {
+	if len(remoteFileName) == 0 {
+		return 0, errors.New("Invalid parameteres for DFS LIST command")
+	}
+
+	maxWaitRound := 5
+	for {
+		fileMetadata, err := SDFSListFile(remoteFileName)
+		if err != nil {
+			return 0, err
+		}
+		if fileMetadata.Master.FileStatus == util.COMPLETE {
+			return fileMetadata.Master.Size, nil
+		}
+		if maxWaitRound == 0 {
+			return 0, errors.New("Cannot get sdfs file size: file upload is in progress, please wait and retry later")
+		}
+		maxWaitRound--
+		time.Sleep(1 * time.Second)
+	}
+}
+
 // return a list of SDFS file names matching regex
 func SDFSSearchFileByRegex(regex string) (*[]string, error) {
 	if len(regex) == 0 {
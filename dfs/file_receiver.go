@@ -5,6 +5,8 @@ import (
 	"maple-juice/config"
 	"maple-juice/util"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -20,6 +22,7 @@ const (
 	RECEIVER_SDFS_CLIENT uint8 = 11
 	RECEIVER_MR_JOB_MANAGER uint8 = 12
 	RECEIVER_MR_NODE_MANAGER uint8 = 13
+	RECEIVER_MR_CACHE uint8 = 14
 
 	WRITE_MODE_APPEND uint8 = 1		// append recieved file to an existing one
 	WRITE_MODE_TRUNCATE uint8 = 0	// create or truncate file
@@ -137,19 +140,9 @@ func initializeFile(buf *[]byte, size int) (*os.File, *string) {
 	writeMode := uint8((*buf)[17 + int(transmissionIdLength) + int(nameLength)])
 	headerSize := 18 + int(transmissionIdLength) + int(nameLength)
 
-	targetFolder := ""
-
-	switch receiverTag {
-	case RECEIVER_SDFS_FILE_SERVER:
-		targetFolder = config.SdfsFileDir
-	case RECEIVER_SDFS_CLIENT:
-		targetFolder = config.LocalFileDir
-	case RECEIVER_MR_JOB_MANAGER:
-		targetFolder = config.JobManagerFileDir
-	case RECEIVER_MR_NODE_MANAGER:
-		targetFolder = config.NodeManagerFileDir
-	default:
-		log.Printf("Unknown reciever tag %d", receiverTag)
+	targetFolder, err := receiverFolder(receiverTag)
+	if err != nil {
+		log.Print(err)
 		return nil, nil
 	}
 
@@ -157,7 +150,6 @@ func initializeFile(buf *[]byte, size int) (*os.File, *string) {
 	filePath := strings.TrimSpace(targetFolder + "/" + fileName)
 
 	var file *os.File
-	var err error
 	switch writeMode {
 	case WRITE_MODE_TRUNCATE:
 		file, err = os.Create(filePath)
@@ -183,6 +175,23 @@ func initializeFile(buf *[]byte, size int) (*os.File, *string) {
 
 
 
+// local folder files sent to a receiver are written to
+func receiverFolder(receiverTag uint8) (string, error) {
+	switch receiverTag {
+	case RECEIVER_SDFS_FILE_SERVER:
+		return config.SdfsFileDir, nil
+	case RECEIVER_SDFS_CLIENT:
+		return config.LocalFileDir, nil
+	case RECEIVER_MR_JOB_MANAGER:
+		return config.JobManagerFileDir, nil
+	case RECEIVER_MR_NODE_MANAGER:
+		return config.NodeManagerFileDir, nil
+	case RECEIVER_MR_CACHE:
+		return config.CacheFileDir, nil
+	}
+	return "", errors.New(fmt.Sprintf("Unknown reciever tag %d", receiverTag))
+}
+
 func SendFile(localFilePath string, remoteFileName, remoteAddr string, transmissionId string, receiverTag uint8, writeMode uint8) error {
 
 	var total uint64 = 0
@@ -6,6 +6,7 @@ import (
 	"maple-juice/membership"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/rpc"
 	"os"
@@ -35,6 +36,17 @@ type RWArgs struct {
 	ClientAddr     string
 	ReceiverTag    uint8
 	WriteMode      uint8
+	Codec          string // compression codec of the file being written, recorded in its metadata
+}
+
+type ReadHeadArgs struct {
+	SdfsFilename string
+	MaxBytes     int
+}
+
+type ReadHeadReply struct {
+	Content  []byte
+	Complete bool // the whole file fits in MaxBytes
 }
 
 type CreateFMArgs struct {
@@ -107,11 +119,40 @@ func (this *FileService) ReadFile(args *RWArgs, reply *string) error {
 	return nil
 }
 
+// first bytes of a file this node is file master of, returned in the reply instead of sending the whole file
+func (this *FileService) ReadFileHead(args *ReadHeadArgs, reply *ReadHeadReply) error {
+	fm, ok := this.Filename2FileMaster[args.SdfsFilename]
+	if !ok {
+		return errors.New("No corresponding filemaster for " + args.SdfsFilename)
+	}
+
+	file, err := os.Open(fm.SdfsFolder + fm.Filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// one more byte tells whether the file is longer
+	content := make([]byte, args.MaxBytes+1)
+	n, err := io.ReadFull(file, content)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	reply.Complete = n <= args.MaxBytes
+	if n > args.MaxBytes {
+		n = args.MaxBytes
+	}
+	reply.Content = content[:n]
+	return nil
+}
+
 // reroute to the corresponding file master
 func (this *FileService) WriteFile(args *RWArgs, reply *string) error {
 	fm, ok := this.Filename2FileMaster[args.SdfsFilename]
 	// TODO: fix error checking and return the actual error
 	if ok {
+		// recorded before the upload starts, so a report marking the file complete always carries its codec
+		this.SetReportCodec(args.SdfsFilename, args.Codec)
 		fm.WriteFile(args.SdfsFilename, reply)
 	} else {
 		return errors.New("No corresponding filemaster for " + args.SdfsFilename)
@@ -180,6 +221,22 @@ func (this *FileService) ChangeReportStatusPendingDelete(filename string) {
 	}
 }
 
+// record the compression codec of a file in the metadata reported to the metadata service
+func (this *FileService) SetReportCodec(filename string, codec string) {
+	if !util.IsCompressionEnabled(codec) {
+		codec = ""
+	}
+
+	this.reportLock.Lock()
+	defer this.reportLock.Unlock()
+
+	for i, fileinfo := range this.Report.FileEntries {
+		if fileinfo.FileName == filename {
+			this.Report.FileEntries[i].Codec = codec
+		}
+	}
+}
+
 func (this *FileService) CreateFileMaster(args *CreateFMArgs, reply *string) error {
 	fm := NewFileMaster(args.Filename, args.Servants, this.Port, this.SdfsFolder, this.LocalFileFolder, this)
 	go fm.scheduler.StartScheduling()
@@ -196,8 +253,9 @@ func (this *FileService) ReportMetadata(args *string, reply *util.FileServerMeta
 	updatedFileEntries := make([]util.FileInfo, 0)
 
 	for _, fileInfo := range this.Report.FileEntries {
-		_, err := os.Stat(this.SdfsFolder + fileInfo.FileName)
+		stat, err := os.Stat(this.SdfsFolder + fileInfo.FileName)
 		if err == nil {
+			fileInfo.Size = stat.Size()
 			if fileInfo.FileStatus == util.PENDING_FILE_UPLOAD || fileInfo.FileStatus == util.WAITING_REPLICATION {
 				log.Println("file status changed to complete")
 				// file is in folder
@@ -243,6 +301,17 @@ func (this *FileService) UpdateMetadata(nodeToFiles *util.NodeToFiles, reply *st
 				if exists1 && exists2{
 					fileMaster.UpdateServantIps(cluster.GetServantIps())
 				}
+			} else if !updatedFileInfo.IsMaster {
+				// servants follow the codec recorded by the master, so it survives the master's failure
+				cluster, exists := (*fileToClusters)[updatedFileInfo.FileName]
+				if exists && cluster.Master != nil {
+					for idx, fileInfo := range this.Report.FileEntries {
+						if fileInfo.FileName == currFileInfo.FileName {
+							this.Report.FileEntries[idx].Codec = cluster.Master.Codec
+							break
+						}
+					}
+				}
 			} else if !currFileInfo.IsMaster && updatedFileInfo.IsMaster {
 				// promoted to master
 				// set is Master to true and create a new filemaster
@@ -279,7 +348,12 @@ func (this *FileService) UpdateMetadata(nodeToFiles *util.NodeToFiles, reply *st
 
 			}
 			if addToReport {
-				this.Report.FileEntries = append(this.Report.FileEntries, *updatedFileInfo)
+				fileInfo := *updatedFileInfo
+				cluster, exists := (*fileToClusters)[fileInfo.FileName]
+				if exists && cluster.Master != nil {
+					fileInfo.Codec = cluster.Master.Codec
+				}
+				this.Report.FileEntries = append(this.Report.FileEntries, fileInfo)
 			}
 		}
 
@@ -14,6 +14,7 @@ import (
 	"net"
 	"net/http"
 	"net/rpc"
+	"os"
 	"strconv"
 	"strings"
 )
@@ -25,6 +26,22 @@ func main() {
 	var cmd string
 	var args []string
 
+	// executables of sandboxed tasks are started through this binary, see MR_sandbox_linux.go
+	if len(os.Args) > 1 && os.Args[1] == maplejuice.SANDBOX_EXEC_CMD {
+		maplejuice.SandboxExec(os.Args[2:])
+	}
+
+	// local mode runs a Maple Juice pipeline in this process without starting any cluster service
+	// go run main.go local <maple_exe> <num_maples> <juice_exe> <num_juices> <src_filename> <dest_filename> <input_has_header> <is_hash>
+	if len(os.Args) > 1 && os.Args[1] == "local" {
+		config.InitLocalConfig()
+		err := maplejuice.ProcessLocalCmd(os.Args[2:])
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
 	util.InitSignals()
 	config.InitConfig()
 
@@ -52,7 +69,7 @@ func main() {
 	dfs.NewDfsRemoteReader().Register()
 	fileService := dfs.NewFileService(config.RpcServerPort, config.Homedir, config.ServerHostnames)
 	fileService.Register()
-	mrNodeManager := new(maplejuice.MRNodeManager);
+	mrNodeManager := maplejuice.NewMRNodeManager();
 	mrNodeManager.Register();
 	maplejuice.NewMRJobManager().Register();
 
@@ -68,7 +85,7 @@ func main() {
 
 
 	// don't allow commands until all servers properly started
-	fmt.Println("Starting servers...\n")
+	fmt.Print("Starting servers...\n\n")
 	util.WaitAllServerStart()
 	dfs.InitializeClient()
 
@@ -97,9 +114,16 @@ func main() {
 		"ls":				 "ls sdfsfilename: list all VM addresses where this file is currently replicated (If you are splitting files into blocks, just set the block size to be large enough that each file is one block)",
 		"multiread": 		 "launches reads from VMi… VMj simultaneously to filename. (Note that you have to implement this anyway for your report's item (iv) experiments).",
 
-		"maple": "test maple",
-		"juice": "test juice",
-		"SELECT": "filter/join sql query. for command format please see SQL_client.go",
+		"maple": "maple <maple_exe> <num_maples> <sdfs_intermediate_filename_prefix> <sdfs_src_filename> <input_has_header> [dest=<sdfs_dest_filename> outputs=<num_output_files> cache=<sdfs_file1>,<sdfs_file2> format=lines|csv|jsonl|fixed:<n>|delim:<sep> compress=gzip|none split_size=<MB> notify_url=<http_url> notify_cmd=<hook_name> job_timeout= task_timeout= exec_timeout= retries= backoff= backoff_max= mem_limit= cpu_limit= fsize_limit= proc_limit=] (dest makes a map-only job, num_maples may be auto)",
+		"juice": "juice <juice_exe> <num_juices> <sdfs_intermediate_filename_prefix> <sdfs_dest_filename> <delete_input> <is_hash> [cache=<sdfs_file1>,<sdfs_file2> compress=gzip|none notify_url=<http_url> notify_cmd=<hook_name> job_timeout= task_timeout= exec_timeout= retries= backoff= backoff_max= mem_limit= cpu_limit= fsize_limit= proc_limit=] (num_juices may be auto)",
+		"iterate": "iterate <maple_exe> <num_maples> <juice_exe> <num_juices> <sdfs_intermediate_filename_prefix> <sdfs_src_filename> <sdfs_dest_filename> <input_has_header> <is_hash> [max_iter= converge_exe=<sdfs_exe> converge_counter=<name> converge_threshold= keep=<num_iterations> cache= compress= format= notify_url=<http_url> notify_cmd=<hook_name> job_timeout= task_timeout= exec_timeout= retries= backoff= backoff_max= mem_limit= cpu_limit= fsize_limit= proc_limit=]",
+		"sort": "sort <sdfs_src_filename> <sdfs_dest_filename> <num_outputs> <input_has_header> [column=<name>|<index>,... type=string|numeric,... order=asc|desc,... limit=<num> input=file|prefix delim=<separator>|tab|csv sample=<num_keys> num_maples= num_juices= notify_url=<http_url> notify_cmd=<hook_name> job_timeout= task_timeout= exec_timeout= retries= backoff= backoff_max= mem_limit= cpu_limit= fsize_limit= proc_limit=] (writes <sdfs_dest_filename>-part<n>, globally sorted when concatenated by name)",
+		"job": "job logs <job_id> [task_number]: print stdout/stderr of every task attempt of a Maple/Juice job, grouped by the leader that ran it; job history [job_id]: list completed jobs or print the task timing breakdown of a job; job export <local_filename> [job_id]: export job history as JSON to local dir; job cancel <job_id>: cancel a queued or running job",
+		"schedule": "schedule add <name> <cron> <skip|queue> <maple|juice|iterate|sort> <job args...>: run a job on a cron schedule (5 fields or @hourly/@daily/@weekly/@monthly); schedule list: list schedules with next and last runs; schedule remove <name>: remove a schedule",
+		"SELECT": "SELECT ALL|<column>|<aggregate> [AS <alias>], ... FROM <file> [WHERE <condition> | WHERE '<regex>'] [GROUP BY <columns>] [HAVING <condition>] [ORDER BY <column> [ASC|DESC], ...] [LIMIT <n>] or SELECT ALL FROM <file1>, <file2> WHERE <file1>.<field1> = <file2>.<field2> or SELECT ALL FROM <file1> [AS <alias>] [INNER|LEFT|RIGHT|FULL [OUTER]] JOIN <file2> [AS <alias>] ON <file1>.<field1> = <file2>.<field2> [AND ...] [JOIN ...] (keywords are case-insensitive, double quote column names with spaces)",
+		"CREATE": "CREATE TABLE <name> (<column> STRING|INT|FLOAT, ...) LOCATION '<sdfs_file>': add a table to the catalog stored in SDFS, queries on it are checked against its column types (the file starts with a header row of the columns)",
+		"DESCRIBE": "DESCRIBE <name>: print the columns and types of a catalog table",
+		"SHOW": "SHOW TABLES: list the tables of the catalog",
 		"SPC" : "select percent composition, used for MP4 demo only. for command format please see SQL_client.go",
 
 		// debug commands
@@ -111,6 +135,10 @@ func main() {
 	for {
 		util.Prompt(`Enter a command (Type "help" for a list of available commands)`, &cmd, &args,
 			func(cmdValue string) bool {
+				// SQL keywords are case-insensitive
+				if sqlCommand(cmdValue) != "" {
+					return true
+				}
 				for k := range validCommands {
 					if k == cmdValue {
 						return true
@@ -120,6 +148,10 @@ func main() {
 			},
 		)
 
+		if sqlCmd := sqlCommand(cmd); sqlCmd != "" {
+			cmd = sqlCmd
+		}
+
 		switch cmd {
 		case "list_mem":
 			// print membership list
@@ -177,8 +209,20 @@ func main() {
 		case "juice":
 			maplejuice.ProcessJuiceCmd(args)
 
-		case "SELECT":
-			query := "SELECT " + strings.Join(args, " ")
+		case "iterate":
+			maplejuice.ProcessIterateCmd(args)
+
+		case "sort":
+			maplejuice.ProcessSortCmd(args)
+
+		case "job":
+			maplejuice.ProcessJobCmd(args)
+
+		case "schedule":
+			maplejuice.ProcessScheduleCmd(args)
+
+		case "SELECT", "CREATE", "DESCRIBE", "SHOW":
+			query := cmd + " " + strings.Join(args, " ")
 			sql.ProcessSqlQuery(query)
 
 
@@ -198,3 +242,13 @@ func main() {
 		}
 	}
 }
+
+// upper case SQL statement keyword of a command, empty if the command is not SQL
+func sqlCommand(cmd string) string {
+	for _, keyword := range []string{"SELECT", "CREATE", "DESCRIBE", "SHOW"} {
+		if strings.EqualFold(cmd, keyword) {
+			return keyword
+		}
+	}
+	return ""
+}
@@ -5,28 +5,57 @@ import (
 	"maple-juice/util"
 	"maple-juice/leaderelection"
 	"errors"
+	"fmt"
 	"log"
 	"net/rpc"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 )
 
-//maple <maple_exe> <num_maples> <sdfs_intermediate_filename_prefix> <sdfs_src_filename> <input_has_header>
+//maple <maple_exe> <num_maples> <sdfs_intermediate_filename_prefix> <sdfs_src_filename> <input_has_header> [options]
+// num_maples=auto splits the input into splits of about split_size MB
+// options:
+//   dest=<sdfs_dest_filename>	map-only job, maple output is written to <sdfs_dest_filename>-<n> and no juice job is needed
+//   outputs=<num_output_files>	number of output files of a map-only job, defaults to num_maples
+//   cache=<sdfs_file1>,<sdfs_file2>	side files fetched once per node and exposed to the executable via $MJ_CACHE_DIR
+//   compress=gzip|none		compress output files stored in SDFS, fetching them decompresses transparently
+//   format=lines|csv|jsonl|fixed:<length>|delim:<separator>	input record format used for splitting,
+//								the executable is compiled with the record readers and gets the format via $MJ_RECORD_FORMAT
+//   split_size=<MB>			target input split size with num_maples=auto, defaults to MAPLE_SPLIT_SIZE_MB
+//   plus the completion hook options listed at parseCompletionHook
+//   plus the timeout and retry options listed at jobPolicyOptionKeys
 func ProcessMapleCmd(args []string) error {
-	if (len(args) != 5){
+	jobRequest, err := parseMapleCmd(args)
+	if err != nil {
+		return err
+	}
+	return submitJob(jobRequest, "Maple")
+}
+
+func parseMapleCmd(args []string) (*util.JobRequest, error) {
+	if (len(args) < 5){
 		log.Print("Invalid maple command")
-		return errors.New("Invalid maple command")
+		return nil, errors.New("Invalid maple command")
 	}
 
-	taskNum, err := strconv.Atoi(args[1]);
+	taskNum, err := util.ParseTaskNum(args[1]);
 	if (err != nil){
 		log.Print("Invalid maple task number")
-		return errors.New("Invalid maple task number")
+		return nil, errors.New("Invalid maple task number")
 	}
 
 	handleInputHeader, err := strconv.Atoi(args[4]);
 	if (err != nil || (handleInputHeader != 0 && handleInputHeader != 1)){
 		log.Print("Invalid input_has_header flag")
-		return errors.New("Invalid input_has_header flag")
+		return nil, errors.New("Invalid input_has_header flag")
+	}
+
+	options, err := parseJobOptions(args[5:], append([]string{"dest", "outputs", "cache", "format", "compress", "split_size", "notify_url", "notify_cmd"}, jobPolicyOptionKeys...))
+	if err != nil {
+		log.Print(err)
+		return nil, err
 	}
 
 	mapleExeName := args[0]
@@ -34,64 +63,118 @@ func ProcessMapleCmd(args []string) error {
 	sdfsSrcFileName := args[3]
 	if(len(mapleExeName)==0 || len(sdfsIntermediateFileName)==0 || len(sdfsSrcFileName)==0){
 		log.Print("file names cannot be empty")
-		return errors.New("file names cannot be empty")
+		return nil, errors.New("file names cannot be empty")
+	}
+
+	outputFileNum := 0
+	if value, exists := options["outputs"]; exists {
+		outputFileNum, err = strconv.Atoi(value)
+		if err != nil || outputFileNum <= 0 {
+			log.Print("Invalid number of output files")
+			return nil, errors.New("Invalid number of output files")
+		}
+	}
+
+	splitSizeMB := 0
+	if value, exists := options["split_size"]; exists {
+		splitSizeMB, err = strconv.Atoi(value)
+		if err != nil || splitSizeMB <= 0 {
+			log.Print("Invalid split size")
+			return nil, errors.New("Invalid split size")
+		}
+	}
+
+	if value, exists := options["format"]; exists {
+		_, err := util.ParseRecordFormat(value)
+		if err != nil {
+			log.Print(err)
+			return nil, err
+		}
+	}
+
+	err = util.ValidateCompression(options["compress"])
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	policy, err := parseJobPolicy(options)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	hook, err := parseCompletionHook(options)
+	if err != nil {
+		log.Print(err)
+		return nil, err
 	}
 
 	jobRequest := &util.JobRequest{
 		IsMaple: true,
+		Policy: policy,
+		Hook: hook,
 		MapleJob: util.MapleJobRequest{
 			ExcecutableFileName: mapleExeName,
 			TaskNum: taskNum,
+			SplitSizeMB: splitSizeMB,
 			SrcSdfsFileName: sdfsSrcFileName,
 			OutputFilePrefix: sdfsIntermediateFileName,
 			PreserveInputHeader: handleInputHeader==1,
+			OutputFileName: options["dest"],
+			OutputFileNum: outputFileNum,
+			CacheFiles: parseCacheFiles(options["cache"]),
+			InputFormat: options["format"],
+			Compression: options["compress"],
 		},
 	}
-
-	// dial job manager and submit job via rpc
-
-	client := dialMRJobManager()
-	if client == nil {
-		return errors.New("Cannot connect to Maple Juice Job Manager")
-	}
-
-	defer client.Close()
-	reply := ""
-	responseErr := client.Call("MRJobManager.SubmitJob", jobRequest, &reply)
-
-	if responseErr != nil {
-		log.Print("Encountered error while executing Maple job", responseErr)
-	} else {
-		log.Print("Finished executing Maple job")
-	}
-
-	return responseErr
+	return jobRequest, nil
 }
 
 // juice <juice_exe> <num_juices> <sdfs_intermediate_filename_prefix> <sdfs_dest_filename> 
-// delete_input={0,1} is_hash={0,1}}
+// delete_input={0,1} is_hash={0,1}} [options]
+// num_juices=auto picks the number from free worker slots and the size of the intermediate data
+// options:
+//   cache=<sdfs_file1>,<sdfs_file2>	side files fetched once per node and exposed to the executable via $MJ_CACHE_DIR
+//   compress=gzip|none		compress output files stored in SDFS, fetching them decompresses transparently
+//   plus the completion hook options listed at parseCompletionHook
+//   plus the timeout and retry options listed at jobPolicyOptionKeys
 func ProcessJuiceCmd(args []string) error {
-	if (len(args) != 6){
+	jobRequest, err := parseJuiceCmd(args)
+	if err != nil {
+		return err
+	}
+	return submitJob(jobRequest, "Juice")
+}
+
+func parseJuiceCmd(args []string) (*util.JobRequest, error) {
+	if (len(args) < 6){
 		log.Print("Invalid juice command")
-		return errors.New("Invalid juice command")
+		return nil, errors.New("Invalid juice command")
 	}
 
-	taskNum, err := strconv.Atoi(args[1]);
+	taskNum, err := util.ParseTaskNum(args[1]);
 	if (err != nil){
 		log.Print("Invalid juice task number")
-		return errors.New("Invalid juice task number")
+		return nil, errors.New("Invalid juice task number")
 	}
 
 	deleteInput, err := strconv.Atoi(args[4]);
 	if (err != nil || (deleteInput != 0 && deleteInput != 1)){
 		log.Print("Invalid delete_input flag")
-		return errors.New("Invalid delete_input flag")
+		return nil, errors.New("Invalid delete_input flag")
 	}
 
 	isHash, err := strconv.Atoi(args[5]);
 	if (err != nil || (isHash != 0 && isHash != 1)){
 		log.Print("Invalid is_hash flag")
-		return errors.New("Invalid is_hash flag")
+		return nil, errors.New("Invalid is_hash flag")
+	}
+
+	options, err := parseJobOptions(args[6:], append([]string{"cache", "compress", "notify_url", "notify_cmd"}, jobPolicyOptionKeys...))
+	if err != nil {
+		log.Print(err)
+		return nil, err
 	}
 
 	juiceExeName := args[0]
@@ -99,11 +182,31 @@ func ProcessJuiceCmd(args []string) error {
 	sdfsDstFileName := args[3]
 	if(len(juiceExeName)==0 || len(sdfsIntermediatePrefix)==0 || len(sdfsDstFileName)==0){
 		log.Print("file names cannot be empty")
-		return errors.New("file names cannot be empty")
+		return nil, errors.New("file names cannot be empty")
+	}
+
+	err = util.ValidateCompression(options["compress"])
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	policy, err := parseJobPolicy(options)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	hook, err := parseCompletionHook(options)
+	if err != nil {
+		log.Print(err)
+		return nil, err
 	}
 
 	jobRequest := &util.JobRequest{
 		IsMaple: false,
+		Policy: policy,
+		Hook: hook,
 		JuiceJob: util.JuiceJobRequest{
 			ExcecutableFileName: juiceExeName,
 			TaskNum: taskNum,
@@ -111,12 +214,354 @@ func ProcessJuiceCmd(args []string) error {
 			OutputFileName: sdfsDstFileName,
 			DeleteInput: deleteInput==1,
 			IsHashPartition: isHash==1,
+			CacheFiles: parseCacheFiles(options["cache"]),
+			Compression: options["compress"],
 		},
 	}
+	return jobRequest, nil
+}
 
-	// dial job manager and submit job via rpc
 
-	client := dialMRJobManager()
+// iterate <maple_exe> <num_maples> <juice_exe> <num_juices> <sdfs_intermediate_filename_prefix> <sdfs_src_filename>
+// <sdfs_dest_filename> input_has_header={0,1} is_hash={0,1} [options]
+// num_maples and num_juices accept auto as well
+// options:
+//   max_iter=<num>					maximum number of iterations, defaults to 10
+//   converge_exe=<sdfs_exe>		executable deciding whether two consecutive outputs converged
+//   converge_counter=<name>		user counter checked after every iteration
+//   converge_threshold=<num>		converged once converge_counter of an iteration is at most this value, defaults to 0
+//   keep=<num>						number of iteration outputs <sdfs_dest_filename>_iter<i> kept in SDFS, 0 keeps all
+//   plus the cache, compress, format and completion hook options and the options listed at jobPolicyOptionKeys,
+//   job_timeout applies to the whole iterative job
+func ProcessIterateCmd(args []string) error {
+	jobRequest, err := parseIterateCmd(args)
+	if err != nil {
+		return err
+	}
+	return submitJob(jobRequest, "iterative")
+}
+
+func parseIterateCmd(args []string) (*util.JobRequest, error) {
+	if len(args) < 9 {
+		log.Print("Invalid iterate command")
+		return nil, errors.New("Invalid iterate command")
+	}
+
+	mapleTaskNum, err := util.ParseTaskNum(args[1])
+	if err != nil {
+		log.Print("Invalid maple task number")
+		return nil, errors.New("Invalid maple task number")
+	}
+
+	juiceTaskNum, err := util.ParseTaskNum(args[3])
+	if err != nil {
+		log.Print("Invalid juice task number")
+		return nil, errors.New("Invalid juice task number")
+	}
+
+	handleInputHeader, err := strconv.Atoi(args[7])
+	if err != nil || (handleInputHeader != 0 && handleInputHeader != 1) {
+		log.Print("Invalid input_has_header flag")
+		return nil, errors.New("Invalid input_has_header flag")
+	}
+
+	isHash, err := strconv.Atoi(args[8])
+	if err != nil || (isHash != 0 && isHash != 1) {
+		log.Print("Invalid is_hash flag")
+		return nil, errors.New("Invalid is_hash flag")
+	}
+
+	optionKeys := []string{"max_iter", "converge_exe", "converge_counter", "converge_threshold", "keep", "cache", "compress", "format", "notify_url", "notify_cmd"}
+	options, err := parseJobOptions(args[9:], append(optionKeys, jobPolicyOptionKeys...))
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	mapleExeName := args[0]
+	juiceExeName := args[2]
+	sdfsIntermediateFileName := args[4]
+	sdfsSrcFileName := args[5]
+	sdfsDestFileName := args[6]
+	if len(mapleExeName) == 0 || len(juiceExeName) == 0 || len(sdfsIntermediateFileName) == 0 || len(sdfsSrcFileName) == 0 || len(sdfsDestFileName) == 0 {
+		log.Print("file names cannot be empty")
+		return nil, errors.New("file names cannot be empty")
+	}
+
+	numOptions := map[string]int{"max_iter": 10, "converge_threshold": 0, "keep": 0}
+	for key := range numOptions {
+		value, exists := options[key]
+		if !exists {
+			continue
+		}
+		num, err := strconv.Atoi(value)
+		if err != nil || num < 0 || (num == 0 && key == "max_iter") {
+			log.Printf("Invalid value for option %s", key)
+			return nil, errors.New(fmt.Sprintf("Invalid value for option %s", key))
+		}
+		numOptions[key] = num
+	}
+
+	if _, exists := options["converge_threshold"]; exists && len(options["converge_counter"]) == 0 {
+		log.Print("converge_threshold requires converge_counter")
+		return nil, errors.New("converge_threshold requires converge_counter")
+	}
+
+	if value, exists := options["format"]; exists {
+		_, err := util.ParseRecordFormat(value)
+		if err != nil {
+			log.Print(err)
+			return nil, err
+		}
+	}
+
+	err = util.ValidateCompression(options["compress"])
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	policy, err := parseJobPolicy(options)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	hook, err := parseCompletionHook(options)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	cacheFiles := parseCacheFiles(options["cache"])
+	jobRequest := &util.JobRequest{
+		IsIterative: true,
+		Policy: policy,
+		Hook: hook,
+		IterativeJob: util.IterativeJobRequest{
+			MapleJob: util.MapleJobRequest{
+				ExcecutableFileName: mapleExeName,
+				TaskNum: mapleTaskNum,
+				SrcSdfsFileName: sdfsSrcFileName,
+				OutputFilePrefix: sdfsIntermediateFileName,
+				PreserveInputHeader: handleInputHeader==1,
+				CacheFiles: cacheFiles,
+				InputFormat: options["format"],
+				Compression: options["compress"],
+			},
+			JuiceJob: util.JuiceJobRequest{
+				ExcecutableFileName: juiceExeName,
+				TaskNum: juiceTaskNum,
+				OutputFileName: sdfsDestFileName,
+				IsHashPartition: isHash==1,
+				CacheFiles: cacheFiles,
+				Compression: options["compress"],
+			},
+			MaxIterations: numOptions["max_iter"],
+			ConvergenceExecutable: options["converge_exe"],
+			ConvergenceCounter: options["converge_counter"],
+			ConvergenceThreshold: int64(numOptions["converge_threshold"]),
+			KeepIterations: numOptions["keep"],
+		},
+	}
+	return jobRequest, nil
+}
+
+//sort <sdfs_src_filename> <sdfs_dest_filename> <num_outputs> <input_has_header> [options]
+// writes <sdfs_dest_filename>-part<n> for n < num_outputs, globally sorted when concatenated by name
+// options:
+//   column=<name>|<index>,...	sort columns given by header name or 0-based index, ties of a column are ordered
+//					by the next one, the whole line by default
+//   type=string|numeric,...		comparator per column or for all columns, defaults to string
+//   order=asc|desc,...			order per column or for all columns, defaults to asc
+//   limit=<num>			keep only the first num records, each maple task passes on its first num records
+//   input=file|prefix			prefix sorts all SDFS files <sdfs_src_filename>-* concatenated in name order
+//   delim=<separator>|tab|csv		column separator, defaults to ","; csv reads RFC 4180 records, quoted
+//					fields may hold commas and line breaks
+//   sample=<num_keys>			number of keys sampled to pick split points
+//   num_maples=<num>|auto		defaults to auto
+//   num_juices=<num>|auto		defaults to num_outputs
+//   plus the completion hook options listed at parseCompletionHook
+//   plus the timeout and retry options listed at jobPolicyOptionKeys
+func ProcessSortCmd(args []string) error {
+	jobRequest, err := parseSortCmd(args)
+	if err != nil {
+		return err
+	}
+	err = submitJob(jobRequest, "sort")
+	if err == nil {
+		sortJob := jobRequest.SortJob
+		log.Printf("Sorted output is at %s to %s", fmtSortOutputName(sortJob.OutputFileName, 0), fmtSortOutputName(sortJob.OutputFileName, sortJob.OutputFileNum-1))
+	}
+	return err
+}
+
+func parseSortCmd(args []string) (*util.JobRequest, error) {
+	if len(args) < 4 {
+		log.Print("Invalid sort command")
+		return nil, errors.New("Invalid sort command")
+	}
+
+	outputFileNum, err := strconv.Atoi(args[2])
+	if err != nil || outputFileNum <= 0 || outputFileNum > util.SORT_MAX_OUTPUT_NUM {
+		log.Print("Invalid number of sort outputs")
+		return nil, errors.New("Invalid number of sort outputs")
+	}
+
+	handleInputHeader, err := strconv.Atoi(args[3])
+	if err != nil || (handleInputHeader != 0 && handleInputHeader != 1) {
+		log.Print("Invalid input_has_header flag")
+		return nil, errors.New("Invalid input_has_header flag")
+	}
+
+	optionKeys := []string{"column", "type", "order", "limit", "input", "delim", "sample", "num_maples", "num_juices", "notify_url", "notify_cmd"}
+	options, err := parseJobOptions(args[4:], append(optionKeys, jobPolicyOptionKeys...))
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	sdfsSrcFileName := args[0]
+	sdfsDestFileName := args[1]
+	if len(sdfsSrcFileName) == 0 || len(sdfsDestFileName) == 0 {
+		log.Print("file names cannot be empty")
+		return nil, errors.New("file names cannot be empty")
+	}
+	if strings.Contains(sdfsDestFileName, "-") {
+		log.Print("sdfs_dest_filename cannot contain dashes")
+		return nil, errors.New("sdfs_dest_filename cannot contain dashes")
+	}
+
+	sortJob := util.SortJobRequest{
+		SrcSdfsFileName: sdfsSrcFileName,
+		OutputFileName:  sdfsDestFileName,
+		OutputFileNum:   outputFileNum,
+		HasHeader:       handleInputHeader == 1,
+		Delimiter:       ",",
+		MapleTaskNum:    util.TASK_NUM_AUTO,
+		JuiceTaskNum:    outputFileNum,
+	}
+
+	switch options["input"] {
+	case "", "file":
+	case "prefix":
+		sortJob.SrcSdfsFileName = ""
+		sortJob.SrcSdfsFilePrefix = sdfsSrcFileName
+	default:
+		log.Print("Invalid sort input, expecting file or prefix")
+		return nil, errors.New("Invalid sort input")
+	}
+
+	sortJob.Keys, err = parseSortKeys(options)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	if value, exists := options["limit"]; exists {
+		num, err := strconv.Atoi(value)
+		if err != nil || num <= 0 {
+			log.Print("Invalid value for option limit")
+			return nil, errors.New("Invalid value for option limit")
+		}
+		sortJob.Limit = num
+	}
+
+	if value, exists := options["delim"]; exists {
+		if value == "tab" {
+			value = "\t"
+		}
+		sortJob.Delimiter = value
+	}
+
+	if value, exists := options["sample"]; exists {
+		num, err := strconv.Atoi(value)
+		if err != nil || num <= 0 {
+			log.Print("Invalid value for option sample")
+			return nil, errors.New("Invalid value for option sample")
+		}
+		sortJob.SampleSize = num
+	}
+
+	taskNums := map[string]*int{"num_maples": &sortJob.MapleTaskNum, "num_juices": &sortJob.JuiceTaskNum}
+	for key, field := range taskNums {
+		value, exists := options[key]
+		if !exists {
+			continue
+		}
+		*field, err = util.ParseTaskNum(value)
+		if err != nil {
+			log.Printf("Invalid value for option %s", key)
+			return nil, errors.New(fmt.Sprintf("Invalid value for option %s", key))
+		}
+	}
+
+	policy, err := parseJobPolicy(options)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	hook, err := parseCompletionHook(options)
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+
+	jobRequest := &util.JobRequest{
+		IsSort:  true,
+		Policy:  policy,
+		Hook:    hook,
+		SortJob: sortJob,
+	}
+	return jobRequest, nil
+}
+
+// sort keys from comma separated column, type and order lists, a single type or order applies to every column
+func parseSortKeys(options map[string]string) ([]util.SortKey, error) {
+	columns := []string{options["column"]}
+	if len(options["column"]) > 0 {
+		columns = strings.Split(options["column"], ",")
+	}
+	types := strings.Split(options["type"], ",")
+	orders := strings.Split(options["order"], ",")
+	if (len(types) != 1 && len(types) != len(columns)) || (len(orders) != 1 && len(orders) != len(columns)) {
+		return nil, errors.New("Options type and order take one value or one per sort column")
+	}
+
+	keys := make([]util.SortKey, 0, len(columns))
+	for idx, column := range columns {
+		key := util.SortKey{Column: column, SortType: types[0]}
+		if len(types) > 1 {
+			key.SortType = types[idx]
+		}
+		if len(key.SortType) == 0 {
+			key.SortType = util.SORT_TYPE_STRING
+		}
+		err := util.ValidateSortType(key.SortType)
+		if err != nil {
+			return nil, err
+		}
+
+		order := orders[0]
+		if len(orders) > 1 {
+			order = orders[idx]
+		}
+		switch order {
+		case "", "asc":
+		case "desc":
+			key.Descending = true
+		default:
+			return nil, errors.New("Invalid sort order, expecting asc or desc")
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// dial job manager and submit job via rpc, blocks until the job completes
+func submitJob(jobRequest *util.JobRequest, title string) error {
+	client := dialMRJobSubmission()
 	if client == nil {
 		return errors.New("Cannot connect to Maple Juice Job Manager")
 	}
@@ -126,15 +571,328 @@ func ProcessJuiceCmd(args []string) error {
 	responseErr := client.Call("MRJobManager.SubmitJob", jobRequest, &reply)
 
 	if responseErr != nil {
-		log.Print("Encountered error while executing Juice job", responseErr)
+		log.Printf("Encountered error while executing %s job %s", title, responseErr.Error())
 	} else {
-		log.Print("Finished executing Juice job")
+		log.Printf("Finished executing %s job %s", title, reply)
 	}
 	return responseErr
 }
 
+// job logs <job_id> [task_number]
+// job history [job_id]
+// job export <local_filename> [job_id]
+// job cancel <job_id>
+func ProcessJobCmd(args []string) error {
+	if len(args) == 0 {
+		log.Print("Invalid job command")
+		return errors.New("Invalid job command")
+	}
+
+	switch args[0] {
+	case "logs":
+		return printJobLogs(args[1:])
+	case "history":
+		return printJobHistory(args[1:])
+	case "export":
+		return exportJobHistory(args[1:])
+	case "cancel":
+		return cancelJob(args[1:])
+	default:
+		log.Printf("Unsupported job command: (%s)", args[0])
+		return errors.New("Unsupported job command")
+	}
+}
+
+// job cancel <job_id>
+func cancelJob(args []string) error {
+	if len(args) != 1 {
+		log.Print("Usage: job cancel <job_id>")
+		return errors.New("Invalid job cancel command")
+	}
+	jobId, err := strconv.Atoi(args[0])
+	if err != nil {
+		log.Print("Invalid job id")
+		return errors.New("Invalid job id")
+	}
+
+	client := dialMRJobManager()
+	if client == nil {
+		return errors.New("Cannot connect to Maple Juice Job Manager")
+	}
+	defer client.Close()
+
+	id := int32(jobId)
+	reply := ""
+	err = client.Call("MRJobManager.CancelJob", &id, &reply)
+	if err != nil {
+		log.Print("Failed to cancel job ", err)
+		return err
+	}
+	log.Printf("Job %d cancelled", jobId)
+	return nil
+}
+
+// schedule add <name> <cron> <skip|queue> <maple|juice|iterate|sort> <job args...>
+// schedule list
+// schedule remove <name>
+// cron is either 5 fields or one of @hourly, @daily, @weekly, @monthly, see util/cron.go.
+// skip drops a run while the previous run is still going, queue runs it once the previous run ends
+func ProcessScheduleCmd(args []string) error {
+	if len(args) == 0 {
+		log.Print("Invalid schedule command")
+		return errors.New("Invalid schedule command")
+	}
+
+	switch args[0] {
+	case "add":
+		return addSchedule(args[1:])
+	case "list":
+		return listSchedules()
+	case "remove":
+		return removeSchedule(args[1:])
+	default:
+		log.Printf("Unsupported schedule command: (%s)", args[0])
+		return errors.New("Unsupported schedule command")
+	}
+}
+
+func addSchedule(args []string) error {
+	usage := "Usage: schedule add <name> <cron> <skip|queue> <maple|juice|iterate|sort> <job args...>"
+	if len(args) < 2 {
+		log.Print(usage)
+		return errors.New("Invalid schedule add command")
+	}
+
+	cronFieldNum := 5
+	if strings.HasPrefix(args[1], "@") {
+		cronFieldNum = 1
+	}
+	if len(args) < 1+cronFieldNum+2 {
+		log.Print(usage)
+		return errors.New("Invalid schedule add command")
+	}
+	cron := strings.Join(args[1:1+cronFieldNum], " ")
+	_, err := util.ParseCronSchedule(cron)
+	if err != nil {
+		log.Print(err.Error())
+		return err
+	}
+
+	spec := &util.ScheduledJob{
+		Name:    args[0],
+		Cron:    cron,
+		Overlap: args[1+cronFieldNum],
+	}
+	jobArgs := args[2+cronFieldNum:]
+	spec.Command = strings.Join(jobArgs, " ")
+
+	var jobRequest *util.JobRequest
+	switch jobArgs[0] {
+	case "maple":
+		jobRequest, err = parseMapleCmd(jobArgs[1:])
+	case "juice":
+		jobRequest, err = parseJuiceCmd(jobArgs[1:])
+	case "iterate":
+		jobRequest, err = parseIterateCmd(jobArgs[1:])
+	case "sort":
+		jobRequest, err = parseSortCmd(jobArgs[1:])
+	default:
+		log.Printf("Unsupported scheduled job type: (%s)", jobArgs[0])
+		return errors.New("Unsupported scheduled job type")
+	}
+	if err != nil {
+		return err
+	}
+	spec.Request = *jobRequest
+
+	client := dialMRJobSubmission()
+	if client == nil {
+		return errors.New("Cannot connect to Maple Juice Job Manager")
+	}
+	defer client.Close()
+
+	reply := ""
+	err = client.Call("MRJobManager.AddSchedule", spec, &reply)
+	if err != nil {
+		log.Print("Failed to add schedule ", err)
+		return err
+	}
+	log.Printf("Schedule %s added, next run at %s", spec.Name, reply)
+	return nil
+}
+
+func listSchedules() error {
+	client := dialMRJobManager()
+	if client == nil {
+		return errors.New("Cannot connect to Maple Juice Job Manager")
+	}
+	defer client.Close()
+
+	arg := ""
+	statuses := make([]util.ScheduleStatus, 0)
+	err := client.Call("MRJobManager.ListSchedules", &arg, &statuses)
+	if err != nil {
+		log.Print("Failed to list schedules ", err)
+		return err
+	}
+	if len(statuses) == 0 {
+		fmt.Println("No schedules found")
+		return nil
+	}
+
+	fmt.Printf("%-16s %-20s %-7s %-25s %-25s %-10s %-10s  %s\n", "NAME", "CRON", "OVERLAP", "NEXT_RUN", "LAST_RUN", "LAST_JOB", "STATE", "COMMAND")
+	for _, status := range statuses {
+		lastRun, lastJob := "-", "-"
+		if !status.LastRun.IsZero() {
+			lastRun = status.LastRun.Format(time.RFC3339)
+			lastJob = fmt.Sprintf("%d:%s", status.LastJobId, status.LastStatus)
+		}
+		state := "idle"
+		if status.Queued {
+			state = "queued"
+		} else if status.Running {
+			state = "running"
+		}
+		fmt.Printf("%-16s %-20s %-7s %-25s %-25s %-10s %-10s  %s\n", status.Name, status.Cron, status.Overlap,
+			status.NextRun.Format(time.RFC3339), lastRun, lastJob, state, status.Command)
+	}
+	return nil
+}
+
+func removeSchedule(args []string) error {
+	if len(args) != 1 {
+		log.Print("Usage: schedule remove <name>")
+		return errors.New("Invalid schedule remove command")
+	}
+
+	client := dialMRJobManager()
+	if client == nil {
+		return errors.New("Cannot connect to Maple Juice Job Manager")
+	}
+	defer client.Close()
+
+	reply := ""
+	err := client.Call("MRJobManager.RemoveSchedule", &args[0], &reply)
+	if err != nil {
+		log.Print("Failed to remove schedule ", err)
+		return err
+	}
+	log.Printf("Schedule %s removed", args[0])
+	return nil
+}
+
+// completion hook options, the job manager calls the hooks once the job succeeds, fails or is cancelled
+//   notify_url=<http_url>		POST a JSON payload with job id, status, output prefix and counters
+//   notify_cmd=<hook_name>		run the command of this name in the hooks folder of this node with the
+//								same payload on stdin
+func parseCompletionHook(options map[string]string) (util.CompletionHook, error) {
+	hook := util.CompletionHook{
+		Url:     options["notify_url"],
+		Command: options["notify_cmd"],
+	}
+	if len(hook.Url) > 0 {
+		parsed, err := url.Parse(hook.Url)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+			return hook, errors.New(fmt.Sprintf("Invalid notify_url (%s)", hook.Url))
+		}
+	}
+	if len(hook.Command) > 0 && !util.IsValidHookName(hook.Command) {
+		return hook, errors.New(fmt.Sprintf("Invalid notify_cmd (%s), expected the name of a command in the hooks folder", hook.Command))
+	}
+	return hook, nil
+}
+
+// job options overriding config defaults for timeouts, retries and sandbox limits
+//   job_timeout=<minutes>		how long to wait for the whole job
+//   task_timeout=<minutes>		per task attempt
+//   exec_timeout=<minutes>		wall clock limit of executable runs on worker nodes
+//   retries=<num>				maximum number of retries per task
+//   backoff=<millis>			delay before the first retry, doubled for each further retry
+//   backoff_max=<millis>		upper bound of the retry delay
+//   mem_limit=<MB>				memory limit of executables, 0 for unlimited
+//   cpu_limit=<seconds>		cpu time limit of executables, 0 for unlimited
+//   fsize_limit=<MB>			largest file executables may write, 0 for unlimited
+//   proc_limit=<num>			process limit of executables, 0 for unlimited
+var jobPolicyOptionKeys = []string{"job_timeout", "task_timeout", "exec_timeout", "retries", "backoff", "backoff_max", "mem_limit", "cpu_limit", "fsize_limit", "proc_limit"}
+
+func parseJobPolicy(options map[string]string) (util.JobPolicy, error) {
+	policy := util.NewJobPolicy()
+	fields := map[string]*int{
+		"job_timeout":  &policy.JobTimeoutMinutes,
+		"task_timeout": &policy.TaskTimeoutMinutes,
+		"exec_timeout": &policy.ExecutionTimeoutMinutes,
+		"retries":      &policy.MaxRetryNum,
+		"backoff":      &policy.RetryBackoffBaseMillis,
+		"backoff_max":  &policy.RetryBackoffMaxMillis,
+		"mem_limit":    &policy.Limits.MemoryLimitMB,
+		"cpu_limit":    &policy.Limits.CpuLimitSeconds,
+		"fsize_limit":  &policy.Limits.FileSizeLimitMB,
+		"proc_limit":   &policy.Limits.ProcessLimit,
+	}
+
+	for key, field := range fields {
+		value, exists := options[key]
+		if !exists {
+			continue
+		}
+		num, err := strconv.Atoi(value)
+		zeroAllowed := key == "retries" || strings.HasSuffix(key, "_limit")
+		if err != nil || num < 0 || (num == 0 && !zeroAllowed) {
+			return policy, errors.New(fmt.Sprintf("Invalid value for job option %s", key))
+		}
+		*field = num
+	}
+	return policy, nil
+}
+
+// parse optional key=value arguments following the positional arguments of a job command
+func parseJobOptions(args []string, allowedKeys []string) (map[string]string, error) {
+	options := make(map[string]string)
+	for _, arg := range args {
+		if len(arg) == 0 {
+			continue
+		}
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 || len(kv[1]) == 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid job option (%s), expecting key=value", arg))
+		}
+
+		allowed := false
+		for _, key := range allowedKeys {
+			if key == kv[0] {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, errors.New(fmt.Sprintf("Unsupported job option (%s)", kv[0]))
+		}
+		options[kv[0]] = kv[1]
+	}
+	return options, nil
+}
+
+func parseCacheFiles(option string) []string {
+	cacheFiles := make([]string, 0)
+	for _, fileName := range strings.Split(option, ",") {
+		fileName = strings.TrimSpace(fileName)
+		if len(fileName) > 0 {
+			cacheFiles = append(cacheFiles, fileName)
+		}
+	}
+	return cacheFiles
+}
 
 func dialMRJobManager() *rpc.Client {
+	return dialMRJobManagerPath(rpc.DefaultRPCPath)
+}
+
+// jobs and schedules are submitted on their own path, see MR_job_submission.go
+func dialMRJobSubmission() *rpc.Client {
+	return dialMRJobManagerPath(MR_JOB_SUBMISSION_RPC_PATH)
+}
+
+func dialMRJobManagerPath(path string) *rpc.Client {
 	leaderId := leaderelection.LeaderId
 
 	if len(leaderId) == 0{
@@ -143,7 +901,7 @@ func dialMRJobManager() *rpc.Client {
 	}
 
 	leaderIp := util.NodeIdToIP(leaderId)
-	client := util.Dial(leaderIp, config.RpcServerPort)
+	client := util.DialPath(leaderIp, config.RpcServerPort, path)
 	if client == nil {
 		log.Printf("Failed to establish connection with Maple Juice Job Manager at %s:%d", leaderId, config.RpcServerPort)
 	}
@@ -0,0 +1,152 @@
+package maplejuice
+
+import (
+	"maple-juice/config"
+	"maple-juice/util"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// completion hooks: once a job succeeds, fails or is cancelled the job manager POSTs a JSON payload to
+// the hook URL and / or asks the node manager of the submitting node to run the hook command with the
+// payload on stdin. The submitting node is the one the job was submitted from, see MR_job_submission.go,
+// and it looks the command up by name in its own hooks folder, so that a job can only run commands the
+// node operator installed. Hooks are best effort, failures are only logged.
+
+const (
+	HOOK_HTTP_TIMEOUT    time.Duration = 10 * time.Second
+	HOOK_HTTP_RETRY_NUM  int           = 3
+	HOOK_COMMAND_TIMEOUT time.Duration = 1 * time.Minute
+)
+
+func (this *MRJobManager) notifyCompletion(job *util.JobRequest, status string, counters *JobCounters, err error) {
+	payload := util.JobCompletionPayload{
+		JobId:        job.JobId,
+		Status:       status,
+		OutputPrefix: jobOutputPrefix(job),
+		Counters:     counters.Snapshot(),
+	}
+	if err != nil {
+		payload.Error = err.Error()
+	}
+
+	content, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode completion payload of job %d: %s", job.JobId, err.Error())
+		return
+	}
+
+	if len(job.Hook.Url) > 0 {
+		err := postCompletionPayload(job.Hook.Url, content)
+		if err != nil {
+			log.Printf("Failed to notify %s of the completion of job %d: %s", job.Hook.Url, job.JobId, err.Error())
+		}
+	}
+
+	if len(job.Hook.Command) > 0 {
+		err := runRemoteCompletionCommand(job.Hook.SubmitterIp, job.Hook.Command, content)
+		if err != nil {
+			log.Printf("Failed to run completion command %s of job %d at %s: %s", job.Hook.Command, job.JobId, job.Hook.SubmitterIp, err.Error())
+		}
+	}
+}
+
+func postCompletionPayload(url string, payload []byte) error {
+	client := &http.Client{Timeout: HOOK_HTTP_TIMEOUT}
+
+	var err error
+	for attempt := 0; attempt < HOOK_HTTP_RETRY_NUM; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+
+		var response *http.Response
+		response, err = client.Post(url, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			continue
+		}
+		response.Body.Close()
+		if response.StatusCode >= 200 && response.StatusCode < 300 {
+			return nil
+		}
+		err = errors.New(fmt.Sprintf("hook responded with status %s", response.Status))
+	}
+	return err
+}
+
+func runRemoteCompletionCommand(submitterIp string, command string, payload []byte) error {
+	if len(submitterIp) == 0 {
+		return errors.New("submitting node is unknown")
+	}
+	client := util.Dial(submitterIp, config.RpcServerPort)
+	if client == nil {
+		return errors.New("Cannot connect to submitting node")
+	}
+	defer client.Close()
+
+	reply := ""
+	return client.Call("MRNodeManager.RunCompletionHook", &util.CompletionHookArg{Command: command, Payload: payload}, &reply)
+}
+
+// run a command of the hooks folder of this node in it, for a job submitted by this node. The payload
+// is written to its stdin and job id and status are also passed as MJ_JOB_ID and MJ_JOB_STATUS
+func (this *MRNodeManager) RunCompletionHook(args *util.CompletionHookArg, reply *string) error {
+	if !util.IsValidHookName(args.Command) {
+		return errors.New(fmt.Sprintf("invalid hook name %s", args.Command))
+	}
+	payload := util.JobCompletionPayload{}
+	err := json.Unmarshal(args.Payload, &payload)
+	if err != nil {
+		return err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(config.HookFileDir + args.Command)
+	cmd.Dir = config.HookFileDir
+	cmd.Env = append(os.Environ(), "MJ_JOB_ID="+strconv.Itoa(int(payload.JobId)), "MJ_JOB_STATUS="+payload.Status)
+	cmd.Stdin = bytes.NewReader(args.Payload)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	timer := time.AfterFunc(HOOK_COMMAND_TIMEOUT, func() {
+		cmd.Process.Kill()
+	})
+	err = cmd.Wait()
+	timer.Stop()
+
+	log.Printf("Completion command %s of job %d finished with output: %s%s", args.Command, payload.JobId, stdout.String(), stderr.String())
+	if err != nil {
+		return err
+	}
+	*reply = "ACK"
+	return nil
+}
+
+// SDFS name or prefix under which the results of a job are stored
+func jobOutputPrefix(job *util.JobRequest) string {
+	if job.IsIterative {
+		return job.IterativeJob.JuiceJob.OutputFileName
+	}
+	if job.IsSort {
+		return job.SortJob.OutputFileName
+	}
+	if job.IsMaple {
+		if job.MapleJob.IsMapOnly() {
+			return job.MapleJob.OutputFileName
+		}
+		return job.MapleJob.OutputFilePrefix
+	}
+	return job.JuiceJob.OutputFileName
+}
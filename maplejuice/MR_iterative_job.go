@@ -0,0 +1,238 @@
+package maplejuice
+
+import (
+	"maple-juice/config"
+	"maple-juice/dfs"
+	"maple-juice/util"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// iterative jobs run the same maple / juice pair until the output converges or the maximum number of
+// iterations is reached. Iteration i reads the output of iteration i-1 and writes <dest>_iter<i>,
+// intermediate files go to <intermediate_prefix>_iter<i>. The final output is also stored as <dest>.
+// Convergence is checked after every iteration with a user counter threshold and / or a convergence
+// executable run on the leader as:
+//   <executable> -prev <previous_output_path> -curr <current_output_path>
+// which prints true once the output has converged, false otherwise.
+
+// counters of all iterations are summed into counters, task attempts of all phases are added to record
+func (this *MRJobManager) executeIterativeJob(job *util.IterativeJobRequest, policy *util.JobPolicy, errorMsgChan *chan error, jobId int32, counters *JobCounters, record *JobRecord) {
+	*errorMsgChan <- this.runIterations(job, policy, jobId, counters, record)
+}
+
+func (this *MRJobManager) runIterations(job *util.IterativeJobRequest, policy *util.JobPolicy, jobId int32, jobCounters *JobCounters, record *JobRecord) error {
+	if job.MaxIterations <= 0 {
+		return errors.New(fmt.Sprintf("Invalid maximum number of iterations %d", job.MaxIterations))
+	}
+
+	inputFileName := job.MapleJob.SrcSdfsFileName
+	outputFileNames := make([]string, 0)
+	defer os.Remove(config.JobManagerFileDir + inputFileName)
+
+	for iteration := 1; iteration <= job.MaxIterations; iteration++ {
+		if this.isJobCancelled(jobId) {
+			return errJobCancelled
+		}
+		log.Printf("Iterative job %d: starting iteration %d", jobId, iteration)
+		outputFileName := fmtIterationName(job.JuiceJob.OutputFileName, iteration)
+		counters := NewJobCounters()
+
+		err := this.runIteration(job, policy, iteration, inputFileName, outputFileName, counters, record)
+		jobCounters.Add(counters.Snapshot())
+		if err != nil {
+			return errors.New(fmt.Sprintf("Iteration %d failed: %s", iteration, err.Error()))
+		}
+		outputFileNames = append(outputFileNames, outputFileName)
+		log.Printf("Iterative job %d: iteration %d completed with counters: %s", jobId, iteration, counters.String())
+
+		converged, err := this.checkConvergence(job, policy, jobId, iteration, inputFileName, outputFileName, counters)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Convergence check of iteration %d failed: %s", iteration, err.Error()))
+		}
+
+		// keep only the last N iteration outputs, the job input is never removed
+		if job.KeepIterations > 0 && len(outputFileNames) > job.KeepIterations {
+			for _, fileName := range outputFileNames[:len(outputFileNames)-job.KeepIterations] {
+				log.Printf("Deleting output of an old iteration: %s", fileName)
+				dfs.SDFSDeleteFile(fileName)
+			}
+			outputFileNames = outputFileNames[len(outputFileNames)-job.KeepIterations:]
+		}
+
+		if iteration > 1 {
+			os.Remove(config.JobManagerFileDir + inputFileName)
+		}
+		inputFileName = outputFileName
+
+		if converged {
+			log.Printf("Iterative job %d converged after %d iterations", jobId, iteration)
+			break
+		}
+		if iteration == job.MaxIterations {
+			log.Printf("Iterative job %d reached the maximum of %d iterations", jobId, iteration)
+		}
+	}
+
+	// output of the last iteration is kept locally by consolidateIterationOutput
+	_, err := dfs.SDFSPutCompressedFile(job.JuiceJob.OutputFileName, config.JobManagerFileDir+inputFileName, job.JuiceJob.Compression)
+	os.Remove(config.JobManagerFileDir + inputFileName)
+	return err
+}
+
+// run one maple job and one juice job, each with its own job id, and consolidate the juice output
+// into a single SDFS file used as input of the next iteration
+func (this *MRJobManager) runIteration(job *util.IterativeJobRequest, policy *util.JobPolicy, iteration int, inputFileName string, outputFileName string, counters *JobCounters, record *JobRecord) error {
+	mapleJob := job.MapleJob
+	mapleJob.SrcSdfsFileName = inputFileName
+	mapleJob.OutputFilePrefix = fmtIterationName(job.MapleJob.OutputFilePrefix, iteration)
+	mapleJob.PreserveInputHeader = job.MapleJob.PreserveInputHeader && iteration == 1
+	mapleJob.OutputFileName = ""
+
+	mapleJobId := this.jobUuid.Add(1)
+	log.Printf("Iteration %d: running maple phase as job %d", iteration, mapleJobId)
+	errorMsgChan := make(chan error, 1)
+	this.executeMapleJob(&mapleJob, policy, &errorMsgChan, mapleJobId, counters, record)
+	if holdsNodeResources(mapleJob.CacheFiles, mapleJob.ExcecutableFileName) {
+		this.releaseJobCache(mapleJobId)
+	}
+	err := <-errorMsgChan
+	if err != nil {
+		return err
+	}
+
+	juiceJob := job.JuiceJob
+	juiceJob.SrcSdfsFilePrefix = mapleJob.OutputFilePrefix
+	juiceJob.OutputFileName = outputFileName
+	juiceJob.DeleteInput = true
+
+	juiceJobId := this.jobUuid.Add(1)
+	log.Printf("Iteration %d: running juice phase as job %d", iteration, juiceJobId)
+	this.executeJuiceJob(&juiceJob, &job.JuicePolicy, &errorMsgChan, juiceJobId, counters, record)
+	if holdsNodeResources(juiceJob.CacheFiles, juiceJob.ExcecutableFileName) {
+		this.releaseJobCache(juiceJobId)
+	}
+	err = <-errorMsgChan
+	if err != nil {
+		return err
+	}
+
+	return consolidateIterationOutput(outputFileName, juiceJob.Compression)
+}
+
+// concat juice outputs <output>-<key> in key order into the SDFS file <output>,
+// a local copy is kept under the job manager folder
+func consolidateIterationOutput(outputFileName string, compression string) error {
+	regexStr := "^" + regexp.QuoteMeta(outputFileName) + "-[^-]+$"
+	fileNames, err := dfs.SDFSSearchFileByRegex(regexStr)
+	if err != nil {
+		return err
+	}
+	sort.Strings(*fileNames)
+
+	localFilePath := config.JobManagerFileDir + outputFileName
+	os.Remove(localFilePath)
+	err = dfs.SDFSFetchAndConcat(*fileNames, outputFileName, dfs.RECEIVER_MR_JOB_MANAGER)
+	if err != nil {
+		return err
+	}
+
+	// juice tasks may produce no output at all
+	file, err := os.OpenFile(localFilePath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	file.Close()
+
+	err = util.CompressFile(localFilePath, compression)
+	if err != nil {
+		return err
+	}
+	_, err = dfs.SDFSPutCompressedFile(outputFileName, localFilePath, compression)
+	if err != nil {
+		return err
+	}
+
+	for _, fileName := range *fileNames {
+		dfs.SDFSDeleteFile(fileName)
+	}
+	return nil
+}
+
+func (this *MRJobManager) checkConvergence(job *util.IterativeJobRequest, policy *util.JobPolicy, jobId int32, iteration int, prevFileName string, currFileName string, counters *JobCounters) (bool, error) {
+	if len(job.ConvergenceCounter) > 0 {
+		// a counter no task reported says nothing about convergence, executables report it even when it is 0
+		value, ok := counters.Lookup(job.ConvergenceCounter)
+		if !ok {
+			log.Printf("Iteration %d: convergence counter %s was not reported by any task, not converged", iteration, job.ConvergenceCounter)
+		} else {
+			log.Printf("Iteration %d: counter %s is %d, convergence threshold is %d", iteration, job.ConvergenceCounter, value, job.ConvergenceThreshold)
+			if value <= job.ConvergenceThreshold {
+				return true, nil
+			}
+		}
+	}
+
+	if len(job.ConvergenceExecutable) > 0 {
+		return runConvergenceExecutable(job.ConvergenceExecutable, policy, jobId, iteration, prevFileName, currFileName)
+	}
+	return false, nil
+}
+
+func runConvergenceExecutable(executableFileName string, policy *util.JobPolicy, jobId int32, iteration int, prevFileName string, currFileName string) (bool, error) {
+	err := dfs.SDFSGetFile(executableFileName, executableFileName, dfs.RECEIVER_MR_JOB_MANAGER)
+	if err != nil {
+		return false, err
+	}
+
+	sandbox, err := NewTaskSandbox(fmt.Sprintf("job%d_converge_iteration%d", jobId, iteration), policy.Limits)
+	if err != nil {
+		return false, err
+	}
+	defer sandbox.Destroy()
+
+	binaryPath, err := sandbox.Build(config.JobManagerFileDir+executableFileName, nil)
+	if err != nil {
+		return false, err
+	}
+
+	// fetch both outputs again, decompressed
+	inputFilePaths := make([]string, 0)
+	for _, fileName := range []string{prevFileName, currFileName} {
+		localFileName := fmt.Sprintf("converge_job%d_%s", jobId, fileName)
+		os.Remove(config.JobManagerFileDir + localFileName)
+		err := dfs.SDFSFetchAndConcat([]string{fileName}, localFileName, dfs.RECEIVER_MR_JOB_MANAGER)
+		if err != nil {
+			return false, err
+		}
+		filePath, err := sandbox.Import(config.JobManagerFileDir+localFileName, localFileName)
+		if err != nil {
+			return false, err
+		}
+		inputFilePaths = append(inputFilePaths, filePath)
+	}
+
+	cmdArgs := []string{"-prev", inputFilePaths[0], "-curr", inputFilePaths[1]}
+	stdout, err := sandbox.Run(binaryPath, cmdArgs, nil, executionTimeout(policy.ExecutionTimeoutMinutes), "", nil)
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.TrimSpace(stdout) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, util.NewTaskFailure(util.TASK_FAILURE_EXECUTABLE, fmt.Sprintf("convergence executable printed %q, expecting true or false", strings.TrimSpace(stdout)))
+}
+
+// file names carry no dashes as those separate keys in intermediate file names
+func fmtIterationName(name string, iteration int) string {
+	return fmt.Sprintf("%s_iter%d", name, iteration)
+}
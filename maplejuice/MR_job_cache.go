@@ -0,0 +1,121 @@
+package maplejuice
+
+import (
+	"maple-juice/config"
+	"maple-juice/dfs"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// distributed cache: side files listed in a job request are fetched from SDFS once per node per job
+// and exposed to executables through the MJ_CACHE_DIR environment variable. Job ids restart with every
+// leader, so caches are kept per leader node id and job id and a job of a new leader never reuses the
+// files of an old one
+
+const (
+	CACHE_DIR_ENV_VAR string = "MJ_CACHE_DIR"
+)
+
+type jobCache struct {
+	ready chan struct{} // closed once fetching completes
+	err   error
+}
+
+type JobCacheManager struct {
+	caches map[string]*jobCache // by cache folder name
+	lock   sync.Mutex
+}
+
+func NewJobCacheManager() *JobCacheManager {
+	return &JobCacheManager{
+		caches: make(map[string]*jobCache),
+	}
+}
+
+// clean up caches left over by a previous run
+func (this *JobCacheManager) Reset() {
+	err := os.RemoveAll(config.CacheFileDir)
+	if err != nil {
+		log.Print("Failed to clean up job cache folder", err)
+	}
+	err = os.MkdirAll(config.CacheFileDir, 0755)
+	if err != nil {
+		log.Print("Failed to create job cache folder", err)
+	}
+}
+
+// fetch cache files of a job if not done yet on this node, blocks until files are available
+// returns environment variables exposing the cache to executables
+func (this *JobCacheManager) Acquire(leaderId string, jobId int32, fileNames []string) ([]string, error) {
+	if len(fileNames) == 0 {
+		return nil, nil
+	}
+
+	dirName := fmtJobCacheDirName(leaderId, jobId)
+	this.lock.Lock()
+	cache, exists := this.caches[dirName]
+	if !exists {
+		cache = &jobCache{ready: make(chan struct{})}
+		this.caches[dirName] = cache
+	}
+	this.lock.Unlock()
+
+	if exists {
+		<-cache.ready
+	} else {
+		cache.err = fetchCacheFiles(dirName, jobId, fileNames)
+		close(cache.ready)
+		if cache.err != nil {
+			// let the next attempt fetch again
+			this.lock.Lock()
+			delete(this.caches, dirName)
+			this.lock.Unlock()
+		}
+	}
+
+	if cache.err != nil {
+		return nil, cache.err
+	}
+	return []string{CACHE_DIR_ENV_VAR + "=" + config.CacheFileDir + dirName}, nil
+}
+
+// remove cached files of a completed job
+func (this *JobCacheManager) Release(leaderId string, jobId int32) {
+	dirName := fmtJobCacheDirName(leaderId, jobId)
+	this.lock.Lock()
+	delete(this.caches, dirName)
+	this.lock.Unlock()
+
+	err := os.RemoveAll(config.CacheFileDir + dirName)
+	if err != nil {
+		log.Printf("Failed to clean up cache for job %d: %s", jobId, err.Error())
+	}
+}
+
+func fetchCacheFiles(dirName string, jobId int32, fileNames []string) error {
+	err := os.RemoveAll(config.CacheFileDir + dirName)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(config.CacheFileDir+dirName, 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, fileName := range fileNames {
+		log.Printf("Fetching cache file %s for job %d", fileName, jobId)
+		err := dfs.SDFSGetFile(fileName, dirName+"/"+fileName, dfs.RECEIVER_MR_CACHE)
+		if err != nil {
+			log.Printf("Encountered error fetching cache file %s from SDFS: %s", fileName, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+func fmtJobCacheDirName(leaderId string, jobId int32) string {
+	return fmt.Sprintf("job%d_%s", jobId, strings.ReplaceAll(leaderId, ":", "_"))
+}
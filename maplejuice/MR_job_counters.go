@@ -0,0 +1,105 @@
+package maplejuice
+
+import (
+	"bufio"
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// user counters: executables report counters by printing lines of the form
+//   MJ_COUNTER <name> <delta>
+// to stderr. Counters of a task attempt are summed by the node manager, counters of
+// successful attempts are summed by the job manager.
+
+const (
+	COUNTER_LINE_PREFIX string = "MJ_COUNTER "
+)
+
+type JobCounters struct {
+	counters map[string]int64
+	lock     sync.Mutex
+}
+
+func NewJobCounters() *JobCounters {
+	return &JobCounters{
+		counters: make(map[string]int64),
+	}
+}
+
+func (this *JobCounters) Add(counters map[string]int64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for name, value := range counters {
+		this.counters[name] += value
+	}
+}
+
+func (this *JobCounters) Get(name string) int64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	return this.counters[name]
+}
+
+// value of a counter and whether any task attempt reported it
+func (this *JobCounters) Lookup(name string) (int64, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	value, ok := this.counters[name]
+	return value, ok
+}
+
+func (this *JobCounters) Snapshot() map[string]int64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	snapshot := make(map[string]int64)
+	for name, value := range this.counters {
+		snapshot[name] = value
+	}
+	return snapshot
+}
+
+// name=value pairs sorted by name
+func (this *JobCounters) String() string {
+	snapshot := this.Snapshot()
+	names := make([]string, 0)
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0)
+	for _, name := range names {
+		pairs = append(pairs, name+"="+strconv.FormatInt(snapshot[name], 10))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// sum counter lines in the stderr of an executable run, malformed counter lines are ignored
+func parseCounterLines(stderr []byte) map[string]int64 {
+	counters := make(map[string]int64)
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(stderr)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, COUNTER_LINE_PREFIX) {
+			continue
+		}
+		fields := strings.Fields(line[len(COUNTER_LINE_PREFIX):])
+		if len(fields) != 2 {
+			continue
+		}
+		delta, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[fields[0]] += delta
+	}
+	return counters
+}
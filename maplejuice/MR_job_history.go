@@ -0,0 +1,355 @@
+package maplejuice
+
+import (
+	"maple-juice/config"
+	"maple-juice/dfs"
+	"maple-juice/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// job history: the job manager records every job it executes along with the timing of each task attempt
+// and stores the record in SDFS as JSON once the job ends, so records survive leader failover.
+// Job ids restart after a failover, record names carry the start time to keep them apart.
+
+const (
+	JOB_STATUS_SUCCEEDED string = "SUCCEEDED"
+	JOB_STATUS_FAILED    string = "FAILED"
+	JOB_STATUS_CANCELLED string = "CANCELLED"
+
+	JOB_TYPE_MAPLE     string = "maple"
+	JOB_TYPE_JUICE     string = "juice"
+	JOB_TYPE_ITERATIVE string = "iterative"
+	JOB_TYPE_SORT      string = "sort"
+)
+
+type JobRecord struct {
+	JobId          int32                    `json:"job_id"`
+	Type           string                   `json:"type"`
+	Spec           JobSpec                  `json:"spec"`
+	Status         string                   `json:"status"`
+	Error          string                   `json:"error,omitempty"`
+	StartTime      time.Time                `json:"start_time"`
+	EndTime        time.Time                `json:"end_time"`
+	DurationMillis int64                    `json:"duration_millis"`
+	Counters       map[string]int64         `json:"counters"`
+	Attempts       []*TaskAttemptRecord     `json:"attempts"`
+	lock           sync.Mutex
+}
+
+// job request as submitted, before task numbers and defaults are resolved
+type JobSpec struct {
+	Policy       util.JobPolicy            `json:"policy"`
+	MapleJob     *util.MapleJobRequest     `json:"maple_job,omitempty"`
+	JuiceJob     *util.JuiceJobRequest     `json:"juice_job,omitempty"`
+	IterativeJob *util.IterativeJobRequest `json:"iterative_job,omitempty"`
+	SortJob      *util.SortJobRequest      `json:"sort_job,omitempty"`
+}
+
+// one attempt of a maple or juice task, phases of iterative jobs run with their own job ids
+type TaskAttemptRecord struct {
+	JobId          int32     `json:"job_id"`
+	Type           string    `json:"type"`
+	TaskNumber     int       `json:"task_number"`
+	Attempt        int       `json:"attempt"`
+	Worker         string    `json:"worker"`
+	StartTime      time.Time `json:"start_time"`
+	EndTime        time.Time `json:"end_time"`
+	DurationMillis int64     `json:"duration_millis"`
+	InputBytes     int64     `json:"input_bytes"`
+	OutputBytes    int64     `json:"output_bytes"`
+	FailureReason  string    `json:"failure_reason,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+func NewJobRecord(job *util.JobRequest) *JobRecord {
+	record := &JobRecord{
+		JobId:     job.JobId,
+		Spec:      JobSpec{Policy: job.Policy},
+		StartTime: time.Now(),
+		Attempts:  make([]*TaskAttemptRecord, 0),
+	}
+
+	// copy the requests, task numbers are changed during execution
+	if job.IsIterative {
+		record.Type = JOB_TYPE_ITERATIVE
+		iterativeJob := job.IterativeJob
+		record.Spec.IterativeJob = &iterativeJob
+	} else if job.IsSort {
+		record.Type = JOB_TYPE_SORT
+		sortJob := job.SortJob
+		record.Spec.SortJob = &sortJob
+	} else if job.IsMaple {
+		record.Type = JOB_TYPE_MAPLE
+		mapleJob := job.MapleJob
+		record.Spec.MapleJob = &mapleJob
+	} else {
+		record.Type = JOB_TYPE_JUICE
+		juiceJob := job.JuiceJob
+		record.Spec.JuiceJob = &juiceJob
+	}
+	return record
+}
+
+func NewTaskAttemptRecord(jobId int32, isMaple bool, taskNumber int, attempt int) *TaskAttemptRecord {
+	taskType := JOB_TYPE_MAPLE
+	if !isMaple {
+		taskType = JOB_TYPE_JUICE
+	}
+	return &TaskAttemptRecord{
+		JobId:      jobId,
+		Type:       taskType,
+		TaskNumber: taskNumber,
+		Attempt:    attempt,
+		StartTime:  time.Now(),
+	}
+}
+
+// complete an attempt with the reply of the worker, the reply is only read for successful attempts
+// as a timed out rpc call may still be writing it
+func (this *TaskAttemptRecord) Finish(result *util.TaskResult, err error) {
+	this.EndTime = time.Now()
+	this.DurationMillis = this.EndTime.Sub(this.StartTime).Milliseconds()
+	if err != nil {
+		this.FailureReason = util.GetTaskFailureReason(err)
+		this.Error = err.Error()
+		return
+	}
+	if result.InputBytes > 0 {
+		this.InputBytes = result.InputBytes
+	}
+	this.OutputBytes = result.OutputBytes
+}
+
+func (this *JobRecord) AddAttempt(attempt *TaskAttemptRecord) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.Attempts = append(this.Attempts, attempt)
+}
+
+func (this *JobRecord) Finish(status string, err error, counters *JobCounters) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.EndTime = time.Now()
+	this.DurationMillis = this.EndTime.Sub(this.StartTime).Milliseconds()
+	this.Counters = counters.Snapshot()
+	this.Status = status
+	if err != nil {
+		this.Error = err.Error()
+	}
+}
+
+// write the record as JSON to SDFS
+func (this *JobRecord) Save() error {
+	this.lock.Lock()
+	content, err := json.MarshalIndent(this, "", "  ")
+	this.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	fileName := fmtJobRecordName(this.JobId, this.StartTime)
+	err = os.WriteFile(config.JobManagerFileDir+fileName, content, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(config.JobManagerFileDir + fileName)
+
+	_, err = dfs.SDFSPutFile(fileName, config.JobManagerFileDir+fileName)
+	return err
+}
+
+func fmtJobRecordName(jobId int32, startTime time.Time) string {
+	return fmt.Sprintf("mjhistory_%d_job%d.json", startTime.UnixMilli(), jobId)
+}
+
+// job id is optional
+func fmtJobRecordRegex(jobId string) string {
+	if len(jobId) == 0 {
+		jobId = "\\d+"
+	}
+	return fmt.Sprintf("^mjhistory_\\d+_job%s\\.json$", jobId)
+}
+
+// job history [job_id]
+// list all recorded jobs, or print the task timing breakdown of every run of a job id
+func printJobHistory(args []string) error {
+	if len(args) > 1 {
+		log.Print("Usage: job history [job_id]")
+		return errors.New("Invalid job history command")
+	}
+
+	if len(args) == 0 {
+		records, err := fetchJobRecords("")
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			fmt.Println("No job history found")
+			return nil
+		}
+		fmt.Printf("%-8s %-10s %-10s %-25s %12s %8s %9s\n", "JOB", "TYPE", "STATUS", "START", "DURATION_MS", "TASKS", "ATTEMPTS")
+		for _, record := range records {
+			fmt.Printf("%-8d %-10s %-10s %-25s %12d %8d %9d\n", record.JobId, record.Type, record.Status,
+				record.StartTime.Format(time.RFC3339), record.DurationMillis, countTasks(record), len(record.Attempts))
+		}
+		return nil
+	}
+
+	jobId, err := parseJobId(args[0])
+	if err != nil {
+		return err
+	}
+	records, err := fetchJobRecords(jobId)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Printf("No job history found for job %s\n", jobId)
+		return nil
+	}
+	for _, record := range records {
+		printJobRecord(record)
+	}
+	return nil
+}
+
+// job export <local_filename> [job_id]
+// write job records as a JSON array to the local folder
+func exportJobHistory(args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		log.Print("Usage: job export <local_filename> [job_id]")
+		return errors.New("Invalid job export command")
+	}
+
+	jobId := ""
+	if len(args) == 2 {
+		var err error
+		jobId, err = parseJobId(args[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	records, err := fetchJobRecords(jobId)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(config.LocalFileDir+args[0], content, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d job records to %s in local folder\n", len(records), args[0])
+	return nil
+}
+
+func printJobRecord(record *JobRecord) {
+	fmt.Printf("========== job %d (%s) ==========\n", record.JobId, record.Type)
+	fmt.Printf("status:   %s\n", record.Status)
+	if len(record.Error) > 0 {
+		fmt.Printf("error:    %s\n", record.Error)
+	}
+	fmt.Printf("start:    %s\n", record.StartTime.Format(time.RFC3339Nano))
+	fmt.Printf("end:      %s\n", record.EndTime.Format(time.RFC3339Nano))
+	fmt.Printf("duration: %d ms\n", record.DurationMillis)
+	spec, err := json.Marshal(record.Spec)
+	if err == nil {
+		fmt.Printf("spec:     %s\n", string(spec))
+	}
+	if len(record.Counters) > 0 {
+		counters := NewJobCounters()
+		counters.Add(record.Counters)
+		fmt.Printf("counters: %s\n", counters.String())
+	}
+
+	fmt.Printf("%-8s %-6s %5s %8s %-16s %12s %12s %12s  %s\n", "JOB", "TYPE", "TASK", "ATTEMPT", "WORKER", "DURATION_MS", "BYTES_IN", "BYTES_OUT", "FAILURE")
+	for _, attempt := range record.Attempts {
+		fmt.Printf("%-8d %-6s %5d %8d %-16s %12d %12d %12d  %s\n", attempt.JobId, attempt.Type, attempt.TaskNumber, attempt.Attempt,
+			attempt.Worker, attempt.DurationMillis, attempt.InputBytes, attempt.OutputBytes, attempt.FailureReason)
+	}
+	fmt.Println()
+}
+
+// fetch records of a job id, or all records if jobId is empty, sorted by start time
+func fetchJobRecords(jobId string) ([]*JobRecord, error) {
+	fileNames, err := dfs.SDFSSearchFileByRegex(fmtJobRecordRegex(jobId))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*JobRecord, 0)
+	for _, fileName := range *fileNames {
+		err := dfs.SDFSGetFile(fileName, fileName, dfs.RECEIVER_SDFS_CLIENT)
+		if err != nil {
+			log.Printf("Failed to fetch job record %s: %s", fileName, err.Error())
+			continue
+		}
+
+		content, err := os.ReadFile(config.LocalFileDir + fileName)
+		os.Remove(config.LocalFileDir + fileName)
+		if err != nil {
+			log.Printf("Failed to read job record %s: %s", fileName, err.Error())
+			continue
+		}
+
+		record := &JobRecord{}
+		err = json.Unmarshal(content, record)
+		if err != nil {
+			log.Printf("Malformed job record %s: %s", fileName, err.Error())
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartTime.Before(records[j].StartTime)
+	})
+	for _, record := range records {
+		sortAttempts(record.Attempts)
+	}
+	return records, nil
+}
+
+func sortAttempts(attempts []*TaskAttemptRecord) {
+	sort.SliceStable(attempts, func(i, j int) bool {
+		a, b := attempts[i], attempts[j]
+		if a.JobId != b.JobId {
+			return a.JobId < b.JobId
+		}
+		if a.TaskNumber != b.TaskNumber {
+			return a.TaskNumber < b.TaskNumber
+		}
+		return a.Attempt < b.Attempt
+	})
+}
+
+// number of distinct tasks across all phases of a job
+func countTasks(record *JobRecord) int {
+	tasks := make(map[string]bool)
+	for _, attempt := range record.Attempts {
+		tasks[fmt.Sprintf("%d-%s-%d", attempt.JobId, attempt.Type, attempt.TaskNumber)] = true
+	}
+	return len(tasks)
+}
+
+func parseJobId(value string) (string, error) {
+	_, err := strconv.Atoi(value)
+	if err != nil || strings.HasPrefix(value, "-") {
+		log.Print("Invalid job id")
+		return "", errors.New("Invalid job id")
+	}
+	return value, nil
+}
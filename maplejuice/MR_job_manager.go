@@ -1,7 +1,6 @@
 package maplejuice
 
 import (
-	"bufio"
 	"maple-juice/config"
 	"maple-juice/util"
 	"maple-juice/leaderelection"
@@ -12,6 +11,7 @@ import (
 	"hash"
 	"hash/fnv"
 	"log"
+	"net/http"
 	"net/rpc"
 	"os"
 	"regexp"
@@ -24,13 +24,11 @@ import (
 )
 
 const (
-	TASK_MAX_RETY_NUM int = 5 // maximum number of retry for each maple/juice sub task
-
 	FILE_PARTITION_BUF_SIZE    int = 32 * 1024
-	MAPLE_TASK_TIMEOUT_MINUTES int = 5
-	JUICE_TASK_TIMEOUT_MINUTES int = 5
 )
 
+var errJobCancelled = errors.New("Job cancelled")
+
 // hosted by leader, does the following:
 // 1. accepts and queue client submitted Maple/Juice jobs
 // 2. partitions input file for each Maple task
@@ -44,6 +42,9 @@ type MRJobManager struct {
 	mapLock                 sync.Mutex
 	transmissionIdGenerator *util.TransmissionIdGenerator
 	jobUuid                 atomic.Int32
+	intermediateBytes       map[string]int64 // intermediate file prefix -> bytes written by maple tasks
+	activeJobs              map[int32]bool   // queued or running job id -> whether the job is cancelled
+	scheduler               *JobScheduler
 }
 
 func NewMRJobManager() *MRJobManager {
@@ -51,43 +52,98 @@ func NewMRJobManager() *MRJobManager {
 		jobQueue:                make(chan *util.JobRequest, 100),
 		filePartitionBuf:        make([]byte, FILE_PARTITION_BUF_SIZE),
 		workerNode2Tasks:        make(map[string][]string),
+		intermediateBytes:       make(map[string]int64),
+		activeJobs:              make(map[int32]bool),
+		scheduler:               NewJobScheduler(),
 		transmissionIdGenerator: util.NewTransmissionIdGenerator("MR-JM-" + membership.SelfNodeId),
 	}
 }
 
-func (this *MRJobManager) SubmitJob(jobRequest *util.JobRequest, reply *string) error {
+// clients submit jobs through MRJobSubmission.SubmitJob, which records the submitting node
+func (this *MRJobManager) submitJob(jobRequest *util.JobRequest, reply *string) error {
 	if membership.SelfNodeId != leaderelection.LeaderId {
 		return errors.New("Please contact leader for Maple Juice job submission")
 	}
 
+	jobRequest.JobId = this.jobUuid.Add(1)
+	if jobRequest.IsIterative {
+		// juice phases fall back to juice task defaults
+		jobRequest.IterativeJob.JuicePolicy = jobRequest.Policy
+		jobRequest.IterativeJob.JuicePolicy.ApplyDefaults(false)
+	}
+	if jobRequest.IsSort {
+		jobRequest.SortJob.JuicePolicy = jobRequest.Policy
+		jobRequest.SortJob.JuicePolicy.ApplyDefaults(false)
+	}
+	jobRequest.Policy.ApplyDefaults(jobRequest.IsMaple || jobRequest.IsIterative || jobRequest.IsSort)
 	jobRequest.ErrorMsgChan = make(chan error, 1)
+	this.mapLock.Lock()
+	this.activeJobs[jobRequest.JobId] = false
+	this.mapLock.Unlock()
 	this.jobQueue <- jobRequest
 
-	timeout := time.After(10 * time.Minute)
+	timeout := time.After(time.Duration(jobRequest.Policy.JobTimeoutMinutes) * time.Minute)
 
 	for {
 		select {
 		case <-timeout:
-			return errors.New("Job execution times out")
+			// nobody waits for the result any more
+			this.cancelJob(jobRequest.JobId)
+			return errors.New(fmt.Sprintf("Job %d execution times out", jobRequest.JobId))
 		case err := <-jobRequest.ErrorMsgChan: // job completes with/ without error
 			if err != nil {
-				return err
+				return errors.New(fmt.Sprintf("Job %d failed: %s", jobRequest.JobId, err.Error()))
 			}
-			*reply = "ACK"
+			*reply = strconv.Itoa(int(jobRequest.JobId))
 			return nil
 		}
 	}
 }
 
+// cancel a queued or running job, tasks already running on workers are left to finish but their results are ignored
+func (this *MRJobManager) CancelJob(jobId *int32, reply *string) error {
+	if membership.SelfNodeId != leaderelection.LeaderId {
+		return errors.New("Please contact leader for Maple Juice job cancellation")
+	}
+	err := this.cancelJob(*jobId)
+	if err != nil {
+		return err
+	}
+	*reply = "ACK"
+	return nil
+}
+
+func (this *MRJobManager) cancelJob(jobId int32) error {
+	this.mapLock.Lock()
+	defer this.mapLock.Unlock()
+
+	_, exists := this.activeJobs[jobId]
+	if !exists {
+		return errors.New(fmt.Sprintf("Job %d is not queued or running", jobId))
+	}
+	log.Printf("Cancelling job %d", jobId)
+	this.activeJobs[jobId] = true
+	return nil
+}
+
+// phases of iterative jobs check the id of the iterative job
+func (this *MRJobManager) isJobCancelled(jobId int32) bool {
+	this.mapLock.Lock()
+	defer this.mapLock.Unlock()
+	return this.activeJobs[jobId]
+}
+
 // register this rpc service and start main thread
 func (this *MRJobManager) Register() {
 	rpc.Register(this)
+	http.Handle(MR_JOB_SUBMISSION_RPC_PATH, &jobSubmissionHandler{jobManager: this})
 	err := util.EmptyFolder(config.JobManagerFileDir)
 	if err != nil {
 		log.Print("Failed to clean up job manager file folder", err)
 	}
 
 	go this.listenForMembershipChange()
+	go this.runScheduler()
 
 	// todo: add graceful termination
 	go func() {
@@ -105,66 +161,133 @@ func (this *MRJobManager) executeJob(job *util.JobRequest) {
 		return
 	}
 
-	jobId := this.jobUuid.Add(1)
+	jobId := job.JobId
+
+	counters := NewJobCounters()
+	record := NewJobRecord(job)
+	errorMsgChan := make(chan error, 1)
 
-	if job.IsMaple {
-		this.executeMapleJob(&job.MapleJob, &job.ErrorMsgChan, jobId)
+	if this.isJobCancelled(jobId) {
+		errorMsgChan <- errJobCancelled
+	} else if job.IsIterative {
+		this.executeIterativeJob(&job.IterativeJob, &job.Policy, &errorMsgChan, jobId, counters, record)
+	} else if job.IsSort {
+		this.executeSortJob(&job.SortJob, &job.Policy, &errorMsgChan, jobId, counters, record)
+	} else if job.IsMaple {
+		this.executeMapleJob(&job.MapleJob, &job.Policy, &errorMsgChan, jobId, counters, record)
+		if holdsNodeResources(job.MapleJob.CacheFiles, job.MapleJob.ExcecutableFileName) {
+			this.releaseJobCache(jobId)
+		}
 	} else {
-		this.executeJuiceJob(&job.JuiceJob, &job.ErrorMsgChan, jobId)
+		this.executeJuiceJob(&job.JuiceJob, &job.Policy, &errorMsgChan, jobId, counters, record)
+		if holdsNodeResources(job.JuiceJob.CacheFiles, job.JuiceJob.ExcecutableFileName) {
+			this.releaseJobCache(jobId)
+		}
+	}
+
+	err := <-errorMsgChan
+	log.Printf("Job %d counters: %s", jobId, counters.String())
+
+	status := JOB_STATUS_SUCCEEDED
+	if err != nil {
+		status = JOB_STATUS_FAILED
+		if this.isJobCancelled(jobId) {
+			status = JOB_STATUS_CANCELLED
+		}
+	}
+	this.mapLock.Lock()
+	delete(this.activeJobs, jobId)
+	this.mapLock.Unlock()
+
+	record.Finish(status, err, counters)
+	job.ErrorMsgChan <- err
+
+	go func() {
+		err := record.Save()
+		if err != nil {
+			log.Printf("Failed to save history of job %d: %s", jobId, err.Error())
+		}
+	}()
+	if job.Hook.IsSet() {
+		go this.notifyCompletion(job, status, counters, err)
+	}
+}
+
+// best effort removal of distributed cache files and workspaces on all worker nodes
+func (this *MRJobManager) releaseJobCache(jobId int32) {
+	this.mapLock.Lock()
+	workerIps := make([]string, 0)
+	for workerIp := range this.workerNode2Tasks {
+		workerIps = append(workerIps, workerIp)
+	}
+	this.mapLock.Unlock()
+
+	for _, workerIp := range workerIps {
+		go func(ip string) {
+			client := util.Dial(ip, config.RpcServerPort)
+			if client == nil {
+				log.Printf("Cannot connect to node %s while releasing job cache", ip)
+				return
+			}
+			defer client.Close()
+
+			reply := ""
+			err := client.Call("MRNodeManager.ReleaseJobCache", &util.JobCacheArg{LeaderId: membership.SelfNodeId, JobId: jobId}, &reply)
+			if err != nil {
+				log.Printf("Failed to release cache of job %d at node %s: %s", jobId, ip, err.Error())
+			}
+		}(workerIp)
 	}
 }
 
-func (this *MRJobManager) executeMapleJob(job *util.MapleJobRequest, errorMsgChan *chan error, jobId int32) {
-	if job.TaskNum <= 0 {
+func (this *MRJobManager) executeMapleJob(job *util.MapleJobRequest, policy *util.JobPolicy, errorMsgChan *chan error, jobId int32, counters *JobCounters, record *JobRecord) {
+	if job.TaskNum < 0 {
 		*errorMsgChan <- errors.New(fmt.Sprintf("Invalid number of tasks %d", job.TaskNum))
 		return
 	}
 
-	// stage 1: fetch input file to local
+	// stage 1: fetch input file to local, decompressing it if it is the compressed output of another job
 	inputFileName := job.SrcSdfsFileName
-	err := dfs.SDFSGetFile(inputFileName, inputFileName, dfs.RECEIVER_MR_JOB_MANAGER)
+	os.Remove(config.JobManagerFileDir + inputFileName)
+	err := dfs.SDFSFetchAndConcat([]string{inputFileName}, inputFileName, dfs.RECEIVER_MR_JOB_MANAGER)
 	if err != nil {
 		*errorMsgChan <- err
 		return
 	}
 	log.Printf("Finished fetching input file")
 
-	// stage 2: profile input file
-	lineCount, err := util.GetFileLineCount(config.JobManagerFileDir + inputFileName)
-	if err != nil {
-		*errorMsgChan <- err
-		return
-	}
-
-	if job.PreserveInputHeader {
-		// ignore header line
-		log.Printf("Input file carries header")
-		lineCount -= 1
-	}
-
-	if lineCount == 0 {
-		*errorMsgChan <- errors.New("Maple input file contains zero data records")
-		return
+	if job.TaskNum == util.TASK_NUM_AUTO {
+		job.TaskNum, err = autoMapleTaskNum(job, config.JobManagerFileDir+inputFileName)
+		if err != nil {
+			*errorMsgChan <- err
+			return
+		}
 	}
 
-	log.Printf("Maple input file %s contains %d data records", inputFileName, lineCount)
-
-	// this should never happen
-	if lineCount < job.TaskNum {
-		log.Print("WARN: Maple input file contains less lines than the number of tasks, auto reducing task number...")
-		job.TaskNum = lineCount
+	// map-only job writes one output file per task
+	if job.IsMapOnly() && job.OutputFileNum > 0 && job.OutputFileNum != job.TaskNum {
+		log.Printf("Map-only job requested %d output files, using %d Maple tasks", job.OutputFileNum, job.OutputFileNum)
+		job.TaskNum = job.OutputFileNum
 	}
 
-	//stage3: partition input file and start Maple workers
-	linesPerWorker := lineCount / job.TaskNum
-	remainder := lineCount % job.TaskNum
-	file, err := os.Open(config.JobManagerFileDir + inputFileName)
+	// stage 2: profile and partition input file
+	taskNum, err := util.PartitionMapleInput(config.JobManagerFileDir+inputFileName, job.SrcSdfsFileName, job.TaskNum, job.PreserveInputHeader, job.InputFormat, config.JobManagerFileDir)
 	if err != nil {
 		*errorMsgChan <- err
 		return
 	}
-	defer file.Close()
+	job.TaskNum = taskNum
+
+	// outputs of an earlier job into the same destination would be fetched together with the new ones
+	if job.IsMapOnly() {
+		err = cleanUpMapOnlyOutput(job.OutputFileName)
+		if err != nil {
+			*errorMsgChan <- err
+			return
+		}
+	}
 
+	//stage3: start Maple workers
 	isTaskCompleted := make([]bool, job.TaskNum)
 	retryNum := make([]int, job.TaskNum)
 	taskResultChans := make([]chan error, job.TaskNum)
@@ -173,32 +296,9 @@ func (this *MRJobManager) executeMapleJob(job *util.MapleJobRequest, errorMsgCha
 		retryNum[idx] = 0
 	}
 
-	scanner := bufio.NewScanner(file)
-	var header string = ""
-
-	if job.PreserveInputHeader {
-		if !scanner.Scan() {
-			*errorMsgChan <- errors.New("Empty input file")
-			return
-		}
-		header = scanner.Text()
-	}
-
 	for taskNumber := 0; taskNumber < job.TaskNum; taskNumber++ {
-		lineNum := linesPerWorker
-		if remainder > 0 {
-			lineNum += 1
-			remainder -= 1
-		}
-		partitionName := util.FmtMapleInputPartitionName(job.SrcSdfsFileName, taskNumber)
-		err := util.PartitionFile(scanner, lineNum, config.JobManagerFileDir+partitionName, header)
-		if err != nil {
-			*errorMsgChan <- err
-			return
-		}
 		log.Printf("Starting initial maple task %d", taskNumber)
-
-		go this.startMapleWorker(taskNumber, job, &taskResultChans[taskNumber], jobId)
+		go this.startMapleWorker(taskNumber, 0, job, policy, &taskResultChans[taskNumber], jobId, counters, record)
 	}
 
 	// stage 4: track Maple worker progress and reschedule for failed tasks
@@ -206,6 +306,10 @@ func (this *MRJobManager) executeMapleJob(job *util.MapleJobRequest, errorMsgCha
 
 	for !jobCompleted {
 		time.Sleep(1 * time.Second) // check every second
+		if this.isJobCancelled(record.JobId) {
+			*errorMsgChan <- errJobCancelled
+			return
+		}
 		jobCompleted = true
 		for taskNumber := 0; taskNumber < job.TaskNum; taskNumber++ {
 			if isTaskCompleted[taskNumber] {
@@ -218,17 +322,24 @@ func (this *MRJobManager) executeMapleJob(job *util.MapleJobRequest, errorMsgCha
 				this.removeTask(taskId)
 				if err != nil {
 					log.Print(fmt.Sprintf("Maple task %d completed with error: ", taskNumber), err)
-					if retryNum[taskNumber] >= TASK_MAX_RETY_NUM {
+					if !util.IsRetryableTaskFailure(err) {
+						*errorMsgChan <- errors.New(fmt.Sprintf("Failing Maple task: task %d failed with non-retryable error: %s", taskNumber, err.Error()))
+						return
+					}
+					if retryNum[taskNumber] >= policy.MaxRetryNum {
 						*errorMsgChan <- errors.New(fmt.Sprintf("Failing Maple task:  task %d failed after %d retries", taskNumber, retryNum[taskNumber]))
 						return
 					}
-					// reschedule
+					// reschedule, SDFS cluster might be in repair so back off a bit
 
 					jobCompleted = false
 					retryNum[taskNumber]++
-					time.Sleep(1 * time.Second) // SDFS cluster might be in repair, lets wait a bit
-					log.Printf("Rescheduling Maple task %d ", taskNumber)
-					go this.startMapleWorker(taskNumber, job, &taskResultChans[taskNumber], jobId)
+					backoff := policy.RetryBackoff(retryNum[taskNumber])
+					log.Printf("Rescheduling Maple task %d in %s", taskNumber, backoff.String())
+					go func(taskNumber int, attempt int) {
+						time.Sleep(backoff)
+						this.startMapleWorker(taskNumber, attempt, job, policy, &taskResultChans[taskNumber], jobId, counters, record)
+					}(taskNumber, retryNum[taskNumber])
 				} else {
 					// task completed
 					isTaskCompleted[taskNumber] = true
@@ -243,88 +354,123 @@ func (this *MRJobManager) executeMapleJob(job *util.MapleJobRequest, errorMsgCha
 	*errorMsgChan <- nil
 }
 
-func (this *MRJobManager) startMapleWorker(taskNumber int, job *util.MapleJobRequest, resultChan *chan error, jobId int32) {
+func cleanUpMapOnlyOutput(outputFileName string) error {
+	fileNames, err := dfs.SDFSSearchFileByRegex("^" + regexp.QuoteMeta(outputFileName) + "-.*$")
+	if err != nil {
+		return err
+	}
+
+	var err1 error
+	for _, fileName := range *fileNames {
+		log.Printf("Deleting output of an earlier map-only job: " + fileName)
+		err1 = dfs.SDFSDeleteFile(fileName)
+	}
+	return err1
+}
+
+func (this *MRJobManager) startMapleWorker(taskNumber int, attempt int, job *util.MapleJobRequest, policy *util.JobPolicy, resultChan *chan error, jobId int32, counters *JobCounters, record *JobRecord) {
+	attemptRecord := NewTaskAttemptRecord(jobId, true, taskNumber, attempt)
+	result := &util.TaskResult{}
+	err := this.runMapleTask(taskNumber, attempt, job, policy, jobId, result, attemptRecord)
+	if err == nil {
+		counters.Add(result.Counters)
+		if !job.IsMapOnly() {
+			this.addIntermediateBytes(job.OutputFilePrefix, result.OutputBytes)
+		}
+	}
+	attemptRecord.Finish(result, err)
+	record.AddAttempt(attemptRecord)
+	*resultChan <- err
+}
+
+// send the input partition to a worker and run the task there, the worker's reply is written to result
+func (this *MRJobManager) runMapleTask(taskNumber int, attempt int, job *util.MapleJobRequest, policy *util.JobPolicy, jobId int32, result *util.TaskResult, attemptRecord *TaskAttemptRecord) error {
 
 	taskId := fmtTaskId(job.SrcSdfsFileName, true, taskNumber, jobId)
 	workerIp := this.assignTask(taskId)
 	if len(workerIp) == 0 {
-		*resultChan <- errors.New("Cannot find free worker") // this should never happen unless all worker nodes died
-		return
+		return errors.New("Cannot find free worker") // this should never happen unless all worker nodes died
 	}
+	attemptRecord.Worker = workerIp
+
 	taskArg := &util.MapleTaskArg{
 		InputFileName:       util.FmtMapleInputPartitionName(job.SrcSdfsFileName, taskNumber),
 		ExcecutableFileName: job.ExcecutableFileName,
 		OutputFilePrefix:    job.OutputFilePrefix,
+		InputFormat:         job.InputFormat,
+		LeaderId:            membership.SelfNodeId,
+		JobId:               jobId,
+		TaskNumber:          taskNumber,
+		Attempt:             attempt,
+		CacheFiles:          job.CacheFiles,
+		Compression:         job.Compression,
+		ExecutionTimeoutMinutes: policy.ExecutionTimeoutMinutes,
+		Limits:              policy.Limits,
+	}
+	if job.IsMapOnly() {
+		taskArg.OutputFileName = util.FmtMapOnlyOutputName(job.OutputFileName, taskNumber)
 	}
 
 	// send parition to worker
 	taskArg.TransmissionId = this.transmissionIdGenerator.NewTransmissionId(taskArg.InputFileName)
 	partitionFileName := taskArg.InputFileName
+	attemptRecord.InputBytes = totalFileSize(config.JobManagerFileDir, []string{partitionFileName})
 	workerAddr := workerIp + ":" + strconv.Itoa(config.FileReceivePort)
 	log.Printf("Send out partition %s with transmission id %s", partitionFileName, taskArg.TransmissionId)
 	err := dfs.SendFile(config.JobManagerFileDir+partitionFileName,
 		partitionFileName, workerAddr, taskArg.TransmissionId, dfs.RECEIVER_MR_NODE_MANAGER, dfs.WRITE_MODE_TRUNCATE)
 	if err != nil {
-		*resultChan <- err
-		return
+		return err
 	}
 
 	// instruct job start
 	client := util.Dial(workerIp, config.RpcServerPort)
 	if client == nil {
 		log.Printf("Cannot connect to node %s while starting Maple worker", workerIp)
-		*resultChan <- errors.New("Cannot connect to node")
-		return
+		return errors.New("Cannot connect to node")
 	}
 
 	defer client.Close()
 
-	retFlag := ""
-
-	call := client.Go("MRNodeManager.StartMapleTask", taskArg, &retFlag, nil)
+	call := client.Go("MRNodeManager.StartMapleTask", taskArg, result, nil)
 	if call.Error != nil {
 		log.Printf("Encountered error while starting Maple task %s via RPC", taskId)
-		*resultChan <- call.Error
-		return
+		return call.Error
 	}
 
-	timeout := time.After(time.Duration(MAPLE_TASK_TIMEOUT_MINUTES) * time.Minute)
+	timeout := time.After(time.Duration(policy.TaskTimeoutMinutes) * time.Minute)
 
 	select {
 	case <-timeout:
-		*resultChan <- errors.New("Timeout executing Maple task" + taskId)
-		return
+		return util.NewTaskFailure(util.TASK_FAILURE_TIMEOUT, "Timeout executing Maple task " + taskId)
 	case c, ok := <-call.Done: // check if channel has output ready
 		if !ok {
 			log.Println("MR Job Master: Channel closed for async rpc call")
-			*resultChan <- errors.New("Unexpected connection break down")
-			return
+			return errors.New("Unexpected connection break down")
 		} else {
-			if retFlag == "ACK" {
-				*resultChan <- nil
+			if c.Error == nil {
+				return nil
 			} else {
-				errMsg := fmt.Sprintf("MR Job Master: Juice task %s failed with error %s", taskId, c.Error.Error())
+				errMsg := fmt.Sprintf("MR Job Master: Maple task %s failed with error %s", taskId, c.Error.Error())
 				log.Print(errMsg)
-				*resultChan <- errors.New(errMsg)
+				return errors.New(errMsg)
 			}
-			return
 		}
 	}
 }
 
-func (this *MRJobManager) executeJuiceJob(job *util.JuiceJobRequest, errorMsgChan *chan error, jobId int32) {
-	if job.TaskNum <= 0 {
+func (this *MRJobManager) executeJuiceJob(job *util.JuiceJobRequest, policy *util.JobPolicy, errorMsgChan *chan error, jobId int32, counters *JobCounters, record *JobRecord) {
+	if job.TaskNum < 0 {
 		*errorMsgChan <- errors.New(fmt.Sprintf("Invalid number of tasks %d", job.TaskNum))
 		return
 	}
+	// the juice job is the last reader of the intermediate size, whatever its outcome
+	defer this.removeIntermediateBytes(job.SrcSdfsFilePrefix)
 
 	// stage 1: list all files related to each key
-	filePrefix := job.SrcSdfsFilePrefix
-	filePrefix = strings.Replace(filePrefix, ".", "\\.", -1) // escape dots in regex
-
 	// Maple outputs should be <file_name>-p<partition_num>-<key>
 	// file name and key should not contain dash
-	regexStr := filePrefix + "-p\\d+-.+"
+	regexStr := fmtJuiceInputRegex(job.SrcSdfsFilePrefix)
 	_, err := regexp.Compile(regexStr)
 	if err != nil {
 		*errorMsgChan <- err
@@ -340,20 +486,7 @@ func (this *MRJobManager) executeJuiceJob(job *util.JuiceJobRequest, errorMsgCha
 	log.Printf("Matched %d files", len(*matchedFiles))
 
 	// group file names by key
-	keyToFiles := make(map[string][]string)
-	for _, fileName := range *matchedFiles {
-		log.Printf("Matched files: %s", fileName)
-		splitted := strings.Split(fileName, "-")
-		if len(splitted) > 0 {
-			key := splitted[len(splitted)-1]
-			files, exists := keyToFiles[key]
-			if !exists {
-				files = make([]string, 0)
-			}
-			files = append(files, fileName)
-			keyToFiles[key] = files
-		}
-	}
+	keyToFiles := groupFilesByKey(*matchedFiles)
 
 	keys := make([]string, 0)
 	for k := range keyToFiles {
@@ -367,6 +500,10 @@ func (this *MRJobManager) executeJuiceJob(job *util.JuiceJobRequest, errorMsgCha
 		return
 	}
 
+	if job.TaskNum == util.TASK_NUM_AUTO {
+		job.TaskNum = this.autoJuiceTaskNum(job, *matchedFiles)
+	}
+
 	if len(keys) < job.TaskNum {
 		log.Print("WARN: Juice input contains less keys than the number of tasks, auto reducing task number... ")
 		job.TaskNum = len(keys)
@@ -378,6 +515,8 @@ func (this *MRJobManager) executeJuiceJob(job *util.JuiceJobRequest, errorMsgCha
 	} else {
 		partitions = partitionByRange(keyToFiles, job.TaskNum)
 	}
+	// hash partitioning leaves out empty buckets, every partition is one task
+	job.TaskNum = len(partitions)
 
 	// stage 3: start juice workers
 	isTaskCompleted := make([]bool, job.TaskNum)
@@ -388,12 +527,16 @@ func (this *MRJobManager) executeJuiceJob(job *util.JuiceJobRequest, errorMsgCha
 		retryNum[idx] = 0
 	}
 	for taskNumber, partition := range partitions {
-		go this.startJuiceWorker(taskNumber, partition, job, &taskResultChans[taskNumber], jobId)
+		go this.startJuiceWorker(taskNumber, 0, partition, job, policy, &taskResultChans[taskNumber], jobId, counters, record)
 	}
 
 	// stage 4: track Juice worker progress and reschedule for failed tasks
 	jobCompleted := false
 	for !jobCompleted {
+		if this.isJobCancelled(record.JobId) {
+			*errorMsgChan <- errJobCancelled
+			return
+		}
 		jobCompleted = true
 		for taskNumber := 0; taskNumber < job.TaskNum; taskNumber++ {
 			if isTaskCompleted[taskNumber] {
@@ -406,7 +549,11 @@ func (this *MRJobManager) executeJuiceJob(job *util.JuiceJobRequest, errorMsgCha
 				this.removeTask(taskId)
 				if err != nil {
 					log.Print(fmt.Sprintf("Juice task %d completed with error: ", taskNumber), err)
-					if retryNum[taskNumber] >= TASK_MAX_RETY_NUM {
+					if !util.IsRetryableTaskFailure(err) {
+						*errorMsgChan <- errors.New(fmt.Sprintf("Failing Juice task: task %d failed with non-retryable error: %s", taskNumber, err.Error()))
+						return
+					}
+					if retryNum[taskNumber] >= policy.MaxRetryNum {
 						*errorMsgChan <- errors.New(fmt.Sprintf("Failing Juice task:  task %d failed after %d retries", taskNumber, retryNum[taskNumber]))
 						return
 					}
@@ -414,9 +561,12 @@ func (this *MRJobManager) executeJuiceJob(job *util.JuiceJobRequest, errorMsgCha
 
 					jobCompleted = false
 					retryNum[taskNumber]++
-					time.Sleep(1 * time.Second)
-					log.Printf("Rescheduling juice task %d", taskNumber)
-					go this.startJuiceWorker(taskNumber, partitions[taskNumber], job, &taskResultChans[taskNumber], jobId)
+					backoff := policy.RetryBackoff(retryNum[taskNumber])
+					log.Printf("Rescheduling juice task %d in %s", taskNumber, backoff.String())
+					go func(taskNumber int, attempt int) {
+						time.Sleep(backoff)
+						this.startJuiceWorker(taskNumber, attempt, partitions[taskNumber], job, policy, &taskResultChans[taskNumber], jobId, counters, record)
+					}(taskNumber, retryNum[taskNumber])
 				} else {
 					// task completed
 					isTaskCompleted[taskNumber] = true
@@ -435,10 +585,34 @@ func (this *MRJobManager) executeJuiceJob(job *util.JuiceJobRequest, errorMsgCha
 	*errorMsgChan <- nil
 }
 
+// Maple outputs are named <file_name>-p<partition_num>-<key>, group them by key
+func groupFilesByKey(fileNames []string) map[string][]string {
+	keyToFiles := make(map[string][]string)
+	for _, fileName := range fileNames {
+		log.Printf("Matched files: %s", fileName)
+		splitted := strings.Split(fileName, "-")
+		if len(splitted) > 0 {
+			key := splitted[len(splitted)-1]
+			files, exists := keyToFiles[key]
+			if !exists {
+				files = make([]string, 0)
+			}
+			files = append(files, fileName)
+			keyToFiles[key] = files
+		}
+	}
+	return keyToFiles
+}
+
+// regex matching all Maple outputs under an intermediate file prefix
+func fmtJuiceInputRegex(filePrefix string) string {
+	filePrefix = strings.Replace(filePrefix, ".", "\\.", -1) // escape dots in regex
+	return filePrefix + "-p\\d+-.+"
+}
+
 func cleanUpJuiceInput(filePrefix string) error {
-	filePrefix = strings.Replace(filePrefix, ".", "\\.", -1)
 	log.Printf("Cleaning up juice input with file prefix: " + filePrefix)
-	fileNames, err := dfs.SDFSSearchFileByRegex(filePrefix + "-p\\d+-.+")
+	fileNames, err := dfs.SDFSSearchFileByRegex(fmtJuiceInputRegex(filePrefix))
 	if err != nil {
 		return err
 	}
@@ -452,61 +626,75 @@ func cleanUpJuiceInput(filePrefix string) error {
 	return err1
 }
 
-func (this *MRJobManager) startJuiceWorker(taskNumber int, parition map[string][]string, job *util.JuiceJobRequest, resultChan *chan error, jobId int32) {
+func (this *MRJobManager) startJuiceWorker(taskNumber int, attempt int, parition map[string][]string, job *util.JuiceJobRequest, policy *util.JobPolicy, resultChan *chan error, jobId int32, counters *JobCounters, record *JobRecord) {
+	attemptRecord := NewTaskAttemptRecord(jobId, false, taskNumber, attempt)
+	result := &util.TaskResult{}
+	err := this.runJuiceTask(taskNumber, attempt, parition, job, policy, jobId, result, attemptRecord)
+	if err == nil {
+		counters.Add(result.Counters)
+	}
+	attemptRecord.Finish(result, err)
+	record.AddAttempt(attemptRecord)
+	*resultChan <- err
+}
+
+// run the task on a worker, the worker's reply is written to result
+func (this *MRJobManager) runJuiceTask(taskNumber int, attempt int, parition map[string][]string, job *util.JuiceJobRequest, policy *util.JobPolicy, jobId int32, result *util.TaskResult, attemptRecord *TaskAttemptRecord) error {
 
 	taskId := fmtTaskId(job.SrcSdfsFilePrefix, false, taskNumber, jobId)
 	workerIp := this.assignTask(taskId)
 	if len(workerIp) == 0 {
-		*resultChan <- errors.New("Cannot find free worker") // this should never happen unless all worker nodes died
-		return
+		return errors.New("Cannot find free worker") // this should never happen unless all worker nodes died
 	}
+	attemptRecord.Worker = workerIp
 
 	taskArg := &util.JuiceTaskArg{
 		InputFilePrefix:     job.SrcSdfsFilePrefix,
 		KeyToFileNames:      parition,
 		ExcecutableFileName: job.ExcecutableFileName,
 		OutputFilePrefix:    job.OutputFileName,
+		LeaderId:            membership.SelfNodeId,
+		JobId:               jobId,
+		TaskNumber:          taskNumber,
+		Attempt:             attempt,
+		CacheFiles:          job.CacheFiles,
+		Compression:         job.Compression,
+		ExecutionTimeoutMinutes: policy.ExecutionTimeoutMinutes,
+		Limits:              policy.Limits,
 	}
 
 	// instruct juice job start
 	client := util.Dial(workerIp, config.RpcServerPort)
 	if client == nil {
 		log.Printf("Cannot connect to node %s while starting Juice worker", workerIp)
-		*resultChan <- errors.New("Cannot connect to node")
-		return
+		return errors.New("Cannot connect to node")
 	}
 
 	defer client.Close()
 
-	retFlag := ""
-
-	call := client.Go("MRNodeManager.StartJuiceTask", taskArg, &retFlag, nil)
+	call := client.Go("MRNodeManager.StartJuiceTask", taskArg, result, nil)
 	if call.Error != nil {
 		log.Printf("Encountered error while starting Juice task %s via RPC", taskId)
-		*resultChan <- call.Error
-		return
+		return call.Error
 	}
 
-	timeout := time.After(time.Duration(JUICE_TASK_TIMEOUT_MINUTES) * time.Minute)
+	timeout := time.After(time.Duration(policy.TaskTimeoutMinutes) * time.Minute)
 
 	select {
 	case <-timeout:
-		*resultChan <- errors.New("Timeout executing Juice task" + taskId)
-		return
+		return util.NewTaskFailure(util.TASK_FAILURE_TIMEOUT, "Timeout executing Juice task " + taskId)
 	case c, ok := <-call.Done: // check if channel has output ready
 		if !ok {
 			log.Println("MR Job Master: Channel closed for async rpc call")
-			*resultChan <- errors.New("Unexpected connection break down")
-			return
+			return errors.New("Unexpected connection break down")
 		} else {
-			if retFlag == "ACK" {
-				*resultChan <- nil
+			if c.Error == nil {
+				return nil
 			} else {
 				errMsg := fmt.Sprintf("MR Job Master: Juice task %s failed with error %s", taskId, c.Error.Error())
 				log.Print(errMsg)
-				*resultChan <- errors.New(errMsg)
+				return errors.New(errMsg)
 			}
-			return
 		}
 	}
 }
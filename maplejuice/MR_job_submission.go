@@ -0,0 +1,69 @@
+package maplejuice
+
+import (
+	"maple-juice/util"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+)
+
+// jobs and schedules are submitted on their own RPC path. Every connection gets its own rpc server
+// bound to the remote address, so that the leader knows which node submitted a job and can ask
+// that node to run the command hook of the job, whatever the client put in the request.
+
+const MR_JOB_SUBMISSION_RPC_PATH string = "/_mrJobSubmission_"
+
+// registered as MRJobManager on the rpc server of a single submission connection
+type MRJobSubmission struct {
+	jobManager  *MRJobManager
+	submitterIp string
+}
+
+func (this *MRJobSubmission) SubmitJob(jobRequest *util.JobRequest, reply *string) error {
+	jobRequest.Hook.SubmitterIp = this.submitterIp
+	return this.jobManager.submitJob(jobRequest, reply)
+}
+
+// scheduled runs notify the node that added the schedule
+func (this *MRJobSubmission) AddSchedule(spec *util.ScheduledJob, reply *string) error {
+	spec.Request.Hook.SubmitterIp = this.submitterIp
+	return this.jobManager.addSchedule(spec, reply)
+}
+
+type jobSubmissionHandler struct {
+	jobManager *MRJobManager
+}
+
+// same handshake as rpc.Server.ServeHTTP, so that clients dial with rpc.DialHTTPPath
+func (this *jobSubmissionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "CONNECT" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must CONNECT\n")
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		log.Print("Failed to hijack job submission connection ", err)
+		return
+	}
+	io.WriteString(conn, "HTTP/1.0 200 Connected to Go RPC\n\n")
+
+	submitterIp, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		log.Print("Failed to read the address of the submitting node ", err)
+		conn.Close()
+		return
+	}
+
+	server := rpc.NewServer()
+	err = server.RegisterName("MRJobManager", &MRJobSubmission{jobManager: this.jobManager, submitterIp: submitterIp})
+	if err != nil {
+		log.Print("Failed to register job submission service ", err)
+		conn.Close()
+		return
+	}
+	server.ServeConn(conn)
+}
@@ -0,0 +1,316 @@
+package maplejuice
+
+import (
+	"maple-juice/config"
+	"maple-juice/util"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// runs a whole Maple Juice pipeline inside one process for developing and testing executables
+// input splitting, key grouping and key partitioning follow the job manager exactly,
+// SDFS is replaced by a local folder and no membership / leader election service is involved
+
+// input splits go to a subfolder of the local runner folder, never to the folders of a node running on the same machine
+const LOCAL_RUNNER_SPLIT_DIR string = "splits/"
+
+// local <maple_exe> <num_maples> <juice_exe> <num_juices> <src_filename> <dest_filename> <input_has_header> <is_hash> [format=<record_format>]
+// executables and source file are read from the local folder, result is written to <dest_filename> in the local folder
+func ProcessLocalCmd(args []string) error {
+	if len(args) < 8 {
+		log.Print("Invalid local command")
+		log.Print("Usage: local <maple_exe> <num_maples> <juice_exe> <num_juices> <src_filename> <dest_filename> <input_has_header> <is_hash> [format=<record_format>]")
+		return errors.New("Invalid local command")
+	}
+
+	options, err := parseJobOptions(args[8:], []string{"format"})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	if value, exists := options["format"]; exists {
+		_, err := util.ParseRecordFormat(value)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+
+	mapleTaskNum, err := util.ParseTaskNum(args[1])
+	if err != nil {
+		log.Print("Invalid maple task number")
+		return errors.New("Invalid maple task number")
+	}
+
+	juiceTaskNum, err := util.ParseTaskNum(args[3])
+	if err != nil {
+		log.Print("Invalid juice task number")
+		return errors.New("Invalid juice task number")
+	}
+
+	handleInputHeader, err := strconv.Atoi(args[6])
+	if err != nil || (handleInputHeader != 0 && handleInputHeader != 1) {
+		log.Print("Invalid input_has_header flag")
+		return errors.New("Invalid input_has_header flag")
+	}
+
+	isHash, err := strconv.Atoi(args[7])
+	if err != nil || (isHash != 0 && isHash != 1) {
+		log.Print("Invalid is_hash flag")
+		return errors.New("Invalid is_hash flag")
+	}
+
+	mapleExeName := args[0]
+	juiceExeName := args[2]
+	srcFileName := args[4]
+	destFileName := args[5]
+	if len(mapleExeName) == 0 || len(juiceExeName) == 0 || len(srcFileName) == 0 || len(destFileName) == 0 {
+		log.Print("file names cannot be empty")
+		return errors.New("file names cannot be empty")
+	}
+
+	// intermediate file names and keys are separated by dashes
+	intermediatePrefix := fmt.Sprintf("local_%d", time.Now().UnixMilli())
+
+	mapleJob := &util.MapleJobRequest{
+		ExcecutableFileName: mapleExeName,
+		TaskNum:             mapleTaskNum,
+		SrcSdfsFileName:     srcFileName,
+		OutputFilePrefix:    intermediatePrefix,
+		PreserveInputHeader: handleInputHeader == 1,
+		InputFormat:         options["format"],
+	}
+
+	juiceJob := &util.JuiceJobRequest{
+		ExcecutableFileName: juiceExeName,
+		TaskNum:             juiceTaskNum,
+		SrcSdfsFilePrefix:   intermediatePrefix,
+		OutputFileName:      destFileName,
+		DeleteInput:         true,
+		IsHashPartition:     isHash == 1,
+	}
+
+	err = RunLocalJob(mapleJob, juiceJob)
+	if err != nil {
+		log.Print("Encountered error while executing local job ", err)
+		return err
+	}
+
+	log.Printf("Local job completed with result at %s in local folder", destFileName)
+	return nil
+}
+
+// run a Maple job followed by a Juice job on the local machine
+// file names in both requests refer to files in the local folder instead of SDFS
+func RunLocalJob(mapleJob *util.MapleJobRequest, juiceJob *util.JuiceJobRequest) error {
+	err := os.RemoveAll(config.LocalRunnerFileDir)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(config.LocalRunnerFileDir+LOCAL_RUNNER_SPLIT_DIR, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = runLocalMapleJob(mapleJob)
+	if err != nil {
+		return err
+	}
+
+	return runLocalJuiceJob(juiceJob)
+}
+
+func runLocalMapleJob(job *util.MapleJobRequest) error {
+	if job.TaskNum < 0 {
+		return errors.New(fmt.Sprintf("Invalid number of tasks %d", job.TaskNum))
+	}
+
+	if job.TaskNum == util.TASK_NUM_AUTO {
+		taskNum, err := autoMapleTaskNum(job, config.LocalFileDir+job.SrcSdfsFileName)
+		if err != nil {
+			return err
+		}
+		job.TaskNum = taskNum
+	}
+
+	splitDir := config.LocalRunnerFileDir + LOCAL_RUNNER_SPLIT_DIR
+	taskNum, err := util.PartitionMapleInput(config.LocalFileDir+job.SrcSdfsFileName, job.SrcSdfsFileName, job.TaskNum, job.PreserveInputHeader, job.InputFormat, splitDir)
+	if err != nil {
+		return err
+	}
+
+	sandbox, binaryPath, env, err := newLocalSandbox("local_maple", job.ExcecutableFileName, job.InputFormat)
+	if err != nil {
+		return err
+	}
+	defer sandbox.Destroy()
+
+	for taskNumber := 0; taskNumber < taskNum; taskNumber++ {
+		log.Printf("Running local maple task %d", taskNumber)
+		inputFileName := util.FmtMapleInputPartitionName(job.SrcSdfsFileName, taskNumber)
+		inputFilePath, err := sandbox.Import(splitDir+inputFileName, inputFileName)
+		if err != nil {
+			return err
+		}
+		outputFileNames, err := runMapleExecutable(sandbox, binaryPath, inputFilePath, job.OutputFilePrefix, env, executionTimeout(0), nil)
+		os.Remove(inputFilePath)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Maple task %d failed: %s", taskNumber, err.Error()))
+		}
+
+		// move outputs to the local stand-in for SDFS
+		for _, fileName := range outputFileNames {
+			err = os.Rename(sandbox.FileDir+fileName, config.LocalRunnerFileDir+fileName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runLocalJuiceJob(job *util.JuiceJobRequest) error {
+	if job.TaskNum < 0 {
+		return errors.New(fmt.Sprintf("Invalid number of tasks %d", job.TaskNum))
+	}
+
+	r, err := regexp.Compile(fmtJuiceInputRegex(job.SrcSdfsFilePrefix))
+	if err != nil {
+		return err
+	}
+
+	fileNames, err := util.ListFolder(config.LocalRunnerFileDir)
+	if err != nil {
+		return err
+	}
+
+	matchedFiles := make([]string, 0)
+	for _, fileName := range fileNames {
+		if r.MatchString(fileName) {
+			matchedFiles = append(matchedFiles, fileName)
+		}
+	}
+	sort.Strings(matchedFiles)
+
+	keyToFiles := groupFilesByKey(matchedFiles)
+	if len(keyToFiles) == 0 {
+		return errors.New("Juice input files not found")
+	}
+
+	// the local machine is the only worker
+	taskNum := job.TaskNum
+	if taskNum == util.TASK_NUM_AUTO {
+		intermediateBytes := totalFileSize(config.LocalRunnerFileDir, matchedFiles)
+		taskNum = util.AutoJuiceTaskNum(intermediateBytes, config.WorkerTaskSlots)
+		log.Printf("Auto task number: intermediate data has %d bytes, using %d Juice tasks", intermediateBytes, taskNum)
+	}
+	if len(keyToFiles) < taskNum {
+		log.Print("WARN: Juice input contains less keys than the number of tasks, auto reducing task number... ")
+		taskNum = len(keyToFiles)
+	}
+
+	var partitions []map[string][]string
+	if job.IsHashPartition {
+		partitions = partitionByHash(keyToFiles, taskNum)
+	} else {
+		partitions = partitionByRange(keyToFiles, taskNum)
+	}
+	// hash partitioning leaves out empty buckets, every partition is one task
+	taskNum = len(partitions)
+	log.Printf("Running %d local juice tasks", taskNum)
+
+	sandbox, binaryPath, _, err := newLocalSandbox("local_juice", job.ExcecutableFileName, "")
+	if err != nil {
+		return err
+	}
+	defer sandbox.Destroy()
+
+	outputFileNames := make([]string, 0)
+	for taskNumber, partition := range partitions {
+		log.Printf("Running local juice task %d on %d keys", taskNumber, len(partition))
+		for key, files := range partition {
+			localFilePath := sandbox.FileDir + fmtJuiceInputFileName(job.SrcSdfsFilePrefix, key)
+			os.Remove(localFilePath)
+			err := concatFiles(config.LocalRunnerFileDir, files, localFilePath)
+			if err != nil {
+				return err
+			}
+
+			expectedOutputFileName := job.OutputFileName + "-" + key
+			outputFileName, err := runJuiceExecutable(sandbox, binaryPath, localFilePath, expectedOutputFileName, nil, executionTimeout(0), nil)
+			os.Remove(localFilePath)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Juice task %d failed on key %s: %s", taskNumber, key, err.Error()))
+			}
+
+			err = os.Rename(sandbox.FileDir+outputFileName, config.LocalRunnerFileDir+expectedOutputFileName)
+			if err != nil {
+				return err
+			}
+			outputFileNames = append(outputFileNames, expectedOutputFileName)
+		}
+	}
+
+	if job.DeleteInput {
+		for _, fileName := range matchedFiles {
+			os.Remove(config.LocalRunnerFileDir + fileName)
+		}
+	}
+
+	// same as fetching all files under the destination prefix from SDFS
+	sort.Strings(outputFileNames)
+	os.Remove(config.LocalFileDir + job.OutputFileName)
+	return concatFiles(config.LocalRunnerFileDir, outputFileNames, config.LocalFileDir+job.OutputFileName)
+}
+
+// executables run in a sandbox with the configured default limits, same as on worker nodes
+// returns the sandbox, the compiled executable and environment variables for running it
+func newLocalSandbox(name string, executableFileName string, inputFormat string) (*TaskSandbox, string, []string, error) {
+	limits := util.NewJobPolicy().Limits
+	limits.ApplyDefaults()
+
+	sandbox, err := NewTaskSandbox(name, limits)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	env, err := addRecordReader(sandbox, inputFormat, nil)
+	if err != nil {
+		sandbox.Destroy()
+		return nil, "", nil, err
+	}
+	binaryPath, err := sandbox.Build(config.LocalFileDir+executableFileName, nil)
+	if err != nil {
+		sandbox.Destroy()
+		return nil, "", nil, err
+	}
+	return sandbox, binaryPath, env, nil
+}
+
+// append files under folder to the destination file in the given order
+func concatFiles(folder string, fileNames []string, destFilePath string) error {
+	dest, err := os.OpenFile(destFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	for _, fileName := range fileNames {
+		src, err := os.Open(folder + fileName)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dest, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -10,15 +10,26 @@ import (
 	"net/rpc"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 
 // responsible for locally executing Maple / Juice task as instructed by the MR Job Manager
 
-type MRNodeManager struct {}
+type MRNodeManager struct {
+	cacheManager     *JobCacheManager
+	workspaceManager *WorkspaceManager
+}
 
+func NewMRNodeManager() *MRNodeManager {
+	return &MRNodeManager{
+		cacheManager:     NewJobCacheManager(),
+		workspaceManager: NewWorkspaceManager(),
+	}
+}
 
 func (this *MRNodeManager) Register() {
 	rpc.Register(this)
@@ -26,10 +37,37 @@ func (this *MRNodeManager) Register() {
 	if err != nil {
 		log.Print("Failed to clean up node manager file folder", err)
 	}
+	this.cacheManager.Reset()
+	this.workspaceManager.Reset()
+	resetSandboxFolder()
+}
+
+// remove distributed cache files and workspaces of a job, called by the job manager once the job ends
+func (this *MRNodeManager) ReleaseJobCache(args *util.JobCacheArg, reply *string) error {
+	this.cacheManager.Release(args.LeaderId, args.JobId)
+	this.workspaceManager.Release(args.LeaderId, args.JobId)
+	*reply = "ACK"
+	return nil
 }
 
 //execute a Maple task locally
-func (this *MRNodeManager) StartMapleTask(args *util.MapleTaskArg, reply *string) error {
+func (this *MRNodeManager) StartMapleTask(args *util.MapleTaskArg, reply *util.TaskResult) error {
+	taskLog := NewTaskLog()
+	err := this.executeMapleTask(args, taskLog, reply)
+	if err != nil {
+		taskLog.AppendError(err)
+	}
+	go taskLog.Upload(args.LeaderId, args.JobId, true, args.TaskNumber, args.Attempt)
+
+	if err != nil {
+		return err
+	}
+	reply.Counters = taskLog.Counters()
+	return nil
+}
+
+// sizes of the input and of the output files written to SDFS are reported through result
+func (this *MRNodeManager) executeMapleTask(args *util.MapleTaskArg, taskLog *TaskLog, result *util.TaskResult) error {
 	// fetch executable from SDFS
 	executableFileName := args.ExcecutableFileName
 	inputFileName := args.InputFileName
@@ -43,6 +81,11 @@ func (this *MRNodeManager) StartMapleTask(args *util.MapleTaskArg, reply *string
 		return err
 	}
 
+	cacheEnv, err := this.cacheManager.Acquire(args.LeaderId, args.JobId, args.CacheFiles)
+	if err != nil {
+		return err
+	}
+
 	// wait for input file's arrival
 	log.Printf("Waiting for input file %s with transmission id %s", inputFileName, transmissionId)
 	for {
@@ -52,48 +95,65 @@ func (this *MRNodeManager) StartMapleTask(args *util.MapleTaskArg, reply *string
 		time.Sleep(200 * time.Millisecond)
 	}
 
-	log.Print("Start running maple executatble...")
-
-
-	// execute executable on input file
-	executableFilePath := config.NodeManagerFileDir + executableFileName
-	inputFilePath := config.NodeManagerFileDir + inputFileName
+	sandbox, err := NewTaskSandbox(fmtSandboxName(args.JobId, true, args.TaskNumber, args.Attempt), args.Limits)
+	if err != nil {
+		log.Print("Failed to create task sandbox", err)
+		return err
+	}
+	defer sandbox.Destroy()
 
-	// go run executable.go -in <input_file_path>
-	cmdArgs := []string {"run", executableFilePath, "-in", inputFilePath, "-prefix", args.OutputFilePrefix}
+	cacheEnv, err = addRecordReader(sandbox, args.InputFormat, cacheEnv)
+	if err != nil {
+		return err
+	}
 
-	cmd := exec.Command("go", cmdArgs...)
-	output, err := cmd.CombinedOutput()
-	
+	binaryPath, err := this.buildExecutable(sandbox, args.LeaderId, args.JobId, executableFileName, taskLog)
 	if err != nil {
-		errMsg := fmt.Sprintf("Error while executing Maple executable %s", err.Error())
-		log.Print(errMsg)
-		log.Print(string(output))
-		return errors.New(errMsg)
-	} 
+		return err
+	}
 
-	if cmd.ProcessState.ExitCode() != 0 {
-		log.Print("Maple executable finished with non zero exit code")
-		return errors.New("Executable finished with non zero exit code")
+	inputFilePath, err := sandbox.Import(config.NodeManagerFileDir+inputFileName, inputFileName)
+	if err != nil {
+		return err
 	}
-	log.Printf("Executable finished with output: %s", string(output))
+	result.InputBytes = totalFileSize(sandbox.FileDir, []string{inputFileName})
 
+	log.Print("Start running maple executatble...")
 
-	//executable output should be a comma separated list of output files
-	splitted := strings.Split(string(output), ",")
-	outputFileNames := make([]string, 0)
-	for _, fileName := range splitted {
-		fileName = strings.Trim(fileName, " \n\r")
-		if len(fileName) > 0 {
-			_, err1 := os.Stat(config.NodeManagerFileDir + fileName)
-			if err1 != nil {
-				return errors.New("Maple executable failed to produce valid output")
+	// execute executable on input file
+	outputFileNames, err := runMapleExecutable(sandbox, binaryPath, inputFilePath, args.OutputFilePrefix, cacheEnv, executionTimeout(args.ExecutionTimeoutMinutes), taskLog)
+	if err != nil {
+		return err
+	}
+
+	// map-only task uploads a single output file instead of per key intermediate files, plus its side outputs
+	if len(args.OutputFileName) > 0 {
+		keyedFileNames := make([]string, 0)
+		sideOutputs := make([]string, 0)
+		for _, fileName := range outputFileNames {
+			if util.IsMapleSideOutput(fileName) {
+				sideOutputs = append(sideOutputs, fileName)
+			} else {
+				keyedFileNames = append(keyedFileNames, fileName)
 			}
-			outputFileNames = append(outputFileNames, fileName)
 		}
+		sort.Strings(keyedFileNames)
+		err = concatFiles(sandbox.FileDir, keyedFileNames, sandbox.FileDir+args.OutputFileName)
+		if err != nil {
+			log.Print("Failed to combine Maple output for map-only task", err)
+			return err
+		}
+		outputFileNames = append([]string{args.OutputFileName}, sideOutputs...)
 	}
 
+	for _, fileName := range outputFileNames {
+		err = util.CompressFile(sandbox.FileDir+fileName, args.Compression)
+		if err != nil {
+			return err
+		}
+	}
 
+	result.OutputBytes = totalFileSize(sandbox.FileDir, outputFileNames)
 
 	uploadTimeout := time.After(300 * time.Second)
 	remainingFiles := len(outputFileNames)
@@ -101,7 +161,7 @@ func (this *MRNodeManager) StartMapleTask(args *util.MapleTaskArg, reply *string
 
 	for _, fileName := range outputFileNames {
 		go func(file string){
-			_, err := dfs.SDFSPutFile(file, config.NodeManagerFileDir+file)
+			_, err := dfs.SDFSPutCompressedFile(file, sandbox.FileDir+file, args.Compression)
 			responseChan <- err
 		}(fileName)
 	}
@@ -122,7 +182,6 @@ func (this *MRNodeManager) StartMapleTask(args *util.MapleTaskArg, reply *string
 		}
 	}
 
-	*reply = "ACK"
 	return nil
 }
 
@@ -141,7 +200,23 @@ func cleanUp(filePrefix string){
 
 
 
-func (this *MRNodeManager) StartJuiceTask(args *util.JuiceTaskArg, reply *string) error {
+func (this *MRNodeManager) StartJuiceTask(args *util.JuiceTaskArg, reply *util.TaskResult) error {
+	taskLog := NewTaskLog()
+	err := this.executeJuiceTask(args, taskLog, reply)
+	if err != nil {
+		taskLog.AppendError(err)
+	}
+	go taskLog.Upload(args.LeaderId, args.JobId, false, args.TaskNumber, args.Attempt)
+
+	if err != nil {
+		return err
+	}
+	reply.Counters = taskLog.Counters()
+	return nil
+}
+
+// sizes of the input and of the output files written to SDFS are reported through result
+func (this *MRNodeManager) executeJuiceTask(args *util.JuiceTaskArg, taskLog *TaskLog, result *util.TaskResult) error {
 	// fetch executable and input key partitions from SDFS
 	executableFileName := args.ExcecutableFileName
 	parition := args.KeyToFileNames
@@ -149,8 +224,13 @@ func (this *MRNodeManager) StartJuiceTask(args *util.JuiceTaskArg, reply *string
 	executableFetchResChan := make(chan error, 1)
 
 
+	var cacheEnv []string
 	go func(){
-		executableFetchResChan <- dfs.SDFSGetFile(executableFileName, executableFileName, dfs.RECEIVER_MR_NODE_MANAGER)
+		err := dfs.SDFSGetFile(executableFileName, executableFileName, dfs.RECEIVER_MR_NODE_MANAGER)
+		if err == nil {
+			cacheEnv, err = this.cacheManager.Acquire(args.LeaderId, args.JobId, args.CacheFiles)
+		}
+		executableFetchResChan <- err
 	}()
 
 	// make this async
@@ -176,32 +256,49 @@ func (this *MRNodeManager) StartJuiceTask(args *util.JuiceTaskArg, reply *string
 		}
 	}
 
+	sandbox, err := NewTaskSandbox(fmtSandboxName(args.JobId, false, args.TaskNumber, args.Attempt), args.Limits)
+	if err != nil {
+		log.Print("Failed to create task sandbox", err)
+		return err
+	}
+	defer sandbox.Destroy()
+
+	binaryPath, err := this.buildExecutable(sandbox, args.LeaderId, args.JobId, executableFileName, taskLog)
+	if err != nil {
+		return err
+	}
+
+	for key := range parition {
+		localFileName := fmtJuiceInputFileName(args.InputFilePrefix, key)
+		_, err := sandbox.Import(config.NodeManagerFileDir+localFileName, localFileName)
+		if err != nil {
+			return err
+		}
+		result.InputBytes += totalFileSize(sandbox.FileDir, []string{localFileName})
+	}
+
+	var outputBytes atomic.Int64
 	executionErrorChan := make(chan error, len(parition))
-	executableFilePath := config.NodeManagerFileDir + args.ExcecutableFileName
 	// execute excutable on all key partitions and send result file to SDFS
 	for key := range parition {
 		go func(k string){
 			log.Printf("Running juice executable on key: %s", k)
-			localFilePath := config.NodeManagerFileDir + fmtJuiceInputFileName(args.InputFilePrefix, k)
-			cmdArgs := []string {"run", executableFilePath, "-in", localFilePath, "-dest", args.OutputFilePrefix + "-" + k}
-			cmd := exec.Command("go", cmdArgs...)
-			output, err := cmd.CombinedOutput()
+			localFilePath := sandbox.FileDir + fmtJuiceInputFileName(args.InputFilePrefix, k)
+			expectedOutputFileName := args.OutputFilePrefix + "-" + k
+			outputFileName, err := runJuiceExecutable(sandbox, binaryPath, localFilePath, expectedOutputFileName, cacheEnv, executionTimeout(args.ExecutionTimeoutMinutes), taskLog)
 			if err != nil {
-				errMsg := fmt.Sprintf("Error while executing Juice executable %s", err.Error())
-				log.Print(errMsg)
-				log.Print(string(output))
 				executionErrorChan <- err
 				return
 			}
 
 			os.Remove(localFilePath)
-			outputFileName := strings.Trim(string(output), " \n\r")
-			expectedOutputFileName := args.OutputFilePrefix + "-" + k 
-			if outputFileName != expectedOutputFileName {
-				log.Printf("WARN: Juice executable not producing file with expected name: output name %s, expected name: %s", outputFileName, expectedOutputFileName)
+			err = util.CompressFile(sandbox.FileDir+outputFileName, args.Compression)
+			if err != nil {
+				executionErrorChan <- err
+				return
 			}
-
-			_, err1 := dfs.SDFSPutFile(expectedOutputFileName, config.NodeManagerFileDir + outputFileName)
+			outputBytes.Add(totalFileSize(sandbox.FileDir, []string{outputFileName}))
+			_, err1 := dfs.SDFSPutCompressedFile(expectedOutputFileName, sandbox.FileDir + outputFileName, args.Compression)
 			executionErrorChan <- err1
 		}(key)
 	}
@@ -209,12 +306,12 @@ func (this *MRNodeManager) StartJuiceTask(args *util.JuiceTaskArg, reply *string
 
 	// track execution progress
 	remainingKey := len(parition)
-	executionTimeout := time.After(10 * time.Minute)
+	timeout = time.After(executionTimeout(args.ExecutionTimeoutMinutes))
 
 	for remainingKey > 0 {
 		select{
-		case <- executionTimeout:
-			return errors.New("Juice task execution timeout")
+		case <- timeout:
+			return util.NewTaskFailure(util.TASK_FAILURE_TIMEOUT, "Juice task execution timeout")
 		case err := <- executionErrorChan:
 			if err != nil {
 				return err 
@@ -224,11 +321,113 @@ func (this *MRNodeManager) StartJuiceTask(args *util.JuiceTaskArg, reply *string
 		}
 	}
 
-	*reply = "ACK"
+	result.OutputBytes = outputBytes.Load()
 	return nil
 }
 
 
+// <executable> -in <input_file_path> -prefix <output_prefix>
+// returns names of the output files produced under the sandbox file folder
+func runMapleExecutable(sandbox *TaskSandbox, binaryPath string, inputFilePath string, outputFilePrefix string, env []string, timeout time.Duration, taskLog *TaskLog) ([]string, error) {
+	cmdArgs := []string {"-in", inputFilePath, "-prefix", outputFilePrefix}
+
+	stdout, err := sandbox.Run(binaryPath, cmdArgs, env, timeout, "", taskLog)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error while executing Maple executable %s", err.Error())
+		log.Print(errMsg)
+		return nil, errors.New(errMsg)
+	}
+	log.Printf("Executable finished with output: %s", stdout)
+
+	//executable output should be a comma separated list of output files
+	splitted := strings.Split(stdout, ",")
+	outputFileNames := make([]string, 0)
+	for _, fileName := range splitted {
+		fileName = strings.Trim(fileName, " \n\r")
+		if len(fileName) > 0 {
+			_, err1 := os.Stat(sandbox.FileDir + fileName)
+			if err1 != nil {
+				return nil, util.NewTaskFailure(util.TASK_FAILURE_EXECUTABLE, "Maple executable failed to produce valid output")
+			}
+			outputFileNames = append(outputFileNames, fileName)
+		}
+	}
+	return outputFileNames, nil
+}
+
+// <executable> -in <input_file_path> -dest <output_file_name>
+// returns name of the output file produced under the sandbox file folder
+func runJuiceExecutable(sandbox *TaskSandbox, binaryPath string, inputFilePath string, outputFileName string, env []string, timeout time.Duration, taskLog *TaskLog) (string, error) {
+	cmdArgs := []string {"-in", inputFilePath, "-dest", outputFileName}
+
+	stdout, err := sandbox.Run(binaryPath, cmdArgs, env, timeout, outputFileName, taskLog)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error while executing Juice executable %s", err.Error())
+		log.Print(errMsg)
+		return "", errors.New(errMsg)
+	}
+
+	producedFileName := strings.Trim(stdout, " \n\r")
+	if producedFileName != outputFileName {
+		log.Printf("WARN: Juice executable not producing file with expected name: output name %s, expected name: %s", producedFileName, outputFileName)
+	}
+	return producedFileName, nil
+}
+
+// single file executables are compiled inside the sandbox, archived Go modules are built once per job on this node
+func (this *MRNodeManager) buildExecutable(sandbox *TaskSandbox, leaderId string, jobId int32, executableFileName string, taskLog *TaskLog) (string, error) {
+	sourceFilePath := config.NodeManagerFileDir + executableFileName
+	if !util.IsArchiveExecutable(executableFileName) {
+		return sandbox.Build(sourceFilePath, taskLog)
+	}
+
+	sources, err := sandbox.Sources()
+	if err != nil {
+		return "", err
+	}
+	binaryPath, err := this.workspaceManager.Build(leaderId, jobId, sourceFilePath, sources, taskLog)
+	if err != nil {
+		return "", err
+	}
+	return sandbox.InstallBinary(binaryPath)
+}
+
+// maple executables of jobs with an input format are compiled together with the record readers, whose
+// names are prefixed with mj, and get the format spec through the environment
+func addRecordReader(sandbox *TaskSandbox, inputFormat string, env []string) ([]string, error) {
+	if len(inputFormat) == 0 {
+		return env, nil
+	}
+	source, err := util.RecordReaderSource()
+	if err != nil {
+		return nil, err
+	}
+	err = sandbox.AddSource("mj_record_reader.go", source)
+	if err != nil {
+		return nil, err
+	}
+	return append(env, util.RECORD_FORMAT_ENV_VAR+"="+inputFormat), nil
+}
+
+// sum of the sizes of files under folder, missing files are ignored
+func totalFileSize(folder string, fileNames []string) int64 {
+	var total int64 = 0
+	for _, fileName := range fileNames {
+		info, err := os.Stat(folder + fileName)
+		if err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+func executionTimeout(minutes int) time.Duration {
+	if minutes <= 0 {
+		minutes = config.ExecutionTimeoutMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
 func fmtJuiceInputFileName(filePrefix string, key string) string {
 	return fmt.Sprintf("juice_input-%s-%s", filePrefix, key)
 }
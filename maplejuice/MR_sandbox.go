@@ -0,0 +1,334 @@
+package maplejuice
+
+import (
+	"maple-juice/config"
+	"maple-juice/util"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// every task attempt runs its executable inside a sandbox: a private working directory used as
+// the executable's HOME, optional resource limits, optional dedicated unprivileged user and
+// optional cgroup. Executables write outputs to $HOME/mr_node_manager/, i.e. the sandbox file folder.
+// Executables are compiled by the node manager outside of the sandbox and only the binary runs restricted.
+
+const (
+	SANDBOX_BINARY_NAME string = "task_exe"
+	SANDBOX_SOURCE_DIR  string = "src/"
+
+	// command of the node manager binary applying the limits before it execs an executable
+	SANDBOX_EXEC_CMD               string = "sandbox_exec"
+	SANDBOX_EXEC_FAILURE_EXIT_CODE int    = 125
+)
+
+type TaskSandbox struct {
+	Name    string
+	Dir     string // home directory of the executable
+	FileDir string // inputs are placed and outputs are expected here
+	limits  util.ResourceLimits
+	uid     int // -1 if executables run as the node user
+	gid     int
+	cgroup  string // per task cgroup folder, empty if cgroups are not used
+}
+
+// state before a run that tells whether the run ran into a limit, cgroup event counts are cumulative
+// over the runs of a sandbox
+type limitEvents struct {
+	time     time.Time
+	oomKills int64 // oom_kill of memory.events
+	pidsMax  int64 // max of pids.events
+}
+
+// create the working directory of a task attempt, name should not contain dashes
+func NewTaskSandbox(name string, limits util.ResourceLimits) (*TaskSandbox, error) {
+	sandbox := &TaskSandbox{
+		Name:    name,
+		Dir:     config.SandboxFileDir + name + "/",
+		FileDir: config.SandboxFileDir + name + "/mr_node_manager/",
+		limits:  limits,
+		uid:     -1,
+		gid:     -1,
+	}
+
+	if len(config.SandboxUser) > 0 {
+		sandboxUser, err := user.Lookup(config.SandboxUser)
+		if err != nil {
+			return nil, err
+		}
+		sandbox.uid, _ = strconv.Atoi(sandboxUser.Uid)
+		sandbox.gid, _ = strconv.Atoi(sandboxUser.Gid)
+	}
+
+	os.RemoveAll(sandbox.Dir)
+	err := os.MkdirAll(sandbox.FileDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = sandbox.chown(sandbox.Dir, sandbox.FileDir)
+	if err != nil {
+		sandbox.Destroy()
+		return nil, err
+	}
+
+	if len(config.SandboxCgroupDir) > 0 {
+		err = sandbox.createCgroup()
+		if err != nil {
+			sandbox.Destroy()
+			return nil, err
+		}
+	}
+	return sandbox, nil
+}
+
+// remove the working directory and cgroup of the sandbox
+func (this *TaskSandbox) Destroy() {
+	if len(this.cgroup) > 0 {
+		err := os.Remove(this.cgroup)
+		if err != nil {
+			log.Printf("Failed to remove cgroup %s: %s", this.cgroup, err.Error())
+		}
+	}
+	err := os.RemoveAll(this.Dir)
+	if err != nil {
+		log.Printf("Failed to clean up sandbox %s: %s", this.Name, err.Error())
+	}
+}
+
+// move a file into the sandbox file folder, returns its new path
+func (this *TaskSandbox) Import(srcFilePath string, fileName string) (string, error) {
+	destFilePath := this.FileDir + fileName
+	err := os.Rename(srcFilePath, destFilePath)
+	if err != nil {
+		return "", err
+	}
+	return destFilePath, this.chown(destFilePath)
+}
+
+// add a source file of package main compiled together with the executable
+func (this *TaskSandbox) AddSource(fileName string, content []byte) error {
+	err := os.MkdirAll(this.Dir+SANDBOX_SOURCE_DIR, 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(this.Dir+SANDBOX_SOURCE_DIR+fileName, content, 0644)
+}
+
+// compile executable source along with added sources into the sandbox, archived Go modules are
+// unpacked into a workspace inside the sandbox. Compiler errors are reported as executable failures
+func (this *TaskSandbox) Build(sourceFilePath string, taskLog *TaskLog) (string, error) {
+	if util.IsArchiveExecutable(sourceFilePath) {
+		sources, err := this.Sources()
+		if err != nil {
+			return "", err
+		}
+		binaryPath, err := BuildModuleArchive(sourceFilePath, this.Dir+"workspace/", sources, taskLog)
+		if err != nil {
+			return "", err
+		}
+		return this.InstallBinary(binaryPath)
+	}
+
+	binaryPath := this.Dir + SANDBOX_BINARY_NAME
+
+	source, err := os.ReadFile(sourceFilePath)
+	if err != nil {
+		return "", err
+	}
+	err = this.AddSource(filepath.Base(sourceFilePath), source)
+	if err != nil {
+		return "", err
+	}
+	sourceFileNames, err := util.ListFolder(this.Dir + SANDBOX_SOURCE_DIR)
+	if err != nil {
+		return "", err
+	}
+
+	cmdArgs := []string{"build", "-o", binaryPath}
+	for _, fileName := range sourceFileNames {
+		cmdArgs = append(cmdArgs, this.Dir+SANDBOX_SOURCE_DIR+fileName)
+	}
+
+	err = goBuild("", cmdArgs, nil, taskLog)
+	if err != nil {
+		return "", err
+	}
+	return binaryPath, this.chown(binaryPath)
+}
+
+// sources added with AddSource
+func (this *TaskSandbox) Sources() (map[string][]byte, error) {
+	sources := make(map[string][]byte)
+	if _, err := os.Stat(this.Dir + SANDBOX_SOURCE_DIR); err != nil {
+		return sources, nil
+	}
+	fileNames, err := util.ListFolder(this.Dir + SANDBOX_SOURCE_DIR)
+	if err != nil {
+		return nil, err
+	}
+	for _, fileName := range fileNames {
+		content, err := os.ReadFile(this.Dir + SANDBOX_SOURCE_DIR + fileName)
+		if err != nil {
+			return nil, err
+		}
+		sources[fileName] = content
+	}
+	return sources, nil
+}
+
+// copy a binary built outside of the sandbox into it, returns its new path
+func (this *TaskSandbox) InstallBinary(srcBinaryPath string) (string, error) {
+	binaryPath := this.Dir + SANDBOX_BINARY_NAME
+	if srcBinaryPath == binaryPath {
+		return binaryPath, this.chown(binaryPath)
+	}
+	content, err := os.ReadFile(srcBinaryPath)
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(binaryPath, content, 0755)
+	if err != nil {
+		return "", err
+	}
+	return binaryPath, this.chown(binaryPath)
+}
+
+// run the go tool in dir (the current directory if empty), compiler errors are reported as executable failures
+func goBuild(dir string, cmdArgs []string, env []string, taskLog *TaskLog) error {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("go", cmdArgs...)
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		if taskLog != nil {
+			taskLog.Append("build", stdout.Bytes(), stderr.Bytes())
+		}
+		log.Print(stderr.String())
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return util.NewTaskFailure(util.TASK_FAILURE_EXECUTABLE, "failed to compile executable: "+strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+	return nil
+}
+
+// run a binary inside the sandbox with stdout and stderr captured separately into the task log
+// the whole process group is killed once timeout is reached, returns its stdout
+func (this *TaskSandbox) Run(binaryPath string, args []string, env []string, timeout time.Duration, title string, taskLog *TaskLog) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd, err := this.command(binaryPath, args)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("failed to apply sandbox limits: %s", err.Error()))
+	}
+	cmd.Dir = this.Dir
+	cmd.Env = append(sandboxEnv(this.Dir), env...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	before := this.limitEvents()
+	err = this.start(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	timedOut := false
+	var timeoutLock sync.Mutex
+	timer := time.AfterFunc(timeout, func() {
+		timeoutLock.Lock()
+		timedOut = true
+		timeoutLock.Unlock()
+		killProcessGroup(cmd)
+	})
+
+	err = cmd.Wait()
+	timer.Stop()
+	// reap processes left behind by the executable
+	killProcessGroup(cmd)
+
+	if taskLog != nil {
+		taskLog.Append(title, stdout.Bytes(), stderr.Bytes())
+	}
+
+	timeoutLock.Lock()
+	defer timeoutLock.Unlock()
+	if timedOut {
+		return "", util.NewTaskFailure(util.TASK_FAILURE_TIMEOUT, fmt.Sprintf("executable killed after running for %s", timeout.String()))
+	}
+	if err != nil {
+		log.Print(stderr.String())
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == SANDBOX_EXEC_FAILURE_EXIT_CODE && strings.HasPrefix(stderr.String(), SANDBOX_EXEC_CMD+":") {
+			return "", errors.New(fmt.Sprintf("failed to apply sandbox limits: %s", strings.TrimSpace(stderr.String())))
+		}
+		reason := this.detectLimitViolation(cmd.ProcessState, before)
+		if len(reason) > 0 {
+			return "", util.NewTaskFailure(reason, fmt.Sprintf("executable exceeded sandbox limit: %s", err.Error()))
+		}
+		if errors.As(err, &exitErr) {
+			// bad input or a bug in the executable, retrying elsewhere won't help
+			return "", util.NewTaskFailure(util.TASK_FAILURE_EXECUTABLE, err.Error())
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+func (this *TaskSandbox) chown(paths ...string) error {
+	if this.uid < 0 {
+		return nil
+	}
+	for _, path := range paths {
+		err := os.Chown(path, this.uid, this.gid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// environment of the node manager with HOME pointing to the sandbox
+func sandboxEnv(homeDir string) []string {
+	env := make([]string, 0)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "HOME=") {
+			env = append(env, kv)
+		}
+	}
+	return append(env, "HOME="+homeDir)
+}
+
+// reset sandbox folder on start up, removing sandboxes of tasks killed with the previous run
+func resetSandboxFolder() {
+	err := os.RemoveAll(config.SandboxFileDir)
+	if err != nil {
+		log.Print("Failed to clean up sandbox folder", err)
+	}
+	err = os.MkdirAll(config.SandboxFileDir, 0755)
+	if err != nil {
+		log.Print("Failed to create sandbox folder", err)
+	}
+}
+
+func fmtSandboxName(jobId int32, isMaple bool, taskNumber int, attempt int) string {
+	taskName := "maple"
+	if !isMaple {
+		taskName = "juice"
+	}
+	return fmt.Sprintf("job%d_%s_task%d_attempt%d", jobId, taskName, taskNumber, attempt)
+}
@@ -0,0 +1,240 @@
+//go:build linux
+
+package maplejuice
+
+import (
+	"maple-juice/config"
+	"maple-juice/util"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	RLIMIT_NPROC int = 6 // not exported by the syscall package
+)
+
+// executables are started through the node manager binary:
+//   <node binary> sandbox_exec <memory_mb> <cpu_seconds> <file_size_mb> <processes> <uid> <gid> <binary> [args...]
+// sets the rlimits and the sandbox user in the child process and then execs the binary, and the child is
+// created inside the task cgroup. The executable therefore never runs without its limits.
+func (this *TaskSandbox) command(binaryPath string, args []string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	wrapperArgs := []string{
+		SANDBOX_EXEC_CMD,
+		strconv.Itoa(this.limits.MemoryLimitMB),
+		strconv.Itoa(this.limits.CpuLimitSeconds),
+		strconv.Itoa(this.limits.FileSizeLimitMB),
+		strconv.Itoa(this.limits.ProcessLimit),
+		strconv.Itoa(this.uid),
+		strconv.Itoa(this.gid),
+		binaryPath,
+	}
+	cmd := exec.Command(self, append(wrapperArgs, args...)...)
+	// own process group, so that the executable and its children are killed together
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd, nil
+}
+
+// start the command inside the task cgroup
+func (this *TaskSandbox) start(cmd *exec.Cmd) error {
+	if len(this.cgroup) > 0 {
+		cgroupDir, err := os.Open(this.cgroup)
+		if err != nil {
+			return err
+		}
+		defer cgroupDir.Close()
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
+	}
+	return cmd.Start()
+}
+
+// entry point of sandbox_exec, does not return
+func SandboxExec(args []string) {
+	err := sandboxExec(args)
+	fmt.Fprintf(os.Stderr, "%s: %s\n", SANDBOX_EXEC_CMD, err.Error())
+	os.Exit(SANDBOX_EXEC_FAILURE_EXIT_CODE)
+}
+
+func sandboxExec(args []string) error {
+	if len(args) < 7 {
+		return errors.New("expected <memory_mb> <cpu_seconds> <file_size_mb> <processes> <uid> <gid> <binary> [args...]")
+	}
+	values := make([]int, 6)
+	for idx := range values {
+		value, err := strconv.Atoi(args[idx])
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid argument %s", args[idx]))
+		}
+		values[idx] = value
+	}
+	memoryLimitMB, cpuLimitSeconds, fileSizeLimitMB, processLimit, uid, gid := values[0], values[1], values[2], values[3], values[4], values[5]
+
+	if memoryLimitMB > 0 {
+		// the go runtime reserves far more address space than it uses, limit the data segment instead
+		limit := uint64(memoryLimitMB) * 1024 * 1024
+		err := setRlimit(syscall.RLIMIT_DATA, limit, limit)
+		if err != nil {
+			return err
+		}
+	}
+	if cpuLimitSeconds > 0 {
+		// go programs ignore SIGXCPU at the soft limit, the kernel kills them at the hard limit
+		limit := uint64(cpuLimitSeconds)
+		err := setRlimit(syscall.RLIMIT_CPU, limit, limit+1)
+		if err != nil {
+			return err
+		}
+	}
+	if fileSizeLimitMB > 0 {
+		limit := uint64(fileSizeLimitMB) * 1024 * 1024
+		err := setRlimit(syscall.RLIMIT_FSIZE, limit, limit)
+		if err != nil {
+			return err
+		}
+	}
+	if processLimit > 0 && uid >= 0 {
+		// counted per user, only meaningful with a dedicated sandbox user
+		limit := uint64(processLimit)
+		err := setRlimit(RLIMIT_NPROC, limit, limit)
+		if err != nil {
+			return err
+		}
+	}
+
+	if uid >= 0 {
+		err := syscall.Setgroups([]int{})
+		if err != nil {
+			return errors.New(fmt.Sprintf("setgroups: %s", err.Error()))
+		}
+		err = syscall.Setgid(gid)
+		if err != nil {
+			return errors.New(fmt.Sprintf("setgid: %s", err.Error()))
+		}
+		err = syscall.Setuid(uid)
+		if err != nil {
+			return errors.New(fmt.Sprintf("setuid: %s", err.Error()))
+		}
+	}
+
+	binaryPath := args[6]
+	err := syscall.Exec(binaryPath, append([]string{binaryPath}, args[7:]...), os.Environ())
+	return errors.New(fmt.Sprintf("exec %s: %s", binaryPath, err.Error()))
+}
+
+func (this *TaskSandbox) createCgroup() error {
+	cgroup := strings.TrimSuffix(config.SandboxCgroupDir, "/") + "/" + this.Name
+	err := os.Mkdir(cgroup, 0755)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+	this.cgroup = cgroup
+
+	if this.limits.MemoryLimitMB > 0 {
+		limit := strconv.Itoa(this.limits.MemoryLimitMB * 1024 * 1024)
+		err = os.WriteFile(cgroup+"/memory.max", []byte(limit), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	if this.limits.ProcessLimit > 0 {
+		err = os.WriteFile(cgroup+"/pids.max", []byte(strconv.Itoa(this.limits.ProcessLimit)), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// event counts of the task cgroup before a run, zero without cgroup
+func (this *TaskSandbox) limitEvents() limitEvents {
+	events := limitEvents{time: time.Now()}
+	if len(this.cgroup) > 0 {
+		events.oomKills = readCgroupEvent(this.cgroup+"/memory.events", "oom_kill")
+		events.pidsMax = readCgroupEvent(this.cgroup+"/pids.events", "max")
+	}
+	return events
+}
+
+// limit a failed run ran into, told by the cgroup events since the run started, the signal that killed
+// the executable and the files it left, empty if none
+func (this *TaskSandbox) detectLimitViolation(state *os.ProcessState, before limitEvents) string {
+	if state == nil {
+		return ""
+	}
+
+	after := this.limitEvents()
+	if after.oomKills > before.oomKills {
+		return util.TASK_FAILURE_MEMORY_LIMIT
+	}
+	if after.pidsMax > before.pidsMax {
+		return util.TASK_FAILURE_PROCESS_LIMIT
+	}
+
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() && this.limits.CpuLimitSeconds > 0 {
+		signal := status.Signal()
+		cpuTime := state.UserTime() + state.SystemTime()
+		if (signal == syscall.SIGKILL || signal == syscall.SIGXCPU) && cpuTime >= time.Duration(this.limits.CpuLimitSeconds)*time.Second {
+			return util.TASK_FAILURE_CPU_LIMIT
+		}
+	}
+
+	// writes past RLIMIT_FSIZE fail and go programs ignore the SIGXFSZ sent along, look for a file of the
+	// limit size written by the run
+	if this.limits.FileSizeLimitMB > 0 {
+		limit := int64(this.limits.FileSizeLimitMB) * 1024 * 1024
+		found := false
+		filepath.Walk(this.Dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() && info.Size() >= limit && !info.ModTime().Before(before.time) {
+				found = true
+			}
+			return nil
+		})
+		if found {
+			return util.TASK_FAILURE_FILE_SIZE_LIMIT
+		}
+	}
+	return ""
+}
+
+// value of a key of a cgroup v2 events file such as memory.events, 0 if it cannot be read
+func readCgroupEvent(filePath string, key string) int64 {
+	events, err := os.ReadFile(filePath)
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(events), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			count, _ := strconv.ParseInt(fields[1], 10, 64)
+			return count
+		}
+	}
+	return 0
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+func setRlimit(resource int, soft uint64, hard uint64) error {
+	limit := syscall.Rlimit{Cur: soft, Max: hard}
+	err := syscall.Setrlimit(resource, &limit)
+	if err != nil {
+		return errors.New(fmt.Sprintf("setrlimit on resource %d: %s", resource, err.Error()))
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+//go:build !linux
+
+package maplejuice
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// resource limits, cgroups and dedicated users are only supported on linux
+
+func (this *TaskSandbox) command(binaryPath string, args []string) (*exec.Cmd, error) {
+	if this.limits.IsLimited() || this.uid >= 0 {
+		return nil, errors.New("sandbox limits are only supported on linux")
+	}
+	return exec.Command(binaryPath, args...), nil
+}
+
+func (this *TaskSandbox) start(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
+
+func SandboxExec(args []string) {
+	fmt.Fprintf(os.Stderr, "%s: only supported on linux\n", SANDBOX_EXEC_CMD)
+	os.Exit(SANDBOX_EXEC_FAILURE_EXIT_CODE)
+}
+
+func (this *TaskSandbox) createCgroup() error {
+	return errors.New("cgroups are only supported on linux")
+}
+
+func (this *TaskSandbox) limitEvents() limitEvents {
+	return limitEvents{}
+}
+
+func (this *TaskSandbox) detectLimitViolation(state *os.ProcessState, before limitEvents) string {
+	return ""
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,324 @@
+package maplejuice
+
+import (
+	"maple-juice/config"
+	"maple-juice/dfs"
+	"maple-juice/leaderelection"
+	"maple-juice/membership"
+	"maple-juice/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recurring jobs: schedules are stored in SDFS as mjschedule_<name>.json and run by the job manager of
+// the current leader. A new leader picks the schedules up from SDFS, runs missed during the failover
+// are not made up for. Schedules are synced from SDFS periodically since SDFS metadata may still be
+// incomplete right after a failover.
+
+const (
+	SCHEDULER_TICK         time.Duration = 5 * time.Second
+	SCHEDULE_SYNC_INTERVAL time.Duration = 30 * time.Second
+)
+
+var scheduleNameRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+type jobSchedule struct {
+	spec       util.ScheduledJob
+	cron       *util.CronSchedule
+	nextRun    time.Time
+	lastRun    time.Time
+	lastJobId  int32
+	lastStatus string
+	running    bool
+	queued     bool
+}
+
+type JobScheduler struct {
+	schedules map[string]*jobSchedule
+	lastSync  time.Time
+	lock      sync.Mutex
+}
+
+func NewJobScheduler() *JobScheduler {
+	return &JobScheduler{
+		schedules: make(map[string]*jobSchedule),
+	}
+}
+
+func newJobSchedule(spec *util.ScheduledJob) (*jobSchedule, error) {
+	cron, err := util.ParseCronSchedule(spec.Cron)
+	if err != nil {
+		return nil, err
+	}
+	nextRun := cron.Next(time.Now())
+	if nextRun.IsZero() {
+		return nil, errors.New(fmt.Sprintf("Cron schedule (%s) never runs", spec.Cron))
+	}
+	return &jobSchedule{
+		spec:    *spec,
+		cron:    cron,
+		nextRun: nextRun,
+	}, nil
+}
+
+// clients add schedules through MRJobSubmission.AddSchedule, which records the submitting node
+func (this *MRJobManager) addSchedule(spec *util.ScheduledJob, reply *string) error {
+	if membership.SelfNodeId != leaderelection.LeaderId {
+		return errors.New("Please contact leader for Maple Juice job scheduling")
+	}
+	if !scheduleNameRegex.MatchString(spec.Name) {
+		return errors.New(fmt.Sprintf("Invalid schedule name (%s), only letters, digits and underscores are allowed", spec.Name))
+	}
+	if spec.Overlap != util.SCHEDULE_OVERLAP_SKIP && spec.Overlap != util.SCHEDULE_OVERLAP_QUEUE {
+		return errors.New(fmt.Sprintf("Invalid overlap policy (%s), expecting %s or %s", spec.Overlap, util.SCHEDULE_OVERLAP_SKIP, util.SCHEDULE_OVERLAP_QUEUE))
+	}
+	schedule, err := newJobSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	this.scheduler.lock.Lock()
+	_, exists := this.scheduler.schedules[spec.Name]
+	this.scheduler.lock.Unlock()
+	if exists {
+		return errors.New(fmt.Sprintf("Schedule %s already exists", spec.Name))
+	}
+
+	err = saveSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	this.scheduler.lock.Lock()
+	this.scheduler.schedules[spec.Name] = schedule
+	this.scheduler.lock.Unlock()
+
+	log.Printf("Added schedule %s (%s), next run at %s", spec.Name, spec.Cron, schedule.nextRun.Format(time.RFC3339))
+	*reply = schedule.nextRun.Format(time.RFC3339)
+	return nil
+}
+
+// running jobs of the schedule are not affected
+func (this *MRJobManager) RemoveSchedule(name *string, reply *string) error {
+	if membership.SelfNodeId != leaderelection.LeaderId {
+		return errors.New("Please contact leader for Maple Juice job scheduling")
+	}
+	this.syncSchedules()
+
+	this.scheduler.lock.Lock()
+	_, exists := this.scheduler.schedules[*name]
+	this.scheduler.lock.Unlock()
+	if !exists {
+		return errors.New(fmt.Sprintf("Schedule %s not found", *name))
+	}
+
+	err := dfs.SDFSDeleteFile(fmtScheduleFileName(*name))
+	if err != nil {
+		return err
+	}
+
+	this.scheduler.lock.Lock()
+	delete(this.scheduler.schedules, *name)
+	this.scheduler.lock.Unlock()
+
+	log.Printf("Removed schedule %s", *name)
+	*reply = "ACK"
+	return nil
+}
+
+func (this *MRJobManager) ListSchedules(arg *string, reply *[]util.ScheduleStatus) error {
+	if membership.SelfNodeId != leaderelection.LeaderId {
+		return errors.New("Please contact leader for Maple Juice job scheduling")
+	}
+	this.syncSchedules()
+
+	this.scheduler.lock.Lock()
+	defer this.scheduler.lock.Unlock()
+
+	statuses := make([]util.ScheduleStatus, 0)
+	for _, schedule := range this.scheduler.schedules {
+		statuses = append(statuses, util.ScheduleStatus{
+			Name:       schedule.spec.Name,
+			Cron:       schedule.spec.Cron,
+			Overlap:    schedule.spec.Overlap,
+			Command:    schedule.spec.Command,
+			NextRun:    schedule.nextRun,
+			LastRun:    schedule.lastRun,
+			LastJobId:  schedule.lastJobId,
+			LastStatus: schedule.lastStatus,
+			Running:    schedule.running,
+			Queued:     schedule.queued,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	*reply = statuses
+	return nil
+}
+
+// main loop of the scheduler, only the leader submits scheduled jobs
+func (this *MRJobManager) runScheduler() {
+	for {
+		time.Sleep(SCHEDULER_TICK)
+
+		if membership.SelfNodeId != leaderelection.LeaderId {
+			// schedules are reloaded from SDFS once this node becomes leader again
+			this.scheduler.lock.Lock()
+			if len(this.scheduler.schedules) > 0 {
+				this.scheduler.schedules = make(map[string]*jobSchedule)
+			}
+			this.scheduler.lastSync = time.Time{}
+			this.scheduler.lock.Unlock()
+			continue
+		}
+
+		this.scheduler.lock.Lock()
+		needSync := time.Since(this.scheduler.lastSync) >= SCHEDULE_SYNC_INTERVAL
+		this.scheduler.lock.Unlock()
+		if needSync {
+			this.syncSchedules()
+		}
+
+		now := time.Now()
+		this.scheduler.lock.Lock()
+		for _, schedule := range this.scheduler.schedules {
+			if schedule.nextRun.After(now) {
+				continue
+			}
+			schedule.nextRun = schedule.cron.Next(now)
+			this.triggerSchedule(schedule)
+		}
+		this.scheduler.lock.Unlock()
+	}
+}
+
+// start a run of the schedule, caller holds the scheduler lock
+func (this *MRJobManager) triggerSchedule(schedule *jobSchedule) {
+	if !schedule.running {
+		schedule.running = true
+		go this.runScheduledJobs(schedule)
+		return
+	}
+
+	if schedule.spec.Overlap == util.SCHEDULE_OVERLAP_QUEUE && !schedule.queued {
+		log.Printf("Schedule %s: previous run is still going, queueing this run", schedule.spec.Name)
+		schedule.queued = true
+		return
+	}
+	log.Printf("Schedule %s: previous run is still going, skipping this run", schedule.spec.Name)
+}
+
+// run the scheduled job, then the queued run if any
+func (this *MRJobManager) runScheduledJobs(schedule *jobSchedule) {
+	for {
+		log.Printf("Schedule %s: submitting %s", schedule.spec.Name, schedule.spec.Command)
+		request := schedule.spec.Request
+		reply := ""
+		err := this.submitJob(&request, &reply)
+
+		status := JOB_STATUS_SUCCEEDED
+		if err != nil {
+			log.Printf("Schedule %s: job %d failed: %s", schedule.spec.Name, request.JobId, err.Error())
+			status = JOB_STATUS_FAILED
+		}
+
+		this.scheduler.lock.Lock()
+		schedule.lastRun = time.Now()
+		schedule.lastJobId = request.JobId
+		schedule.lastStatus = status
+		if !schedule.queued {
+			schedule.running = false
+			this.scheduler.lock.Unlock()
+			return
+		}
+		schedule.queued = false
+		this.scheduler.lock.Unlock()
+	}
+}
+
+// add schedules found in SDFS but not yet known by this job manager
+func (this *MRJobManager) syncSchedules() {
+	this.scheduler.lock.Lock()
+	this.scheduler.lastSync = time.Now()
+	this.scheduler.lock.Unlock()
+
+	fileNames, err := dfs.SDFSSearchFileByRegex(`^mjschedule_[A-Za-z0-9_]+\.json$`)
+	if err != nil {
+		log.Printf("Failed to search schedules in SDFS: %s", err.Error())
+		return
+	}
+
+	for _, fileName := range *fileNames {
+		name := strings.TrimSuffix(strings.TrimPrefix(fileName, "mjschedule_"), ".json")
+		this.scheduler.lock.Lock()
+		_, exists := this.scheduler.schedules[name]
+		this.scheduler.lock.Unlock()
+		if exists {
+			continue
+		}
+
+		spec, err := loadSchedule(fileName)
+		if err != nil {
+			log.Printf("Failed to load schedule %s: %s", fileName, err.Error())
+			continue
+		}
+		schedule, err := newJobSchedule(spec)
+		if err != nil {
+			log.Printf("Failed to load schedule %s: %s", fileName, err.Error())
+			continue
+		}
+
+		this.scheduler.lock.Lock()
+		if _, exists := this.scheduler.schedules[name]; !exists {
+			this.scheduler.schedules[name] = schedule
+			log.Printf("Loaded schedule %s (%s), next run at %s", name, spec.Cron, schedule.nextRun.Format(time.RFC3339))
+		}
+		this.scheduler.lock.Unlock()
+	}
+}
+
+func saveSchedule(spec *util.ScheduledJob) error {
+	content, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fileName := fmtScheduleFileName(spec.Name)
+	err = os.WriteFile(config.JobManagerFileDir+fileName, content, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(config.JobManagerFileDir + fileName)
+
+	_, err = dfs.SDFSPutFile(fileName, config.JobManagerFileDir+fileName)
+	return err
+}
+
+func loadSchedule(fileName string) (*util.ScheduledJob, error) {
+	err := dfs.SDFSGetFile(fileName, fileName, dfs.RECEIVER_MR_JOB_MANAGER)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(config.JobManagerFileDir + fileName)
+	os.Remove(config.JobManagerFileDir + fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &util.ScheduledJob{}
+	err = json.Unmarshal(content, spec)
+	return spec, err
+}
+
+func fmtScheduleFileName(name string) string {
+	return fmt.Sprintf("mjschedule_%s.json", name)
+}
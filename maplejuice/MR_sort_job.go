@@ -0,0 +1,228 @@
+package maplejuice
+
+import (
+	"maple-juice/config"
+	"maple-juice/dfs"
+	"maple-juice/util"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// total-order sort jobs: the job manager samples sort keys of the input and picks N-1 split points, then
+// runs a maple phase sending every record to the range of its key (intermediate key part<n>) and a juice
+// phase sorting each range. Output <dest>-part<n> holds range n, concatenating the outputs by name gives
+// the globally sorted input. Ranges without records get an empty output so there are always N outputs.
+// Both executables are generated from templates with the split points and comparator embedded.
+// With a limit each maple task only passes on its first records under the sort order, all to the first range.
+
+const (
+	SORT_SAMPLE_SIZE int = 10000
+)
+
+// task attempts of both phases are added to record, each phase runs with its own job id
+func (this *MRJobManager) executeSortJob(job *util.SortJobRequest, policy *util.JobPolicy, errorMsgChan *chan error, jobId int32, counters *JobCounters, record *JobRecord) {
+	*errorMsgChan <- this.runSortJob(job, policy, jobId, counters, record)
+}
+
+func (this *MRJobManager) runSortJob(job *util.SortJobRequest, policy *util.JobPolicy, jobId int32, counters *JobCounters, record *JobRecord) error {
+	if job.OutputFileNum <= 0 || job.OutputFileNum > util.SORT_MAX_OUTPUT_NUM {
+		return errors.New(fmt.Sprintf("Invalid number of sort outputs %d", job.OutputFileNum))
+	}
+	if job.Limit < 0 {
+		return errors.New(fmt.Sprintf("Invalid sort limit %d", job.Limit))
+	}
+	if len(job.Keys) == 0 {
+		job.Keys = []util.SortKey{{SortType: util.SORT_TYPE_STRING}}
+	}
+	if job.SampleSize <= 0 {
+		job.SampleSize = SORT_SAMPLE_SIZE
+	}
+
+	// stage 1: sample the input and pick split points, the maple phase fetches the input once more
+	spec := &util.SortTaskSpec{
+		Delimiter:    job.Delimiter,
+		HasHeader:    job.HasHeader,
+		HeaderOutput: fmtSortOutputName(job.OutputFileName, 0),
+		Limit:        job.Limit,
+	}
+	sampleFileName := fmt.Sprintf("sort_sample_job%d", jobId)
+	os.Remove(config.JobManagerFileDir + sampleFileName)
+	defer os.Remove(config.JobManagerFileDir + sampleFileName)
+	inputFileName, err := this.fetchSortInput(job, jobId, sampleFileName)
+	if err != nil {
+		return err
+	}
+	if inputFileName != job.SrcSdfsFileName {
+		defer dfs.SDFSDeleteFile(inputFileName)
+	}
+	header, sample, err := util.SampleSortKeys(config.JobManagerFileDir+sampleFileName, spec, job.Keys, job.SampleSize)
+	os.Remove(config.JobManagerFileDir + sampleFileName)
+	if err != nil {
+		return err
+	}
+	spec.Header = header
+
+	// outputs of an earlier sort into the same destination may outnumber the new ones
+	err = cleanUpSortOutput(job.OutputFileName)
+	if err != nil {
+		return err
+	}
+	if len(sample) == 0 {
+		log.Printf("Sort job %d: input has no records", jobId)
+		return completeSortOutput(job, spec)
+	}
+
+	// with a limit each maple task keeps its first records, which fit into a single range
+	if job.Limit == 0 {
+		spec.SplitPoints = util.ChooseSplitPoints(sample, job.OutputFileNum, spec.Keys)
+	}
+	log.Printf("Sort job %d: picked %d split points from %d sampled keys", jobId, len(spec.SplitPoints), len(sample))
+
+	// stage 2: generate executables with the split points embedded
+	timestamp := time.Now().UnixMilli()
+	mapleExeName := fmt.Sprintf("sort_maple_job%d_%d.go", jobId, timestamp)
+	juiceExeName := fmt.Sprintf("sort_juice_job%d_%d.go", jobId, timestamp)
+	executables := map[string]string{mapleExeName: util.SORT_MAPLE_TEMPLATE, juiceExeName: util.SORT_JUICE_TEMPLATE}
+	for exeName, templateName := range executables {
+		err := util.GenerateSortExecutable(templateName, spec, config.JobManagerFileDir+exeName)
+		if err != nil {
+			return err
+		}
+		_, err = dfs.SDFSPutFile(exeName, config.JobManagerFileDir+exeName)
+		os.Remove(config.JobManagerFileDir + exeName)
+		if err != nil {
+			return err
+		}
+		defer dfs.SDFSDeleteFile(exeName)
+	}
+
+	// stage 3: range partition records
+	if this.isJobCancelled(jobId) {
+		return errJobCancelled
+	}
+	mapleJob := util.MapleJobRequest{
+		ExcecutableFileName: mapleExeName,
+		TaskNum:             job.MapleTaskNum,
+		SrcSdfsFileName:     inputFileName,
+		OutputFilePrefix:    fmt.Sprintf("sort_job%d_%d", jobId, timestamp),
+		PreserveInputHeader: job.HasHeader,
+	}
+	// CSV records may span lines, input splits must not cut them
+	if job.Delimiter == util.SORT_DELIMITER_CSV {
+		mapleJob.InputFormat = util.RECORD_FORMAT_CSV
+	}
+	mapleJobId := this.jobUuid.Add(1)
+	log.Printf("Sort job %d: running maple phase as job %d", jobId, mapleJobId)
+	errorMsgChan := make(chan error, 1)
+	this.executeMapleJob(&mapleJob, policy, &errorMsgChan, mapleJobId, counters, record)
+	err = <-errorMsgChan
+	if err != nil {
+		return err
+	}
+
+	// stage 4: sort every range
+	juiceJob := util.JuiceJobRequest{
+		ExcecutableFileName: juiceExeName,
+		TaskNum:             job.JuiceTaskNum,
+		SrcSdfsFilePrefix:   mapleJob.OutputFilePrefix,
+		OutputFileName:      job.OutputFileName,
+		DeleteInput:         true,
+		IsHashPartition:     false,
+	}
+	juiceJobId := this.jobUuid.Add(1)
+	log.Printf("Sort job %d: running juice phase as job %d", jobId, juiceJobId)
+	this.executeJuiceJob(&juiceJob, &job.JuicePolicy, &errorMsgChan, juiceJobId, counters, record)
+	err = <-errorMsgChan
+	if err != nil {
+		return err
+	}
+
+	return completeSortOutput(job, spec)
+}
+
+// fetch the sort input to the job manager folder, input given by prefix is concatenated and stored in
+// SDFS for the maple phase under the returned name
+func (this *MRJobManager) fetchSortInput(job *util.SortJobRequest, jobId int32, localFileName string) (string, error) {
+	if len(job.SrcSdfsFilePrefix) == 0 {
+		err := dfs.SDFSFetchAndConcat([]string{job.SrcSdfsFileName}, localFileName, dfs.RECEIVER_MR_JOB_MANAGER)
+		return job.SrcSdfsFileName, err
+	}
+
+	fileNames, err := dfs.SDFSSearchFileByRegex("^" + regexp.QuoteMeta(job.SrcSdfsFilePrefix) + "-")
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(*fileNames)
+	err = os.WriteFile(config.JobManagerFileDir+localFileName, []byte{}, 0644)
+	if err == nil {
+		err = dfs.SDFSFetchAndConcat(*fileNames, localFileName, dfs.RECEIVER_MR_JOB_MANAGER)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	inputFileName := fmt.Sprintf("sort_input_job%d", jobId)
+	_, err = dfs.SDFSPutFile(inputFileName, config.JobManagerFileDir+localFileName)
+	return inputFileName, err
+}
+
+// write empty outputs for ranges without records, the first output still carries the header
+func completeSortOutput(job *util.SortJobRequest, spec *util.SortTaskSpec) error {
+	fileNames, err := dfs.SDFSSearchFileByRegex(fmtSortOutputRegex(job.OutputFileName))
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, fileName := range *fileNames {
+		existing[fileName] = true
+	}
+
+	for rangeNumber := 0; rangeNumber < job.OutputFileNum; rangeNumber++ {
+		fileName := fmtSortOutputName(job.OutputFileName, rangeNumber)
+		if existing[fileName] {
+			continue
+		}
+
+		content := ""
+		if rangeNumber == 0 && job.HasHeader {
+			content = spec.Header + "\n"
+		}
+		err := os.WriteFile(config.JobManagerFileDir+fileName, []byte(content), 0644)
+		if err != nil {
+			return err
+		}
+		_, err = dfs.SDFSPutFile(fileName, config.JobManagerFileDir+fileName)
+		os.Remove(config.JobManagerFileDir + fileName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cleanUpSortOutput(outputFileName string) error {
+	fileNames, err := dfs.SDFSSearchFileByRegex(fmtSortOutputRegex(outputFileName))
+	if err != nil {
+		return err
+	}
+
+	var err1 error
+	for _, fileName := range *fileNames {
+		log.Printf("Deleting output of an earlier sort: " + fileName)
+		err1 = dfs.SDFSDeleteFile(fileName)
+	}
+	return err1
+}
+
+func fmtSortOutputName(outputFileName string, rangeNumber int) string {
+	return outputFileName + "-" + util.FmtSortRangeKey(rangeNumber)
+}
+
+func fmtSortOutputRegex(outputFileName string) string {
+	return "^" + regexp.QuoteMeta(outputFileName) + "-part\\d+$"
+}
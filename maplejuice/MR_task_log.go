@@ -0,0 +1,191 @@
+package maplejuice
+
+import (
+	"maple-juice/config"
+	"maple-juice/dfs"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// stdout and stderr of a task attempt, stored in SDFS under a job log prefix once the attempt ends
+// user counters reported through stderr are collected along the way. Job ids restart with every leader,
+// so log names also carry the id of the leader that ran the job to tell runs of the same job id apart
+
+type TaskLog struct {
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+	counters *JobCounters
+	lock     sync.Mutex
+}
+
+func NewTaskLog() *TaskLog {
+	return &TaskLog{
+		counters: NewJobCounters(),
+	}
+}
+
+// record output of one executable run, a juice task runs its executable once per key
+func (this *TaskLog) Append(title string, stdout []byte, stderr []byte) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if len(title) > 0 {
+		header := fmt.Sprintf("----- %s -----\n", title)
+		this.stdout.WriteString(header)
+		this.stderr.WriteString(header)
+	}
+	this.stdout.Write(stdout)
+	this.stderr.Write(stderr)
+	this.counters.Add(parseCounterLines(stderr))
+}
+
+// counters reported by all executable runs of the attempt
+func (this *TaskLog) Counters() map[string]int64 {
+	return this.counters.Snapshot()
+}
+
+// record a failure raised by the node manager itself, e.g. failing to fetch input
+func (this *TaskLog) AppendError(err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.stderr.WriteString(fmt.Sprintf("----- node manager -----\ntask attempt failed: %s\n", err.Error()))
+}
+
+// upload stdout and stderr of a task attempt to SDFS
+func (this *TaskLog) Upload(leaderId string, jobId int32, isMaple bool, taskNumber int, attempt int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	streams := map[string][]byte{
+		"stdout": this.stdout.Bytes(),
+		"stderr": this.stderr.Bytes(),
+	}
+
+	for stream, content := range streams {
+		fileName := fmtTaskLogName(leaderId, jobId, isMaple, taskNumber, attempt, stream)
+		err := os.WriteFile(config.NodeManagerFileDir+fileName, content, 0644)
+		if err != nil {
+			log.Printf("Failed to write task log %s: %s", fileName, err.Error())
+			continue
+		}
+		_, err = dfs.SDFSPutFile(fileName, config.NodeManagerFileDir+fileName)
+		os.Remove(config.NodeManagerFileDir + fileName)
+		if err != nil {
+			log.Printf("Failed to upload task log %s: %s", fileName, err.Error())
+		}
+	}
+}
+
+func fmtTaskLogName(leaderId string, jobId int32, isMaple bool, taskNumber int, attempt int, stream string) string {
+	taskName := "maple"
+	if !isMaple {
+		taskName = "juice"
+	}
+	return fmt.Sprintf("mjlog_job%d_%s_%s_task%d_attempt%d.%s", jobId, fmtTaskLogRunName(leaderId), taskName, taskNumber, attempt, stream)
+}
+
+// leader node id <ip>:<port>-<startup_ts> as <ip>_<port>_<startup_ts>
+func fmtTaskLogRunName(leaderId string) string {
+	return strings.NewReplacer(":", "_", "-", "_").Replace(leaderId)
+}
+
+// the first submatch is the run name of the leader
+func fmtTaskLogRegex(jobId int32, taskNumber string) string {
+	if len(taskNumber) == 0 {
+		taskNumber = "\\d+"
+	}
+	return fmt.Sprintf("^mjlog_job%d_([\\d._]+)_(maple|juice)_task%s_attempt\\d+\\.(stdout|stderr)$", jobId, taskNumber)
+}
+
+// startup timestamp of the leader in a run name, runs of a job id are printed in the order of their leaders
+func taskLogRunStartTime(runName string) int64 {
+	startTime, _ := strconv.ParseInt(runName[strings.LastIndex(runName, "_")+1:], 10, 64)
+	return startTime
+}
+
+// job logs <job_id> [task_number]
+// fetch captured stdout and stderr of every attempt of a job's tasks and print them
+func printJobLogs(args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		log.Print("Usage: job logs <job_id> [task_number]")
+		return errors.New("Invalid job logs command")
+	}
+
+	jobId, err := strconv.Atoi(args[0])
+	if err != nil {
+		log.Print("Invalid job id")
+		return errors.New("Invalid job id")
+	}
+
+	taskNumber := ""
+	if len(args) == 2 {
+		_, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Print("Invalid task number")
+			return errors.New("Invalid task number")
+		}
+		taskNumber = args[1]
+	}
+
+	logRegex := fmtTaskLogRegex(int32(jobId), taskNumber)
+	fileNames, err := dfs.SDFSSearchFileByRegex(logRegex)
+	if err != nil {
+		return err
+	}
+
+	if len(*fileNames) == 0 {
+		fmt.Printf("No logs found for job %d\n", jobId)
+		return nil
+	}
+
+	// group the logs by the leader that ran the job
+	runs := make(map[string][]string)
+	runNames := make([]string, 0)
+	r := regexp.MustCompile(logRegex)
+	for _, fileName := range *fileNames {
+		runName := r.FindStringSubmatch(fileName)[1]
+		if _, exists := runs[runName]; !exists {
+			runNames = append(runNames, runName)
+		}
+		runs[runName] = append(runs[runName], fileName)
+	}
+	sort.Slice(runNames, func(i, j int) bool {
+		return taskLogRunStartTime(runNames[i]) < taskLogRunStartTime(runNames[j])
+	})
+
+	for _, runName := range runNames {
+		if len(runNames) > 1 {
+			fmt.Printf("########## job %d run by leader %s ##########\n", jobId, runName)
+		}
+		sort.Strings(runs[runName])
+		for _, fileName := range runs[runName] {
+			printTaskLog(fileName)
+		}
+	}
+	return nil
+}
+
+func printTaskLog(fileName string) {
+	err := dfs.SDFSGetFile(fileName, fileName, dfs.RECEIVER_SDFS_CLIENT)
+	if err != nil {
+		log.Printf("Failed to fetch task log %s: %s", fileName, err.Error())
+		return
+	}
+
+	content, err := os.ReadFile(config.LocalFileDir + fileName)
+	os.Remove(config.LocalFileDir + fileName)
+	if err != nil {
+		log.Printf("Failed to read task log %s: %s", fileName, err.Error())
+		return
+	}
+	fmt.Printf("========== %s ==========\n%s\n", fileName, string(content))
+}
@@ -0,0 +1,101 @@
+package maplejuice
+
+import (
+	"maple-juice/config"
+	"maple-juice/dfs"
+	"maple-juice/membership"
+	"maple-juice/util"
+	"log"
+	"os"
+)
+
+// auto task count mode, see util/task_num.go
+// the job manager remembers how many bytes maple jobs wrote under each intermediate prefix so that
+// a following juice job can be sized by its input, the size is dropped once that juice job ends. Sizes
+// unknown to the leader, e.g. after a failover or for a second juice job on kept intermediate files, are
+// rebuilt from the SDFS metadata of the intermediate files
+
+// pick the number of maple tasks from the size of the fetched input file
+func autoMapleTaskNum(job *util.MapleJobRequest, inputFilePath string) (int, error) {
+	info, err := os.Stat(inputFilePath)
+	if err != nil {
+		return 0, err
+	}
+	taskNum := util.AutoMapleTaskNum(info.Size(), job.SplitSizeMB)
+	log.Printf("Auto task number: input file %s has %d bytes, using %d Maple tasks", job.SrcSdfsFileName, info.Size(), taskNum)
+	return taskNum, nil
+}
+
+// pick the number of juice tasks from the free task slots and the size of the intermediate files
+func (this *MRJobManager) autoJuiceTaskNum(job *util.JuiceJobRequest, intermediateFiles []string) int {
+	freeSlots := this.freeTaskSlots()
+
+	this.mapLock.Lock()
+	intermediateBytes, exists := this.intermediateBytes[job.SrcSdfsFilePrefix]
+	this.mapLock.Unlock()
+
+	if !exists {
+		var err error
+		intermediateBytes, err = sdfsTotalFileSize(intermediateFiles)
+		if err != nil {
+			log.Printf("Auto task number: size of intermediate data %s unknown (%s), using %d Juice tasks", job.SrcSdfsFilePrefix, err.Error(), freeSlots)
+			return freeSlots
+		}
+	}
+	taskNum := util.AutoJuiceTaskNum(intermediateBytes, freeSlots)
+	log.Printf("Auto task number: intermediate data %s has %d bytes, %d free slots, using %d Juice tasks", job.SrcSdfsFilePrefix, intermediateBytes, freeSlots, taskNum)
+	return taskNum
+}
+
+// task slots not taken by running tasks summed over alive workers,
+// the number of workers if every worker is busy
+func (this *MRJobManager) freeTaskSlots() int {
+	this.mapLock.Lock()
+	defer this.mapLock.Unlock()
+
+	if len(this.workerNode2Tasks) == 0 {
+		for _, ip := range membership.LocalMembershipList.AliveMembers() {
+			this.workerNode2Tasks[ip] = make([]string, 0)
+		}
+	}
+
+	freeSlots := 0
+	for _, tasks := range this.workerNode2Tasks {
+		if len(tasks) < config.WorkerTaskSlots {
+			freeSlots += config.WorkerTaskSlots - len(tasks)
+		}
+	}
+	if freeSlots == 0 {
+		freeSlots = len(this.workerNode2Tasks)
+	}
+	if freeSlots == 0 {
+		freeSlots = 1
+	}
+	return freeSlots
+}
+
+// bytes of SDFS files as reported by their replicas
+func sdfsTotalFileSize(fileNames []string) (int64, error) {
+	total := int64(0)
+	for _, fileName := range fileNames {
+		size, err := dfs.SDFSFileSize(fileName)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// record bytes written by a successful maple task attempt under an intermediate prefix
+func (this *MRJobManager) addIntermediateBytes(filePrefix string, bytes int64) {
+	this.mapLock.Lock()
+	defer this.mapLock.Unlock()
+	this.intermediateBytes[filePrefix] += bytes
+}
+
+func (this *MRJobManager) removeIntermediateBytes(filePrefix string) {
+	this.mapLock.Lock()
+	defer this.mapLock.Unlock()
+	delete(this.intermediateBytes, filePrefix)
+}
@@ -0,0 +1,167 @@
+package maplejuice
+
+import (
+	"maple-juice/config"
+	"maple-juice/util"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// archived executables: an executable named *.tar, *.tar.gz, *.tgz or *.zip is a Go module, with go.mod at
+// the archive root or in its only top-level folder and the main package at the module root. Worker nodes
+// unpack it into a per job workspace and build it once per job. Builds are offline: dependencies must be
+// vendored under vendor/ (go mod vendor), neither the module proxy nor the checksum database is used.
+
+const (
+	WORKSPACE_SOURCE_DIR string = "src/"
+)
+
+var offlineBuildEnv = []string{"GOFLAGS=-mod=vendor", "GOPROXY=off", "GOSUMDB=off", "GOWORK=off", "GOTOOLCHAIN=local"}
+
+type jobWorkspace struct {
+	ready      chan struct{} // closed once the build completes
+	binaryPath string
+	err        error
+}
+
+type WorkspaceManager struct {
+	workspaces map[string]*jobWorkspace
+	lock       sync.Mutex
+}
+
+func NewWorkspaceManager() *WorkspaceManager {
+	return &WorkspaceManager{
+		workspaces: make(map[string]*jobWorkspace),
+	}
+}
+
+// clean up workspaces left over by a previous run
+func (this *WorkspaceManager) Reset() {
+	err := os.RemoveAll(config.WorkspaceFileDir)
+	if err != nil {
+		log.Print("Failed to clean up workspace folder", err)
+	}
+	err = os.MkdirAll(config.WorkspaceFileDir, 0755)
+	if err != nil {
+		log.Print("Failed to create workspace folder", err)
+	}
+}
+
+// unpack and build an archived executable if not done yet for the job on this node, blocks until built
+// extra sources are added to the main package, returns the path of the binary
+func (this *WorkspaceManager) Build(leaderId string, jobId int32, archivePath string, extraSources map[string][]byte, taskLog *TaskLog) (string, error) {
+	name := fmtWorkspaceName(leaderId, jobId, filepath.Base(archivePath))
+
+	this.lock.Lock()
+	workspace, exists := this.workspaces[name]
+	if !exists {
+		workspace = &jobWorkspace{ready: make(chan struct{})}
+		this.workspaces[name] = workspace
+	}
+	this.lock.Unlock()
+
+	if exists {
+		<-workspace.ready
+	} else {
+		log.Printf("Building archived executable %s for job %d", filepath.Base(archivePath), jobId)
+		workspace.binaryPath, workspace.err = BuildModuleArchive(archivePath, config.WorkspaceFileDir+name+"/", extraSources, taskLog)
+		close(workspace.ready)
+		if workspace.err != nil {
+			// let the next attempt build again
+			this.lock.Lock()
+			delete(this.workspaces, name)
+			this.lock.Unlock()
+		}
+	}
+	return workspace.binaryPath, workspace.err
+}
+
+// remove workspaces of a completed job
+func (this *WorkspaceManager) Release(leaderId string, jobId int32) {
+	prefix := fmtWorkspaceName(leaderId, jobId, "")
+	this.lock.Lock()
+	for name := range this.workspaces {
+		if strings.HasPrefix(name, prefix) {
+			delete(this.workspaces, name)
+		}
+	}
+	this.lock.Unlock()
+
+	dirs, err := filepath.Glob(config.WorkspaceFileDir + prefix + "*")
+	if err != nil {
+		return
+	}
+	for _, dir := range dirs {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			log.Printf("Failed to clean up workspace %s: %s", dir, err.Error())
+		}
+	}
+}
+
+// unpack a Go module archive under workspaceDir and build its main package offline with vendored dependencies,
+// returns the path of the binary
+func BuildModuleArchive(archivePath string, workspaceDir string, extraSources map[string][]byte, taskLog *TaskLog) (string, error) {
+	sourceDir := workspaceDir + WORKSPACE_SOURCE_DIR
+	os.RemoveAll(workspaceDir)
+	err := os.MkdirAll(sourceDir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	err = util.ExtractArchive(archivePath, sourceDir, int64(config.ExecutableArchiveLimitMB)*1024*1024)
+	if err != nil {
+		return "", util.NewTaskFailure(util.TASK_FAILURE_EXECUTABLE, "failed to unpack executable archive: "+err.Error())
+	}
+	moduleDir, err := findModuleRoot(sourceDir)
+	if err != nil {
+		return "", util.NewTaskFailure(util.TASK_FAILURE_EXECUTABLE, err.Error())
+	}
+
+	for fileName, content := range extraSources {
+		err := os.WriteFile(filepath.Join(moduleDir, fileName), content, 0644)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	binaryPath := workspaceDir + SANDBOX_BINARY_NAME
+	err = goBuild(moduleDir, []string{"build", "-mod=vendor", "-o", binaryPath, "."}, offlineBuildEnv, taskLog)
+	if err != nil {
+		return "", err
+	}
+	return binaryPath, nil
+}
+
+// go.mod is expected at the archive root or in its only top-level folder
+func findModuleRoot(sourceDir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(sourceDir, "go.mod")); err == nil {
+		return sourceDir, nil
+	}
+
+	entries, err := os.ReadDir(sourceDir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		moduleDir := filepath.Join(sourceDir, entries[0].Name())
+		if _, err := os.Stat(filepath.Join(moduleDir, "go.mod")); err == nil {
+			return moduleDir, nil
+		}
+	}
+	return "", errors.New("go.mod not found at the root of the executable archive")
+}
+
+// whether tasks of a job leave cache files or workspaces on worker nodes to release once the job ends
+func holdsNodeResources(cacheFiles []string, executableFileName string) bool {
+	return len(cacheFiles) > 0 || util.IsArchiveExecutable(executableFileName)
+}
+
+// per leader like the job cache, job ids restart with every leader
+func fmtWorkspaceName(leaderId string, jobId int32, executableFileName string) string {
+	return fmtJobCacheDirName(leaderId, jobId) + "_" + executableFileName
+}
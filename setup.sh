@@ -4,7 +4,12 @@ mkdir -p sdfs
 mkdir -p local
 mkdir -p mr_job_manager
 mkdir -p mr_node_manager
+mkdir -p mr_cache
+mkdir -p mr_sandbox
+mkdir -p mr_workspace
 mkdir -p sql_template
+mkdir -p mr_local
+mkdir -p mr_hooks
 touch config.txt
 
 echo "MEMBERSHIP_SERVICE_PORT=8001" > config.txt
@@ -23,18 +28,48 @@ echo "REPLICATION_FACTOR=3" >> config.txt
 echo "RPC_SERVER_PORT=8004" >> config.txt
 echo "FILE_RECEIVE_PORT=8005" >> config.txt
 
-#configuration for sql layer executor num
+#configuration for sql layer executor num, auto lets the job manager pick
 echo "MAPLE_TASK_NUM=3" >> config.txt
 echo "JUICE_TASK_NUM=3" >> config.txt
+echo "BROADCAST_JOIN_THRESHOLD_MB=16" >> config.txt
+#skip, fail or error (rows go to an error file next to the query result)
+echo "SQL_MALFORMED_ROWS=fail" >> config.txt
+
+#auto task count tuning
+echo "MAPLE_SPLIT_SIZE_MB=64" >> config.txt
+echo "JUICE_TASK_INPUT_SIZE_MB=64" >> config.txt
+echo "WORKER_TASK_SLOTS=2" >> config.txt
+
+#defaults for maple juice jobs, can be overridden per job
+echo "MAPLE_TASK_TIMEOUT_MINUTES=5" >> config.txt
+echo "JUICE_TASK_TIMEOUT_MINUTES=5" >> config.txt
+echo "EXECUTION_TIMEOUT_MINUTES=10" >> config.txt
+echo "JOB_TIMEOUT_MINUTES=10" >> config.txt
+echo "TASK_MAX_RETRY_NUM=5" >> config.txt
+echo "RETRY_BACKOFF_BASE_MILLIS=1000" >> config.txt
+echo "RETRY_BACKOFF_MAX_MILLIS=30000" >> config.txt
+
+#sandbox for user executables, 0 means unlimited
+#SANDBOX_USER=<user> runs executables as an unprivileged user (node must run as root)
+#SANDBOX_CGROUP_DIR=<dir> creates a cgroup v2 per task under a delegated directory
+echo "TASK_MEMORY_LIMIT_MB=0" >> config.txt
+echo "TASK_CPU_LIMIT_SECONDS=0" >> config.txt
+echo "TASK_FILE_SIZE_LIMIT_MB=0" >> config.txt
+echo "TASK_PROCESS_LIMIT=0" >> config.txt
+#most MB unpacked from an executable archive
+echo "EXECUTABLE_ARCHIVE_LIMIT_MB=512" >> config.txt
 
 echo "LOG_FILE_NAME=log" >> config.txt
 echo "LOG_SERVER_ID=vm$1" >> config.txt
 echo "SERVER_HOSTNAMES=fa23-cs425-3801.cs.illinois.edu,fa23-cs425-3802.cs.illinois.edu,fa23-cs425-3803.cs.illinois.edu,fa23-cs425-3804.cs.illinois.edu,fa23-cs425-3805.cs.illinois.edu,fa23-cs425-3806.cs.illinois.edu,fa23-cs425-3807.cs.illinois.edu,fa23-cs425-3808.cs.illinois.edu,fa23-cs425-3809.cs.illinois.edu,fa23-cs425-3810.cs.illinois.edu" >> config.txt
 
-cp ~/maple-juice/sql/filter_juice/* ~/sql_template/
 cp ~/maple-juice/sql/filter_maple/* ~/sql_template/
 cp ~/maple-juice/sql/join_juice/* ~/sql_template/
 cp ~/maple-juice/sql/join_maple/* ~/sql_template/
+cp ~/maple-juice/sql/broadcast_join/* ~/sql_template/
+cp ~/maple-juice/sql/group_juice/* ~/sql_template/
+cp ~/maple-juice/sort/sort_maple/* ~/sql_template/
+cp ~/maple-juice/sort/sort_juice/* ~/sql_template/
 
 cp ~/maple-juice/demo/maple1/maple1.go ~/sql_template/demo_maple1.go
 cp ~/maple-juice/demo/maple2/maple2.go ~/sql_template/demo_maple2.go
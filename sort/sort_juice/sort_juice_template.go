@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// juice of a total-order sort job: sorts all records of one range by their sort keys,
+// keeping the first Limit records if a limit is set
+
+type sortKeySpec struct {
+	ColumnIndex int
+	Numeric     bool
+	Descending  bool
+}
+
+type sortSpec struct {
+	Keys         []sortKeySpec
+	Delimiter    string
+	HasHeader    bool
+	Header       string
+	HeaderOutput string
+	SplitPoints  [][]string
+	Limit        int
+}
+
+type record struct {
+	key  []string
+	line string
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	homedir, _ := os.UserHomeDir()
+	nodeManagerFileDir := homedir + "/mr_node_manager/"
+
+	specJson := "{{ .SpecJson }}"
+	inputFileFlag := flag.String("in", "", "Input filename")
+	outputFileFlag := flag.String("dest", "", "Output filename")
+	flag.Parse()
+
+	if *inputFileFlag == "" || *outputFileFlag == "" {
+		log.Fatal("Usage: go run sort_juice.go -in <inputfile> -dest <outputfile>")
+	}
+
+	spec := sortSpec{}
+	err := json.Unmarshal([]byte(specJson), &spec)
+	if err != nil {
+		log.Fatal("Invalid sort spec:", err)
+	}
+
+	file, err := os.Open(*inputFileFlag)
+	if err != nil {
+		log.Fatal("Error opening input file:", err)
+	}
+	defer file.Close()
+
+	records := make([]record, 0)
+	input := newRecordInput(file, &spec)
+	for {
+		r, ok := input.next()
+		if !ok {
+			break
+		}
+		records = append(records, r)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return compareSortRecords(records[i].key, records[j].key, spec.Keys) < 0
+	})
+	if spec.Limit > 0 && len(records) > spec.Limit {
+		records = records[:spec.Limit]
+	}
+
+	outputFile, err := os.Create(nodeManagerFileDir + *outputFileFlag)
+	if err != nil {
+		log.Fatal("Error creating output file:", err)
+	}
+	defer outputFile.Close()
+
+	writer := bufio.NewWriter(outputFile)
+	if spec.HasHeader && *outputFileFlag == spec.HeaderOutput {
+		writer.WriteString(spec.Header + "\n")
+	}
+	for _, r := range records {
+		_, err := writer.WriteString(r.line + "\n")
+		if err != nil {
+			log.Fatal("Error writing to output file:", err)
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		log.Fatal("Error writing to output file:", err)
+	}
+
+	fmt.Println(*outputFileFlag)
+	os.Exit(0)
+}
+
+// sort keys of a record, missing fields are empty
+func sortKeysOf(fields []string, line string, keys []sortKeySpec) []string {
+	values := make([]string, len(keys))
+	for idx, key := range keys {
+		switch {
+		case key.ColumnIndex < 0:
+			values[idx] = line
+		case key.ColumnIndex < len(fields):
+			values[idx] = fields[key.ColumnIndex]
+		}
+	}
+	return values
+}
+
+// same order as util.CompareSortRecords
+func compareSortRecords(a []string, b []string, keys []sortKeySpec) int {
+	for idx, key := range keys {
+		result := compareSortKeys(a[idx], b[idx], key.Numeric, key.Descending)
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// same order as util.CompareSortKeys
+func compareSortKeys(a string, b string, numeric bool, descending bool) int {
+	result := 0
+	if numeric {
+		x, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		y, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		switch {
+		case errA == nil && errB == nil:
+			if x < y {
+				result = -1
+			} else if x > y {
+				result = 1
+			}
+		case errA == nil:
+			// after all numbers in either order
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			result = strings.Compare(a, b)
+		}
+	} else {
+		result = strings.Compare(a, b)
+	}
+
+	if descending {
+		return -result
+	}
+	return result
+}
+
+// records of the input: lines, or CSV records with delimiter csv whose quoted fields may hold commas
+// and line breaks. A CSV record is written back as encoding/csv writes it
+type recordInput struct {
+	spec    *sortSpec
+	scanner *bufio.Scanner
+	reader  *csv.Reader
+	text    bytes.Buffer
+	writer  *csv.Writer
+}
+
+func newRecordInput(file io.Reader, spec *sortSpec) *recordInput {
+	input := &recordInput{spec: spec}
+	if spec.Delimiter == "csv" {
+		input.reader = csv.NewReader(file)
+		input.reader.FieldsPerRecord = -1
+		input.writer = csv.NewWriter(&input.text)
+		return input
+	}
+	input.scanner = bufio.NewScanner(file)
+	input.scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return input
+}
+
+// next record with its sort keys, false after the last one
+func (this *recordInput) next() (record, bool) {
+	if this.reader == nil {
+		if !this.scanner.Scan() {
+			if err := this.scanner.Err(); err != nil {
+				log.Fatal("Error reading input file:", err)
+			}
+			return record{}, false
+		}
+		line := strings.TrimRight(this.scanner.Text(), "\r")
+		return record{key: sortKeysOf(strings.Split(line, this.spec.Delimiter), line, this.spec.Keys), line: line}, true
+	}
+
+	fields, err := this.reader.Read()
+	if err == io.EOF {
+		return record{}, false
+	}
+	if err != nil {
+		log.Fatal("Error reading input file:", err)
+	}
+	line := "\"\""
+	// a single empty field would be an empty line, which CSV readers skip
+	if len(fields) != 1 || len(fields[0]) > 0 {
+		this.text.Reset()
+		this.writer.Write(fields)
+		this.writer.Flush()
+		if err := this.writer.Error(); err != nil {
+			log.Fatal("Error encoding record:", err)
+		}
+		line = strings.TrimSuffix(this.text.String(), "\n")
+	}
+	return record{key: sortKeysOf(fields, line, this.spec.Keys), line: line}, true
+}
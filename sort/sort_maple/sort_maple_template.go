@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maple of a total-order sort job: sends every record to the range of its sort key,
+// ranges are bounded by the split points sampled by the job manager and keyed part<n>.
+// With a limit only the first records of the input partition are sent on
+
+type sortKeySpec struct {
+	ColumnIndex int
+	Numeric     bool
+	Descending  bool
+}
+
+type sortSpec struct {
+	Keys         []sortKeySpec
+	Delimiter    string
+	HasHeader    bool
+	Header       string
+	HeaderOutput string
+	SplitPoints  [][]string
+	Limit        int
+}
+
+type record struct {
+	key  []string
+	line string
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	homedir, _ := os.UserHomeDir()
+	nodeManagerFileDir := homedir + "/mr_node_manager/"
+
+	specJson := "{{ .SpecJson }}"
+	inputFileFlag := flag.String("in", "", "Input filename")
+	prefixFlag := flag.String("prefix", "", "SDFS intermediate filename prefix")
+	flag.Parse()
+
+	if *inputFileFlag == "" || *prefixFlag == "" {
+		log.Fatal("Usage: go run sort_maple.go -in <inputfile> -prefix <sdfs_intermediate_filename_prefix>")
+	}
+
+	spec := sortSpec{}
+	err := json.Unmarshal([]byte(specJson), &spec)
+	if err != nil {
+		log.Fatal("Invalid sort spec:", err)
+	}
+
+	file, err := os.Open(*inputFileFlag)
+	if err != nil {
+		log.Fatal("Error opening input file:", err)
+	}
+	defer file.Close()
+
+	input := newRecordInput(file, &spec)
+	if spec.HasHeader {
+		if _, ok := input.next(); !ok {
+			log.Fatal("Empty input to sort maple executable")
+		}
+	}
+
+	partitionNumber := extractPartitionNumber(*inputFileFlag)
+	output := make(map[string]*bufio.Writer)
+	outputFiles := make(map[string]*os.File)
+	outputFileNames := []string{}
+
+	writeRecord := func(key []string, line string) {
+		rangeNumber := sort.Search(len(spec.SplitPoints), func(i int) bool {
+			return compareSortRecords(key, spec.SplitPoints[i], spec.Keys) < 0
+		})
+		rangeKey := fmt.Sprintf("part%05d", rangeNumber)
+
+		writer, exists := output[rangeKey]
+		if !exists {
+			outputFileName := fmt.Sprintf("%s-%s-%s", *prefixFlag, partitionNumber, rangeKey)
+			outputFile, err := os.Create(nodeManagerFileDir + outputFileName)
+			if err != nil {
+				log.Fatal("Error creating output file:", err)
+			}
+			writer = bufio.NewWriter(outputFile)
+			output[rangeKey] = writer
+			outputFiles[rangeKey] = outputFile
+			outputFileNames = append(outputFileNames, outputFileName)
+		}
+
+		_, err := writer.WriteString(line + "\n")
+		if err != nil {
+			log.Fatal("Error writing to output file:", err)
+		}
+	}
+
+	// with a limit only the first records of this partition are written, at most 2*Limit are held at a time
+	kept := make([]record, 0)
+	for {
+		r, ok := input.next()
+		if !ok {
+			break
+		}
+		if spec.Limit == 0 {
+			writeRecord(r.key, r.line)
+			continue
+		}
+		kept = append(kept, r)
+		if len(kept) >= 2*spec.Limit {
+			kept = firstRecords(kept, &spec)
+		}
+	}
+
+	for _, r := range firstRecords(kept, &spec) {
+		writeRecord(r.key, r.line)
+	}
+
+	for rangeKey, writer := range output {
+		err := writer.Flush()
+		if err != nil {
+			log.Fatal("Error writing to output file:", err)
+		}
+		outputFiles[rangeKey].Close()
+	}
+
+	fmt.Println(strings.Join(outputFileNames, ","))
+	os.Exit(0)
+}
+
+// first Limit records in sort order, ties keep their input order
+func firstRecords(records []record, spec *sortSpec) []record {
+	sort.SliceStable(records, func(i, j int) bool {
+		return compareSortRecords(records[i].key, records[j].key, spec.Keys) < 0
+	})
+	if len(records) > spec.Limit {
+		records = records[:spec.Limit]
+	}
+	return records
+}
+
+// input partitions are named <file>-p<n>
+func extractPartitionNumber(inputFilePath string) string {
+	fileName := filepath.Base(inputFilePath)
+	idx := strings.LastIndex(fileName, "-")
+	if idx < 0 {
+		log.Fatalf("Invalid maple input file name format for %s", fileName)
+	}
+	return fileName[idx+1:]
+}
+
+// sort keys of a record, missing fields are empty
+func sortKeysOf(fields []string, line string, keys []sortKeySpec) []string {
+	values := make([]string, len(keys))
+	for idx, key := range keys {
+		switch {
+		case key.ColumnIndex < 0:
+			values[idx] = line
+		case key.ColumnIndex < len(fields):
+			values[idx] = fields[key.ColumnIndex]
+		}
+	}
+	return values
+}
+
+// same order as util.CompareSortRecords
+func compareSortRecords(a []string, b []string, keys []sortKeySpec) int {
+	for idx, key := range keys {
+		result := compareSortKeys(a[idx], b[idx], key.Numeric, key.Descending)
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// same order as util.CompareSortKeys
+func compareSortKeys(a string, b string, numeric bool, descending bool) int {
+	result := 0
+	if numeric {
+		x, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		y, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		switch {
+		case errA == nil && errB == nil:
+			if x < y {
+				result = -1
+			} else if x > y {
+				result = 1
+			}
+		case errA == nil:
+			// after all numbers in either order
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			result = strings.Compare(a, b)
+		}
+	} else {
+		result = strings.Compare(a, b)
+	}
+
+	if descending {
+		return -result
+	}
+	return result
+}
+
+// records of the input: lines, or CSV records with delimiter csv whose quoted fields may hold commas
+// and line breaks. A CSV record is written back as encoding/csv writes it
+type recordInput struct {
+	spec    *sortSpec
+	scanner *bufio.Scanner
+	reader  *csv.Reader
+	text    bytes.Buffer
+	writer  *csv.Writer
+}
+
+func newRecordInput(file io.Reader, spec *sortSpec) *recordInput {
+	input := &recordInput{spec: spec}
+	if spec.Delimiter == "csv" {
+		input.reader = csv.NewReader(file)
+		input.reader.FieldsPerRecord = -1
+		input.writer = csv.NewWriter(&input.text)
+		return input
+	}
+	input.scanner = bufio.NewScanner(file)
+	input.scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return input
+}
+
+// next record with its sort keys, false after the last one
+func (this *recordInput) next() (record, bool) {
+	if this.reader == nil {
+		if !this.scanner.Scan() {
+			if err := this.scanner.Err(); err != nil {
+				log.Fatal("Error reading input file:", err)
+			}
+			return record{}, false
+		}
+		line := strings.TrimRight(this.scanner.Text(), "\r")
+		return record{key: sortKeysOf(strings.Split(line, this.spec.Delimiter), line, this.spec.Keys), line: line}, true
+	}
+
+	fields, err := this.reader.Read()
+	if err == io.EOF {
+		return record{}, false
+	}
+	if err != nil {
+		log.Fatal("Error reading input file:", err)
+	}
+	line := "\"\""
+	// a single empty field would be an empty line, which CSV readers skip
+	if len(fields) != 1 || len(fields[0]) > 0 {
+		this.text.Reset()
+		this.writer.Write(fields)
+		this.writer.Flush()
+		if err := this.writer.Error(); err != nil {
+			log.Fatal("Error encoding record:", err)
+		}
+		line = strings.TrimSuffix(this.text.String(), "\n")
+	}
+	return record{key: sortKeysOf(fields, line, this.spec.Keys), line: line}, true
+}
@@ -0,0 +1,358 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// syntax tree of SQL queries, every node records where it starts in the query text
+
+type Pos struct {
+	Offset int // byte offset in the query
+	Line   int // 1-based
+	Column int // 1-based, in characters
+}
+
+func (this Pos) String() string {
+	return fmt.Sprintf("line %d, column %d", this.Line, this.Column)
+}
+
+// SELECT <columns> FROM <tables> [WHERE <condition>] [GROUP BY <columns>] [HAVING <condition>]
+// [ORDER BY <output columns>] [LIMIT <count>]
+type SelectStmt struct {
+	Pos     Pos
+	All     bool          // SELECT ALL or SELECT *
+	Columns []*SelectItem // output columns in order, empty for SELECT ALL
+	From    []*TableRef
+	Where   Expr // nil without WHERE clause
+	GroupBy []*ColumnRef
+	Having  Expr // nil without HAVING clause
+	OrderBy []*OrderItem
+	Limit   *NumberLit // nil without LIMIT clause, a whole number
+}
+
+// output column: <column or aggregate> [AS <alias>]
+type SelectItem struct {
+	Pos   Pos
+	Expr  Expr // *ColumnRef or *FuncCall
+	Alias string // empty without AS
+}
+
+// <output column> [ASC | DESC], the column is given by name, alias, expression or 1-based number
+type OrderItem struct {
+	Pos        Pos
+	Expr       Expr // *ColumnRef, *FuncCall or *NumberLit
+	Descending bool
+}
+
+func (this *OrderItem) String() string {
+	if this.Descending {
+		return this.Expr.String() + " DESC"
+	}
+	return this.Expr.String()
+}
+
+// table a query reads from, a catalog table or a local file uploaded to SDFS. Tables after the first one are either listed with a comma and joined by
+// the WHERE condition, or joined to the tables before them with [INNER | LEFT | RIGHT | FULL] JOIN ... ON
+type TableRef struct {
+	Pos   Pos
+	Name  string
+	Alias string // empty without alias
+	Join  string // INNER, LEFT, RIGHT or FULL, empty for the first table and tables listed with a comma
+	On    Expr   // join condition of JOIN
+}
+
+// name qualifying the columns of the table, the alias hides the file name
+func (this *TableRef) RefName() string {
+	if len(this.Alias) > 0 {
+		return this.Alias
+	}
+	return this.Name
+}
+
+// *SelectStmt, *CreateTableStmt, *DescribeStmt or *ShowTablesStmt
+type Statement interface {
+	Position() Pos
+}
+
+// catalog statements, tables created with CREATE TABLE are queried by name like local files
+
+// CREATE TABLE <name> (<column> <type>, ...) LOCATION '<sdfs_file>'
+type CreateTableStmt struct {
+	Pos      Pos
+	Name     string
+	Columns  []*ColumnDef
+	Location string // SDFS file holding the rows, starting with a header row of the column names
+}
+
+// <column> <type> of CREATE TABLE, the type is one of the COLUMN_TYPE_* names
+type ColumnDef struct {
+	Pos  Pos
+	Name string
+	Type string
+}
+
+// DESCRIBE <name>
+type DescribeStmt struct {
+	Pos  Pos
+	Name string
+}
+
+// SHOW TABLES
+type ShowTablesStmt struct {
+	Pos Pos
+}
+
+type Expr interface {
+	Position() Pos
+	String() string
+}
+
+// column, optionally qualified by a table: <table>.<column>
+type ColumnRef struct {
+	Pos    Pos
+	Table  string // empty if unqualified
+	Column string
+	Quoted bool // column written as a double quoted identifier
+}
+
+// single quoted string
+type StringLit struct {
+	Pos   Pos
+	Value string
+}
+
+type NumberLit struct {
+	Pos   Pos
+	Value string // as written in the query
+}
+
+type NullLit struct {
+	Pos Pos
+}
+
+// <left> <op> <right>, op is AND, OR or a comparison operator (!= is stored as <>)
+type BinaryExpr struct {
+	Pos   Pos
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// NOT <expr>
+type NotExpr struct {
+	Pos  Pos
+	Expr Expr
+}
+
+// <expr> [NOT] LIKE <pattern>, % matches any characters and _ a single one
+type LikeExpr struct {
+	Pos     Pos
+	Not     bool
+	Expr    Expr
+	Pattern Expr
+}
+
+// <expr> [NOT] IN (<value>, ...)
+type InExpr struct {
+	Pos  Pos
+	Not  bool
+	Expr Expr
+	List []Expr
+}
+
+// <expr> [NOT] BETWEEN <low> AND <high>, bounds included
+type BetweenExpr struct {
+	Pos  Pos
+	Not  bool
+	Expr Expr
+	Low  Expr
+	High Expr
+}
+
+// <expr> IS [NOT] NULL
+type IsNullExpr struct {
+	Pos  Pos
+	Not  bool
+	Expr Expr
+}
+
+// <name>(<arg>, ...), the name is upper case. Aggregates also take COUNT(*) and <name>(DISTINCT <arg>)
+type FuncCall struct {
+	Pos      Pos
+	Name     string
+	Args     []Expr
+	Star     bool
+	Distinct bool
+}
+
+func (this *SelectStmt) Position() Pos      { return this.Pos }
+func (this *CreateTableStmt) Position() Pos { return this.Pos }
+func (this *DescribeStmt) Position() Pos    { return this.Pos }
+func (this *ShowTablesStmt) Position() Pos  { return this.Pos }
+
+func (this *ColumnRef) Position() Pos   { return this.Pos }
+func (this *StringLit) Position() Pos   { return this.Pos }
+func (this *NumberLit) Position() Pos   { return this.Pos }
+func (this *NullLit) Position() Pos     { return this.Pos }
+func (this *BinaryExpr) Position() Pos  { return this.Pos }
+func (this *NotExpr) Position() Pos     { return this.Pos }
+func (this *LikeExpr) Position() Pos    { return this.Pos }
+func (this *InExpr) Position() Pos      { return this.Pos }
+func (this *BetweenExpr) Position() Pos { return this.Pos }
+func (this *IsNullExpr) Position() Pos  { return this.Pos }
+func (this *FuncCall) Position() Pos    { return this.Pos }
+
+func (this *ColumnRef) String() string {
+	column := this.Column
+	if this.Quoted {
+		column = quoteIdentifier(column)
+	}
+	if len(this.Table) > 0 {
+		return this.Table + "." + column
+	}
+	return column
+}
+
+func (this *StringLit) String() string {
+	return "'" + strings.ReplaceAll(this.Value, "'", "''") + "'"
+}
+
+func (this *NumberLit) String() string {
+	return this.Value
+}
+
+func (this *NullLit) String() string {
+	return "NULL"
+}
+
+func (this *BinaryExpr) String() string {
+	return fmt.Sprintf("(%s %s %s)", this.Left.String(), this.Op, this.Right.String())
+}
+
+func (this *NotExpr) String() string {
+	return fmt.Sprintf("(NOT %s)", this.Expr.String())
+}
+
+func (this *LikeExpr) String() string {
+	return fmt.Sprintf("(%s %sLIKE %s)", this.Expr.String(), notPrefix(this.Not), this.Pattern.String())
+}
+
+func (this *InExpr) String() string {
+	return fmt.Sprintf("(%s %sIN (%s))", this.Expr.String(), notPrefix(this.Not), joinExprs(this.List))
+}
+
+func (this *BetweenExpr) String() string {
+	return fmt.Sprintf("(%s %sBETWEEN %s AND %s)", this.Expr.String(), notPrefix(this.Not), this.Low.String(), this.High.String())
+}
+
+func (this *IsNullExpr) String() string {
+	return fmt.Sprintf("(%s IS %sNULL)", this.Expr.String(), notPrefix(this.Not))
+}
+
+func (this *FuncCall) String() string {
+	if this.Star {
+		return this.Name + "(*)"
+	}
+	if this.Distinct {
+		return fmt.Sprintf("%s(DISTINCT %s)", this.Name, joinExprs(this.Args))
+	}
+	return fmt.Sprintf("%s(%s)", this.Name, joinExprs(this.Args))
+}
+
+func notPrefix(not bool) string {
+	if not {
+		return "NOT "
+	}
+	return ""
+}
+
+func joinExprs(exprs []Expr) string {
+	strs := make([]string, len(exprs))
+	for idx, expr := range exprs {
+		strs[idx] = expr.String()
+	}
+	return strings.Join(strs, ", ")
+}
+
+// name of the column in the query result, aggregates without alias are named as written, e.g. COUNT(*)
+func (this *SelectItem) OutputName() string {
+	if len(this.Alias) > 0 {
+		return this.Alias
+	}
+	if column, ok := this.Expr.(*ColumnRef); ok {
+		return column.Column
+	}
+	return this.Expr.String()
+}
+
+func (this *SelectItem) String() string {
+	if len(this.Alias) > 0 {
+		return this.Expr.String() + " AS " + quoteIdentifier(this.Alias)
+	}
+	return this.Expr.String()
+}
+
+func (this *SelectStmt) String() string {
+	var builder strings.Builder
+	builder.WriteString("SELECT ")
+	if this.All {
+		builder.WriteString("ALL")
+	}
+	for idx, item := range this.Columns {
+		if idx > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(item.String())
+	}
+	builder.WriteString(" FROM ")
+	for idx, table := range this.From {
+		switch {
+		case len(table.Join) > 0:
+			builder.WriteString(" " + table.Join + " JOIN ")
+		case idx > 0:
+			builder.WriteString(", ")
+		}
+		builder.WriteString(table.Name)
+		if len(table.Alias) > 0 {
+			builder.WriteString(" AS " + quoteIdentifier(table.Alias))
+		}
+		if table.On != nil {
+			builder.WriteString(" ON ")
+			builder.WriteString(table.On.String())
+		}
+	}
+	if this.Where != nil {
+		builder.WriteString(" WHERE ")
+		builder.WriteString(this.Where.String())
+	}
+	for idx, column := range this.GroupBy {
+		if idx == 0 {
+			builder.WriteString(" GROUP BY ")
+		} else {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(column.String())
+	}
+	if this.Having != nil {
+		builder.WriteString(" HAVING ")
+		builder.WriteString(this.Having.String())
+	}
+	for idx, item := range this.OrderBy {
+		if idx == 0 {
+			builder.WriteString(" ORDER BY ")
+		} else {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(item.String())
+	}
+	if this.Limit != nil {
+		builder.WriteString(" LIMIT ")
+		builder.WriteString(this.Limit.Value)
+	}
+	return builder.String()
+}
+
+func quoteIdentifier(name string) string {
+	return "\"" + strings.ReplaceAll(name, "\"", "\"\"") + "\""
+}
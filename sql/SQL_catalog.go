@@ -0,0 +1,281 @@
+package sql
+
+import (
+	"maple-juice/config"
+	"maple-juice/dfs"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// table catalog: CREATE TABLE records the typed columns of a table and the SDFS file holding its rows in a
+// JSON file stored in SDFS, so that it is replicated and every node sees the same tables. A query on a
+// catalog table reads its SDFS file, and its columns are resolved and type checked against the schema
+// before any maple job is submitted. Other table names are local files uploaded with the query, with
+// untyped columns read from their header line. The SDFS file of a table still starts with a header row
+// naming the columns, which maple tasks look fields up by, CREATE TABLE checks that it names the declared
+// columns in order.
+// The catalog is replaced as a whole, of two tables created on different nodes at once one may be lost.
+
+const SQL_CATALOG_FILE string = "sql_catalog.json"
+
+// CREATE TABLE reads the header row from the first bytes of the SDFS file of the table
+const SQL_HEADER_MAX_BYTES int = 64 * 1024
+
+const (
+	COLUMN_TYPE_STRING string = "STRING"
+	COLUMN_TYPE_INT    string = "INT"
+	COLUMN_TYPE_FLOAT  string = "FLOAT"
+)
+
+// type names accepted by CREATE TABLE
+var columnTypeNames = map[string]string{
+	"STRING":  COLUMN_TYPE_STRING,
+	"VARCHAR": COLUMN_TYPE_STRING,
+	"TEXT":    COLUMN_TYPE_STRING,
+	"INT":     COLUMN_TYPE_INT,
+	"INTEGER": COLUMN_TYPE_INT,
+	"BIGINT":  COLUMN_TYPE_INT,
+	"FLOAT":   COLUMN_TYPE_FLOAT,
+	"DOUBLE":  COLUMN_TYPE_FLOAT,
+	"REAL":    COLUMN_TYPE_FLOAT,
+}
+
+type Catalog struct {
+	Tables []*TableSchema `json:"tables"` // sorted by name
+}
+
+type TableSchema struct {
+	Name     string         `json:"name"`
+	Columns  []ColumnSchema `json:"columns"`
+	Location string         `json:"location"`
+}
+
+type ColumnSchema struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// input of a query: a catalog table, or a local file uploaded to SDFS under its own name
+type queryTable struct {
+	header   []string
+	types    []string // types of the header columns, nil for local files
+	sdfsFile string
+	local    bool
+}
+
+func isNumericType(columnType string) bool {
+	return columnType == COLUMN_TYPE_INT || columnType == COLUMN_TYPE_FLOAT
+}
+
+// empty catalog if no table was created yet
+func fetchCatalog() (*Catalog, error) {
+	catalog := &Catalog{Tables: make([]*TableSchema, 0)}
+	fileNames, err := dfs.SDFSSearchFileByRegex("^" + regexp.QuoteMeta(SQL_CATALOG_FILE) + "$")
+	if err != nil {
+		return nil, err
+	}
+	if len(*fileNames) == 0 {
+		return catalog, nil
+	}
+
+	err = dfs.SDFSGetFile(SQL_CATALOG_FILE, SQL_CATALOG_FILE, dfs.RECEIVER_SDFS_CLIENT)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(config.LocalFileDir + SQL_CATALOG_FILE)
+	os.Remove(config.LocalFileDir + SQL_CATALOG_FILE)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, catalog)
+	if err != nil {
+		return nil, fmt.Errorf("malformed table catalog %s: %s", SQL_CATALOG_FILE, err)
+	}
+	return catalog, nil
+}
+
+func (this *Catalog) save() error {
+	content, err := json.MarshalIndent(this, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(config.LocalFileDir+SQL_CATALOG_FILE, content, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(config.LocalFileDir + SQL_CATALOG_FILE)
+
+	_, err = dfs.SDFSPutFile(SQL_CATALOG_FILE, config.LocalFileDir+SQL_CATALOG_FILE)
+	return err
+}
+
+// nil if there is no such table
+func (this *Catalog) find(name string) *TableSchema {
+	for _, table := range this.Tables {
+		if table.Name == name {
+			return table
+		}
+	}
+	return nil
+}
+
+func executeCreateTable(stmt *CreateTableStmt) error {
+	schema := &TableSchema{Name: stmt.Name, Columns: make([]ColumnSchema, 0), Location: stmt.Location}
+	for idx, column := range stmt.Columns {
+		for _, other := range stmt.Columns[:idx] {
+			if other.Name == column.Name {
+				return &QueryError{Pos: column.Pos, Msg: fmt.Sprintf("column %s is defined twice", column.Name)}
+			}
+		}
+		schema.Columns = append(schema.Columns, ColumnSchema{Name: column.Name, Type: column.Type})
+	}
+
+	catalog, err := fetchCatalog()
+	if err != nil {
+		return err
+	}
+	if catalog.find(stmt.Name) != nil {
+		return &QueryError{Pos: stmt.Pos, Msg: fmt.Sprintf("table %s already exists", stmt.Name)}
+	}
+	fileNames, err := dfs.SDFSSearchFileByRegex("^" + regexp.QuoteMeta(stmt.Location) + "$")
+	if err != nil {
+		return err
+	}
+	if len(*fileNames) == 0 {
+		return &QueryError{Pos: stmt.Pos, Msg: fmt.Sprintf("SDFS file %s of table %s does not exist", stmt.Location, stmt.Name)}
+	}
+	// maple tasks look fields up by the header row, it has to name the declared columns in order
+	header, err := fetchHeader(stmt.Location)
+	if err != nil {
+		return &QueryError{Pos: stmt.Pos, Msg: err.Error()}
+	}
+	if len(header) != len(stmt.Columns) {
+		return &QueryError{Pos: stmt.Pos, Msg: fmt.Sprintf("header row of %s has %d columns (%s), table %s declares %d", stmt.Location, len(header), strings.Join(header, ", "), stmt.Name, len(stmt.Columns))}
+	}
+	for idx, column := range stmt.Columns {
+		if header[idx] != column.Name {
+			return &QueryError{Pos: column.Pos, Msg: fmt.Sprintf("column %d of the header row of %s is %s, not %s", idx+1, stmt.Location, header[idx], column.Name)}
+		}
+	}
+
+	catalog.Tables = append(catalog.Tables, schema)
+	sort.Slice(catalog.Tables, func(i, j int) bool {
+		return catalog.Tables[i].Name < catalog.Tables[j].Name
+	})
+	err = catalog.save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created table %s on SDFS file %s\n", stmt.Name, stmt.Location)
+	return nil
+}
+
+// header row of an SDFS file, only the first bytes of the file are read
+func fetchHeader(sdfsFile string) ([]string, error) {
+	head, complete, err := dfs.SDFSReadFileHead(sdfsFile, SQL_HEADER_MAX_BYTES)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read SDFS file %s: %s", sdfsFile, err)
+	}
+
+	reader := csv.NewReader(bytes.NewReader(head))
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("SDFS file %s has no header row", sdfsFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid header row of SDFS file %s: %s", sdfsFile, err)
+	}
+	if !complete && reader.InputOffset() == int64(len(head)) {
+		return nil, fmt.Errorf("header row of SDFS file %s is longer than %d bytes", sdfsFile, SQL_HEADER_MAX_BYTES)
+	}
+	for idx, field := range header {
+		header[idx] = strings.TrimSpace(field)
+	}
+	return header, nil
+}
+
+func executeDescribe(stmt *DescribeStmt) error {
+	catalog, err := fetchCatalog()
+	if err != nil {
+		return err
+	}
+	table := catalog.find(stmt.Name)
+	if table == nil {
+		return &QueryError{Pos: stmt.Pos, Msg: fmt.Sprintf("unknown table %s, SHOW TABLES lists the tables", stmt.Name)}
+	}
+
+	fmt.Printf("table:    %s\n", table.Name)
+	fmt.Printf("location: %s\n", table.Location)
+	fmt.Printf("%-32s %s\n", "COLUMN", "TYPE")
+	for _, column := range table.Columns {
+		fmt.Printf("%-32s %s\n", column.Name, column.Type)
+	}
+	return nil
+}
+
+func executeShowTables(stmt *ShowTablesStmt) error {
+	catalog, err := fetchCatalog()
+	if err != nil {
+		return err
+	}
+	if len(catalog.Tables) == 0 {
+		fmt.Println("No tables, create one with CREATE TABLE")
+		return nil
+	}
+	fmt.Printf("%-32s %s\n", "TABLE", "LOCATION")
+	for _, table := range catalog.Tables {
+		fmt.Printf("%-32s %s\n", table.Name, table.Location)
+	}
+	return nil
+}
+
+// inputs of the tables in FROM order, catalog tables take precedence over local files of the same name
+func resolveTables(stmt *SelectStmt) ([]*queryTable, error) {
+	catalog, err := fetchCatalog()
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch table catalog: %s", err)
+	}
+
+	tables := make([]*queryTable, 0, len(stmt.From))
+	for _, ref := range stmt.From {
+		if schema := catalog.find(ref.Name); schema != nil {
+			table := &queryTable{header: make([]string, 0), types: make([]string, 0), sdfsFile: schema.Location}
+			for _, column := range schema.Columns {
+				table.header = append(table.header, column.Name)
+				table.types = append(table.types, column.Type)
+			}
+			tables = append(tables, table)
+			continue
+		}
+
+		header, err := readHeader(ref)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, &queryTable{header: header, sdfsFile: ref.Name, local: true})
+	}
+	return tables, nil
+}
+
+// upload the local files a query reads, catalog tables are in SDFS already
+func uploadLocalTables(tables []*queryTable) error {
+	for _, table := range tables {
+		if !table.local {
+			continue
+		}
+		log.Printf("Uploading query input file %s", table.sdfsFile)
+		_, err := dfs.SDFSPutFile(table.sdfsFile, config.LocalFileDir+table.sdfsFile)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -8,259 +8,493 @@ import (
 	"maple-juice/maplejuice"
 	"fmt"
 	"log"
+	"os"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 )
 
 // Filter queries
-// SELECT ALL FROM <file_name> WHERE "<column_name>"="<regex>"
-// SELECT ALL FROM <file_name> WHERE "<regex>"	this one does does the whole line matching
+// SELECT ALL FROM <file_name> WHERE <condition>
+// SELECT ALL FROM <file_name> WHERE '<regex>'	this one does the whole line matching
+// SELECT ALL FROM <file_name>	returns every line
+// conditions combine =, <>, <, <=, >, >=, [NOT] LIKE, [NOT] IN, [NOT] BETWEEN, IS [NOT] NULL and
+// REGEXP(<column>, '<regex>') with AND, OR, NOT and parentheses
 
 // Join queries
-// SELECT ALL FROM <file1>, <file2> WHERE <file1>."<field_name1>"=<file2>."<field_name2>"
-func ProcessSqlQuery(query string) {
+// SELECT ALL FROM <file1>, <file2> WHERE <file1>.<field_name1> = <file2>.<field_name2>
+// SELECT ALL FROM <file1> [AS <alias1>] [LEFT] JOIN <file2> [AS <alias2>] ON <alias1>.<field1> = <alias2>.<field2> JOIN ...
+// more tables are joined one after another, by a chain of join stages
 
-	splitted := strings.Split(query, " ")
+// ALL may be replaced by a list of output columns: SELECT <column> [AS <alias>], <file>.<column> ...
 
-	if len(splitted) != 6  && len(splitted) != 7{
-		log.Println("Invalid SQL query")
-		log.Println("Filter usage1 : SELECT ALL FROM <file_name> WHERE \"<column_name>\"=\"<regex>\"")
-		log.Println("Filter usage2: SELECT ALL FROM <file_name> WHERE \"<regex>\"")
-		log.Println("Join usage: SELECT ALL FROM <file1>, <file2> WHERE <file1>.\"<field_name1>\"=<file2>.\"<field_name2>\"")
-		return
-	}
+// keywords are case-insensitive, column names may be double quoted as in "Fiber Type", a quote inside a
+// string is written twice. For compatibility a double quoted regex is still accepted: WHERE "<column>"="<regex>"
 
-	// filter query
-	if len(splitted) == 6 {
-		fileName := splitted[3]
-		var regex, columnName string
+// Table catalog, see SQL_catalog.go
+// CREATE TABLE <name> (<column> STRING|INT|FLOAT, ...) LOCATION '<sdfs_file>'
+// DESCRIBE <name>
+// SHOW TABLES
 
-		// queries specifies which column to filter
-		if strings.Contains(splitted[5], "=") {
-			condition := strings.Split(splitted[5], "=")
-			if len(condition) != 2{
-				log.Printf("Invalid condition for filter sql query")
-				log.Println("Filter usage1: SELECT ALL FROM <file_name> WHERE \"<column_name>\"=\"<regex>\"")
-				log.Println("Filter usage2: SELECT ALL FROM <file_name> WHERE \"<regex>\"")
-				return
-			} 
-	
-			columnName = condition[0]
-			regex = condition[1]
-			// trim quotation marks
-			columnName = columnName[1:len(columnName)-1]
-			regex = regex[1:len(regex)-1]
-
-		// query does not specify which column to filter, use the whole condition as a regex
-		} else {
-			columnName = ""
-			regex = splitted[5][1:len(splitted[5])-1]
+// input rows that are not valid CSV or do not have as many fields as the header are malformed, by
+// SQL_MALFORMED_ROWS they are skipped, fail the query or are written to the local error file
+// sql_malformed_rows_<node>_<timestamp> with the input split, the error and the row text
+func ProcessSqlQuery(query string) {
+	stmt, err := ParseStatement(query)
+	if err == nil {
+		switch stmt := stmt.(type) {
+		case *SelectStmt:
+			err = executeSelect(stmt)
+		case *CreateTableStmt:
+			err = executeCreateTable(stmt)
+		case *DescribeStmt:
+			err = executeDescribe(stmt)
+		case *ShowTablesStmt:
+			err = executeShowTables(stmt)
 		}
+	}
+	if err == nil {
+		return
+	}
 
-
-		executeFilterQuery(fileName, columnName, regex)
+	var pos Pos
+	if syntaxErr, ok := err.(*SyntaxError); ok {
+		pos = syntaxErr.Pos
+	} else if queryErr, ok := err.(*QueryError); ok {
+		pos = queryErr.Pos
 	} else {
-		// join query
-
-		fileName1 := strings.Trim(splitted[3], ",")
-		fileName2 := splitted[4]
-		joinCondition := strings.Split(splitted[6], "=")
-		if len(joinCondition) != 2 {
-			log.Printf("Invalid join condition for join sql query")
-			log.Println("Filter usage: SELECT ALL FROM <file_name> WHERE \"<column_name>\"=\"<regex>\"")
-			return
-		}
+		log.Println("SQL statement failed:", err)
+		return
+	}
+	fmt.Println(err.Error())
+	fmt.Println(markErrorPosition(query, pos))
+	fmt.Println("Filter usage: SELECT ALL|<columns> FROM <file_name> [WHERE <condition> | WHERE '<regex>']")
+	fmt.Println("Join usage: SELECT ALL|<columns> FROM <file1>, <file2>, ... WHERE <file1>.<field_name1> = <file2>.<field_name2> AND ...")
+	fmt.Println("            SELECT ALL|<columns> FROM <file1> [AS <alias>] [INNER|LEFT|RIGHT|FULL] JOIN <file2> [AS <alias>] ON <condition> ...")
+	fmt.Println("Catalog usage: CREATE TABLE <name> (<column> STRING|INT|FLOAT, ...) LOCATION '<sdfs_file>' | DESCRIBE <name> | SHOW TABLES")
+}
 
-		left := strings.Split(joinCondition[0], ".")
-		right := strings.Split(joinCondition[1], ".")
+// query that parses but cannot be executed
+type QueryError struct {
+	Pos Pos
+	Msg string
+}
 
-		if len(left) != 2 || len(right) != 2 {
-			log.Printf("Invalid join condition for join sql query")
-			log.Println("Filter usage: SELECT ALL FROM <file_name> WHERE \"<column_name>\"=\"<regex>\"")
-			return
-		}
+func (this *QueryError) Error() string {
+	return fmt.Sprintf("invalid query at %s: %s", this.Pos.String(), this.Msg)
+}
 
-		leftFile := left[0]
-		rightFile := right[0]
-
-		// trim quotation marks
-		leftField := left[1][1:len(left[1])-1]
-		rightField := right[1][1:len(right[1])-1]
-		var field1, field2 string
-
-		if (leftFile == fileName1 && rightFile == fileName2) {
-			field1 = leftField
-			field2 = rightField
-		} else if (leftFile == fileName2 && rightFile == fileName1) {
-			field1 = rightField
-			field2 = leftField
-		} else {
-			log.Printf("Invalid join condition for join sql query: bad field qualifier")
-			log.Println("Filter usage: SELECT ALL FROM <file_name> WHERE \"<column_name>\"=\"<regex>\"")
-			return
-		}
+func executeSelect(stmt *SelectStmt) error {
+	tables, err := resolveTables(stmt)
+	if err != nil {
+		return err
+	}
 
-		executeJoinQuery(fileName1, fileName2, field1, field2)
+	switch len(stmt.From) {
+	case 1:
+		header := tables[0].header
+		predicate, err := compileFilter(stmt, header)
+		if err != nil {
+			return err
+		}
+		if isGroupQuery(stmt) {
+			spec, outputHeader, err := planGroupQuery(stmt, header)
+			if err != nil {
+				return err
+			}
+			order, err := planOrder(stmt, outputHeader, tables)
+			if err != nil {
+				return err
+			}
+			err = checkAndUpload(stmt, tables)
+			if err != nil {
+				return err
+			}
+			executeGroupQuery(tables[0].sdfsFile, predicate, spec, outputHeader, order)
+			return nil
+		}
+		projection, outputHeader, err := filterProjection(stmt, header)
+		if err != nil {
+			return err
+		}
+		order, err := planOrder(stmt, outputHeader, tables)
+		if err != nil {
+			return err
+		}
+		err = checkAndUpload(stmt, tables)
+		if err != nil {
+			return err
+		}
+		executeFilterQuery(tables[0].sdfsFile, predicate, projection, outputHeader, order)
+	default:
+		if isGroupQuery(stmt) {
+			return &QueryError{Pos: stmt.Pos, Msg: "aggregates are not supported in join queries"}
+		}
+		headers := make([][]string, len(stmt.From))
+		for idx, table := range tables {
+			headers[idx] = table.header
+		}
+		join, err := planJoin(stmt, headers)
+		if err != nil {
+			return err
+		}
+		outputHeader := joinOutputHeader(stmt, headers)
+		order, err := planOrder(stmt, outputHeader, tables)
+		if err != nil {
+			return err
+		}
+		err = checkAndUpload(stmt, tables)
+		if err != nil {
+			return err
+		}
+		fileNames := make([]string, 0)
+		for _, table := range tables {
+			fileNames = append(fileNames, table.sdfsFile)
+		}
+		executeJoinQuery(fileNames, join, outputHeader, order)
 	}
+	return nil
 }
 
+// last checks of a planned query, then the local input files are uploaded
+func checkAndUpload(stmt *SelectStmt, tables []*queryTable) error {
+	err := checkTypes(stmt, tables)
+	if err != nil {
+		return err
+	}
+	err = uploadLocalTables(tables)
+	if err != nil {
+		return fmt.Errorf("cannot upload query input: %s", err)
+	}
+	return nil
+}
 
+func executeFilterQuery(inputFile string, predicate *util.FilterPredicate, projection []string, outputHeader []string, order *orderPlan){
 
-func executeFilterQuery(inputFile string, columnName string, regex string){
-
-	if len(inputFile) == 0 || len(regex) == 0 {
+	if len(inputFile) == 0 {
 		log.Println("Invalid filter query arguments")
 		return
 	}
 
-	_, err := regexp.Compile(regex)
-	if err != nil {
-		log.Printf("Invalid regular expression in filter query: (%s)", regex)
-		return
-	}
-
 	timestamp := time.Now().UnixMilli()
 	sdfsDestFileName := fmt.Sprintf("filter_query_result_%s_%d", membership.SelfNodeId, timestamp)
 
 	// generate executable with template
 	executableName := fmt.Sprintf("filter_maple_%s_%s_%d.go", inputFile, membership.SelfNodeId, timestamp)
-	err = util.GenerateFilterMapleExecutables(columnName, regex, executableName)
+	err := util.GenerateFilterMapleExecutables(predicate, projection, nil, order.topNSpec(), malformedRowPolicy(timestamp, "filter"), executableName)
+	if err == nil {
+		err = addTemplateRows(executableName)
+	}
 
 	if err != nil {
 		log.Println("Error generating filter maple executable")
 		return
 	}
 	
-	// upload generated executable and input to sdfs
-	log.Printf("Uploading query input file")
-	_, err = dfs.SDFSPutFile(inputFile, config.LocalFileDir + inputFile)
-	if err != nil {
-		log.Println("Error uploading input file", err)
-	}
-
+	// upload generated executable to sdfs
 	log.Printf("Uploading executables")
 	_, err = dfs.SDFSPutFile(executableName, config.LocalFileDir + executableName)
 	if err != nil {
 		log.Println("Error uploading maple executable", err)
 	}
-	_, err = dfs.SDFSPutFile("filter_juice.go", config.TemplateFileDir + "filter_juice.go")
-	if err != nil {
-		log.Println("Error uploading juice executable", err)
-	}
 	
-	// submit maple job
+	// submit map-only maple job, filter needs no juice phase
 	prefix := fmt.Sprintf("%s_%s_%d", inputFile, membership.SelfNodeId, timestamp)
-	err = maplejuice.ProcessMapleCmd([]string{executableName, strconv.Itoa(config.MapleTaskNum), prefix, inputFile, "1"})
+	err = maplejuice.ProcessMapleCmd([]string{executableName, util.FmtTaskNum(config.MapleTaskNum), prefix, inputFile, "1", "dest=" + sdfsDestFileName, "format=csv"})
 	if err != nil {
 		log.Println("Error executing Maple job for query", err)
 		return 
 	}
-	// submit juice job
-	err = maplejuice.ProcessJuiceCmd([]string{"filter_juice.go", strconv.Itoa(config.JuiceTaskNum), prefix, sdfsDestFileName, "0", "0"})
+	fetchMalformedRows(timestamp)
+	fetchQueryResult(sdfsDestFileName, outputHeader, order)
+}
+
+
+// the maple filters lines and aggregates them per group, the juice merges the partial aggregates of each group
+func executeGroupQuery(inputFile string, predicate *util.FilterPredicate, spec *util.GroupBySpec, outputHeader []string, order *orderPlan){
+	if len(inputFile) == 0 {
+		log.Println("Invalid group query arguments")
+		return
+	}
+
+	timestamp := time.Now().UnixMilli()
+	sdfsDestFilePrefix := fmt.Sprintf("group_query_result_%s_%d", membership.SelfNodeId, timestamp)
+
+	executableName := fmt.Sprintf("group_maple_%s_%s_%d.go", inputFile, membership.SelfNodeId, timestamp)
+	err := util.GenerateFilterMapleExecutables(predicate, nil, spec, nil, malformedRowPolicy(timestamp, "group"), executableName)
+	if err == nil {
+		err = addTemplateRows(executableName)
+	}
 	if err != nil {
-		log.Println("Error executing Juice job for query", err)
+		log.Println("Error generating maple executable for group query")
 		return
 	}
-	err = dfs.SDFSFetchAndConcatWithPrefix(sdfsDestFileName, sdfsDestFileName, dfs.RECEIVER_SDFS_CLIENT)
+
+	executableJuiceName := fmt.Sprintf("group_juice_%s_%d.go", membership.SelfNodeId, timestamp)
+	err = util.GenerateGroupJuiceExecutable(spec, order.topNSpec(), executableJuiceName)
 	if err != nil {
-		log.Println("Error fetching query result to local folder", err)
+		log.Println("Error generating juice executable for group query")
 		return
 	}
 
+	// upload generated executables to sdfs
+	_, err = dfs.SDFSPutFile(executableName, config.LocalFileDir + executableName)
+	if err != nil {
+		log.Println("Error uploading maple executable for group query", err)
+		return
+	}
+	_, err = dfs.SDFSPutFile(executableJuiceName, config.LocalFileDir + executableJuiceName)
+	if err != nil {
+		log.Println("Error uploading juice executable for group query", err)
+		return
+	}
 
-	log.Printf("Query completed with result at %s in local folder", sdfsDestFileName)
-}
+	prefix := fmt.Sprintf("group_%s_%s_%d", inputFile, membership.SelfNodeId, timestamp)
+	err = maplejuice.ProcessMapleCmd([]string{executableName, util.FmtTaskNum(config.MapleTaskNum), prefix, inputFile, "1", "format=csv"})
+	if err != nil {
+		log.Println("Error executing maple job for group query", err)
+		return
+	}
+	fetchMalformedRows(timestamp)
 
+	// partial aggregates are hash partitioned so that each group is merged by a single juice task
+	err = maplejuice.ProcessJuiceCmd([]string{executableJuiceName, util.FmtTaskNum(config.JuiceTaskNum), prefix, sdfsDestFilePrefix, "1", "1"})
+	if err != nil {
+		log.Println("Error executing juice job for group query", err)
+		return
+	}
 
+	fetchQueryResult(sdfsDestFilePrefix, outputHeader, order)
+}
 
-func executeJoinQuery(fileName1, fileName2, fieldName1, fieldName2 string){
-	if len(fileName1) == 0 || len(fileName2) == 0 || len(fieldName1) == 0 || len(fieldName2) == 0 {
-		log.Println("Empty query argument found in join query")
-		return
+// the tables are joined by a chain of join stages. The result of every stage but the last is concatenated
+// into an SDFS file with a header row, which the next stage joins with the next table
+func executeJoinQuery(fileNames []string, join *joinPlan, outputHeader []string, order *orderPlan){
+	for _, fileName := range fileNames {
+		if len(fileName) == 0 {
+			log.Println("Empty query argument found in join query")
+			return
+		}
 	}
 
 	timestamp := time.Now().UnixMilli()
 
+	input := fileNames[0]
+	for idx, stage := range join.stages {
+		s := idx + 1
+		if s == len(join.stages) {
+			sdfsDestFilePrefix := fmt.Sprintf("join_query_result_%s_%d", membership.SelfNodeId, timestamp)
+			err := executeJoinStage(s, stage, input, fileNames[s], sdfsDestFilePrefix, order.topNSpec(), timestamp)
+			if err != nil {
+				return
+			}
+			fetchMalformedRows(timestamp)
+			fetchQueryResult(sdfsDestFilePrefix, outputHeader, order)
+			return
+		}
+
+		sdfsDestFilePrefix := fmt.Sprintf("join_stage%d_result_%s_%d", s, membership.SelfNodeId, timestamp)
+		err := executeJoinStage(s, stage, input, fileNames[s], sdfsDestFilePrefix, nil, timestamp)
+		if err != nil {
+			return
+		}
+		// maple inputs cannot have dashes in their name
+		input = strings.ReplaceAll(fmt.Sprintf("join_stage%d_input_%s_%d", s, membership.SelfNodeId, timestamp), "-", "_")
+		err = storeJoinResult(sdfsDestFilePrefix, input, stage.header)
+		if err != nil {
+			log.Println("Error storing result of join stage", s, err)
+			return
+		}
+	}
+}
+
+// join stage s joins the SDFS file input1, the first table or the result of the previous stage, with
+// input2 into the files <sdfsDestFilePrefix>-*. topN only applies to broadcast joins, the juice of a
+// reduce side join runs once per join key and never sees a whole task's rows
+func executeJoinStage(s int, stage *joinStage, input1, input2, sdfsDestFilePrefix string, topN *util.TopNSpec, timestamp int64) error {
+	inputs := [2]string{input1, input2}
+	smallSide := broadcastSide(stage, inputs)
+	if smallSide >= 0 {
+		log.Printf("Join stage %d: broadcasting %s to the maple tasks", s, inputs[smallSide])
+		return executeBroadcastJoinStage(s, stage, inputs, smallSide, sdfsDestFilePrefix, topN, timestamp)
+	}
+
 	// generate executable with template for both d1 and d2
-	executableNameD1 := fmt.Sprintf("join_maple_%s_%s_%d.go", fileName1, membership.SelfNodeId, timestamp)
-	err := util.GenerateJoinMapleExecutables(fieldName1, executableNameD1)
+	executableNameD1 := fmt.Sprintf("join_maple1_stage%d_%s_%d.go", s, membership.SelfNodeId, timestamp)
+	err := util.GenerateJoinMapleExecutables(stage.columns[0], 0, stage.projections[0], malformedRowPolicy(timestamp, fmt.Sprintf("stage%d_0", s)), executableNameD1)
+	if err == nil {
+		err = addTemplateRows(executableNameD1)
+	}
+	if err != nil {
+		log.Println("Error generating maple executable for join query")
+		return err
+	}
 
+	executableNameD2 := fmt.Sprintf("join_maple2_stage%d_%s_%s_%d.go", s, input2, membership.SelfNodeId, timestamp)
+	err = util.GenerateJoinMapleExecutables(stage.columns[1], 1, stage.projections[1], malformedRowPolicy(timestamp, fmt.Sprintf("stage%d_1", s)), executableNameD2)
+	if err == nil {
+		err = addTemplateRows(executableNameD2)
+	}
 	if err != nil {
 		log.Println("Error generating maple executable for join query")
-		return
+		return err
 	}
 
-	executableNameD2 := fmt.Sprintf("join_maple_%s_%s_%d.go", fileName2, membership.SelfNodeId, timestamp)
-	err = util.GenerateJoinMapleExecutables(fieldName2, executableNameD2)
+	executableJuiceName := fmt.Sprintf("join_juice_stage%d_%s_%d.go", s, membership.SelfNodeId, timestamp)
+	err = util.GenerateJoinJuiceExecutable(stage.joinType, stage.columnCounts, stage.output, executableJuiceName)
+	if err != nil {
+		log.Println("Error generating juice executable for join query")
+		return err
+	}
 
-	executableJuiceName := "join_juice.go"
+	// upload generated executables to sdfs
+	for _, executableName := range []string{executableNameD1, executableNameD2, executableJuiceName} {
+		_, err = dfs.SDFSPutFile(executableName, config.LocalFileDir + executableName)
+		if err != nil {
+			log.Println("Error uploading executable for join query", err)
+			return err
+		}
+	}
 
+	// create maple task
+	prefix := fmt.Sprintf("join_stage%d_%s_%s_%d", s, input2, membership.SelfNodeId, timestamp)
+	err = maplejuice.ProcessMapleCmd([]string{executableNameD1, util.FmtTaskNum(config.MapleTaskNum), prefix, input1, "1", "format=csv"})
 	if err != nil {
-		log.Println("Error generating maple executable for join query")
-		return
+		log.Println("Error executing maple job for dataset1 in join query", err)
+		return err
 	}
-
-	// upload generated executable and input to sdfs
-	_, err = dfs.SDFSPutFile(executableNameD1, config.LocalFileDir + executableNameD1)
+	err = maplejuice.ProcessMapleCmd([]string{executableNameD2, util.FmtTaskNum(config.MapleTaskNum), prefix, input2, "1", "format=csv"})
 	if err != nil {
-		log.Println("Error uploading executable for join query", err)
-		return
+		log.Println("Error executing maple job for dataset2 in join query", err)
+		return err
 	}
-	_, err = dfs.SDFSPutFile(fileName1, config.LocalFileDir + fileName1)
+
+	// create juice task
+	err = maplejuice.ProcessJuiceCmd([]string{executableJuiceName , util.FmtTaskNum(config.JuiceTaskNum), prefix, sdfsDestFilePrefix, "0", "0"})
 	if err != nil {
-		log.Println("Error uploading input file for join query", err)
-		return
+		log.Println("Error executing juice job for join query", err)
+		return err
 	}
+	return nil
+}
 
-	_, err = dfs.SDFSPutFile(executableNameD2, config.LocalFileDir + executableNameD2)
+// map-only join stage, every maple task joins its split of the large input with the small input fetched
+// through the job cache
+func executeBroadcastJoinStage(s int, stage *joinStage, inputs [2]string, smallSide int, sdfsDestFilePrefix string, topN *util.TopNSpec, timestamp int64) error {
+	executableName := fmt.Sprintf("broadcast_join_stage%d_%s_%d.go", s, membership.SelfNodeId, timestamp)
+	err := util.GenerateBroadcastJoinExecutable(stage.joinType, smallSide, inputs[smallSide], stage.columns, stage.projections, stage.columnCounts, stage.output, topN, malformedRowPolicy(timestamp, fmt.Sprintf("stage%d", s)), executableName)
+	if err == nil {
+		err = addTemplateRows(executableName)
+	}
+	if err != nil {
+		log.Println("Error generating broadcast join executable for join query")
+		return err
+	}
+	_, err = dfs.SDFSPutFile(executableName, config.LocalFileDir + executableName)
 	if err != nil {
 		log.Println("Error uploading executable for join query", err)
-		return
+		return err
 	}
-	_, err = dfs.SDFSPutFile(fileName2, config.LocalFileDir + fileName2)
+
+	prefix := fmt.Sprintf("join_stage%d_%s_%s_%d", s, inputs[1], membership.SelfNodeId, timestamp)
+	err = maplejuice.ProcessMapleCmd([]string{executableName, util.FmtTaskNum(config.MapleTaskNum), prefix, inputs[1-smallSide], "1", "dest=" + sdfsDestFilePrefix, "cache=" + inputs[smallSide], "format=csv"})
 	if err != nil {
-		log.Println("Error uploading input file for join query", err)
-		return
+		log.Println("Error executing maple job for broadcast join", err)
+		return err
 	}
+	return nil
+}
 
+// concatenate the result files <sdfsResultPrefix>-* of a join stage into the SDFS file sdfsFileName,
+// headed by the column names the next stage reads
+func storeJoinResult(sdfsResultPrefix string, sdfsFileName string, header []string) error {
+	err := dfs.SDFSFetchAndConcatWithPrefix(sdfsResultPrefix, sdfsFileName, dfs.RECEIVER_SDFS_CLIENT)
+	if err != nil {
+		return err
+	}
+	localFilePath := config.LocalFileDir + sdfsFileName
+	defer os.Remove(localFilePath)
 
-	_, err = dfs.SDFSPutFile(executableJuiceName, config.TemplateFileDir + executableJuiceName)
+	err = prependHeader(localFilePath, header)
 	if err != nil {
-		log.Println("Error uploading juice executable for join query", err)
-		return
+		return err
 	}
+	_, err = dfs.SDFSPutFile(sdfsFileName, localFilePath)
+	return err
+}
 
-	// create maple task
-	prefix := fmt.Sprintf("join_%s_%s_%s_%d", fieldName1, fileName2, membership.SelfNodeId, timestamp)
-	err = maplejuice.ProcessMapleCmd([]string{executableNameD1, strconv.Itoa(config.MapleTaskNum), prefix, fileName1, "1"})
+// fetch the query result files <sdfsResultPrefix>-* to the local folder, after ordering them with a sort
+// job if the query has ORDER BY
+func fetchQueryResult(sdfsResultPrefix string, outputHeader []string, order *orderPlan) {
+	resultFileName := sdfsResultPrefix
+	if order != nil && len(order.keys) > 0 {
+		var err error
+		resultFileName, err = sortQueryResult(sdfsResultPrefix, order)
+		if err != nil {
+			log.Println("Error executing sort job for query", err)
+			return
+		}
+	}
+
+	err := dfs.SDFSFetchAndConcatWithPrefix(resultFileName, resultFileName, dfs.RECEIVER_SDFS_CLIENT)
 	if err != nil {
-		log.Println("Error executing maple job for dataset1 in join query", err)
+		log.Println("Error fetching query result to local folder", err)
 		return
 	}
-	err = maplejuice.ProcessMapleCmd([]string{executableNameD2, strconv.Itoa(config.MapleTaskNum), prefix, fileName2, "1"})
+	if order != nil && order.limit >= 0 {
+		err = limitRows(config.LocalFileDir + resultFileName, order.limit)
+		if err != nil {
+			log.Println("Error limiting rows of query result", err)
+			return
+		}
+	}
+	err = prependHeader(config.LocalFileDir + resultFileName, outputHeader)
 	if err != nil {
-		log.Println("Error executing maple job for dataset2 in join query", err)
+		log.Println("Error writing header row of query result", err)
 		return
 	}
 
-	sdfsDestFilePrefix := fmt.Sprintf("join_query_result_%s_%d", membership.SelfNodeId, timestamp)
-	// create juice task
-	err = maplejuice.ProcessJuiceCmd([]string{executableJuiceName , strconv.Itoa(config.JuiceTaskNum), prefix, sdfsDestFilePrefix, "0", "0"})
+	log.Printf("Query completed with result at %s in local folder", resultFileName)
+}
+
+// local error file of the malformed rows of the query started at timestamp
+func fmtMalformedRowsFileName(timestamp int64) string {
+	return fmt.Sprintf("sql_malformed_rows_%s_%d", membership.SelfNodeId, timestamp)
+}
+
+// malformed row policy of a maple executable of the query, executables of a query write their error
+// rows to side outputs told apart by name
+func malformedRowPolicy(timestamp int64, name string) *util.MalformedRowPolicy {
+	return &util.MalformedRowPolicy{
+		Action:          config.SqlMalformedRows,
+		ErrorFilePrefix: fmt.Sprintf("%s%s_%s", util.MAPLE_SIDE_OUTPUT_PREFIX, fmtMalformedRowsFileName(timestamp), name),
+	}
+}
 
+// with the error policy, concatenate the side outputs holding malformed rows of the query inputs into
+// the local error file, headed by its column names
+func fetchMalformedRows(timestamp int64) {
+	if config.SqlMalformedRows != util.SQL_MALFORMED_ERROR {
+		return
+	}
+	fileName := fmtMalformedRowsFileName(timestamp)
+	prefix := util.MAPLE_SIDE_OUTPUT_PREFIX + fileName + "_"
+	fileNames, err := dfs.SDFSSearchFileByRegex("^" + regexp.QuoteMeta(prefix))
 	if err != nil {
-		log.Println("Error executing juice job for join query", err)
+		log.Println("Error searching malformed rows of query inputs", err)
+		return
+	}
+	if len(*fileNames) == 0 {
 		return
 	}
 
-	err = dfs.SDFSFetchAndConcatWithPrefix(sdfsDestFilePrefix, sdfsDestFilePrefix, dfs.RECEIVER_SDFS_CLIENT)
-
+	err = dfs.SDFSFetchAndConcatWithPrefix(prefix, fileName, dfs.RECEIVER_SDFS_CLIENT)
+	if err == nil {
+		err = prependHeader(config.LocalFileDir + fileName, []string{"input", "error", "row"})
+	}
 	if err != nil {
-		log.Println("Error fetching join query result to local folder", err)
+		log.Println("Error fetching malformed rows of query inputs to local folder", err)
 		return
 	}
-
-	log.Printf("Query completed with result at %s in local folder", sdfsDestFilePrefix)
+	log.Printf("Malformed rows of the query inputs are at %s in local folder", fileName)
 }
-
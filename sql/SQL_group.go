@@ -0,0 +1,156 @@
+package sql
+
+import (
+	"maple-juice/util"
+	"fmt"
+	"strconv"
+)
+
+// aggregate queries: SELECT <group columns and aggregates> FROM <file> [WHERE ...] [GROUP BY ...] [HAVING ...]
+// Aggregates are COUNT(*), COUNT(<column>), COUNT(DISTINCT <column>), SUM, AVG, MIN and MAX of a column.
+// Without GROUP BY all lines form a single group.
+
+// number of intermediate keys groups are hashed into, bounds the number of juice executable runs
+const SQL_GROUP_BUCKETS = 32
+
+// whether the query aggregates lines into groups
+func isGroupQuery(stmt *SelectStmt) bool {
+	if len(stmt.GroupBy) > 0 || stmt.Having != nil {
+		return true
+	}
+	for _, item := range stmt.Columns {
+		if call, ok := item.Expr.(*FuncCall); ok && util.IsAggregateFunc(call.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// group values of a query: GROUP BY columns first, then aggregates, see util.GroupBySpec
+type groupPlan struct {
+	spec    *util.GroupBySpec
+	table   *TableRef
+	header  []string
+	aliases map[string]int // values of the select items by alias
+}
+
+func planGroupQuery(stmt *SelectStmt, header []string) (*util.GroupBySpec, []string, error) {
+	if stmt.All {
+		return nil, nil, &QueryError{Pos: stmt.Pos, Msg: "SELECT ALL cannot be grouped, list the group columns and aggregates"}
+	}
+
+	plan := &groupPlan{
+		spec:    &util.GroupBySpec{Columns: make([]string, 0), Aggregates: make([]util.AggregateSpec, 0), Buckets: SQL_GROUP_BUCKETS, Outputs: make([]int, 0)},
+		table:   stmt.From[0],
+		header:  header,
+		aliases: make(map[string]int),
+	}
+	for _, column := range stmt.GroupBy {
+		err := plan.checkColumn(column)
+		if err != nil {
+			return nil, nil, err
+		}
+		if findColumn(plan.spec.Columns, column.Column) < 0 {
+			plan.spec.Columns = append(plan.spec.Columns, column.Column)
+		}
+	}
+
+	outputHeader := make([]string, 0)
+	for _, item := range stmt.Columns {
+		slot, err := plan.valueOf(item.Expr)
+		if err != nil {
+			return nil, nil, err
+		}
+		plan.spec.Outputs = append(plan.spec.Outputs, slot)
+		outputHeader = append(outputHeader, item.OutputName())
+		if len(item.Alias) > 0 {
+			plan.aliases[item.Alias] = slot
+		}
+	}
+
+	if stmt.Having != nil {
+		compiler := &filterCompiler{table: plan.table, header: header, group: plan}
+		having, err := compiler.compile(stmt.Having)
+		if err != nil {
+			return nil, nil, err
+		}
+		plan.spec.Having = having
+	}
+	return plan.spec, outputHeader, nil
+}
+
+// number of the group value of a group column or an aggregate
+func (this *groupPlan) valueOf(expr Expr) (int, error) {
+	switch expr := expr.(type) {
+	case *ColumnRef:
+		return this.columnValue(expr)
+	case *FuncCall:
+		if util.IsAggregateFunc(expr.Name) {
+			return this.aggregateValue(expr)
+		}
+		return 0, &QueryError{Pos: expr.Pos, Msg: fmt.Sprintf("unknown aggregate function %s, expected COUNT, SUM, AVG, MIN or MAX", expr.Name)}
+	}
+	return 0, &QueryError{Pos: expr.Position(), Msg: "expected a group column or an aggregate"}
+}
+
+func (this *groupPlan) columnValue(column *ColumnRef) (int, error) {
+	if len(column.Table) == 0 {
+		if slot, ok := this.aliases[column.Column]; ok {
+			return slot, nil
+		}
+	}
+	err := this.checkColumn(column)
+	if err != nil {
+		return 0, err
+	}
+	slot := findColumn(this.spec.Columns, column.Column)
+	if slot < 0 {
+		return 0, &QueryError{Pos: column.Pos, Msg: fmt.Sprintf("column %s must be listed in GROUP BY or used in an aggregate", column.Column)}
+	}
+	return slot, nil
+}
+
+func (this *groupPlan) aggregateValue(call *FuncCall) (int, error) {
+	aggregate := util.AggregateSpec{Func: call.Name, Distinct: call.Distinct}
+	switch {
+	case call.Star:
+		if call.Name != util.AGGREGATE_COUNT {
+			return 0, &QueryError{Pos: call.Pos, Msg: fmt.Sprintf("%s(*) is not supported, only COUNT(*)", call.Name)}
+		}
+	case len(call.Args) != 1:
+		return 0, &QueryError{Pos: call.Pos, Msg: fmt.Sprintf("%s takes a single column", call.Name)}
+	default:
+		column, ok := call.Args[0].(*ColumnRef)
+		if !ok {
+			return 0, &QueryError{Pos: call.Args[0].Position(), Msg: "expected a column"}
+		}
+		err := this.checkColumn(column)
+		if err != nil {
+			return 0, err
+		}
+		aggregate.Column = column.Column
+	}
+	if call.Distinct && call.Name != util.AGGREGATE_COUNT {
+		return 0, &QueryError{Pos: call.Pos, Msg: "DISTINCT is only supported in COUNT(DISTINCT <column>)"}
+	}
+
+	for idx, existing := range this.spec.Aggregates {
+		if existing == aggregate {
+			return len(this.spec.Columns) + idx, nil
+		}
+	}
+	this.spec.Aggregates = append(this.spec.Aggregates, aggregate)
+	return len(this.spec.Columns) + len(this.spec.Aggregates) - 1, nil
+}
+
+func (this *groupPlan) checkColumn(column *ColumnRef) error {
+	if len(column.Table) > 0 && column.Table != this.table.RefName() {
+		return &QueryError{Pos: column.Pos, Msg: fmt.Sprintf("unknown table %s", column.Table)}
+	}
+	return checkColumn(column, this.table, this.header)
+}
+
+// HAVING operand of a group value
+func groupValueOperand(slot int) util.FilterOperand {
+	return util.FilterOperand{Kind: util.FILTER_OPERAND_COLUMN, Value: strconv.Itoa(slot)}
+}
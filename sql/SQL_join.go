@@ -0,0 +1,316 @@
+package sql
+
+import (
+	"maple-juice/config"
+	"maple-juice/dfs"
+	"maple-juice/util"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// join of two or more tables: FROM <file1>, <file2>, ... WHERE <condition> is an inner join, FROM <file1>
+// [INNER | LEFT | RIGHT | FULL] JOIN <file2> ON <condition> ... joins each table by its ON condition. A
+// condition is an equality of columns of two tables, or several of them combined with AND, which make up
+// a composite join key. The tables are joined by a chain of binary join stages, stage s joins the result
+// of the stages before it (the first table for stage 1) with table s.
+
+// column of the table at position side in the FROM clause
+type tableColumn struct {
+	side   int
+	column string
+}
+
+type joinStage struct {
+	joinType     string                  // one of util.JOIN_*
+	columns      [2][]string             // columns of the joined result and of table s the join key is combined from, in matching order
+	projections  [2][]string             // columns each maple emits, nil for whole lines
+	output       []util.JoinOutputColumn // where the juice finds the result columns, nil to concatenate whole lines
+	columnCounts [2]int                  // columns of the lines of each side, NULL columns pad unmatched rows of outer joins
+	header       []string                // result columns, named as the next stage reads them
+}
+
+type joinPlan struct {
+	stages []*joinStage // stage s at index s-1, the last one writes the query result
+}
+
+func planJoin(stmt *SelectStmt, headers [][]string) (*joinPlan, error) {
+	err := checkTableNames(stmt)
+	if err != nil {
+		return nil, err
+	}
+	terms, err := joinTerms(stmt, headers)
+	if err != nil {
+		return nil, err
+	}
+	items, err := itemColumns(stmt, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &joinPlan{}
+	width := len(headers[0])
+	for s := 1; s < len(stmt.From); s++ {
+		stage := &joinStage{joinType: util.JOIN_INNER}
+		if len(stmt.From[s].Join) > 0 {
+			stage.joinType = strings.ToLower(stmt.From[s].Join)
+		}
+		for _, term := range terms[s] {
+			stage.columns[0] = append(stage.columns[0], stageColumn(term[0], s))
+			stage.columns[1] = append(stage.columns[1], term[1].column)
+		}
+
+		if stmt.All {
+			// whole lines, the result holds every column of the tables joined so far
+			stage.columnCounts = [2]int{width, len(headers[s])}
+			width += len(headers[s])
+			for side, header := range headers[:s+1] {
+				for _, column := range header {
+					stage.header = append(stage.header, qualifiedColumn(tableColumn{side: side, column: column}))
+				}
+			}
+		} else {
+			stage.projections = [2][]string{make([]string, 0), make([]string, 0)}
+			stage.output = make([]util.JoinOutputColumn, 0)
+			for _, column := range stageResult(s, len(stmt.From), items, terms) {
+				side := 0
+				if column.side == s {
+					side = 1
+				}
+				// a column needed twice is emitted by the maple once
+				name := stageColumn(column, s)
+				index := findColumn(stage.projections[side], name)
+				if index < 0 {
+					index = len(stage.projections[side])
+					stage.projections[side] = append(stage.projections[side], name)
+				}
+				stage.output = append(stage.output, util.JoinOutputColumn{Side: side, Index: index})
+				stage.header = append(stage.header, qualifiedColumn(column))
+			}
+			stage.columnCounts = [2]int{len(stage.projections[0]), len(stage.projections[1])}
+		}
+		plan.stages = append(plan.stages, stage)
+	}
+	return plan, nil
+}
+
+// input of a join stage at most BROADCAST_JOIN_THRESHOLD_MB large by SDFS metadata, the smaller one if
+// both are, or -1 to shuffle both. An input preserved by an outer join is never broadcast, its lines
+// without a match must be seen by a single task
+func broadcastSide(stage *joinStage, inputs [2]string) int {
+	if config.BroadcastJoinThresholdMB == 0 {
+		return -1
+	}
+	threshold := int64(config.BroadcastJoinThresholdMB) * 1024 * 1024
+
+	side := -1
+	var smallest int64
+	for idx, input := range inputs {
+		if stage.preserves(idx) {
+			continue
+		}
+		size, err := dfs.SDFSFileSize(input)
+		if err != nil {
+			log.Printf("Cannot get size of join input %s, not broadcasting it: %s", input, err)
+			continue
+		}
+		if size <= threshold && (side < 0 || size < smallest) {
+			side = idx
+			smallest = size
+		}
+	}
+	return side
+}
+
+// whether lines of the input at side 0 or 1 are kept without a match
+func (this *joinStage) preserves(side int) bool {
+	switch this.joinType {
+	case util.JOIN_LEFT:
+		return side == 0
+	case util.JOIN_RIGHT:
+		return side == 1
+	case util.JOIN_FULL:
+		return true
+	}
+	return false
+}
+
+// tables are told apart by their name or alias. Only a self join of two tables may name the same file
+// twice, its condition compares the first table on the left with the second one on the right
+func checkTableNames(stmt *SelectStmt) error {
+	for idx, table := range stmt.From {
+		for _, other := range stmt.From[:idx] {
+			if other.RefName() != table.RefName() {
+				continue
+			}
+			if len(stmt.From) == 2 && len(table.Alias) == 0 && len(other.Alias) == 0 {
+				continue
+			}
+			return &QueryError{Pos: table.Pos, Msg: fmt.Sprintf("table name %s is used twice, give the tables different aliases", table.RefName())}
+		}
+	}
+	return nil
+}
+
+// equalities of the join condition of each stage, the first column of a term belongs to a table before
+// the one the stage joins
+func joinTerms(stmt *SelectStmt, headers [][]string) ([][][2]tableColumn, error) {
+	terms := make([][][2]tableColumn, len(stmt.From))
+	usesJoin := false
+	for _, table := range stmt.From[1:] {
+		usesJoin = usesJoin || len(table.Join) > 0
+	}
+
+	if usesJoin {
+		if stmt.Where != nil {
+			return nil, &QueryError{Pos: stmt.Where.Position(), Msg: "WHERE is not supported with JOIN, the ON condition joins the tables"}
+		}
+		for s, table := range stmt.From {
+			if s == 0 {
+				continue
+			}
+			if len(table.Join) == 0 {
+				return nil, &QueryError{Pos: table.Pos, Msg: "tables listed with a comma are joined by WHERE, which is not supported with JOIN"}
+			}
+			for _, expr := range conjuncts(table.On) {
+				term, err := joinTerm(stmt, expr, headers)
+				if err != nil {
+					return nil, err
+				}
+				if term[1].side != s {
+					return nil, &QueryError{Pos: expr.Position(), Msg: fmt.Sprintf("expected a condition comparing a column of %s with a column of a table before it", table.RefName())}
+				}
+				terms[s] = append(terms[s], term)
+			}
+		}
+		return terms, nil
+	}
+
+	if stmt.Where == nil {
+		return nil, &QueryError{Pos: stmt.From[1].Pos, Msg: "join queries need a WHERE <file1>.<field1> = <file2>.<field2> condition"}
+	}
+	for _, expr := range conjuncts(stmt.Where) {
+		term, err := joinTerm(stmt, expr, headers)
+		if err != nil {
+			return nil, err
+		}
+		terms[term[1].side] = append(terms[term[1].side], term)
+	}
+	for s, table := range stmt.From {
+		if s > 0 && len(terms[s]) == 0 {
+			return nil, &QueryError{Pos: table.Pos, Msg: fmt.Sprintf("%s is not joined, expected a condition comparing its columns with a table before it", table.RefName())}
+		}
+	}
+	return terms, nil
+}
+
+// terms of a condition combined with AND
+func conjuncts(expr Expr) []Expr {
+	if binary, ok := expr.(*BinaryExpr); ok && binary.Op == "AND" {
+		return append(conjuncts(binary.Left), conjuncts(binary.Right)...)
+	}
+	return []Expr{expr}
+}
+
+// columns of two tables compared by a term of the join condition, in the order of the tables
+func joinTerm(stmt *SelectStmt, term Expr, headers [][]string) ([2]tableColumn, error) {
+	columns := [2]tableColumn{}
+	condition, ok := term.(*BinaryExpr)
+	if !ok || condition.Op != "=" {
+		return columns, &QueryError{Pos: term.Position(), Msg: "expected a join condition <file1>.<field1> = <file2>.<field2>"}
+	}
+	left, ok := condition.Left.(*ColumnRef)
+	if !ok {
+		return columns, &QueryError{Pos: condition.Left.Position(), Msg: "expected a column"}
+	}
+	right, ok := condition.Right.(*ColumnRef)
+	if !ok {
+		return columns, &QueryError{Pos: condition.Right.Position(), Msg: "expected a column"}
+	}
+
+	leftSide, err := resolveJoinSide(stmt, left, headers)
+	if err != nil {
+		return columns, err
+	}
+	rightSide, err := resolveJoinSide(stmt, right, headers)
+	if err != nil {
+		return columns, err
+	}
+	if leftSide == rightSide {
+		// self join, the table name stands for the first table on the left and the second on the right
+		if len(stmt.From) == 2 && stmt.From[0].RefName() == stmt.From[1].RefName() && len(left.Table) > 0 && len(right.Table) > 0 {
+			leftSide, rightSide = 0, 1
+		} else {
+			return columns, &QueryError{Pos: right.Pos, Msg: fmt.Sprintf("expected a column of another table than %s", stmt.From[leftSide].RefName())}
+		}
+	}
+
+	columns[0] = tableColumn{side: leftSide, column: left.Column}
+	columns[1] = tableColumn{side: rightSide, column: right.Column}
+	if leftSide > rightSide {
+		columns[0], columns[1] = columns[1], columns[0]
+	}
+	return columns, nil
+}
+
+// selected columns, nil for SELECT ALL
+func itemColumns(stmt *SelectStmt, headers [][]string) ([]tableColumn, error) {
+	if stmt.All {
+		return nil, nil
+	}
+	items := make([]tableColumn, 0)
+	for _, item := range stmt.Columns {
+		column, err := itemColumn(item)
+		if err != nil {
+			return nil, err
+		}
+		side, err := resolveJoinSide(stmt, column, headers)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, tableColumn{side: side, column: column.Column})
+	}
+	return items, nil
+}
+
+// columns written by stage s: the selected columns for the last stage, otherwise the columns of the
+// tables joined so far that are selected or join a later table
+func stageResult(s int, tableNum int, items []tableColumn, terms [][][2]tableColumn) []tableColumn {
+	if s == tableNum-1 {
+		return items
+	}
+	needed := append([]tableColumn{}, items...)
+	for _, stageTerms := range terms[s+1:] {
+		for _, term := range stageTerms {
+			needed = append(needed, term[0])
+		}
+	}
+
+	result := make([]tableColumn, 0)
+	for _, column := range needed {
+		if column.side > s {
+			continue
+		}
+		found := false
+		for _, other := range result {
+			found = found || other == column
+		}
+		if !found {
+			result = append(result, column)
+		}
+	}
+	return result
+}
+
+// name of a column in the lines stage s joins, the result of the stages before it qualifies columns by
+// the position of their table
+func stageColumn(column tableColumn, s int) string {
+	if s == 1 || column.side == s {
+		return column.column
+	}
+	return qualifiedColumn(column)
+}
+
+func qualifiedColumn(column tableColumn) string {
+	return fmt.Sprintf("%d.%s", column.side, column.column)
+}
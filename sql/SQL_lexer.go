@@ -0,0 +1,263 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokens of the SQL dialect:
+//   keywords        case-insensitive, e.g. SELECT / select
+//   identifiers     unquoted (letters, digits, _) or double quoted, "" escapes a double quote
+//   strings         single quoted, '' escapes a single quote, backslashes are kept as is
+//   numbers         123, 1.5, 2e10
+//   symbols         , . ; ( ) * - = <> != < <= > >=
+
+type TokenType int
+
+const (
+	TOKEN_EOF TokenType = iota
+	TOKEN_KEYWORD
+	TOKEN_IDENT
+	TOKEN_QUOTED_IDENT
+	TOKEN_STRING
+	TOKEN_NUMBER
+	TOKEN_SYMBOL
+)
+
+var reservedKeywords = map[string]bool{
+	"SELECT":  true,
+	"ALL":     true,
+	"AS":      true,
+	"FROM":    true,
+	"WHERE":   true,
+	"AND":     true,
+	"OR":      true,
+	"NOT":     true,
+	"LIKE":    true,
+	"IN":      true,
+	"BETWEEN": true,
+	"IS":      true,
+	"NULL":    true,
+	"GROUP":    true,
+	"BY":       true,
+	"HAVING":   true,
+	"DISTINCT": true,
+	"ORDER":    true,
+	"ASC":      true,
+	"DESC":     true,
+	"LIMIT":    true,
+	"JOIN":     true,
+	"INNER":    true,
+	"LEFT":     true,
+	"RIGHT":    true,
+	"FULL":     true,
+	"OUTER":    true,
+	"ON":       true,
+}
+
+var symbols = []string{"<>", "!=", "<=", ">=", ",", ".", ";", "(", ")", "*", "=", "<", ">", "-"}
+
+type Token struct {
+	Type  TokenType
+	Value string // keywords are upper case, quotes are removed from identifiers and strings
+	Pos   Pos
+}
+
+type SyntaxError struct {
+	Pos Pos
+	Msg string
+}
+
+func (this *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %s: %s", this.Pos.String(), this.Msg)
+}
+
+// line of the query at pos with a caret under pos
+func markErrorPosition(query string, pos Pos) string {
+	lines := strings.Split(query, "\n")
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return ""
+	}
+	return fmt.Sprintf("%s\n%s^", lines[pos.Line-1], strings.Repeat(" ", pos.Column-1))
+}
+
+func (this Token) String() string {
+	switch this.Type {
+	case TOKEN_EOF:
+		return "end of query"
+	case TOKEN_KEYWORD, TOKEN_IDENT, TOKEN_NUMBER:
+		return this.Value
+	case TOKEN_QUOTED_IDENT:
+		return quoteIdentifier(this.Value)
+	case TOKEN_STRING:
+		return "'" + this.Value + "'"
+	}
+	return "\"" + this.Value + "\""
+}
+
+type lexer struct {
+	query  string
+	offset int
+	line   int
+	column int
+}
+
+func Tokenize(query string) ([]Token, error) {
+	lex := &lexer{query: query, line: 1, column: 1}
+	tokens := make([]Token, 0)
+	for {
+		token, err := lex.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+		if token.Type == TOKEN_EOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (this *lexer) pos() Pos {
+	return Pos{Offset: this.offset, Line: this.line, Column: this.column}
+}
+
+func (this *lexer) peek() (rune, int) {
+	if this.offset >= len(this.query) {
+		return 0, 0
+	}
+	return utf8.DecodeRuneInString(this.query[this.offset:])
+}
+
+func (this *lexer) advance() rune {
+	r, size := this.peek()
+	this.offset += size
+	if r == '\n' {
+		this.line++
+		this.column = 1
+	} else {
+		this.column++
+	}
+	return r
+}
+
+func (this *lexer) next() (Token, error) {
+	for {
+		r, size := this.peek()
+		if size == 0 || !unicode.IsSpace(r) {
+			break
+		}
+		this.advance()
+	}
+
+	start := this.pos()
+	r, size := this.peek()
+	switch {
+	case size == 0:
+		return Token{Type: TOKEN_EOF, Pos: start}, nil
+	case r == '\'':
+		value, err := this.readQuoted('\'')
+		return Token{Type: TOKEN_STRING, Value: value, Pos: start}, err
+	case r == '"':
+		value, err := this.readQuoted('"')
+		if err == nil && len(value) == 0 {
+			err = &SyntaxError{Pos: start, Msg: "empty quoted identifier"}
+		}
+		return Token{Type: TOKEN_QUOTED_IDENT, Value: value, Pos: start}, err
+	case unicode.IsDigit(r):
+		return this.readNumber(start), nil
+	case isIdentRune(r):
+		word := this.readWhile(isIdentRune)
+		if reservedKeywords[strings.ToUpper(word)] {
+			return Token{Type: TOKEN_KEYWORD, Value: strings.ToUpper(word), Pos: start}, nil
+		}
+		return Token{Type: TOKEN_IDENT, Value: word, Pos: start}, nil
+	}
+
+	for _, symbol := range symbols {
+		if strings.HasPrefix(this.query[this.offset:], symbol) {
+			for range symbol {
+				this.advance()
+			}
+			return Token{Type: TOKEN_SYMBOL, Value: symbol, Pos: start}, nil
+		}
+	}
+	return Token{}, &SyntaxError{Pos: start, Msg: fmt.Sprintf("unexpected character %q", r)}
+}
+
+// quoted string or identifier, a doubled quote stands for the quote itself
+func (this *lexer) readQuoted(quote rune) (string, error) {
+	start := this.pos()
+	this.advance()
+	var builder strings.Builder
+	for {
+		r, size := this.peek()
+		if size == 0 {
+			if quote == '\'' {
+				return "", &SyntaxError{Pos: start, Msg: "unterminated string"}
+			}
+			return "", &SyntaxError{Pos: start, Msg: "unterminated quoted identifier"}
+		}
+		this.advance()
+		if r == quote {
+			if next, _ := this.peek(); next != quote {
+				return builder.String(), nil
+			}
+			this.advance()
+		}
+		builder.WriteRune(r)
+	}
+}
+
+// digits with an optional fraction and exponent, names starting with digits such as 2023data are identifiers
+func (this *lexer) readNumber(start Pos) Token {
+	begin := this.offset
+	this.readWhile(unicode.IsDigit)
+	if r, _ := this.peek(); isIdentRune(r) && r != 'e' && r != 'E' {
+		this.readWhile(isIdentRune)
+		return Token{Type: TOKEN_IDENT, Value: this.query[begin:this.offset], Pos: start}
+	}
+
+	if r, _ := this.peek(); r == '.' && this.offset+1 < len(this.query) && isDigitByte(this.query[this.offset+1]) {
+		this.advance()
+		this.readWhile(unicode.IsDigit)
+	}
+	if r, _ := this.peek(); r == 'e' || r == 'E' {
+		rest := this.query[this.offset+1:]
+		if strings.HasPrefix(rest, "+") || strings.HasPrefix(rest, "-") {
+			rest = rest[1:]
+		}
+		if len(rest) > 0 && isDigitByte(rest[0]) {
+			this.advance()
+			if sign, _ := this.peek(); sign == '+' || sign == '-' {
+				this.advance()
+			}
+			this.readWhile(unicode.IsDigit)
+		}
+	}
+	if r, _ := this.peek(); isIdentRune(r) {
+		this.readWhile(isIdentRune)
+		return Token{Type: TOKEN_IDENT, Value: this.query[begin:this.offset], Pos: start}
+	}
+	return Token{Type: TOKEN_NUMBER, Value: this.query[begin:this.offset], Pos: start}
+}
+
+func (this *lexer) readWhile(accept func(rune) bool) string {
+	begin := this.offset
+	for {
+		r, size := this.peek()
+		if size == 0 || !accept(r) {
+			return this.query[begin:this.offset]
+		}
+		this.advance()
+	}
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isDigitByte(b byte) bool {
+	return b >= '0' && b <= '9'
+}
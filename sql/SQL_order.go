@@ -0,0 +1,232 @@
+package sql
+
+import (
+	"maple-juice/config"
+	"maple-juice/maplejuice"
+	"maple-juice/util"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ORDER BY and LIMIT: once a query has written its result to SDFS, a sort job range partitions the result
+// files by the ORDER BY columns and sorts every range. Columns order by their type: INT and FLOAT catalog
+// columns, COUNT, SUM and AVG numerically, with values that are not numbers after the numbers as strings,
+// STRING catalog columns and columns of local files as text. With a LIMIT, tasks that see a whole input split or bucket of
+// result rows (the filter maple, the broadcast join maple and the group juice) only write their first rows
+// under the sort order, so the sort job only sees a few rows per task and keeps the first of them. The join
+// juice runs once per join key, so a reduce side join writes all of its rows.
+
+// number of ranges the result is sorted in when the number of juice tasks is picked automatically
+const SQL_SORT_OUTPUTS = 8
+
+type orderPlan struct {
+	keys  []util.SortKey // output columns by 0-based number, empty without ORDER BY
+	limit int            // -1 without LIMIT
+	topN  *util.TopNSpec // first rows written by the tasks writing the result rows, nil without LIMIT
+}
+
+// input tables in FROM order resolve ORDER BY columns that are not named in the output and give their types
+func planOrder(stmt *SelectStmt, outputHeader []string, tables []*queryTable) (*orderPlan, error) {
+	if len(stmt.OrderBy) == 0 && stmt.Limit == nil {
+		return nil, nil
+	}
+
+	checker := &typeChecker{stmt: stmt, tables: tables}
+	for _, table := range tables {
+		checker.headers = append(checker.headers, table.header)
+	}
+
+	plan := &orderPlan{keys: make([]util.SortKey, 0), limit: -1}
+	topNKeys := make([]util.SortKeySpec, 0)
+	for _, item := range stmt.OrderBy {
+		idx, err := orderColumn(stmt, item.Expr, outputHeader, checker.headers)
+		if err != nil {
+			return nil, err
+		}
+		sortType := util.SORT_TYPE_STRING
+		if isNumericType(checker.outputType(idx)) {
+			sortType = util.SORT_TYPE_NUMERIC
+		}
+		plan.keys = append(plan.keys, util.SortKey{Column: strconv.Itoa(idx), SortType: sortType, Descending: item.Descending})
+		topNKeys = append(topNKeys, util.SortKeySpec{ColumnIndex: idx, Numeric: sortType == util.SORT_TYPE_NUMERIC, Descending: item.Descending})
+	}
+
+	if stmt.Limit != nil {
+		limit, err := strconv.Atoi(stmt.Limit.Value)
+		if err != nil || limit < 0 {
+			return nil, &QueryError{Pos: stmt.Limit.Pos, Msg: fmt.Sprintf("invalid LIMIT %s", stmt.Limit.Value)}
+		}
+		plan.limit = limit
+		if limit > 0 {
+			plan.topN = &util.TopNSpec{Keys: topNKeys, Limit: limit}
+		}
+	}
+	return plan, nil
+}
+
+// top-N of the tasks writing the result rows, nil if the query has no LIMIT
+func (this *orderPlan) topNSpec() *util.TopNSpec {
+	if this == nil {
+		return nil
+	}
+	return this.topN
+}
+
+// type of the output column with the 0-based number, empty if unknown
+func (this *typeChecker) outputType(idx int) string {
+	if !this.stmt.All {
+		return this.typeOf(this.stmt.Columns[idx].Expr)
+	}
+	for _, table := range this.tables {
+		if idx < len(table.header) {
+			if table.types == nil {
+				return ""
+			}
+			return table.types[idx]
+		}
+		idx -= len(table.header)
+	}
+	return ""
+}
+
+// 0-based number of the output column an ORDER BY item refers to
+func orderColumn(stmt *SelectStmt, expr Expr, outputHeader []string, headers [][]string) (int, error) {
+	switch expr := expr.(type) {
+	case *NumberLit:
+		position, err := strconv.Atoi(expr.Value)
+		if err != nil || position < 1 || position > len(outputHeader) {
+			return 0, &QueryError{Pos: expr.Pos, Msg: fmt.Sprintf("expected an output column number between 1 and %d", len(outputHeader))}
+		}
+		return position - 1, nil
+
+	case *ColumnRef:
+		if len(expr.Table) == 0 {
+			idx, err := outputNamed(expr, outputHeader)
+			if err != nil || idx >= 0 {
+				return idx, err
+			}
+		}
+		side, err := resolveJoinSide(stmt, expr, headers)
+		if err != nil {
+			return 0, err
+		}
+		if stmt.All {
+			offset := 0
+			for _, header := range headers[:side] {
+				offset += len(header)
+			}
+			return offset + findColumn(headers[side], expr.Column), nil
+		}
+		for idx, item := range stmt.Columns {
+			column, ok := item.Expr.(*ColumnRef)
+			if !ok || column.Column != expr.Column {
+				continue
+			}
+			itemSide, err := resolveJoinSide(stmt, column, headers)
+			if err == nil && itemSide == side {
+				return idx, nil
+			}
+		}
+
+	case *FuncCall:
+		for idx, item := range stmt.Columns {
+			if item.Expr.String() == expr.String() {
+				return idx, nil
+			}
+		}
+	}
+	return 0, &QueryError{Pos: expr.Position(), Msg: fmt.Sprintf("%s is not an output column of the query", expr.String())}
+}
+
+// output column with the name or alias, -1 if there is none
+func outputNamed(column *ColumnRef, outputHeader []string) (int, error) {
+	found := -1
+	for idx, name := range outputHeader {
+		if name != column.Column {
+			continue
+		}
+		if found >= 0 {
+			return 0, &QueryError{Pos: column.Pos, Msg: fmt.Sprintf("output column %s is ambiguous, qualify it with its table or use its number", column.Column)}
+		}
+		found = idx
+	}
+	return found, nil
+}
+
+// sort the query result files <sdfsResultPrefix>-* into the returned SDFS prefix
+func sortQueryResult(sdfsResultPrefix string, order *orderPlan) (string, error) {
+	// sort outputs cannot have dashes in their name
+	sortedPrefix := strings.ReplaceAll(sdfsResultPrefix, "-", "_") + "_sorted"
+
+	outputNum := SQL_SORT_OUTPUTS
+	if config.JuiceTaskNum > 0 {
+		outputNum = config.JuiceTaskNum
+	}
+	if order.limit > 0 {
+		outputNum = 1
+	}
+
+	columns := make([]string, 0, len(order.keys))
+	types := make([]string, 0, len(order.keys))
+	orders := make([]string, 0, len(order.keys))
+	for _, key := range order.keys {
+		columns = append(columns, key.Column)
+		types = append(types, key.SortType)
+		if key.Descending {
+			orders = append(orders, "desc")
+		} else {
+			orders = append(orders, "asc")
+		}
+	}
+	args := []string{
+		sdfsResultPrefix, sortedPrefix, strconv.Itoa(outputNum), "0", "input=prefix", "delim=" + util.SORT_DELIMITER_CSV,
+		"column=" + strings.Join(columns, ","), "type=" + strings.Join(types, ","), "order=" + strings.Join(orders, ","),
+		"num_maples=" + util.FmtTaskNum(config.MapleTaskNum), "num_juices=" + util.FmtTaskNum(config.JuiceTaskNum),
+	}
+	if order.limit > 0 {
+		args = append(args, "limit="+strconv.Itoa(order.limit))
+	}
+	return sortedPrefix, maplejuice.ProcessSortCmd(args)
+}
+
+// keep the first rows of the local query result, quoted fields of a row may hold line breaks
+func limitRows(filePath string, limit int) error {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tmpFilePath := filePath + ".tmp"
+	tmpFile, err := os.Create(tmpFilePath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFilePath)
+
+	reader := util.NewRecordReader(file, util.RecordFormat{Kind: util.RECORD_FORMAT_CSV})
+	for rows := 0; rows < limit && err == nil; rows++ {
+		var row []byte
+		row, err = reader.Next()
+		if err == nil {
+			_, err = tmpFile.Write(append(row, '\n'))
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	closeErr := tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(tmpFilePath, filePath)
+}
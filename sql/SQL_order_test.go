@@ -0,0 +1,54 @@
+package sql
+
+import (
+	"maple-juice/util"
+	"reflect"
+	"testing"
+)
+
+func TestPlanOrderSortTypes(t *testing.T) {
+	people := &queryTable{
+		header: []string{"name", "zip", "age", "height"},
+		types:  []string{COLUMN_TYPE_STRING, COLUMN_TYPE_STRING, COLUMN_TYPE_INT, COLUMN_TYPE_FLOAT},
+	}
+	local := &queryTable{header: []string{"id", "score"}, local: true}
+
+	tests := []struct {
+		query  string
+		tables []*queryTable
+		want   []string // sort type of every ORDER BY key
+	}{
+		{"SELECT name, zip, age FROM people ORDER BY zip, age DESC, 1", []*queryTable{people}, []string{util.SORT_TYPE_STRING, util.SORT_TYPE_NUMERIC, util.SORT_TYPE_STRING}},
+		{"SELECT ALL FROM people ORDER BY height, zip", []*queryTable{people}, []string{util.SORT_TYPE_NUMERIC, util.SORT_TYPE_STRING}},
+		{"SELECT id, score FROM scores ORDER BY score", []*queryTable{local}, []string{util.SORT_TYPE_STRING}},
+		{
+			"SELECT zip, COUNT(*), SUM(age), AVG(height), MIN(name), MAX(age) FROM people GROUP BY zip ORDER BY 2, 3, 4, 5, 6, zip",
+			[]*queryTable{people},
+			[]string{util.SORT_TYPE_NUMERIC, util.SORT_TYPE_NUMERIC, util.SORT_TYPE_NUMERIC, util.SORT_TYPE_STRING, util.SORT_TYPE_NUMERIC, util.SORT_TYPE_STRING},
+		},
+		{"SELECT ALL FROM people, scores WHERE people.name = scores.id ORDER BY score, age", []*queryTable{people, local}, []string{util.SORT_TYPE_STRING, util.SORT_TYPE_NUMERIC}},
+	}
+	for _, test := range tests {
+		stmt, err := ParseQuery(test.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) failed: %s", test.query, err)
+			continue
+		}
+		headers := make([][]string, 0)
+		for _, table := range test.tables {
+			headers = append(headers, table.header)
+		}
+		plan, err := planOrder(stmt, joinOutputHeader(stmt, headers), test.tables)
+		if err != nil {
+			t.Errorf("planOrder(%q) failed: %s", test.query, err)
+			continue
+		}
+		got := make([]string, 0)
+		for _, key := range plan.keys {
+			got = append(got, key.SortType)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("planOrder(%q) sort types = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
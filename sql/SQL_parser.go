@@ -0,0 +1,775 @@
+package sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// recursive descent parser of the grammar
+//   query      := SELECT (ALL | * | select_item {, select_item}) FROM table_ref {join} [WHERE expr]
+//                 [GROUP BY column_ref {, column_ref}] [HAVING expr]
+//                 [ORDER BY order_item {, order_item}] [LIMIT number] [;]
+//   select_item:= (column_ref | function) [AS name]
+//   order_item := (column_ref | function | number) [ASC | DESC]
+//   join       := , table_ref | [INNER | (LEFT | RIGHT | FULL) [OUTER]] JOIN table_ref ON expr
+//   table_ref  := (quoted_ident | name {. name}) [[AS] name]   e.g. traffic.csv AS t, written without spaces
+//   expr       := and_expr {OR and_expr}
+//   and_expr   := not_expr {AND not_expr}
+//   not_expr   := NOT not_expr | predicate
+//   predicate  := primary [(= | <> | != | < | <= | > | >=) primary
+//                         | [NOT] LIKE primary
+//                         | [NOT] IN ( primary {, primary} )
+//                         | [NOT] BETWEEN primary AND primary
+//                         | IS [NOT] NULL]
+//   primary    := string | [-] number | NULL | function | column_ref | ( expr )
+//   function   := name ( [* | [DISTINCT] expr {, expr}] )
+//   column_ref := name {. name}                      last name is the column, the rest the table
+//
+// catalog statements, CREATE, TABLE, LOCATION, DESCRIBE, SHOW and TABLES are not reserved so that they
+// may still name columns
+//   statement  := query | create | describe | SHOW TABLES [;]
+//   create     := CREATE TABLE table_name ( name type {, name type} ) LOCATION string [;]
+//   describe   := (DESCRIBE | DESC) table_name [;]
+//   table_name := quoted_ident | name {. name}
+//   type       := STRING | VARCHAR | TEXT | INT | INTEGER | BIGINT | FLOAT | DOUBLE | REAL
+
+type parser struct {
+	tokens []Token
+	idx    int
+}
+
+func ParseQuery(query string) (*SelectStmt, error) {
+	tokens, err := Tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	return p.parseSelect()
+}
+
+func ParseStatement(query string) (Statement, error) {
+	tokens, err := Tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	switch {
+	case p.isWord("CREATE"):
+		return p.parseCreateTable()
+	case p.isWord("DESCRIBE") || p.isKeyword("DESC"):
+		return p.parseDescribe()
+	case p.isWord("SHOW"):
+		return p.parseShowTables()
+	}
+	stmt, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (this *parser) peek() Token {
+	return this.tokens[this.idx]
+}
+
+// token after the next one
+func (this *parser) peekNext() Token {
+	if this.idx+1 < len(this.tokens) {
+		return this.tokens[this.idx+1]
+	}
+	return this.tokens[len(this.tokens)-1]
+}
+
+func (this *parser) advance() Token {
+	token := this.tokens[this.idx]
+	if token.Type != TOKEN_EOF {
+		this.idx++
+	}
+	return token
+}
+
+func (this *parser) isKeyword(keyword string) bool {
+	token := this.peek()
+	return token.Type == TOKEN_KEYWORD && token.Value == keyword
+}
+
+// unreserved keyword, an identifier of any case
+func (this *parser) isWord(word string) bool {
+	token := this.peek()
+	return token.Type == TOKEN_IDENT && strings.EqualFold(token.Value, word)
+}
+
+func (this *parser) isSymbol(symbol string) bool {
+	token := this.peek()
+	return token.Type == TOKEN_SYMBOL && token.Value == symbol
+}
+
+func (this *parser) expectKeyword(keyword string) (Token, error) {
+	if !this.isKeyword(keyword) {
+		return Token{}, this.unexpected(keyword)
+	}
+	return this.advance(), nil
+}
+
+func (this *parser) expectSymbol(symbol string) (Token, error) {
+	if !this.isSymbol(symbol) {
+		return Token{}, this.unexpected(fmt.Sprintf("%q", symbol))
+	}
+	return this.advance(), nil
+}
+
+func (this *parser) expectWord(word string) (Token, error) {
+	if !this.isWord(word) {
+		return Token{}, this.unexpected(word)
+	}
+	return this.advance(), nil
+}
+
+// optional semicolon at the end of a statement
+func (this *parser) expectEnd() error {
+	if this.isSymbol(";") {
+		this.advance()
+	}
+	if this.peek().Type != TOKEN_EOF {
+		return this.unexpected("end of query")
+	}
+	return nil
+}
+
+func (this *parser) unexpected(expected string) error {
+	token := this.peek()
+	return &SyntaxError{Pos: token.Pos, Msg: fmt.Sprintf("expected %s, found %s", expected, token.String())}
+}
+
+func (this *parser) parseSelect() (*SelectStmt, error) {
+	selectToken, err := this.expectKeyword("SELECT")
+	if err != nil {
+		return nil, err
+	}
+	stmt := &SelectStmt{Pos: selectToken.Pos}
+
+	if this.isKeyword("ALL") || this.isSymbol("*") {
+		this.advance()
+		stmt.All = true
+	} else {
+		for {
+			item, err := this.parseSelectItem()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Columns = append(stmt.Columns, item)
+			if !this.isSymbol(",") {
+				break
+			}
+			this.advance()
+		}
+	}
+
+	_, err = this.expectKeyword("FROM")
+	if err != nil {
+		return nil, err
+	}
+	table, err := this.parseTableRef()
+	if err != nil {
+		return nil, err
+	}
+	stmt.From = append(stmt.From, table)
+	for {
+		join := ""
+		switch {
+		case this.isSymbol(","):
+			this.advance()
+		case this.isKeyword("JOIN"):
+			join = "INNER"
+		case this.isKeyword("INNER"):
+			join = "INNER"
+			this.advance()
+		case this.isKeyword("LEFT") || this.isKeyword("RIGHT") || this.isKeyword("FULL"):
+			join = this.advance().Value
+			if this.isKeyword("OUTER") {
+				this.advance()
+			}
+		default:
+			return this.parseClauses(stmt)
+		}
+
+		if len(join) > 0 {
+			_, err = this.expectKeyword("JOIN")
+			if err != nil {
+				return nil, err
+			}
+		}
+		table, err := this.parseTableRef()
+		if err != nil {
+			return nil, err
+		}
+		table.Join = join
+		if len(join) > 0 {
+			_, err = this.expectKeyword("ON")
+			if err != nil {
+				return nil, err
+			}
+			table.On, err = this.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+		}
+		stmt.From = append(stmt.From, table)
+	}
+}
+
+// clauses after FROM
+func (this *parser) parseClauses(stmt *SelectStmt) (*SelectStmt, error) {
+	var err error
+
+	if this.isKeyword("WHERE") {
+		this.advance()
+		stmt.Where, err = this.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if this.isKeyword("GROUP") {
+		this.advance()
+		_, err = this.expectKeyword("BY")
+		if err != nil {
+			return nil, err
+		}
+		for {
+			token := this.peek()
+			if token.Type != TOKEN_IDENT && token.Type != TOKEN_QUOTED_IDENT {
+				return nil, this.unexpected("column name")
+			}
+			column, err := this.parseColumnRef()
+			if err != nil {
+				return nil, err
+			}
+			stmt.GroupBy = append(stmt.GroupBy, column)
+			if !this.isSymbol(",") {
+				break
+			}
+			this.advance()
+		}
+	}
+
+	if this.isKeyword("HAVING") {
+		this.advance()
+		stmt.Having, err = this.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if this.isKeyword("ORDER") {
+		this.advance()
+		_, err = this.expectKeyword("BY")
+		if err != nil {
+			return nil, err
+		}
+		for {
+			item, err := this.parseOrderItem()
+			if err != nil {
+				return nil, err
+			}
+			stmt.OrderBy = append(stmt.OrderBy, item)
+			if !this.isSymbol(",") {
+				break
+			}
+			this.advance()
+		}
+	}
+
+	if this.isKeyword("LIMIT") {
+		this.advance()
+		token := this.peek()
+		if token.Type != TOKEN_NUMBER {
+			return nil, this.unexpected("number of rows")
+		}
+		_, err := strconv.Atoi(token.Value)
+		if err != nil {
+			return nil, &SyntaxError{Pos: token.Pos, Msg: fmt.Sprintf("expected a whole number of rows, found %s", token.Value)}
+		}
+		this.advance()
+		stmt.Limit = &NumberLit{Pos: token.Pos, Value: token.Value}
+	}
+
+	if this.isSymbol(";") {
+		this.advance()
+	}
+	if this.peek().Type != TOKEN_EOF {
+		return nil, this.unexpected(expectedClauses(stmt))
+	}
+	return stmt, nil
+}
+
+// clauses that may still follow the last clause of a query
+func expectedClauses(stmt *SelectStmt) string {
+	clauses := []string{"WHERE", "GROUP BY", "HAVING", "ORDER BY", "LIMIT"}
+	present := []bool{stmt.Where != nil, len(stmt.GroupBy) > 0, stmt.Having != nil, len(stmt.OrderBy) > 0, stmt.Limit != nil}
+	next := 0
+	for idx, isPresent := range present {
+		if isPresent {
+			next = idx + 1
+		}
+	}
+	if next == len(clauses) {
+		return "end of query"
+	}
+	return strings.Join(clauses[next:], ", ") + " or end of query"
+}
+
+func (this *parser) parseOrderItem() (*OrderItem, error) {
+	token := this.peek()
+	var expr Expr
+	var err error
+	switch {
+	case token.Type == TOKEN_NUMBER:
+		this.advance()
+		expr = &NumberLit{Pos: token.Pos, Value: token.Value}
+	case token.Type == TOKEN_IDENT && this.peekNext().Type == TOKEN_SYMBOL && this.peekNext().Value == "(":
+		expr, err = this.parseFuncCall()
+	case token.Type == TOKEN_IDENT || token.Type == TOKEN_QUOTED_IDENT:
+		expr, err = this.parseColumnRef()
+	default:
+		return nil, this.unexpected("output column name or number")
+	}
+	if err != nil {
+		return nil, err
+	}
+	item := &OrderItem{Pos: token.Pos, Expr: expr}
+
+	if this.isKeyword("ASC") {
+		this.advance()
+	} else if this.isKeyword("DESC") {
+		this.advance()
+		item.Descending = true
+	}
+	return item, nil
+}
+
+func (this *parser) parseSelectItem() (*SelectItem, error) {
+	token := this.peek()
+	if token.Type != TOKEN_IDENT && token.Type != TOKEN_QUOTED_IDENT {
+		return nil, this.unexpected("ALL, * or column name")
+	}
+	var expr Expr
+	var err error
+	if token.Type == TOKEN_IDENT && this.peekNext().Type == TOKEN_SYMBOL && this.peekNext().Value == "(" {
+		expr, err = this.parseFuncCall()
+	} else {
+		expr, err = this.parseColumnRef()
+	}
+	if err != nil {
+		return nil, err
+	}
+	item := &SelectItem{Pos: token.Pos, Expr: expr}
+
+	if this.isKeyword("AS") {
+		this.advance()
+		alias := this.peek()
+		if alias.Type != TOKEN_IDENT && alias.Type != TOKEN_QUOTED_IDENT {
+			return nil, this.unexpected("alias")
+		}
+		this.advance()
+		item.Alias = alias.Value
+	}
+	return item, nil
+}
+
+func (this *parser) parseTableRef() (*TableRef, error) {
+	pos, name, err := this.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	return this.parseTableAlias(&TableRef{Pos: pos, Name: name})
+}
+
+// SDFS file names such as traffic.csv, parts must not be separated by spaces
+func (this *parser) parseTableName() (Pos, string, error) {
+	token := this.peek()
+	if token.Type == TOKEN_QUOTED_IDENT {
+		this.advance()
+		return token.Pos, token.Value, nil
+	}
+	if !isNamePart(token) {
+		return Pos{}, "", this.unexpected("table name")
+	}
+
+	this.advance()
+	name := token.Value
+	end := token.Pos.Offset + len(token.Value)
+	for this.isSymbol(".") && this.peek().Pos.Offset == end {
+		next := this.peekNext()
+		if !isNamePart(next) || next.Pos.Offset != end+1 {
+			break
+		}
+		this.advance()
+		this.advance()
+		name += "." + next.Value
+		end = next.Pos.Offset + len(next.Value)
+	}
+	return token.Pos, name, nil
+}
+
+func (this *parser) parseCreateTable() (Statement, error) {
+	createToken := this.advance()
+	_, err := this.expectWord("TABLE")
+	if err != nil {
+		return nil, err
+	}
+	_, name, err := this.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &CreateTableStmt{Pos: createToken.Pos, Name: name}
+
+	_, err = this.expectSymbol("(")
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token := this.peek()
+		if token.Type != TOKEN_IDENT && token.Type != TOKEN_QUOTED_IDENT {
+			return nil, this.unexpected("column name")
+		}
+		this.advance()
+		typeToken := this.peek()
+		if typeToken.Type != TOKEN_IDENT {
+			return nil, this.unexpected("column type")
+		}
+		columnType, ok := columnTypeNames[strings.ToUpper(typeToken.Value)]
+		if !ok {
+			return nil, &SyntaxError{Pos: typeToken.Pos, Msg: fmt.Sprintf("unknown column type %s, expected STRING, INT or FLOAT", typeToken.Value)}
+		}
+		this.advance()
+		stmt.Columns = append(stmt.Columns, &ColumnDef{Pos: token.Pos, Name: token.Value, Type: columnType})
+		if !this.isSymbol(",") {
+			break
+		}
+		this.advance()
+	}
+	_, err = this.expectSymbol(")")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = this.expectWord("LOCATION")
+	if err != nil {
+		return nil, err
+	}
+	location := this.peek()
+	if location.Type != TOKEN_STRING || len(location.Value) == 0 {
+		return nil, this.unexpected("quoted SDFS file name")
+	}
+	this.advance()
+	stmt.Location = location.Value
+	err = this.expectEnd()
+	if err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (this *parser) parseDescribe() (Statement, error) {
+	describeToken := this.advance()
+	_, name, err := this.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	err = this.expectEnd()
+	if err != nil {
+		return nil, err
+	}
+	return &DescribeStmt{Pos: describeToken.Pos, Name: name}, nil
+}
+
+func (this *parser) parseShowTables() (Statement, error) {
+	showToken := this.advance()
+	_, err := this.expectWord("TABLES")
+	if err != nil {
+		return nil, err
+	}
+	err = this.expectEnd()
+	if err != nil {
+		return nil, err
+	}
+	return &ShowTablesStmt{Pos: showToken.Pos}, nil
+}
+
+// [AS] <alias> after a table name
+func (this *parser) parseTableAlias(table *TableRef) (*TableRef, error) {
+	if this.isKeyword("AS") {
+		this.advance()
+	} else if token := this.peek(); token.Type != TOKEN_IDENT && token.Type != TOKEN_QUOTED_IDENT {
+		return table, nil
+	}
+	alias := this.peek()
+	if alias.Type != TOKEN_IDENT && alias.Type != TOKEN_QUOTED_IDENT {
+		return nil, this.unexpected("alias")
+	}
+	this.advance()
+	table.Alias = alias.Value
+	return table, nil
+}
+
+func (this *parser) parseExpr() (Expr, error) {
+	left, err := this.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for this.isKeyword("OR") {
+		token := this.advance()
+		right, err := this.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Pos: token.Pos, Op: "OR", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (this *parser) parseAnd() (Expr, error) {
+	left, err := this.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for this.isKeyword("AND") {
+		token := this.advance()
+		right, err := this.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Pos: token.Pos, Op: "AND", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (this *parser) parseNot() (Expr, error) {
+	if this.isKeyword("NOT") {
+		token := this.advance()
+		expr, err := this.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &NotExpr{Pos: token.Pos, Expr: expr}, nil
+	}
+	return this.parsePredicate()
+}
+
+func (this *parser) parsePredicate() (Expr, error) {
+	left, err := this.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	token := this.peek()
+	if token.Type == TOKEN_SYMBOL && isComparisonOp(token.Value) {
+		this.advance()
+		right, err := this.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		op := token.Value
+		if op == "!=" {
+			op = "<>"
+		}
+		return &BinaryExpr{Pos: token.Pos, Op: op, Left: left, Right: right}, nil
+	}
+
+	if this.isKeyword("IS") {
+		this.advance()
+		not := this.isKeyword("NOT")
+		if not {
+			this.advance()
+		}
+		_, err := this.expectKeyword("NULL")
+		if err != nil {
+			return nil, err
+		}
+		return &IsNullExpr{Pos: token.Pos, Not: not, Expr: left}, nil
+	}
+
+	not := this.isKeyword("NOT")
+	if not {
+		this.advance()
+	}
+	switch {
+	case this.isKeyword("LIKE"):
+		this.advance()
+		pattern, err := this.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &LikeExpr{Pos: token.Pos, Not: not, Expr: left, Pattern: pattern}, nil
+	case this.isKeyword("IN"):
+		this.advance()
+		_, err := this.expectSymbol("(")
+		if err != nil {
+			return nil, err
+		}
+		list := make([]Expr, 0)
+		for {
+			value, err := this.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+			if !this.isSymbol(",") {
+				break
+			}
+			this.advance()
+		}
+		_, err = this.expectSymbol(")")
+		if err != nil {
+			return nil, err
+		}
+		return &InExpr{Pos: token.Pos, Not: not, Expr: left, List: list}, nil
+	case this.isKeyword("BETWEEN"):
+		this.advance()
+		low, err := this.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		_, err = this.expectKeyword("AND")
+		if err != nil {
+			return nil, err
+		}
+		high, err := this.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &BetweenExpr{Pos: token.Pos, Not: not, Expr: left, Low: low, High: high}, nil
+	}
+	if not {
+		return nil, this.unexpected("LIKE, IN or BETWEEN")
+	}
+	return left, nil
+}
+
+func (this *parser) parsePrimary() (Expr, error) {
+	token := this.peek()
+	switch token.Type {
+	case TOKEN_STRING:
+		this.advance()
+		return &StringLit{Pos: token.Pos, Value: token.Value}, nil
+	case TOKEN_NUMBER:
+		this.advance()
+		return &NumberLit{Pos: token.Pos, Value: token.Value}, nil
+	case TOKEN_KEYWORD:
+		if token.Value == "NULL" {
+			this.advance()
+			return &NullLit{Pos: token.Pos}, nil
+		}
+	case TOKEN_IDENT, TOKEN_QUOTED_IDENT:
+		if token.Type == TOKEN_IDENT && this.peekNext().Type == TOKEN_SYMBOL && this.peekNext().Value == "(" {
+			return this.parseFuncCall()
+		}
+		column, err := this.parseColumnRef()
+		if err != nil {
+			return nil, err
+		}
+		return column, nil
+	case TOKEN_SYMBOL:
+		switch token.Value {
+		case "-":
+			this.advance()
+			number := this.peek()
+			if number.Type != TOKEN_NUMBER {
+				return nil, this.unexpected("number")
+			}
+			this.advance()
+			return &NumberLit{Pos: token.Pos, Value: "-" + number.Value}, nil
+		case "(":
+			this.advance()
+			expr, err := this.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			_, err = this.expectSymbol(")")
+			if err != nil {
+				return nil, err
+			}
+			return expr, nil
+		}
+	}
+	return nil, this.unexpected("column, string or number")
+}
+
+func (this *parser) parseFuncCall() (Expr, error) {
+	name := this.advance()
+	this.advance()
+	call := &FuncCall{Pos: name.Pos, Name: strings.ToUpper(name.Value), Args: make([]Expr, 0)}
+	if this.isSymbol(")") {
+		this.advance()
+		return call, nil
+	}
+	if this.isSymbol("*") {
+		this.advance()
+		call.Star = true
+		_, err := this.expectSymbol(")")
+		if err != nil {
+			return nil, err
+		}
+		return call, nil
+	}
+	if this.isKeyword("DISTINCT") {
+		this.advance()
+		call.Distinct = true
+	}
+	for {
+		arg, err := this.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+		if !this.isSymbol(",") {
+			break
+		}
+		this.advance()
+	}
+	_, err := this.expectSymbol(")")
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// qualified names such as traffic.csv."Interconne", the last part is the column
+func (this *parser) parseColumnRef() (*ColumnRef, error) {
+	first := this.advance()
+	parts := []Token{first}
+	for this.isSymbol(".") {
+		next := this.peekNext()
+		if !isNamePart(next) && next.Type != TOKEN_QUOTED_IDENT {
+			this.advance()
+			return nil, this.unexpected("column name")
+		}
+		this.advance()
+		parts = append(parts, this.advance())
+	}
+
+	table := ""
+	for idx, part := range parts[:len(parts)-1] {
+		if idx > 0 {
+			table += "."
+		}
+		table += part.Value
+	}
+	column := parts[len(parts)-1]
+	return &ColumnRef{Pos: first.Pos, Table: table, Column: column.Value, Quoted: column.Type == TOKEN_QUOTED_IDENT}, nil
+}
+
+// unquoted part of a dotted name, numbers allow names such as logs.2023
+func isNamePart(token Token) bool {
+	return token.Type == TOKEN_IDENT || token.Type == TOKEN_NUMBER
+}
+
+func isComparisonOp(op string) bool {
+	switch op {
+	case "=", "<>", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
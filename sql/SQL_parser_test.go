@@ -0,0 +1,174 @@
+package sql
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string // SelectStmt.String() of the parsed query
+	}{
+		{"SELECT ALL FROM traffic.csv", "SELECT ALL FROM traffic.csv"},
+		{"select * from d1 where a = 1;", "SELECT ALL FROM d1 WHERE (a = 1)"},
+		{
+			"SELECT name AS who, d2.country FROM d1, d2 WHERE d1.id = d2.uid",
+			`SELECT name AS "who", d2.country FROM d1, d2 WHERE (d1.id = d2.uid)`,
+		},
+		{`SELECT "col,1" FROM "my file.csv" AS f`, `SELECT "col,1" FROM my file.csv AS "f"`},
+		{
+			"SELECT a FROM t WHERE b IS NOT NULL AND c NOT LIKE '%x' AND d = -1.5e3",
+			"SELECT a FROM t WHERE (((b IS NOT NULL) AND (c NOT LIKE '%x')) AND (d = -1.5e3))",
+		},
+		{
+			"SELECT a FROM t WHERE NOT a BETWEEN 1 AND 2 OR b IN ('x', 'y') AND c <> 3",
+			"SELECT a FROM t WHERE ((NOT (a BETWEEN 1 AND 2)) OR ((b IN ('x', 'y')) AND (c <> 3)))",
+		},
+		{
+			"SELECT city, COUNT(*), AVG(age) AS avg_age FROM people GROUP BY city HAVING COUNT(*) > 2 ORDER BY 2 DESC, city LIMIT 10",
+			`SELECT city, COUNT(*), AVG(age) AS "avg_age" FROM people GROUP BY city HAVING (COUNT(*) > 2) ORDER BY 2 DESC, city LIMIT 10`,
+		},
+		{"SELECT COUNT(DISTINCT a) FROM t", "SELECT COUNT(DISTINCT a) FROM t"},
+		{
+			"SELECT t.a, u.b FROM t LEFT OUTER JOIN u ON t.id = u.id INNER JOIN v ON u.k = v.k",
+			"SELECT t.a, u.b FROM t LEFT JOIN u ON (t.id = u.id) INNER JOIN v ON (u.k = v.k)",
+		},
+		{"SELECT create, show FROM tables", "SELECT create, show FROM tables"},
+	}
+	for _, test := range tests {
+		stmt, err := ParseQuery(test.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) failed: %s", test.query, err)
+			continue
+		}
+		if got := stmt.String(); got != test.want {
+			t.Errorf("ParseQuery(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestParseQuoteEscapes(t *testing.T) {
+	tests := []struct {
+		query  string
+		column string // column of the WHERE comparison
+		value  string // string compared with
+	}{
+		{"SELECT a FROM t WHERE b = 'it''s'", "b", "it's"},
+		{"SELECT a FROM t WHERE b = ''''", "b", "'"},
+		{"SELECT a FROM t WHERE b = ''", "b", ""},
+		{`SELECT a FROM t WHERE "we""ird" = 'x'`, `we"ird`, "x"},
+		{`SELECT a FROM t WHERE "it's" = 'say "hi"'`, "it's", `say "hi"`},
+		{`SELECT a FROM t WHERE b = 'back\slash'`, "b", `back\slash`},
+	}
+	for _, test := range tests {
+		stmt, err := ParseQuery(test.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) failed: %s", test.query, err)
+			continue
+		}
+		comparison, ok := stmt.Where.(*BinaryExpr)
+		if !ok {
+			t.Errorf("ParseQuery(%q): WHERE is %T, want a comparison", test.query, stmt.Where)
+			continue
+		}
+		column, ok := comparison.Left.(*ColumnRef)
+		if !ok || column.Column != test.column {
+			t.Errorf("ParseQuery(%q): left side is %s, want column %q", test.query, comparison.Left.String(), test.column)
+		}
+		value, ok := comparison.Right.(*StringLit)
+		if !ok || value.Value != test.value {
+			t.Errorf("ParseQuery(%q): right side is %s, want string %q", test.query, comparison.Right.String(), test.value)
+		}
+	}
+}
+
+func TestParseStatement(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Statement
+	}{
+		{
+			"CREATE TABLE people (name STRING, age int, height DOUBLE) LOCATION 'people.csv';",
+			&CreateTableStmt{
+				Pos:  Pos{Offset: 0, Line: 1, Column: 1},
+				Name: "people",
+				Columns: []*ColumnDef{
+					{Pos: Pos{Offset: 21, Line: 1, Column: 22}, Name: "name", Type: COLUMN_TYPE_STRING},
+					{Pos: Pos{Offset: 34, Line: 1, Column: 35}, Name: "age", Type: COLUMN_TYPE_INT},
+					{Pos: Pos{Offset: 43, Line: 1, Column: 44}, Name: "height", Type: COLUMN_TYPE_FLOAT},
+				},
+				Location: "people.csv",
+			},
+		},
+		{
+			`create table "my t" ("a b" TEXT) location 'it''s.csv'`,
+			&CreateTableStmt{
+				Pos:      Pos{Offset: 0, Line: 1, Column: 1},
+				Name:     "my t",
+				Columns:  []*ColumnDef{{Pos: Pos{Offset: 21, Line: 1, Column: 22}, Name: "a b", Type: COLUMN_TYPE_STRING}},
+				Location: "it's.csv",
+			},
+		},
+		{"DESCRIBE people", &DescribeStmt{Pos: Pos{Offset: 0, Line: 1, Column: 1}, Name: "people"}},
+		{"desc people;", &DescribeStmt{Pos: Pos{Offset: 0, Line: 1, Column: 1}, Name: "people"}},
+		{"SHOW TABLES", &ShowTablesStmt{Pos: Pos{Offset: 0, Line: 1, Column: 1}}},
+		{"show tables;", &ShowTablesStmt{Pos: Pos{Offset: 0, Line: 1, Column: 1}}},
+	}
+	for _, test := range tests {
+		stmt, err := ParseStatement(test.query)
+		if err != nil {
+			t.Errorf("ParseStatement(%q) failed: %s", test.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(stmt, test.want) {
+			t.Errorf("ParseStatement(%q) = %#v, want %#v", test.query, stmt, test.want)
+		}
+	}
+
+	stmt, err := ParseStatement("SELECT a FROM t")
+	if _, ok := stmt.(*SelectStmt); err != nil || !ok {
+		t.Errorf("ParseStatement of a query = %T, %v, want *SelectStmt", stmt, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query  string
+		line   int
+		column int
+		msg    string // part of the error message
+	}{
+		{"SELECT", 1, 7, "expected ALL, * or column name, found end of query"},
+		{"SELECT a FROM", 1, 14, "expected table name"},
+		{"SELECT a b FROM t", 1, 10, "expected FROM, found b"},
+		{"SELECT a FROM t WHERE b = 'abc", 1, 27, "unterminated string"},
+		{`SELECT "abc FROM t`, 1, 8, "unterminated quoted identifier"},
+		{"SELECT a FROM t WHERE b # 1", 1, 25, "unexpected character '#'"},
+		{"SELECT a FROM t\nWHERE b = = 1", 2, 11, `found "="`},
+		{"SELECT a FROM t WHERE b = 1 c", 1, 29, "found c"},
+		{"SELECT a FROM t GROUP a", 1, 23, "expected BY"},
+		{"SELECT a FROM t ORDER BY", 1, 25, "expected output column name or number"},
+		{"SELECT a FROM t LIMIT x", 1, 23, "expected number of rows"},
+		{"CREATE TABLE p (a BLOB) LOCATION 'x'", 1, 19, "unknown column type BLOB"},
+		{"CREATE TABLE p (a INT LOCATION 'x'", 1, 23, `expected ")", found LOCATION`},
+		{"DESCRIBE", 1, 9, "expected table name"},
+		{"SHOW TABLE", 1, 6, "expected TABLES, found TABLE"},
+	}
+	for _, test := range tests {
+		_, err := ParseStatement(test.query)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("ParseStatement(%q) = %v, want a syntax error", test.query, err)
+			continue
+		}
+		if syntaxErr.Pos.Line != test.line || syntaxErr.Pos.Column != test.column {
+			t.Errorf("ParseStatement(%q) error at %s, want line %d, column %d", test.query, syntaxErr.Pos.String(), test.line, test.column)
+		}
+		if !strings.Contains(syntaxErr.Msg, test.msg) {
+			t.Errorf("ParseStatement(%q) error %q, want it to contain %q", test.query, syntaxErr.Msg, test.msg)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package sql
+
+import (
+	"maple-juice/util"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// compile the WHERE clause of a filter query into the predicate evaluated by the filter maple, nil keeps every line.
+// A lone string is matched as a regex against whole lines. For queries written for the old syntax,
+// WHERE "<regex>" and WHERE "<column>"="<regex>" are still read as regexes when <regex> is not a column.
+func compileFilter(stmt *SelectStmt, header []string) (*util.FilterPredicate, error) {
+	switch where := stmt.Where.(type) {
+	case nil:
+		return nil, nil
+	case *StringLit:
+		return lineRegexPredicate(where.Pos, where.Value)
+	case *ColumnRef:
+		if where.Quoted && len(where.Table) == 0 && findColumn(header, where.Column) < 0 {
+			return lineRegexPredicate(where.Pos, where.Column)
+		}
+	}
+	compiler := &filterCompiler{table: stmt.From[0], header: header}
+	return compiler.compile(stmt.Where)
+}
+
+// compiles WHERE clauses, or HAVING clauses when group is set: columns and aggregates then refer to group values
+type filterCompiler struct {
+	table  *TableRef
+	header []string
+	group  *groupPlan
+}
+
+func (this *filterCompiler) compile(expr Expr) (*util.FilterPredicate, error) {
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		if expr.Op == "AND" || expr.Op == "OR" {
+			left, err := this.compile(expr.Left)
+			if err != nil {
+				return nil, err
+			}
+			right, err := this.compile(expr.Right)
+			if err != nil {
+				return nil, err
+			}
+			return &util.FilterPredicate{Op: strings.ToLower(expr.Op), Args: []*util.FilterPredicate{left, right}}, nil
+		}
+		if regex, ok := this.legacyRegex(expr); ok {
+			return regexPredicate(this.columnOperand(expr.Left.(*ColumnRef)), regex.Pos, regex.Column, false)
+		}
+		operands, err := this.operands(expr.Left, expr.Right)
+		if err != nil {
+			return nil, err
+		}
+		return &util.FilterPredicate{Op: util.FILTER_OP_COMPARE, Cmp: expr.Op, Operands: operands}, nil
+
+	case *NotExpr:
+		arg, err := this.compile(expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return negate(arg, true), nil
+
+	case *LikeExpr:
+		pattern, ok := expr.Pattern.(*StringLit)
+		if !ok {
+			return nil, &QueryError{Pos: expr.Pattern.Position(), Msg: "expected a quoted LIKE pattern"}
+		}
+		operands, err := this.operands(expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return regexPredicate(operands[0], pattern.Pos, likeToRegex(pattern.Value), expr.Not)
+
+	case *InExpr:
+		operands, err := this.operands(append([]Expr{expr.Expr}, expr.List...)...)
+		if err != nil {
+			return nil, err
+		}
+		return negate(&util.FilterPredicate{Op: util.FILTER_OP_IN, Operands: operands}, expr.Not), nil
+
+	case *BetweenExpr:
+		operands, err := this.operands(expr.Expr, expr.Low, expr.High)
+		if err != nil {
+			return nil, err
+		}
+		return negate(&util.FilterPredicate{Op: util.FILTER_OP_BETWEEN, Operands: operands}, expr.Not), nil
+
+	case *IsNullExpr:
+		operands, err := this.operands(expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return negate(&util.FilterPredicate{Op: util.FILTER_OP_IS_NULL, Operands: operands}, expr.Not), nil
+
+	case *FuncCall:
+		if util.IsAggregateFunc(expr.Name) {
+			break
+		}
+		if expr.Name != "REGEXP" {
+			return nil, &QueryError{Pos: expr.Pos, Msg: fmt.Sprintf("unknown function %s, expected REGEXP(<column>, '<regex>')", expr.Name)}
+		}
+		if len(expr.Args) != 2 {
+			return nil, &QueryError{Pos: expr.Pos, Msg: "REGEXP takes a column and a quoted regex"}
+		}
+		regex, ok := expr.Args[1].(*StringLit)
+		if !ok {
+			return nil, &QueryError{Pos: expr.Args[1].Position(), Msg: "expected a quoted regex"}
+		}
+		operands, err := this.operands(expr.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return regexPredicate(operands[0], regex.Pos, regex.Value, false)
+	}
+	return nil, &QueryError{Pos: expr.Position(), Msg: "expected a condition such as <column> = <value>"}
+}
+
+// <column>="<regex>" of the old syntax, the right side is a double quoted name that is not a column
+func (this *filterCompiler) legacyRegex(expr *BinaryExpr) (*ColumnRef, bool) {
+	if expr.Op != "=" || this.group != nil {
+		return nil, false
+	}
+	left, ok := expr.Left.(*ColumnRef)
+	if !ok || this.checkColumn(left) != nil {
+		return nil, false
+	}
+	right, ok := expr.Right.(*ColumnRef)
+	if !ok || !right.Quoted || len(right.Table) > 0 || findColumn(this.header, right.Column) >= 0 {
+		return nil, false
+	}
+	return right, true
+}
+
+func (this *filterCompiler) operands(exprs ...Expr) ([]util.FilterOperand, error) {
+	operands := make([]util.FilterOperand, 0, len(exprs))
+	for _, expr := range exprs {
+		if this.group != nil {
+			switch expr.(type) {
+			case *ColumnRef, *FuncCall:
+				slot, err := this.group.valueOf(expr)
+				if err != nil {
+					return nil, err
+				}
+				operands = append(operands, groupValueOperand(slot))
+				continue
+			}
+		}
+
+		switch expr := expr.(type) {
+		case *FuncCall:
+			if util.IsAggregateFunc(expr.Name) {
+				return nil, &QueryError{Pos: expr.Pos, Msg: "aggregates are only allowed in SELECT and HAVING"}
+			}
+			return nil, &QueryError{Pos: expr.Pos, Msg: "expected a column, string or number"}
+		case *ColumnRef:
+			err := this.checkColumn(expr)
+			if err != nil {
+				return nil, err
+			}
+			operands = append(operands, this.columnOperand(expr))
+		case *StringLit:
+			operands = append(operands, util.FilterOperand{Kind: util.FILTER_OPERAND_STRING, Value: expr.Value})
+		case *NumberLit:
+			_, err := strconv.ParseFloat(expr.Value, 64)
+			if err != nil {
+				return nil, &QueryError{Pos: expr.Pos, Msg: fmt.Sprintf("invalid number %s", expr.Value)}
+			}
+			operands = append(operands, util.FilterOperand{Kind: util.FILTER_OPERAND_NUMBER, Value: expr.Value})
+		case *NullLit:
+			operands = append(operands, util.FilterOperand{Kind: util.FILTER_OPERAND_NULL})
+		default:
+			return nil, &QueryError{Pos: expr.Position(), Msg: "expected a column, string or number"}
+		}
+	}
+	return operands, nil
+}
+
+func (this *filterCompiler) checkColumn(column *ColumnRef) error {
+	if len(column.Table) > 0 && column.Table != this.table.RefName() {
+		return &QueryError{Pos: column.Pos, Msg: fmt.Sprintf("unknown table %s", column.Table)}
+	}
+	return checkColumn(column, this.table, this.header)
+}
+
+func (this *filterCompiler) columnOperand(column *ColumnRef) util.FilterOperand {
+	return util.FilterOperand{Kind: util.FILTER_OPERAND_COLUMN, Value: column.Column}
+}
+
+func lineRegexPredicate(pos Pos, regex string) (*util.FilterPredicate, error) {
+	return regexPredicate(util.FilterOperand{Kind: util.FILTER_OPERAND_LINE}, pos, regex, false)
+}
+
+func regexPredicate(operand util.FilterOperand, pos Pos, regex string, not bool) (*util.FilterPredicate, error) {
+	if len(regex) == 0 {
+		return nil, &QueryError{Pos: pos, Msg: "empty regex"}
+	}
+	_, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, &QueryError{Pos: pos, Msg: fmt.Sprintf("invalid regex: %s", err.Error())}
+	}
+	predicate := &util.FilterPredicate{Op: util.FILTER_OP_REGEXP, Regex: regex, Operands: []util.FilterOperand{operand}}
+	return negate(predicate, not), nil
+}
+
+func negate(predicate *util.FilterPredicate, not bool) *util.FilterPredicate {
+	if !not {
+		return predicate
+	}
+	return &util.FilterPredicate{Op: util.FILTER_OP_NOT, Args: []*util.FilterPredicate{predicate}}
+}
+
+// LIKE pattern as an anchored regex: % matches any characters, _ a single character
+func likeToRegex(pattern string) string {
+	var builder strings.Builder
+	builder.WriteString("^(?s)")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			builder.WriteString(".*")
+		case '_':
+			builder.WriteString(".")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	builder.WriteString("$")
+	return builder.String()
+}
@@ -0,0 +1,167 @@
+package sql
+
+import (
+	"maple-juice/config"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// output columns of a query: SELECT ALL keeps every column of the input files, otherwise the listed columns
+// are emitted in order under their alias, if any. Column names are resolved against the schema of catalog
+// tables or the header line of the local copies of the input files, and the query result starts with a
+// header row of the output names.
+// Input files and query results are RFC 4180 CSV, fields holding a comma, a quote or a line break are quoted.
+
+// column names in the header line of a query input file
+func readHeader(table *TableRef) ([]string, error) {
+	file, err := os.Open(config.LocalFileDir + table.Name)
+	if err != nil {
+		return nil, &QueryError{Pos: table.Pos, Msg: fmt.Sprintf("cannot read %s from the local folder", table.Name)}
+	}
+	defer file.Close()
+
+	header, err := csv.NewReader(file).Read()
+	if err == io.EOF {
+		return nil, &QueryError{Pos: table.Pos, Msg: fmt.Sprintf("%s has no header line", table.Name)}
+	}
+	if err != nil {
+		return nil, &QueryError{Pos: table.Pos, Msg: fmt.Sprintf("invalid header line of %s: %s", table.Name, err)}
+	}
+	for idx, field := range header {
+		header[idx] = strings.TrimSpace(field)
+	}
+	return header, nil
+}
+
+// check that a column of the condition exists in the header of the input file
+func checkColumn(column *ColumnRef, table *TableRef, header []string) error {
+	if findColumn(header, column.Column) < 0 {
+		return &QueryError{Pos: column.Pos, Msg: fmt.Sprintf("unknown column %s in %s", column.Column, table.Name)}
+	}
+	return nil
+}
+
+// input columns of a filter query and the output header, the projection is nil for SELECT ALL
+func filterProjection(stmt *SelectStmt, header []string) ([]string, []string, error) {
+	if stmt.All {
+		return nil, header, nil
+	}
+
+	table := stmt.From[0]
+	projection := make([]string, 0)
+	outputHeader := make([]string, 0)
+	for _, item := range stmt.Columns {
+		column, err := itemColumn(item)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(column.Table) > 0 && column.Table != table.RefName() {
+			return nil, nil, &QueryError{Pos: item.Pos, Msg: fmt.Sprintf("unknown table %s", column.Table)}
+		}
+		err = checkColumn(column, table, header)
+		if err != nil {
+			return nil, nil, err
+		}
+		projection = append(projection, column.Column)
+		outputHeader = append(outputHeader, item.OutputName())
+	}
+	return projection, outputHeader, nil
+}
+
+// output header of a join query, SELECT ALL concatenates the columns of all tables
+func joinOutputHeader(stmt *SelectStmt, headers [][]string) []string {
+	outputHeader := make([]string, 0)
+	if stmt.All {
+		for _, header := range headers {
+			outputHeader = append(outputHeader, header...)
+		}
+		return outputHeader
+	}
+	for _, item := range stmt.Columns {
+		outputHeader = append(outputHeader, item.OutputName())
+	}
+	return outputHeader
+}
+
+// column of a select item outside of aggregate queries
+func itemColumn(item *SelectItem) (*ColumnRef, error) {
+	column, ok := item.Expr.(*ColumnRef)
+	if !ok {
+		return nil, &QueryError{Pos: item.Pos, Msg: "expected a column"}
+	}
+	return column, nil
+}
+
+// position in the FROM clause of the table a column belongs to, unqualified columns must be
+// found in exactly one of the tables
+func resolveJoinSide(stmt *SelectStmt, column *ColumnRef, headers [][]string) (int, error) {
+	if len(column.Table) > 0 {
+		for side, table := range stmt.From {
+			if table.RefName() == column.Table {
+				return side, checkColumn(column, table, headers[side])
+			}
+		}
+		return 0, &QueryError{Pos: column.Pos, Msg: fmt.Sprintf("unknown table %s", column.Table)}
+	}
+
+	side := -1
+	for idx, header := range headers {
+		if findColumn(header, column.Column) < 0 {
+			continue
+		}
+		if side >= 0 {
+			return 0, &QueryError{Pos: column.Pos, Msg: fmt.Sprintf("column %s is ambiguous, qualify it with its table", column.Column)}
+		}
+		side = idx
+	}
+	if side < 0 {
+		return 0, &QueryError{Pos: column.Pos, Msg: fmt.Sprintf("unknown column %s", column.Column)}
+	}
+	return side, nil
+}
+
+func findColumn(columns []string, name string) int {
+	for idx, column := range columns {
+		if column == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+// write the header row at the top of the local query result
+func prependHeader(filePath string, header []string) error {
+	tmpFilePath := filePath + ".tmp"
+	tmpFile, err := os.Create(tmpFilePath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFilePath)
+
+	writer := csv.NewWriter(tmpFile)
+	writer.Write(header)
+	writer.Flush()
+	err = writer.Error()
+	if err == nil {
+		var result *os.File
+		result, err = os.Open(filePath)
+		if err == nil {
+			_, err = io.Copy(tmpFile, result)
+			result.Close()
+		} else if os.IsNotExist(err) {
+			// no matching lines
+			err = nil
+		}
+	}
+	closeErr := tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(tmpFilePath, filePath)
+}
@@ -0,0 +1,88 @@
+package sql
+
+import (
+	"maple-juice/config"
+	"bytes"
+	_ "embed"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"os"
+)
+
+// the maple templates reading CSV tables (filter maple, join maple and broadcast join) share the table
+// reader, the malformed row policy, the row writer and the sort key comparison in one source file. It is
+// compiled with the filter maple template and linked into the other template folders, and appended to
+// every executable generated from these templates, as executables are uploaded as a single source file
+
+//go:embed filter_maple/template_rows.go
+var templateRowsSource []byte
+
+// append the shared template source to a generated executable in the local folder
+func addTemplateRows(executableName string) error {
+	source, err := os.ReadFile(config.LocalFileDir + executableName)
+	if err != nil {
+		return err
+	}
+	merged, err := appendSource(source, templateRowsSource)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(config.LocalFileDir+executableName, merged, 0644)
+}
+
+// declarations of extra follow those of source, imports of extra missing from source are added
+// as another import declaration. Both are files of the same package
+func appendSource(source []byte, extra []byte) ([]byte, error) {
+	fileSet := token.NewFileSet()
+	sourceFile, err := goparser.ParseFile(fileSet, "", source, goparser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	extraFile, err := goparser.ParseFile(fileSet, "", extra, goparser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	imported := make(map[string]bool)
+	for _, spec := range sourceFile.Imports {
+		imported[importSpecText(spec)] = true
+	}
+	var missing bytes.Buffer
+	for _, spec := range extraFile.Imports {
+		if !imported[importSpecText(spec)] {
+			missing.WriteString("\t" + importSpecText(spec) + "\n")
+		}
+	}
+
+	sourceEnd := importsEnd(fileSet, sourceFile)
+	var merged bytes.Buffer
+	merged.Write(source[:sourceEnd])
+	if missing.Len() > 0 {
+		merged.WriteString("\n\nimport (\n")
+		merged.Write(missing.Bytes())
+		merged.WriteString(")")
+	}
+	merged.Write(source[sourceEnd:])
+	merged.WriteString("\n")
+	merged.Write(extra[importsEnd(fileSet, extraFile):])
+	return merged.Bytes(), nil
+}
+
+func importSpecText(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name + " " + spec.Path.Value
+	}
+	return spec.Path.Value
+}
+
+// offset right after the import declarations, or the package clause if there are none
+func importsEnd(fileSet *token.FileSet, file *ast.File) int {
+	end := file.Name.End()
+	for _, decl := range file.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.IMPORT {
+			end = genDecl.End()
+		}
+	}
+	return fileSet.Position(end).Offset
+}
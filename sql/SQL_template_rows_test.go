@@ -0,0 +1,63 @@
+package sql
+
+import (
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestAppendSource(t *testing.T) {
+	tests := []struct {
+		source string
+		extra  string
+		want   []string // imports of the merged file
+	}{
+		{
+			"package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nfunc main() { fmt.Println(helper(), os.Args) }\n",
+			"package main\n\nimport (\n\t\"os\"\n\t\"strings\"\n)\n\nfunc helper() string { return strings.Join(os.Args, \" \") }\n",
+			[]string{"fmt", "os", "strings"},
+		},
+		{
+			"package main\n\nfunc main() { println(helper()) }\n",
+			"package main\n\nimport \"strings\"\n\nfunc helper() string { return strings.ToUpper(\"a\") }\n",
+			[]string{"strings"},
+		},
+		{
+			"package main\n\nimport \"strings\"\n\nfunc main() { println(strings.ToLower(helper())) }\n",
+			"package main\n\nfunc helper() string { return \"A\" }\n",
+			[]string{"strings"},
+		},
+	}
+	for _, test := range tests {
+		merged, err := appendSource([]byte(test.source), []byte(test.extra))
+		if err != nil {
+			t.Errorf("appendSource(%q) failed: %s", test.source, err)
+			continue
+		}
+		file, err := goparser.ParseFile(token.NewFileSet(), "", merged, 0)
+		if err != nil {
+			t.Errorf("merged source does not parse: %s\n%s", err, merged)
+			continue
+		}
+		imports := make([]string, 0)
+		for _, spec := range file.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			imports = append(imports, path)
+		}
+		if !reflect.DeepEqual(imports, test.want) {
+			t.Errorf("merged imports are %v, want %v", imports, test.want)
+		}
+		funcs := 0
+		for _, decl := range file.Decls {
+			if _, ok := decl.(*ast.FuncDecl); ok {
+				funcs++
+			}
+		}
+		if funcs != 2 {
+			t.Errorf("merged source has %d functions, want 2:\n%s", funcs, merged)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package sql
+
+import (
+	"maple-juice/util"
+	"fmt"
+	"strings"
+)
+
+// type checks of queries before any job is submitted. Columns of catalog tables have the type of their
+// schema, columns of local files have none and are not checked. Numbers are compared with numbers and
+// strings with strings: a column compared with a string is compared as text, so that '9' > '10', and a
+// column compared with a number only matches fields holding a number. SUM and AVG take numeric columns.
+
+type typeChecker struct {
+	stmt    *SelectStmt
+	tables  []*queryTable
+	headers [][]string
+	aliases map[string]Expr // select items by alias while checking HAVING
+}
+
+func checkTypes(stmt *SelectStmt, tables []*queryTable) error {
+	checker := &typeChecker{stmt: stmt, tables: tables}
+	for _, table := range tables {
+		checker.headers = append(checker.headers, table.header)
+	}
+
+	for _, table := range stmt.From {
+		if table.On != nil {
+			err := checker.checkCondition(table.On)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if stmt.Where != nil {
+		err := checker.checkCondition(stmt.Where)
+		if err != nil {
+			return err
+		}
+	}
+	for _, item := range stmt.Columns {
+		err := checker.checkAggregate(item.Expr)
+		if err != nil {
+			return err
+		}
+	}
+	if stmt.Having != nil {
+		checker.aliases = make(map[string]Expr)
+		for _, item := range stmt.Columns {
+			if len(item.Alias) > 0 {
+				checker.aliases[item.Alias] = item.Expr
+			}
+		}
+		return checker.checkCondition(stmt.Having)
+	}
+	return nil
+}
+
+func (this *typeChecker) checkCondition(expr Expr) error {
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		if expr.Op == "AND" || expr.Op == "OR" {
+			err := this.checkCondition(expr.Left)
+			if err != nil {
+				return err
+			}
+			return this.checkCondition(expr.Right)
+		}
+		return this.checkComparison(expr.Left, expr.Right)
+	case *NotExpr:
+		return this.checkCondition(expr.Expr)
+	case *InExpr:
+		for _, value := range expr.List {
+			err := this.checkComparison(expr.Expr, value)
+			if err != nil {
+				return err
+			}
+		}
+	case *BetweenExpr:
+		err := this.checkComparison(expr.Expr, expr.Low)
+		if err != nil {
+			return err
+		}
+		return this.checkComparison(expr.Expr, expr.High)
+	case *LikeExpr:
+		return this.checkAggregate(expr.Expr)
+	case *IsNullExpr:
+		return this.checkAggregate(expr.Expr)
+	}
+	return nil
+}
+
+func (this *typeChecker) checkComparison(left Expr, right Expr) error {
+	for _, expr := range []Expr{left, right} {
+		err := this.checkAggregate(expr)
+		if err != nil {
+			return err
+		}
+	}
+	leftType := this.typeOf(left)
+	rightType := this.typeOf(right)
+	if len(leftType) == 0 || len(rightType) == 0 || isNumericType(leftType) == isNumericType(rightType) {
+		return nil
+	}
+	return &QueryError{Pos: right.Position(), Msg: fmt.Sprintf("cannot compare %s of type %s with %s of type %s", left.String(), leftType, right.String(), rightType)}
+}
+
+// SUM and AVG of a string column
+func (this *typeChecker) checkAggregate(expr Expr) error {
+	call, ok := expr.(*FuncCall)
+	if !ok || (call.Name != util.AGGREGATE_SUM && call.Name != util.AGGREGATE_AVG) || len(call.Args) != 1 {
+		return nil
+	}
+	column, ok := call.Args[0].(*ColumnRef)
+	if !ok || this.columnType(column) != COLUMN_TYPE_STRING {
+		return nil
+	}
+	return &QueryError{Pos: column.Pos, Msg: fmt.Sprintf("%s takes a numeric column, %s is of type %s", call.Name, column.String(), COLUMN_TYPE_STRING)}
+}
+
+// one of the COLUMN_TYPE_* types, empty if unknown
+func (this *typeChecker) typeOf(expr Expr) string {
+	switch expr := expr.(type) {
+	case *StringLit:
+		return COLUMN_TYPE_STRING
+	case *NumberLit:
+		if strings.ContainsAny(expr.Value, ".eE") {
+			return COLUMN_TYPE_FLOAT
+		}
+		return COLUMN_TYPE_INT
+	case *ColumnRef:
+		if item, ok := this.aliases[expr.Column]; ok && len(expr.Table) == 0 {
+			if column, ok := item.(*ColumnRef); ok {
+				return this.columnType(column)
+			}
+			return this.typeOf(item)
+		}
+		return this.columnType(expr)
+	case *FuncCall:
+		switch expr.Name {
+		case util.AGGREGATE_COUNT:
+			return COLUMN_TYPE_INT
+		case util.AGGREGATE_SUM, util.AGGREGATE_AVG:
+			return COLUMN_TYPE_FLOAT
+		case util.AGGREGATE_MIN, util.AGGREGATE_MAX:
+			if len(expr.Args) == 1 {
+				if column, ok := expr.Args[0].(*ColumnRef); ok {
+					return this.columnType(column)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// schema type of a column, empty for columns of local files and names that are not columns
+func (this *typeChecker) columnType(column *ColumnRef) string {
+	side := 0
+	if len(this.stmt.From) > 1 {
+		var err error
+		side, err = resolveJoinSide(this.stmt, column, this.headers)
+		if err != nil {
+			return ""
+		}
+	} else if len(column.Table) > 0 && column.Table != this.stmt.From[0].RefName() {
+		return ""
+	}
+
+	table := this.tables[side]
+	idx := findColumn(table.header, column.Column)
+	if table.types == nil || idx < 0 {
+		return ""
+	}
+	return table.types[idx]
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+
+broadcast join: the small input of a join stage is shipped to every maple task through the job cache
+($MJ_CACHE_DIR) and held in memory by join key. Each line of the large input is joined with the lines
+of the small input having the same key in the maple phase, so the join needs no juice stage.
+
+query is SELECT d1.name, d2.country FROM d1, d2 WHERE d1.id = d2.uid with d2 small
+
+d2 in memory:          a line of the d1 split:      output:
+	1 -> [US, France]      1,test                       test,US
+	                                                    test,France
+
+the large input is the one preserved by an outer join, its lines without a match are written with NULL
+(empty) columns in place of the small input. Lines with a NULL (empty) join column never match
+
+*/
+
+// output column: the input position in the join stage and the index of the column in the line
+// emitted for that input
+type outputColumn struct {
+	Side  int
+	Index int
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	homedir, _ := os.UserHomeDir()
+	nodeManagerFileDir := homedir + "/mr_node_manager/"
+
+	// define flags
+	// inner, left or right
+	joinType := "{{ .JoinType }}"
+	// position of the small input in the join stage (0 or 1), it is read from the job cache
+	smallSideValue := "{{ .SmallSide }}"
+	smallFileName := "{{ .SmallFileName }}"
+	// columns of each input the join key is combined from, matched in order
+	joinColumnsJson := "{{ .JoinColumnsJson }}"
+	// names of the columns the query needs from each input, empty for whole lines
+	projectionsJson := "{{ .ProjectionsJson }}"
+	// number of columns of each input, pads unmatched lines without projection
+	columnCountsJson := "{{ .ColumnCountsJson }}"
+	projectionJson := "{{ .ProjectionJson }}"
+	// only the first rows under the ORDER BY of the query are written when set
+	topNJson := "{{ .TopNJson }}"
+	// skip, fail or error: rows that are not valid CSV or whose fields do not match the header are
+	// dropped, fail the task or are written to the side outputs <errorFilePrefix>-<partition> and
+	// <errorFilePrefix>-cache for the small input, which every task reads and only the task of the
+	// first partition writes
+	malformedPolicy := "{{ .MalformedPolicy }}"
+	errorFilePrefix := "{{ .ErrorFilePrefix }}"
+	inputFileFlag := flag.String("in", "", "Input filename")
+	prefixFlag := flag.String("prefix", "", "SDFS intermediate filename prefix")
+	flag.Parse()
+
+	// check if required flags are provided
+	if *inputFileFlag == "" || *prefixFlag == "" {
+		log.Fatal("Usage: go run broadcast_join.go -in <inputfile> -prefix <sdfs_intermediate_filename_prefix>")
+	}
+
+	smallSide, err := strconv.Atoi(smallSideValue)
+	if err != nil || smallSide < 0 || smallSide > 1 {
+		log.Fatal("Invalid small input side:", smallSideValue)
+	}
+	largeSide := 1 - smallSide
+	preserved := (joinType == "left" && largeSide == 0) || (joinType == "right" && largeSide == 1)
+
+	joinColumns := [2][]string{}
+	err = json.Unmarshal([]byte(joinColumnsJson), &joinColumns)
+	if err != nil || len(joinColumns[0]) == 0 || len(joinColumns[0]) != len(joinColumns[1]) {
+		log.Fatal("Invalid join columns:", err)
+	}
+	// a nil projection keeps whole lines
+	projections := [2][]string{}
+	if len(projectionsJson) > 0 {
+		err = json.Unmarshal([]byte(projectionsJson), &projections)
+		if err != nil {
+			log.Fatal("Invalid projections:", err)
+		}
+	}
+	columnCounts := [2]int{}
+	err = json.Unmarshal([]byte(columnCountsJson), &columnCounts)
+	if err != nil {
+		log.Fatal("Invalid column counts:", err)
+	}
+	projection := []outputColumn{}
+	if len(projectionJson) > 0 {
+		err := json.Unmarshal([]byte(projectionJson), &projection)
+		if err != nil {
+			log.Fatal("Invalid projection:", err)
+		}
+	}
+
+	var topN *topNSpec
+	if len(topNJson) > 0 {
+		topN = &topNSpec{}
+		err = json.Unmarshal([]byte(topNJson), topN)
+		if err != nil {
+			log.Fatal("Invalid top-N spec:", err)
+		}
+	}
+
+	partitionNumber := extractPartitionNumber(*inputFileFlag)
+
+	// rows of the small input by join key
+	smallFile, err := os.Open(os.Getenv("MJ_CACHE_DIR") + "/" + smallFileName)
+	if err != nil {
+		log.Fatal("Error opening cached input file:", err)
+	}
+	smallPolicy := malformedPolicy
+	if smallPolicy == "error" && partitionNumber != "p0" {
+		smallPolicy = "skip"
+	}
+	smallMalformed := &malformedRows{
+		input:         smallFileName,
+		policy:        smallPolicy,
+		errorFileDir:  nodeManagerFileDir,
+		errorFileName: errorFilePrefix + "-cache",
+	}
+	smallInput := newInput(newTableReader(smallFile, smallMalformed), joinColumns[smallSide], projections[smallSide])
+	smallRows := make(map[string][][]string)
+	for {
+		key, row, ok, err := smallInput.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal("Error reading cached input file:", err)
+		}
+		if ok {
+			smallRows[key] = append(smallRows[key], row)
+		}
+	}
+	smallFile.Close()
+
+	file, err := os.Open(*inputFileFlag)
+	if err != nil {
+		log.Fatal("Error opening input file:", err)
+	}
+	defer file.Close()
+
+	malformed := &malformedRows{
+		input:         filepath.Base(*inputFileFlag),
+		policy:        malformedPolicy,
+		errorFileDir:  nodeManagerFileDir,
+		errorFileName: fmt.Sprintf("%s-%s", errorFilePrefix, partitionNumber),
+	}
+	largeInput := newInput(newTableReader(file, malformed), joinColumns[largeSide], projections[largeSide])
+
+	output := make(map[string]*rowWriter)
+	outputFiles := []string{}
+	key := "DummyJoinKey"
+
+	writeRow := func(row []string) {
+		// create or retrieve the writer for the key
+		writer, exists := output[key]
+		if !exists {
+			outputFileName := fmt.Sprintf("%s-%s-%s", *prefixFlag, partitionNumber, key)
+			writer = newRowWriter(nodeManagerFileDir + outputFileName)
+			output[key] = writer
+			outputFiles = append(outputFiles, outputFileName)
+		}
+		writer.write(row)
+	}
+
+	// with a top-N spec only the first rows of the split are written, at most 2*Limit are held at a time
+	rows := []outputRow{}
+	emit := func(row []string) {
+		if topN != nil {
+			rows = append(rows, outputRow{keys: rowKeys(row, topN.Keys), row: row})
+			if len(rows) >= 2*topN.Limit {
+				rows = firstRows(rows, topN)
+			}
+			return
+		}
+		writeRow(row)
+	}
+
+	for {
+		joinKey, row, ok, err := largeInput.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal("Error reading input file:", err)
+		}
+		joined := [2][]string{}
+		joined[largeSide] = row
+
+		matches := [][]string{}
+		if ok {
+			matches = smallRows[joinKey]
+		}
+		for _, match := range matches {
+			joined[smallSide] = match
+			emit(joinRows(joined[0], joined[1], projection, columnCounts))
+		}
+		if len(matches) == 0 && preserved {
+			joined[smallSide] = nil
+			emit(joinRows(joined[0], joined[1], projection, columnCounts))
+		}
+	}
+
+	if topN != nil {
+		for _, row := range firstRows(rows, topN) {
+			writeRow(row.row)
+		}
+	}
+
+	for _, writer := range output {
+		writer.close()
+	}
+	for _, errorFileName := range []string{smallMalformed.close(), malformed.close()} {
+		if len(errorFileName) > 0 {
+			outputFiles = append(outputFiles, errorFileName)
+		}
+	}
+
+	fmt.Println(strings.Join(outputFiles, ","))
+	os.Exit(0)
+}
+
+// join key and projection columns of an input, located by its header row
+type input struct {
+	reader        *tableReader
+	joinColumnIdx []int
+	projectionIdx []int
+	projected     bool
+}
+
+func newInput(reader *tableReader, joinColumns []string, projection []string) *input {
+	result := &input{reader: reader, projected: projection != nil}
+	for _, columnName := range joinColumns {
+		idx := findColumnIndex(reader.header, columnName)
+		if idx < 0 {
+			log.Fatalf("Unable to locate join column (%s) in input file header (%s)", columnName, strings.Join(reader.header, ","))
+		}
+		result.joinColumnIdx = append(result.joinColumnIdx, idx)
+	}
+	for _, columnName := range projection {
+		idx := findColumnIndex(reader.header, columnName)
+		if idx < 0 {
+			log.Fatalf("Unable to locate column (%s) for projection in input file header (%s)", columnName, strings.Join(reader.header, ","))
+		}
+		result.projectionIdx = append(result.projectionIdx, idx)
+	}
+	return result
+}
+
+// join key and emitted columns of the next row, false if a join column is NULL (empty). io.EOF after
+// the last row
+func (this *input) read() (string, []string, bool, error) {
+	values, _, err := this.reader.read()
+	if err != nil {
+		return "", nil, false, err
+	}
+	keyValues := make([]string, len(this.joinColumnIdx))
+	ok := true
+	for i, idx := range this.joinColumnIdx {
+		keyValues[i] = strings.TrimSpace(values[idx])
+		ok = ok && len(keyValues[i]) > 0
+	}
+
+	row := values
+	if this.projected {
+		row = make([]string, len(this.projectionIdx))
+		for i, idx := range this.projectionIdx {
+			row[i] = values[idx]
+		}
+	}
+	// values may contain commas, a NUL separator keeps composite keys apart
+	return strings.Join(keyValues, "\x00"), row, ok, nil
+}
+
+func extractPartitionNumber(inputFileName string) string {
+	splitted := strings.Split(inputFileName, "-")
+	if (len(splitted)!=2){
+		log.Fatalf("WARN: invalid maple input file name format for %s", inputFileName)
+		return ""
+	}
+	return splitted[1]
+}
+
+func findColumnIndex(header []string, columnName string) int {
+	for idx, field := range header {
+		if field == columnName {
+			return idx
+		}
+	}
+	return -1
+}
+
+// a nil row is the missing side of an outer join, its columns are NULL
+func joinRows(row1 []string, row2 []string, projection []outputColumn, columnCounts [2]int) []string {
+	rows := [2][]string{row1, row2}
+	if len(projection) == 0 {
+		fields := make([]string, 0, columnCounts[0]+columnCounts[1])
+		for side, row := range rows {
+			if row == nil {
+				row = make([]string, columnCounts[side])
+			}
+			fields = append(fields, row...)
+		}
+		return fields
+	}
+
+	fields := make([]string, len(projection))
+	for i, column := range projection {
+		if column.Side < 0 || column.Side > 1 {
+			log.Fatalf("Invalid output column %d of dataset %d", column.Index, column.Side)
+		}
+		if rows[column.Side] == nil {
+			continue
+		}
+		if column.Index >= len(rows[column.Side]) {
+			log.Fatalf("Invalid output column %d of dataset %d", column.Index, column.Side)
+		}
+		fields[i] = rows[column.Side][column.Index]
+	}
+	return fields
+}
+
+// first output rows under the ORDER BY of the query, keys index into the output columns
+type topNSpec struct {
+	Keys  []sortKeySpec
+	Limit int
+}
+
+type sortKeySpec struct {
+	ColumnIndex int
+	Numeric     bool
+	Descending  bool
+}
+
+type outputRow struct {
+	keys []string
+	row  []string
+}
+
+// first Limit rows in sort order, ties keep their output order
+func firstRows(rows []outputRow, spec *topNSpec) []outputRow {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for idx, key := range spec.Keys {
+			result := compareSortKeys(rows[i].keys[idx], rows[j].keys[idx], key.Numeric, key.Descending)
+			if result != 0 {
+				return result < 0
+			}
+		}
+		return false
+	})
+	if len(rows) > spec.Limit {
+		rows = rows[:spec.Limit]
+	}
+	return rows
+}
+
+func rowKeys(row []string, keys []sortKeySpec) []string {
+	values := make([]string, len(keys))
+	for idx, key := range keys {
+		if key.ColumnIndex < len(row) {
+			values[idx] = row[key.ColumnIndex]
+		}
+	}
+	return values
+}
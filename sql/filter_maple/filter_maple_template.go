@@ -2,14 +2,93 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
+	"io"
 	"log"
 	"os"
 	"fmt"
+	"hash/fnv"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+// WHERE clause compiled by the SQL client, see util/sql_filter.go
+type filterPredicate struct {
+	Op       string
+	Cmp      string
+	Regex    string
+	Args     []*filterPredicate
+	Operands []filterOperand
+}
+
+type filterOperand struct {
+	Kind  string
+	Value string
+}
+
+// predicate with columns resolved against the input header
+type predicate struct {
+	op       string
+	cmp      string
+	regex    *regexp.Regexp
+	args     []*predicate
+	operands []operand
+}
+
+type operand struct {
+	kind     string
+	column   int
+	value    string
+	number   float64
+	isNumber bool
+}
+
+// GROUP BY spec, see util/sql_group.go
+type groupSpec struct {
+	Columns    []string
+	Aggregates []aggregateSpec
+	Buckets    int
+}
+
+type aggregateSpec struct {
+	Func     string
+	Column   string
+	Distinct bool
+}
+
+// partial aggregate of a group over the lines of this split, merged by the group juice
+type aggregateState struct {
+	Count      int64 // lines for COUNT(*), non NULL values otherwise
+	Numbers    int64 // numeric values
+	Sum        float64
+	MinNum     float64
+	MaxNum     float64
+	MinNumText string
+	MaxNumText string
+	MinText    string
+	MaxText    string
+	NonNumeric bool
+	Distinct   map[string]bool `json:",omitempty"`
+}
+
+type groupPartial struct {
+	Key    []string
+	States []*aggregateState
+}
+
+// three-valued logic of SQL, lines are kept only when the predicate is true
+type truth int
+
+const (
+	FALSE truth = iota
+	TRUE
+	UNKNOWN
+)
+
 
 func main() {
 	log.SetOutput(os.Stderr)
@@ -17,30 +96,29 @@ func main() {
 	nodeManagerFileDir := homedir + "/mr_node_manager/"
 
 	// define flags
-	filterColumn := "{{ .FilterColumn }}"
-	regexFlag := "{{ .Regex }}"
+	// compiled WHERE clause, empty to keep every line
+	predicateJson := "{{ .PredicateJson }}"
+	// names of the columns to output in order, empty for whole lines
+	projectionJson := "{{ .ProjectionJson }}"
+	// aggregates the kept lines per group when set
+	groupJson := "{{ .GroupJson }}"
+	// only the first output lines under the ORDER BY of the query are written when set
+	topNJson := "{{ .TopNJson }}"
+	// skip, fail or error: rows that are not valid CSV or whose fields do not match the header are
+	// dropped, fail the task or are written to the side output <errorFilePrefix>-<partition>
+	malformedPolicy := "{{ .MalformedPolicy }}"
+	errorFilePrefix := "{{ .ErrorFilePrefix }}"
 	inputFileFlag := flag.String("in", "", "Input filename")
 	prefixFlag := flag.String("prefix", "", "SDFS intermediate filename prefix")
 	flag.Parse()
 
-	filterColumn = strings.TrimSpace(filterColumn)
-	filterByColumn := len(filterColumn) > 0	
-	filterColumnIdx := -1
-
 	// check if required flags are provided
 	if *inputFileFlag == "" || *prefixFlag == "" {
-		log.Fatal("Usage: go run filter_maple.go -E <regex> -in <inputfile> -prefix <sdfs_intermediate_filename_prefix>")
-		return
-	}
-
-	// compile the regular expression
-	regexpPattern, err := regexp.Compile(regexFlag)
-	if err != nil {
-		log.Fatal("Error compiling regular expression:", err)
+		log.Fatal("Usage: go run filter_maple.go -in <inputfile> -prefix <sdfs_intermediate_filename_prefix>")
 		return
 	}
 
-	output := make(map[string]*os.File)
+	output := make(map[string]*rowWriter)
 
 	file, err := os.Open(*inputFileFlag)
 	if err != nil {
@@ -49,57 +127,142 @@ func main() {
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	if !scanner.Scan(){
-		log.Fatal("Empty input to filter maple executable")
+	partitionNumber := extractPartitionNumber(*inputFileFlag)
+	malformed := &malformedRows{
+		input:         filepath.Base(*inputFileFlag),
+		policy:        malformedPolicy,
+		errorFileDir:  nodeManagerFileDir,
+		errorFileName: fmt.Sprintf("%s-%s", errorFilePrefix, partitionNumber),
+	}
+	reader := newTableReader(file, malformed)
+	header := reader.header
+
+	var condition *predicate
+	if len(predicateJson) > 0 {
+		spec := &filterPredicate{}
+		err = json.Unmarshal([]byte(predicateJson), spec)
+		if err != nil {
+			log.Fatal("Invalid predicate:", err)
+		}
+		condition = compilePredicate(spec, header)
+	}
+
+	projection := []string{}
+	if len(projectionJson) > 0 {
+		err = json.Unmarshal([]byte(projectionJson), &projection)
+		if err != nil {
+			log.Fatal("Invalid projection:", err)
+		}
+	}
+	projectionIdx := []int{}
+	for _, columnName := range projection {
+		idx := findColumnIndex(header, columnName)
+		if idx < 0 {
+			log.Fatalf("Unable to locate column (%s) for projection in input file header (%s)", columnName, strings.Join(header, ","))
+		}
+		projectionIdx = append(projectionIdx, idx)
+	}
+
+	var group *groupSpec
+	groupIdx := []int{}
+	aggregateIdx := []int{}
+	groups := make(map[string]*groupPartial)
+	if len(groupJson) > 0 {
+		group = &groupSpec{}
+		err = json.Unmarshal([]byte(groupJson), group)
+		if err != nil {
+			log.Fatal("Invalid group spec:", err)
+		}
+		for _, columnName := range group.Columns {
+			groupIdx = append(groupIdx, requireColumnIndex(header, columnName))
+		}
+		for _, aggregate := range group.Aggregates {
+			idx := -1
+			if len(aggregate.Column) > 0 {
+				idx = requireColumnIndex(header, aggregate.Column)
+			}
+			aggregateIdx = append(aggregateIdx, idx)
+		}
 	}
 
-	if filterByColumn {
-		header := scanner.Text()
-		filterColumnIdx = findColumnIndex(header, filterColumn)
-		if filterColumnIdx < 0 {
-			log.Fatalf("Unable to locate column (%s)for filter operation in input file header (%s)", filterColumn, header)
+	var topN *topNSpec
+	if len(topNJson) > 0 {
+		topN = &topNSpec{}
+		err = json.Unmarshal([]byte(topNJson), topN)
+		if err != nil {
+			log.Fatal("Invalid top-N spec:", err)
 		}
 	}
 
 	outputFiles := []string{}
 	key := "DummyFilterKey"
 
+	writeRow := func(row []string) {
+		// create or retrieve the writer for the key
+		writer, exists := output[key]
+		if !exists {
+			outputFileName := fmt.Sprintf("%s-%s-%s", *prefixFlag, partitionNumber, key)
+			writer = newRowWriter(nodeManagerFileDir + outputFileName)
+			output[key] = writer
+			outputFiles = append(outputFiles, outputFileName)
+		}
+		writer.write(row)
+	}
+
+	// with a top-N spec only the first rows of the split are written, at most 2*Limit are held at a time
+	rows := []outputRow{}
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	for {
+		values, line, err := reader.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal("Error reading input file:", err)
+		}
 
-		// check if the field matches the regular expression
-		if  (!filterByColumn && regexpPattern.MatchString(line)) || (filterByColumn && regexpPattern.MatchString(getFieldByIndex(line, filterColumnIdx))) {
+		if group != nil {
+			if condition == nil || evaluate(condition, line, values) == TRUE {
+				aggregateLine(group, groups, groupIdx, aggregateIdx, values)
+			}
+			continue
+		}
 
-			// create or retrieve file descriptor for the key
-			outputFile, exists := output[key]
-			if !exists {
-				outputFileName := fmt.Sprintf("%s-%s-%s", *prefixFlag, extractPartitionNumber(*inputFileFlag), key)
-				var err error
-				outputFile, err = os.Create(nodeManagerFileDir + outputFileName)
-				if err != nil {
-					log.Fatal("Error creating output file:", err)
+		if condition == nil || evaluate(condition, line, values) == TRUE {
+			row := values
+			if len(projectionIdx) > 0 {
+				row = make([]string, len(projectionIdx))
+				for i, idx := range projectionIdx {
+					row[i] = values[idx]
 				}
-				output[key] = outputFile
-				outputFiles = append(outputFiles, outputFileName)
 			}
 
-			// write directly to the file descriptor
-			_, err := outputFile.WriteString(line + "\n")
-			if err != nil {
-				log.Fatal("Error writing to output file:", err)
+			if topN != nil {
+				rows = append(rows, outputRow{keys: rowKeys(row, topN.Keys), row: row})
+				if len(rows) >= 2*topN.Limit {
+					rows = firstRows(rows, topN)
+				}
+				continue
 			}
+			writeRow(row)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatal("Error reading input file:", err)
-		return
+	if topN != nil {
+		for _, row := range firstRows(rows, topN) {
+			writeRow(row.row)
+		}
 	}
 
-	for _, file := range output {
-		file.Close()
+	for _, writer := range output {
+		writer.close()
+	}
+
+	if group != nil {
+		outputFiles = writeGroups(group, groups, nodeManagerFileDir, *prefixFlag, partitionNumber)
+	}
+	if errorFileName := malformed.close(); len(errorFileName) > 0 {
+		outputFiles = append(outputFiles, errorFileName)
 	}
 
 	fmt.Println(strings.Join(outputFiles, ","))
@@ -116,24 +279,359 @@ func extractPartitionNumber(inputFileName string) string {
 }
 
 
-func findColumnIndex(header string, columnName string) int {
-	splitted := strings.Split(header, ",")
-	idx := 0
-	for _, field := range splitted {
-		field = strings.Trim(field, " \n\r")
+func findColumnIndex(header []string, columnName string) int {
+	for idx, field := range header {
 		if field == columnName {
 			return idx
 		}
-		idx++
 	}
 	return -1
 }
 
-func getFieldByIndex(line string, index int) string {
-	splitted := strings.Split(line, ",")
-	if index < len(splitted) {
-		return splitted[index]
+func requireColumnIndex(header []string, columnName string) int {
+	idx := findColumnIndex(header, columnName)
+	if idx < 0 {
+		log.Fatalf("Unable to locate column (%s) for aggregation in input file header (%s)", columnName, strings.Join(header, ","))
+	}
+	return idx
+}
+
+func aggregateLine(group *groupSpec, groups map[string]*groupPartial, groupIdx []int, aggregateIdx []int, values []string) {
+	key := make([]string, len(groupIdx))
+	for i, idx := range groupIdx {
+		key[i] = fieldAt(values, idx)
+	}
+	groupId := strings.Join(key, "\x00")
+
+	partial, exists := groups[groupId]
+	if !exists {
+		partial = newGroupPartial(group, key)
+		groups[groupId] = partial
+	}
+
+	for i, aggregate := range group.Aggregates {
+		state := partial.States[i]
+		if aggregateIdx[i] < 0 {
+			state.Count++
+			continue
+		}
+		value := fieldAt(values, aggregateIdx[i])
+		if len(value) == 0 {
+			continue
+		}
+		state.Count++
+		if aggregate.Distinct {
+			state.Distinct[value] = true
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			state.NonNumeric = true
+		} else {
+			state.Numbers++
+			state.Sum += number
+			if state.Numbers == 1 || number < state.MinNum {
+				state.MinNum, state.MinNumText = number, value
+			}
+			if state.Numbers == 1 || number > state.MaxNum {
+				state.MaxNum, state.MaxNumText = number, value
+			}
+		}
+		if state.Count == 1 || value < state.MinText {
+			state.MinText = value
+		}
+		if state.Count == 1 || value > state.MaxText {
+			state.MaxText = value
+		}
+	}
+}
+
+func newGroupPartial(group *groupSpec, key []string) *groupPartial {
+	partial := &groupPartial{Key: key}
+	for _, aggregate := range group.Aggregates {
+		state := &aggregateState{}
+		if aggregate.Distinct {
+			state.Distinct = make(map[string]bool)
+		}
+		partial.States = append(partial.States, state)
+	}
+	return partial
+}
+
+// one line of JSON per group, in the intermediate file of the bucket the group hashes to.
+// Without GROUP BY columns the single group is written even for an empty split, so that counts of 0 are output
+func writeGroups(group *groupSpec, groups map[string]*groupPartial, nodeManagerFileDir string, prefix string, partition string) []string {
+	if len(group.Columns) == 0 && len(groups) == 0 {
+		groups[""] = newGroupPartial(group, []string{})
+	}
+
+	output := make(map[string]*bufio.Writer)
+	files := []*os.File{}
+	outputFiles := []string{}
+	for groupId, partial := range groups {
+		hash := fnv.New32a()
+		hash.Write([]byte(groupId))
+		outputFileName := fmt.Sprintf("%s-%s-g%03d", prefix, partition, hash.Sum32()%uint32(group.Buckets))
+
+		writer, exists := output[outputFileName]
+		if !exists {
+			file, err := os.Create(nodeManagerFileDir + outputFileName)
+			if err != nil {
+				log.Fatal("Error creating output file:", err)
+			}
+			files = append(files, file)
+			writer = bufio.NewWriter(file)
+			output[outputFileName] = writer
+			outputFiles = append(outputFiles, outputFileName)
+		}
+
+		content, err := json.Marshal(partial)
+		if err != nil {
+			log.Fatal("Error encoding group:", err)
+		}
+		_, err = writer.Write(append(content, '\n'))
+		if err != nil {
+			log.Fatal("Error writing to output file:", err)
+		}
+	}
+
+	for _, writer := range output {
+		err := writer.Flush()
+		if err != nil {
+			log.Fatal("Error writing to output file:", err)
+		}
+	}
+	for _, file := range files {
+		file.Close()
+	}
+	return outputFiles
+}
+
+// rows have as many fields as the header
+func fieldAt(values []string, idx int) string {
+	return strings.TrimSpace(values[idx])
+}
+
+func compilePredicate(spec *filterPredicate, header []string) *predicate {
+	compiled := &predicate{op: spec.Op, cmp: spec.Cmp}
+	if spec.Op == "regexp" {
+		regex, err := regexp.Compile(spec.Regex)
+		if err != nil {
+			log.Fatal("Error compiling regular expression:", err)
+		}
+		compiled.regex = regex
+	}
+	for _, arg := range spec.Args {
+		compiled.args = append(compiled.args, compilePredicate(arg, header))
+	}
+	for _, specOperand := range spec.Operands {
+		value := operand{kind: specOperand.Kind, value: specOperand.Value, column: -1}
+		switch specOperand.Kind {
+		case "column":
+			value.column = findColumnIndex(header, specOperand.Value)
+			if value.column < 0 {
+				log.Fatalf("Unable to locate column (%s) for filter operation in input file header (%s)", specOperand.Value, strings.Join(header, ","))
+			}
+		case "number":
+			number, err := strconv.ParseFloat(specOperand.Value, 64)
+			if err != nil {
+				log.Fatalf("Invalid number %s in predicate", specOperand.Value)
+			}
+			value.number = number
+			value.isNumber = true
+		}
+		compiled.operands = append(compiled.operands, value)
+	}
+	return compiled
+}
+
+func evaluate(condition *predicate, line string, values []string) truth {
+	switch condition.op {
+	case "and":
+		result := TRUE
+		for _, arg := range condition.args {
+			switch evaluate(arg, line, values) {
+			case FALSE:
+				return FALSE
+			case UNKNOWN:
+				result = UNKNOWN
+			}
+		}
+		return result
+	case "or":
+		result := FALSE
+		for _, arg := range condition.args {
+			switch evaluate(arg, line, values) {
+			case TRUE:
+				return TRUE
+			case UNKNOWN:
+				result = UNKNOWN
+			}
+		}
+		return result
+	case "not":
+		switch evaluate(condition.args[0], line, values) {
+		case TRUE:
+			return FALSE
+		case FALSE:
+			return TRUE
+		}
+		return UNKNOWN
+	case "compare":
+		order, ok := compareOperands(condition.operands[0], condition.operands[1], line, values)
+		if !ok {
+			return UNKNOWN
+		}
+		return toTruth(matchesOrder(order, condition.cmp))
+	case "in":
+		result := FALSE
+		for _, item := range condition.operands[1:] {
+			order, ok := compareOperands(condition.operands[0], item, line, values)
+			if !ok {
+				result = UNKNOWN
+			} else if order == 0 {
+				return TRUE
+			}
+		}
+		return result
+	case "between":
+		low, lowOk := compareOperands(condition.operands[0], condition.operands[1], line, values)
+		high, highOk := compareOperands(condition.operands[0], condition.operands[2], line, values)
+		if (lowOk && low < 0) || (highOk && high > 0) {
+			return FALSE
+		}
+		if !lowOk || !highOk {
+			return UNKNOWN
+		}
+		return TRUE
+	case "is_null":
+		_, isNull := operandValue(condition.operands[0], line, values)
+		return toTruth(isNull)
+	case "regexp":
+		value, isNull := operandValue(condition.operands[0], line, values)
+		if isNull {
+			return UNKNOWN
+		}
+		return toTruth(condition.regex.MatchString(value))
+	}
+	log.Fatalf("Unknown predicate operation %s", condition.op)
+	return UNKNOWN
+}
+
+// fields are trimmed, an empty field is NULL
+func operandValue(value operand, line string, values []string) (string, bool) {
+	switch value.kind {
+	case "column":
+		field := strings.TrimSpace(values[value.column])
+		return field, len(field) == 0
+	case "line":
+		return line, false
+	case "null":
+		return "", true
+	}
+	return value.value, false
+}
+
+// compares numerically when either side is a number literal or both are numeric fields, as strings otherwise.
+// not ok when either side is NULL or a number is compared with a field that is not numeric
+func compareOperands(left operand, right operand, line string, values []string) (int, bool) {
+	leftValue, leftNull := operandValue(left, line, values)
+	rightValue, rightNull := operandValue(right, line, values)
+	if leftNull || rightNull {
+		return 0, false
+	}
+
+	leftNumber, leftErr := toNumber(left, leftValue)
+	rightNumber, rightErr := toNumber(right, rightValue)
+	if left.isNumber || right.isNumber {
+		if leftErr != nil || rightErr != nil {
+			return 0, false
+		}
+	} else if leftErr != nil || rightErr != nil || left.kind != "column" || right.kind != "column" {
+		return strings.Compare(leftValue, rightValue), true
+	}
+
+	switch {
+	case leftNumber < rightNumber:
+		return -1, true
+	case leftNumber > rightNumber:
+		return 1, true
+	}
+	return 0, true
+}
+
+func toNumber(value operand, field string) (float64, error) {
+	if value.isNumber {
+		return value.number, nil
+	}
+	return strconv.ParseFloat(field, 64)
+}
+
+func matchesOrder(order int, cmp string) bool {
+	switch cmp {
+	case "=":
+		return order == 0
+	case "<>":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case ">=":
+		return order >= 0
+	}
+	log.Fatalf("Unknown comparison operator %s", cmp)
+	return false
+}
+
+func toTruth(value bool) truth {
+	if value {
+		return TRUE
+	}
+	return FALSE
+}
+
+// first output rows under the ORDER BY of the query, keys index into the output columns
+type topNSpec struct {
+	Keys  []sortKeySpec
+	Limit int
+}
+
+type sortKeySpec struct {
+	ColumnIndex int
+	Numeric     bool
+	Descending  bool
+}
+
+type outputRow struct {
+	keys []string
+	row  []string
+}
+
+// first Limit rows in sort order, ties keep their output order
+func firstRows(rows []outputRow, spec *topNSpec) []outputRow {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for idx, key := range spec.Keys {
+			result := compareSortKeys(rows[i].keys[idx], rows[j].keys[idx], key.Numeric, key.Descending)
+			if result != 0 {
+				return result < 0
+			}
+		}
+		return false
+	})
+	if len(rows) > spec.Limit {
+		rows = rows[:spec.Limit]
+	}
+	return rows
+}
+
+func rowKeys(row []string, keys []sortKeySpec) []string {
+	values := make([]string, len(keys))
+	for idx, key := range keys {
+		if key.ColumnIndex < len(row) {
+			values[idx] = row[key.ColumnIndex]
+		}
 	}
-	log.Fatal("Invalid maple input file, mismatch between header and record field length")
-	return ""
+	return values
 }
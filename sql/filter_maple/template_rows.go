@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// rows of input tables and output files shared by the maple templates reading CSV tables. This file is
+// compiled with the filter maple template and linked into the join maple and broadcast join folders,
+// generated executables get it appended, see sql/SQL_template_rows.go
+
+// same order as util.CompareSortKeys
+func compareSortKeys(a string, b string, numeric bool, descending bool) int {
+	result := 0
+	if numeric {
+		x, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		y, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		switch {
+		case errA == nil && errB == nil:
+			if x < y {
+				result = -1
+			} else if x > y {
+				result = 1
+			}
+		case errA == nil:
+			// after all numbers in either order
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			result = strings.Compare(a, b)
+		}
+	} else {
+		result = strings.Compare(a, b)
+	}
+
+	if descending {
+		return -result
+	}
+	return result
+}
+
+// CSV rows of an input table, the header sets the number of fields of every row. Rows that are not
+// valid CSV or have another number of fields are malformed and handled by the malformed row policy.
+// A quoted field left open at the end of the input makes only its first line malformed and the lines
+// after it are read again, the same way the leader splits maple input into rows
+type tableReader struct {
+	reader    *csv.Reader
+	file      *eofReader
+	input     bytes.Buffer // input the CSV reader has read from offset on, holds the text of the rows
+	offset    int64
+	header    []string
+	malformed *malformedRows
+}
+
+// remembers whether the end of the input was reached
+type eofReader struct {
+	reader io.Reader
+	eof    bool
+}
+
+func (this *eofReader) Read(p []byte) (int, error) {
+	n, err := this.reader.Read(p)
+	if err == io.EOF {
+		this.eof = true
+	}
+	return n, err
+}
+
+func newTableReader(file io.Reader, malformed *malformedRows) *tableReader {
+	result := &tableReader{file: &eofReader{reader: file}, malformed: malformed}
+	result.reset("")
+	header, err := result.reader.Read()
+	if err == io.EOF {
+		log.Fatalf("Empty input %s", malformed.input)
+	}
+	if err != nil {
+		log.Fatalf("Invalid header of %s: %s", malformed.input, err)
+	}
+	for idx, field := range header {
+		header[idx] = strings.TrimSpace(field)
+	}
+	result.header = header
+	result.consume()
+	return result
+}
+
+// read the text given, then the input read ahead by the CSV reader and the rest of the file
+func (this *tableReader) reset(text string) {
+	unread := append([]byte(text), this.input.Bytes()...)
+	this.input.Reset()
+	this.offset = 0
+	this.reader = csv.NewReader(io.TeeReader(io.MultiReader(bytes.NewReader(unread), this.file), &this.input))
+	this.reader.FieldsPerRecord = len(this.header) // set by the first row read if there is no header yet
+}
+
+// next well-formed row and the text it was read from, io.EOF after the last row
+func (this *tableReader) read() ([]string, string, error) {
+	for {
+		row, err := this.reader.Read()
+		if err == io.EOF {
+			return nil, "", err
+		}
+		text := this.consume()
+		if err == nil {
+			return row, text, nil
+		}
+		if _, ok := err.(*csv.ParseError); !ok {
+			return nil, "", err
+		}
+		first, rest, found := strings.Cut(text, "\n")
+		if found && errors.Is(err, csv.ErrQuote) && this.file.eof && this.input.Len() == 0 {
+			// quoted field left open at the end of the input
+			this.malformed.add(strings.TrimRight(first, "\r"), err)
+			this.reset(rest + "\n")
+			continue
+		}
+		this.malformed.add(text, err)
+	}
+}
+
+// input read since the last call without its line break
+func (this *tableReader) consume() string {
+	end := this.reader.InputOffset()
+	text := string(this.input.Next(int(end - this.offset)))
+	this.offset = end
+	return strings.TrimRight(text, "\r\n")
+}
+
+// malformed rows of an input file: skipped, failing the task or written to an error file as the input
+// name, the error and the text of the row
+type malformedRows struct {
+	input         string
+	policy        string
+	errorFileDir  string
+	errorFileName string
+	errorFile     *rowWriter
+	count         int
+}
+
+func (this *malformedRows) add(text string, err error) {
+	this.count++
+	switch this.policy {
+	case "skip":
+	case "error":
+		if this.errorFile == nil {
+			this.errorFile = newRowWriter(this.errorFileDir + this.errorFileName)
+		}
+		this.errorFile.write([]string{this.input, err.Error(), text})
+	default:
+		log.Fatalf("Malformed row in %s: %s", this.input, err)
+	}
+}
+
+// name of the error file, empty if no row was written to it
+func (this *malformedRows) close() string {
+	if this.count > 0 {
+		log.Printf("%d malformed rows in %s handled by policy %s", this.count, this.input, this.policy)
+	}
+	if this.errorFile == nil {
+		return ""
+	}
+	this.errorFile.close()
+	return this.errorFileName
+}
+
+// output file of CSV rows, fields are quoted when they hold a comma, a quote or a line break
+type rowWriter struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func newRowWriter(filePath string) *rowWriter {
+	file, err := os.Create(filePath)
+	if err != nil {
+		log.Fatal("Error creating output file:", err)
+	}
+	return &rowWriter{file: file, writer: csv.NewWriter(file)}
+}
+
+func (this *rowWriter) write(row []string) {
+	var err error
+	if len(row) == 1 && len(row[0]) == 0 {
+		// a single empty field would be an empty line, which CSV readers skip
+		this.writer.Flush()
+		_, err = this.file.WriteString("\"\"\n")
+	} else {
+		err = this.writer.Write(row)
+	}
+	if err != nil {
+		log.Fatal("Error writing to output file:", err)
+	}
+}
+
+func (this *rowWriter) close() {
+	this.writer.Flush()
+	err := this.writer.Error()
+	if err != nil {
+		log.Fatal("Error writing to output file:", err)
+	}
+	this.file.Close()
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// juice of a GROUP BY query: merges the partial aggregates written by the maple tasks for the groups
+// hashed to this key, applies HAVING and writes one CSV row per group with the selected values
+
+// HAVING clause compiled by the SQL client, see util/sql_filter.go
+type filterPredicate struct {
+	Op       string
+	Cmp      string
+	Regex    string
+	Args     []*filterPredicate
+	Operands []filterOperand
+}
+
+type filterOperand struct {
+	Kind  string
+	Value string
+}
+
+// predicate with columns resolved to values of the group
+type predicate struct {
+	op       string
+	cmp      string
+	regex    *regexp.Regexp
+	args     []*predicate
+	operands []operand
+}
+
+type operand struct {
+	kind     string
+	column   int
+	value    string
+	number   float64
+	isNumber bool
+}
+
+// GROUP BY spec, see util/sql_group.go
+type groupSpec struct {
+	Columns    []string
+	Aggregates []aggregateSpec
+	Buckets    int
+	Outputs    []int
+	Having     *filterPredicate
+}
+
+type aggregateSpec struct {
+	Func     string
+	Column   string
+	Distinct bool
+}
+
+// partial aggregate of a group written by a maple task
+type aggregateState struct {
+	Count      int64 // lines for COUNT(*), non NULL values otherwise
+	Numbers    int64 // numeric values
+	Sum        float64
+	MinNum     float64
+	MaxNum     float64
+	MinNumText string
+	MaxNumText string
+	MinText    string
+	MaxText    string
+	NonNumeric bool
+	Distinct   map[string]bool `json:",omitempty"`
+}
+
+type groupPartial struct {
+	Key    []string
+	States []*aggregateState
+}
+
+// three-valued logic of SQL, lines are kept only when the predicate is true
+type truth int
+
+const (
+	FALSE truth = iota
+	TRUE
+	UNKNOWN
+)
+
+
+func main() {
+	log.SetOutput(os.Stderr)
+	homedir, _ := os.UserHomeDir()
+	nodeManagerFileDir := homedir + "/mr_node_manager/"
+
+	specJson := "{{ .SpecJson }}"
+	// only the first groups under the ORDER BY of the query are written when set
+	topNJson := "{{ .TopNJson }}"
+	inputFileFlag := flag.String("in", "", "Input filename")
+	outputFileFlag := flag.String("dest", "", "Output filename")
+	flag.Parse()
+
+	if *inputFileFlag == "" || *outputFileFlag == "" {
+		log.Fatal("Usage: go run group_juice.go -in <inputfile> -dest <outputfile>")
+	}
+
+	spec := groupSpec{}
+	err := json.Unmarshal([]byte(specJson), &spec)
+	if err != nil {
+		log.Fatal("Invalid group spec:", err)
+	}
+	var having *predicate
+	if spec.Having != nil {
+		having = compilePredicate(spec.Having)
+	}
+	var topN *topNSpec
+	if len(topNJson) > 0 {
+		topN = &topNSpec{}
+		err = json.Unmarshal([]byte(topNJson), topN)
+		if err != nil {
+			log.Fatal("Invalid top-N spec:", err)
+		}
+	}
+
+	file, err := os.Open(*inputFileFlag)
+	if err != nil {
+		log.Fatal("Error opening input file:", err)
+	}
+	defer file.Close()
+
+	groups := make(map[string]*groupPartial)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		partial := &groupPartial{}
+		err := json.Unmarshal(scanner.Bytes(), partial)
+		if err != nil || len(partial.States) != len(spec.Aggregates) {
+			log.Fatalf("Invalid partial aggregate: %s", scanner.Text())
+		}
+		groupId := strings.Join(partial.Key, "\x00")
+		merged, exists := groups[groupId]
+		if !exists {
+			groups[groupId] = partial
+			continue
+		}
+		for i, state := range partial.States {
+			mergeStates(merged.States[i], state)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal("Error reading input file:", err)
+	}
+
+	groupIds := make([]string, 0, len(groups))
+	for groupId := range groups {
+		groupIds = append(groupIds, groupId)
+	}
+	sort.Strings(groupIds)
+
+	writer := newRowWriter(nodeManagerFileDir + *outputFileFlag)
+
+	rows := []outputRow{}
+	for _, groupId := range groupIds {
+		partial := groups[groupId]
+		values := append([]string{}, partial.Key...)
+		for i, aggregate := range spec.Aggregates {
+			values = append(values, finalValue(aggregate, partial.States[i]))
+		}
+		if having != nil && evaluate(having, "", values) != TRUE {
+			continue
+		}
+
+		fields := make([]string, len(spec.Outputs))
+		for i, idx := range spec.Outputs {
+			fields[i] = values[idx]
+		}
+		if topN != nil {
+			rows = append(rows, outputRow{keys: rowKeys(fields, topN.Keys), row: fields})
+			continue
+		}
+		writer.write(fields)
+	}
+	if topN != nil {
+		for _, row := range firstRows(rows, topN) {
+			writer.write(row.row)
+		}
+	}
+	writer.close()
+
+	fmt.Println(*outputFileFlag)
+	os.Exit(0)
+}
+
+func mergeStates(state *aggregateState, other *aggregateState) {
+	if other.Numbers > 0 {
+		if state.Numbers == 0 || other.MinNum < state.MinNum {
+			state.MinNum, state.MinNumText = other.MinNum, other.MinNumText
+		}
+		if state.Numbers == 0 || other.MaxNum > state.MaxNum {
+			state.MaxNum, state.MaxNumText = other.MaxNum, other.MaxNumText
+		}
+		state.Numbers += other.Numbers
+		state.Sum += other.Sum
+	}
+	if other.Count > 0 {
+		if state.Count == 0 || other.MinText < state.MinText {
+			state.MinText = other.MinText
+		}
+		if state.Count == 0 || other.MaxText > state.MaxText {
+			state.MaxText = other.MaxText
+		}
+		state.Count += other.Count
+	}
+	state.NonNumeric = state.NonNumeric || other.NonNumeric
+	if len(other.Distinct) > 0 && state.Distinct == nil {
+		state.Distinct = make(map[string]bool)
+	}
+	for value := range other.Distinct {
+		state.Distinct[value] = true
+	}
+}
+
+// value of an aggregate, empty for NULL. SUM and AVG skip values that are not numeric,
+// MIN and MAX compare numerically when every value is numeric
+func finalValue(aggregate aggregateSpec, state *aggregateState) string {
+	switch aggregate.Func {
+	case "COUNT":
+		if aggregate.Distinct {
+			return strconv.Itoa(len(state.Distinct))
+		}
+		return strconv.FormatInt(state.Count, 10)
+	case "SUM":
+		if state.Numbers == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(state.Sum, 'f', -1, 64)
+	case "AVG":
+		if state.Numbers == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(state.Sum/float64(state.Numbers), 'f', -1, 64)
+	case "MIN":
+		if state.Count == 0 {
+			return ""
+		}
+		if !state.NonNumeric {
+			return state.MinNumText
+		}
+		return state.MinText
+	case "MAX":
+		if state.Count == 0 {
+			return ""
+		}
+		if !state.NonNumeric {
+			return state.MaxNumText
+		}
+		return state.MaxText
+	}
+	log.Fatalf("Unknown aggregate function %s", aggregate.Func)
+	return ""
+}
+
+// columns of the HAVING clause are numbers of group values
+func compilePredicate(spec *filterPredicate) *predicate {
+	compiled := &predicate{op: spec.Op, cmp: spec.Cmp}
+	if spec.Op == "regexp" {
+		regex, err := regexp.Compile(spec.Regex)
+		if err != nil {
+			log.Fatal("Error compiling regular expression:", err)
+		}
+		compiled.regex = regex
+	}
+	for _, arg := range spec.Args {
+		compiled.args = append(compiled.args, compilePredicate(arg))
+	}
+	for _, specOperand := range spec.Operands {
+		value := operand{kind: specOperand.Kind, value: specOperand.Value, column: -1}
+		switch specOperand.Kind {
+		case "column":
+			idx, err := strconv.Atoi(specOperand.Value)
+			if err != nil {
+				log.Fatalf("Invalid group value %s in predicate", specOperand.Value)
+			}
+			value.column = idx
+		case "number":
+			number, err := strconv.ParseFloat(specOperand.Value, 64)
+			if err != nil {
+				log.Fatalf("Invalid number %s in predicate", specOperand.Value)
+			}
+			value.number = number
+			value.isNumber = true
+		}
+		compiled.operands = append(compiled.operands, value)
+	}
+	return compiled
+}
+
+func evaluate(condition *predicate, line string, values []string) truth {
+	switch condition.op {
+	case "and":
+		result := TRUE
+		for _, arg := range condition.args {
+			switch evaluate(arg, line, values) {
+			case FALSE:
+				return FALSE
+			case UNKNOWN:
+				result = UNKNOWN
+			}
+		}
+		return result
+	case "or":
+		result := FALSE
+		for _, arg := range condition.args {
+			switch evaluate(arg, line, values) {
+			case TRUE:
+				return TRUE
+			case UNKNOWN:
+				result = UNKNOWN
+			}
+		}
+		return result
+	case "not":
+		switch evaluate(condition.args[0], line, values) {
+		case TRUE:
+			return FALSE
+		case FALSE:
+			return TRUE
+		}
+		return UNKNOWN
+	case "compare":
+		order, ok := compareOperands(condition.operands[0], condition.operands[1], line, values)
+		if !ok {
+			return UNKNOWN
+		}
+		return toTruth(matchesOrder(order, condition.cmp))
+	case "in":
+		result := FALSE
+		for _, item := range condition.operands[1:] {
+			order, ok := compareOperands(condition.operands[0], item, line, values)
+			if !ok {
+				result = UNKNOWN
+			} else if order == 0 {
+				return TRUE
+			}
+		}
+		return result
+	case "between":
+		low, lowOk := compareOperands(condition.operands[0], condition.operands[1], line, values)
+		high, highOk := compareOperands(condition.operands[0], condition.operands[2], line, values)
+		if (lowOk && low < 0) || (highOk && high > 0) {
+			return FALSE
+		}
+		if !lowOk || !highOk {
+			return UNKNOWN
+		}
+		return TRUE
+	case "is_null":
+		_, isNull := operandValue(condition.operands[0], line, values)
+		return toTruth(isNull)
+	case "regexp":
+		value, isNull := operandValue(condition.operands[0], line, values)
+		if isNull {
+			return UNKNOWN
+		}
+		return toTruth(condition.regex.MatchString(value))
+	}
+	log.Fatalf("Unknown predicate operation %s", condition.op)
+	return UNKNOWN
+}
+
+// fields are trimmed, an empty field is NULL
+func operandValue(value operand, line string, values []string) (string, bool) {
+	switch value.kind {
+	case "column":
+		if value.column >= len(values) {
+			log.Fatalf("Invalid group value %d in predicate", value.column)
+		}
+		field := strings.TrimSpace(values[value.column])
+		return field, len(field) == 0
+	case "line":
+		return line, false
+	case "null":
+		return "", true
+	}
+	return value.value, false
+}
+
+// compares numerically when either side is a number literal or both are numeric fields, as strings otherwise.
+// not ok when either side is NULL or a number is compared with a field that is not numeric
+func compareOperands(left operand, right operand, line string, values []string) (int, bool) {
+	leftValue, leftNull := operandValue(left, line, values)
+	rightValue, rightNull := operandValue(right, line, values)
+	if leftNull || rightNull {
+		return 0, false
+	}
+
+	leftNumber, leftErr := toNumber(left, leftValue)
+	rightNumber, rightErr := toNumber(right, rightValue)
+	if left.isNumber || right.isNumber {
+		if leftErr != nil || rightErr != nil {
+			return 0, false
+		}
+	} else if leftErr != nil || rightErr != nil || left.kind != "column" || right.kind != "column" {
+		return strings.Compare(leftValue, rightValue), true
+	}
+
+	switch {
+	case leftNumber < rightNumber:
+		return -1, true
+	case leftNumber > rightNumber:
+		return 1, true
+	}
+	return 0, true
+}
+
+func toNumber(value operand, field string) (float64, error) {
+	if value.isNumber {
+		return value.number, nil
+	}
+	return strconv.ParseFloat(field, 64)
+}
+
+func matchesOrder(order int, cmp string) bool {
+	switch cmp {
+	case "=":
+		return order == 0
+	case "<>":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case ">=":
+		return order >= 0
+	}
+	log.Fatalf("Unknown comparison operator %s", cmp)
+	return false
+}
+
+func toTruth(value bool) truth {
+	if value {
+		return TRUE
+	}
+	return FALSE
+}
+
+// first output rows under the ORDER BY of the query, keys index into the output columns
+type topNSpec struct {
+	Keys  []sortKeySpec
+	Limit int
+}
+
+type sortKeySpec struct {
+	ColumnIndex int
+	Numeric     bool
+	Descending  bool
+}
+
+type outputRow struct {
+	keys []string
+	row  []string
+}
+
+// first Limit rows in sort order, ties keep their output order
+func firstRows(rows []outputRow, spec *topNSpec) []outputRow {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for idx, key := range spec.Keys {
+			result := compareSortKeys(rows[i].keys[idx], rows[j].keys[idx], key.Numeric, key.Descending)
+			if result != 0 {
+				return result < 0
+			}
+		}
+		return false
+	})
+	if len(rows) > spec.Limit {
+		rows = rows[:spec.Limit]
+	}
+	return rows
+}
+
+func rowKeys(row []string, keys []sortKeySpec) []string {
+	values := make([]string, len(keys))
+	for idx, key := range keys {
+		if key.ColumnIndex < len(row) {
+			values[idx] = row[key.ColumnIndex]
+		}
+	}
+	return values
+}
+
+// same order as util.CompareSortKeys
+func compareSortKeys(a string, b string, numeric bool, descending bool) int {
+	result := 0
+	if numeric {
+		x, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		y, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		switch {
+		case errA == nil && errB == nil:
+			if x < y {
+				result = -1
+			} else if x > y {
+				result = 1
+			}
+		case errA == nil:
+			// after all numbers in either order
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			result = strings.Compare(a, b)
+		}
+	} else {
+		result = strings.Compare(a, b)
+	}
+
+	if descending {
+		return -result
+	}
+	return result
+}
+
+// output file of CSV rows, fields are quoted when they hold a comma, a quote or a line break
+type rowWriter struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func newRowWriter(filePath string) *rowWriter {
+	file, err := os.Create(filePath)
+	if err != nil {
+		log.Fatal("Error creating output file:", err)
+	}
+	return &rowWriter{file: file, writer: csv.NewWriter(file)}
+}
+
+func (this *rowWriter) write(row []string) {
+	var err error
+	if len(row) == 1 && len(row[0]) == 0 {
+		// a single empty field would be an empty line, which CSV readers skip
+		this.writer.Flush()
+		_, err = this.file.WriteString("\"\"\n")
+	} else {
+		err = this.writer.Write(row)
+	}
+	if err != nil {
+		log.Fatal("Error writing to output file:", err)
+	}
+}
+
+func (this *rowWriter) close() {
+	this.writer.Flush()
+	err := this.writer.Error()
+	if err != nil {
+		log.Fatal("Error writing to output file:", err)
+	}
+	this.file.Close()
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+/*
+
+an example to help understand the juice task:
+
+query is SELECT d1.name, d1.age, d2.country FROM d1, d2 WHERE d1.name = d2.id
+
+key for the current task is test
+content of the input file: (values)
+0,test,18
+1,US
+1,France
+
+the first field of a row is the position of the dataset in the FROM clause (0 for d1, 1 for d2), the
+others hold the columns the query needs from that dataset, in the order listed in the maple executable.
+if the column to join on is unique, the input file should only have two lines, one from d1 and one
+from d2. if it's not unique, multiple lines from each dataset will appear.
+
+Juice (key, values):
+	- separate values into two collections d1 and d2 based on the dataset position
+	- for i in d1:
+		for j in d2:
+			output(the selected columns of i and j)
+
+so for the example input above, the generated output will be
+test,18,US
+test,18,France
+
+without projection (SELECT ALL) whole lines of d1 and d2 are concatenated. Rows are read and written as
+CSV, fields holding a comma, a quote or a line break are quoted
+
+outer joins also output the lines of a key only found in the dataset they preserve (d1 for LEFT, d2 for
+RIGHT, both for FULL), with NULL (empty) columns in place of the other dataset
+
+joins of more tables run one join after another, d1 is then the result of the joins before
+
+*/
+
+// output column: the dataset position and the index of the column in the line emitted for that dataset
+type outputColumn struct {
+	Side  int
+	Index int
+}
+
+func main() {
+	log.SetOutput(os.Stderr)
+	homedir, _ := os.UserHomeDir()
+	nodeManagerFileDir := homedir + "/mr_node_manager/"
+
+	// define flags
+	// inner, left, right or full
+	joinType := "{{ .JoinType }}"
+	// number of columns of each dataset, pads unmatched lines without projection
+	columnCountsJson := "{{ .ColumnCountsJson }}"
+	projectionJson := "{{ .ProjectionJson }}"
+	inputFileFlag := flag.String("in", "", "Input filename")
+	outputFileFlag := flag.String("dest", "", "Output filename")
+	flag.Parse()
+
+
+	// check if required flags are provided
+	if *inputFileFlag == "" || *outputFileFlag == "" {
+		log.Fatal("Usage: go run join_juice.go -in <inputfile> -dest <outputfile>")
+	}
+
+	columnCounts := [2]int{}
+	err := json.Unmarshal([]byte(columnCountsJson), &columnCounts)
+	if err != nil {
+		log.Fatal("Invalid column counts:", err)
+	}
+	preserved := [2]bool{joinType == "left" || joinType == "full", joinType == "right" || joinType == "full"}
+
+	projection := []outputColumn{}
+	if len(projectionJson) > 0 {
+		err := json.Unmarshal([]byte(projectionJson), &projection)
+		if err != nil {
+			log.Fatal("Invalid projection:", err)
+		}
+	}
+
+	// rows of the first and the second dataset
+	datasetToRows := [2][][]string{}
+
+	// Read input file
+	file, err := os.Open(*inputFileFlag)
+	if err != nil {
+		log.Fatal("Error opening input file:", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	// process each row and populate datasetToRows
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal("Error reading input file:", err)
+		}
+		switch row[0] {
+		case "0":
+			datasetToRows[0] = append(datasetToRows[0], row[1:])
+		case "1":
+			datasetToRows[1] = append(datasetToRows[1], row[1:])
+		default:
+			log.Fatalf("Invalid dataset in row: %v", row)
+		}
+	}
+
+	// write output to file
+	writer := newRowWriter(nodeManagerFileDir + *outputFileFlag)
+
+	writeRow := func(row []string) {
+		writer.write(row)
+	}
+
+	// combine rows from d1 and d2, a key only found in one dataset is written if an outer join preserves it
+	switch {
+	case len(datasetToRows[0]) > 0 && len(datasetToRows[1]) > 0:
+		for _, i1 := range datasetToRows[0] {
+			for _, i2 := range datasetToRows[1] {
+				writeRow(joinRows(i1, i2, projection, columnCounts))
+			}
+		}
+	case len(datasetToRows[0]) > 0 && preserved[0]:
+		for _, i1 := range datasetToRows[0] {
+			writeRow(joinRows(i1, nil, projection, columnCounts))
+		}
+	case len(datasetToRows[1]) > 0 && preserved[1]:
+		for _, i2 := range datasetToRows[1] {
+			writeRow(joinRows(nil, i2, projection, columnCounts))
+		}
+	}
+	writer.close()
+
+	fmt.Println(*outputFileFlag)
+	os.Exit(0)
+}
+
+// a nil row is the missing side of an outer join, its columns are NULL
+func joinRows(row1 []string, row2 []string, projection []outputColumn, columnCounts [2]int) []string {
+	rows := [2][]string{row1, row2}
+	if len(projection) == 0 {
+		fields := make([]string, 0, columnCounts[0]+columnCounts[1])
+		for side, row := range rows {
+			if row == nil {
+				row = make([]string, columnCounts[side])
+			}
+			fields = append(fields, row...)
+		}
+		return fields
+	}
+
+	fields := make([]string, len(projection))
+	for i, column := range projection {
+		if column.Side < 0 || column.Side > 1 {
+			log.Fatalf("Invalid output column %d of dataset %d", column.Index, column.Side)
+		}
+		if rows[column.Side] == nil {
+			continue
+		}
+		if column.Index >= len(rows[column.Side]) {
+			log.Fatalf("Invalid output column %d of dataset %d", column.Index, column.Side)
+		}
+		fields[i] = rows[column.Side][column.Index]
+	}
+	return fields
+}
+
+
+// output file of CSV rows, fields are quoted when they hold a comma, a quote or a line break
+type rowWriter struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func newRowWriter(filePath string) *rowWriter {
+	file, err := os.Create(filePath)
+	if err != nil {
+		log.Fatal("Error creating output file:", err)
+	}
+	return &rowWriter{file: file, writer: csv.NewWriter(file)}
+}
+
+func (this *rowWriter) write(row []string) {
+	var err error
+	if len(row) == 1 && len(row[0]) == 0 {
+		// a single empty field would be an empty line, which CSV readers skip
+		this.writer.Flush()
+		_, err = this.file.WriteString("\"\"\n")
+	} else {
+		err = this.writer.Write(row)
+	}
+	if err != nil {
+		log.Fatal("Error writing to output file:", err)
+	}
+}
+
+func (this *rowWriter) close() {
+	this.writer.Flush()
+	err := this.writer.Error()
+	if err != nil {
+		log.Fatal("Error writing to output file:", err)
+	}
+	this.file.Close()
+}
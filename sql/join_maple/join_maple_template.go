@@ -1,14 +1,21 @@
 package main
 
 import (
-	"bufio"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"fmt"
 )
 
+// lines are keyed by the hex encoded values of the join columns, which may not be valid in file names.
+// Lines with a NULL (empty) join column never match, they are keyed null<side> so that outer joins
+// still output them. Every line is written as a CSV row of the side followed by the emitted columns
+
 
 func main() {
 	log.SetOutput(os.Stderr)
@@ -16,7 +23,16 @@ func main() {
 	nodeManagerFileDir := homedir + "/mr_node_manager/"
 
 	// define flags
-	joinColumn := "{{ .JoinColumn }}"
+	// columns the join key is combined from, matched in order with those of the other dataset
+	joinColumnsJson := "{{ .JoinColumnsJson }}"
+	// position of the dataset in the FROM clause, tells the juice task which side a line belongs to
+	side := "{{ .Side }}"
+	// names of the columns the query needs from this dataset, empty for whole lines
+	projectionJson := "{{ .ProjectionJson }}"
+	// skip, fail or error: rows that are not valid CSV or whose fields do not match the header are
+	// dropped, fail the task or are written to the side output <errorFilePrefix>-<partition>
+	malformedPolicy := "{{ .MalformedPolicy }}"
+	errorFilePrefix := "{{ .ErrorFilePrefix }}"
 	inputFileFlag := flag.String("in", "", "Input filename")
 	prefixFlag := flag.String("prefix", "", "SDFS intermediate filename prefix")
 	flag.Parse()
@@ -27,7 +43,7 @@ func main() {
 		log.Fatal("Usage: go run yourprogram.go -col <column_index> -in <inputfile> -prefix <sdfs_intermediate_filename_prefix>")
 	}
 
-	output := make(map[string]*os.File)
+	output := make(map[string]*rowWriter)
 
 	file, err := os.Open(*inputFileFlag)
 	if err != nil {
@@ -35,67 +51,105 @@ func main() {
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	partitionNumber := extractPartitionNumber(*inputFileFlag)
+	malformed := &malformedRows{
+		input:         filepath.Base(*inputFileFlag),
+		policy:        malformedPolicy,
+		errorFileDir:  nodeManagerFileDir,
+		errorFileName: fmt.Sprintf("%s-%s", errorFilePrefix, partitionNumber),
+	}
+	reader := newTableReader(file, malformed)
+	header := reader.header
 
-	if !scanner.Scan(){
-		log.Fatal("Empty input to join maple executable")
+	joinColumns := []string{}
+	err = json.Unmarshal([]byte(joinColumnsJson), &joinColumns)
+	if err != nil || len(joinColumns) == 0 {
+		log.Fatal("Invalid join columns:", err)
+	}
+	joinColumnIdx := []int{}
+	for _, columnName := range joinColumns {
+		idx := findColumnIndex(header, columnName)
+		if idx < 0 {
+			log.Fatalf("Unable to locate join column (%s) in input file header (%s)", columnName, strings.Join(header, ","))
+		}
+		joinColumnIdx = append(joinColumnIdx, idx)
 	}
-	header := scanner.Text()
 
-	joinColumnIdx := findColumnIndex(header, joinColumn)
-	if joinColumnIdx < 0 {
-		log.Fatal("Unable to locate column for filter operation in input file header")
+	// a dataset none of whose columns are selected emits only its side, still needed to find matches
+	projected := len(projectionJson) > 0
+	projection := []string{}
+	if projected {
+		err = json.Unmarshal([]byte(projectionJson), &projection)
+		if err != nil {
+			log.Fatal("Invalid projection:", err)
+		}
+	}
+	projectionIdx := []int{}
+	for _, columnName := range projection {
+		idx := findColumnIndex(header, columnName)
+		if idx < 0 {
+			log.Fatalf("Unable to locate column (%s) for projection in input file header (%s)", columnName, strings.Join(header, ","))
+		}
+		projectionIdx = append(projectionIdx, idx)
 	}
 
 
 	outputFiles := []string{}
 
-	// process the lines
-	for scanner.Scan() {
-		line := scanner.Text()
-		values := strings.Split(line, ",")
-
-		// check if the line has enough columns
-		if joinColumnIdx < len(values) {
-			key := values[joinColumnIdx]
-			key = strings.TrimSpace(key)
-
-			// create or retrieve file descriptor for the key
-			outputFile, exists := output[key]
-			if !exists {
-				outputFileName := fmt.Sprintf("%s-%s-%s.txt", *prefixFlag, extractPartitionNumber(*inputFileFlag), key)
-				outputFiles = append(outputFiles, outputFileName)
-				var err error
-				outputFile, err = os.Create(nodeManagerFileDir + outputFileName)
-				if err != nil {
-					log.Fatal("Error creating output file:", err)
-				}
-				output[key] = outputFile
-			}
+	// process the rows
+	for {
+		values, _, err := reader.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal("Error reading input file:", err)
+		}
+		key := joinKey(values, joinColumnIdx, side)
+
+		// create or retrieve the writer for the key
+		writer, exists := output[key]
+		if !exists {
+			outputFileName := fmt.Sprintf("%s-%s-%s.txt", *prefixFlag, partitionNumber, key)
+			outputFiles = append(outputFiles, outputFileName)
+			writer = newRowWriter(nodeManagerFileDir + outputFileName)
+			output[key] = writer
+		}
 
-			formattedValue := fmt.Sprintf("%s @ %s", *inputFileFlag, line)
-			_, err := outputFile.WriteString(formattedValue + "\n")
-			if err != nil {
-				log.Fatal("Error writing to output file:", err)
+		row := append([]string{side}, values...)
+		if projected {
+			row = []string{side}
+			for _, idx := range projectionIdx {
+				row = append(row, values[idx])
 			}
-		} else {
-			log.Fatalf("Column index %d out of bounds in line: %s\n", joinColumnIdx, line)
 		}
+		writer.write(row)
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatal("Error reading input file:", err)
+	// close all writers
+	for _, writer := range output {
+		writer.close()
 	}
-
-	// close all file descriptors
-	for _, file := range output {
-		file.Close()
+	if errorFileName := malformed.close(); len(errorFileName) > 0 {
+		outputFiles = append(outputFiles, errorFileName)
 	}
 
 	fmt.Println(strings.Join(outputFiles, ","))
 	os.Exit(0)
 }
 
+func joinKey(values []string, joinColumnIdx []int, side string) string {
+	keyValues := make([]string, len(joinColumnIdx))
+	for i, idx := range joinColumnIdx {
+		keyValues[i] = strings.TrimSpace(values[idx])
+		if len(keyValues[i]) == 0 {
+			return "null" + side
+		}
+	}
+	// values may contain commas, a NUL separator keeps composite keys apart
+	return hex.EncodeToString([]byte(strings.Join(keyValues, "\x00")))
+}
+
 func extractPartitionNumber(inputFileName string) string {
 	splitted := strings.Split(inputFileName, "-")
 	if (len(splitted)!=2){
@@ -106,24 +160,11 @@ func extractPartitionNumber(inputFileName string) string {
 }
 
 
-func findColumnIndex(header string, columnName string) int {
-	splitted := strings.Split(header, ",")
-	idx := 0
-	for _, field := range splitted {
-		field = strings.Trim(field, " \n\r")
+func findColumnIndex(header []string, columnName string) int {
+	for idx, field := range header {
 		if field == columnName {
 			return idx
 		}
-		idx++
 	}
 	return -1
 }
-
-func getFieldByIndex(line string, index int) string {
-	splitted := strings.Split(line, ",")
-	if index < len(splitted) {
-		return splitted[index]
-	}
-	log.Fatal("Invalid maple input file, mismatch between header and record field length")
-	return ""
-}
@@ -0,0 +1 @@
+../filter_maple/template_rows.go
\ No newline at end of file
@@ -0,0 +1,159 @@
+package util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// executables packaged as archives of a Go module, recognized by file name
+var archiveExecutableSuffixes = []string{".tar", ".tar.gz", ".tgz", ".zip"}
+
+var gzipMagic []byte = []byte{0x1f, 0x8b}
+
+func IsArchiveExecutable(fileName string) bool {
+	for _, suffix := range archiveExecutableSuffixes {
+		if strings.HasSuffix(fileName, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// bytes an archive may still unpack
+type extractLimit struct {
+	maxBytes  int64
+	remaining int64
+}
+
+// unpack a tar, gzipped tar or zip archive into destDir, the format is detected from the content.
+// Entries escaping destDir and links are rejected, and so are archives unpacking to more than maxBytes.
+func ExtractArchive(archivePath string, destDir string, maxBytes int64) error {
+	limit := &extractLimit{maxBytes: maxBytes, remaining: maxBytes}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return extractZip(archivePath, destDir, limit)
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		return extractTar(gzipReader, destDir, limit)
+	default:
+		return extractTar(reader, destDir, limit)
+	}
+}
+
+func extractTar(reader io.Reader, destDir string, limit *extractLimit) error {
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.New(fmt.Sprintf("Malformed tar archive: %s", err.Error()))
+		}
+
+		path, err := archiveEntryPath(destDir, header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeReg:
+			err = writeArchiveEntry(path, tarReader, os.FileMode(header.Mode), limit)
+		case tar.TypeSymlink, tar.TypeLink:
+			return errors.New(fmt.Sprintf("Links are not supported in executable archives: %s", header.Name))
+		default:
+			// pax headers and the like carry no files
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(archivePath string, destDir string, limit *extractLimit) error {
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Malformed zip archive: %s", err.Error()))
+	}
+	defer zipReader.Close()
+
+	for _, entry := range zipReader.File {
+		path, err := archiveEntryPath(destDir, entry.Name)
+		if err != nil {
+			return err
+		}
+		mode := entry.Mode()
+		if mode.IsDir() {
+			err = os.MkdirAll(path, 0755)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			return errors.New(fmt.Sprintf("Links are not supported in executable archives: %s", entry.Name))
+		}
+
+		src, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = writeArchiveEntry(path, src, mode, limit)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func archiveEntryPath(destDir string, name string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", errors.New(fmt.Sprintf("Archive entry escapes the workspace: %s", name))
+	}
+	return filepath.Join(destDir, cleaned), nil
+}
+
+func writeArchiveEntry(path string, content io.Reader, mode os.FileMode, limit *extractLimit) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// one byte past the limit tells that the archive is too large, sizes in headers are not trusted
+	written, err := io.Copy(file, io.LimitReader(content, limit.remaining+1))
+	limit.remaining -= written
+	if err == nil && limit.remaining < 0 {
+		err = errors.New(fmt.Sprintf("Executable archive unpacks to more than %d bytes", limit.maxBytes))
+	}
+	return err
+}
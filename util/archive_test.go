@@ -0,0 +1,37 @@
+package util
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveEntryPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // path under the workspace, empty if the entry is rejected
+	}{
+		{"main.go", "main.go"},
+		{"cmd/tool/main.go", "cmd/tool/main.go"},
+		{"./a/../b.go", "b.go"},
+		{"a/", "a"},
+		{"..a/b.go", "..a/b.go"},
+		{"../b.go", ""},
+		{"a/../../b.go", ""},
+		{"..", ""},
+		{"/etc/passwd", ""},
+	}
+	destDir := filepath.FromSlash("/tmp/workspace")
+	for _, test := range tests {
+		path, err := archiveEntryPath(destDir, test.name)
+		if len(test.want) == 0 {
+			if err == nil {
+				t.Errorf("archiveEntryPath(%q) = %q, want an error", test.name, path)
+			}
+			continue
+		}
+		want := filepath.Join(destDir, filepath.FromSlash(test.want))
+		if err != nil || path != want {
+			t.Errorf("archiveEntryPath(%q) = %q, %v, want %q", test.name, path, err, want)
+		}
+	}
+}
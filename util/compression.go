@@ -0,0 +1,141 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// optional compression of files written to SDFS by maple and juice tasks. The codec is recorded in the
+// SDFS metadata of each file when it is written and fetches decompress by it, a file is never taken for
+// compressed by its content
+
+const (
+	COMPRESSION_NONE string = "none"
+	COMPRESSION_GZIP string = "gzip"
+)
+
+func ValidateCompression(codec string) error {
+	switch codec {
+	case "", COMPRESSION_NONE, COMPRESSION_GZIP:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Unsupported compression codec (%s)", codec))
+}
+
+func IsCompressionEnabled(codec string) bool {
+	return len(codec) > 0 && codec != COMPRESSION_NONE
+}
+
+// compress a file in place with the given codec
+func CompressFile(filePath string, codec string) error {
+	if !IsCompressionEnabled(codec) {
+		return nil
+	}
+	if codec != COMPRESSION_GZIP {
+		return ValidateCompression(codec)
+	}
+
+	src, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpFilePath := filePath + ".compressing"
+	dest, err := os.Create(tmpFilePath)
+	if err != nil {
+		return err
+	}
+
+	writer := gzip.NewWriter(dest)
+	_, err = io.Copy(writer, src)
+	if err == nil {
+		err = writer.Close()
+	}
+	closeErr := dest.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFilePath)
+		return err
+	}
+	return os.Rename(tmpFilePath, filePath)
+}
+
+// decompress a file in place with the given codec
+func DecompressFile(filePath string, codec string) error {
+	if !IsCompressionEnabled(codec) {
+		return nil
+	}
+
+	tmpFilePath := filePath + ".decompressing"
+	os.Remove(tmpFilePath)
+	err := AppendDecompressed(filePath, tmpFilePath, codec)
+	if err != nil {
+		os.Remove(tmpFilePath)
+		return err
+	}
+	return os.Rename(tmpFilePath, filePath)
+}
+
+// append a file compressed with the given codec to the destination file, decompressing it first
+func AppendDecompressed(srcFilePath string, destFilePath string, codec string) error {
+	err := ValidateCompression(codec)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(srcFilePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dest, err := os.OpenFile(destFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	if !IsCompressionEnabled(codec) {
+		_, err = io.Copy(dest, src)
+		return err
+	}
+
+	gzipReader, err := gzip.NewReader(bufio.NewReader(src))
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+	_, err = io.Copy(dest, gzipReader)
+	return err
+}
+
+// decompress the first bytes of a file compressed with the given codec, as much of them as can be
+// decompressed is returned
+func DecompressHead(head []byte, codec string) ([]byte, error) {
+	if !IsCompressionEnabled(codec) {
+		return head, nil
+	}
+	err := ValidateCompression(codec)
+	if err != nil {
+		return nil, err
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(head))
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
+	content, err := io.ReadAll(gzipReader)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return content, nil
+}
@@ -0,0 +1,153 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cron style schedules of recurring jobs, in local time of the leader:
+//   <minute> <hour> <day_of_month> <month> <day_of_week>
+// each field is *, a number, a range a-b, a step */n or a-b/n, or a comma separated list of those,
+// day of week 0 and 7 are sunday. @hourly, @daily, @weekly and @monthly are accepted as well.
+// As in cron, a run matches either day field when both day of month and day of week are restricted.
+
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+type CronSchedule struct {
+	minutes     []bool
+	hours       []bool
+	daysOfMonth []bool
+	months      []bool
+	daysOfWeek  []bool
+	domStar     bool
+	dowStar     bool
+}
+
+func ParseCronSchedule(spec string) (*CronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, exists := cronMacros[spec]; exists {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.New(fmt.Sprintf("Invalid cron schedule (%s), expecting 5 fields", spec))
+	}
+
+	schedule := &CronSchedule{
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+	var err error
+	bounds := []struct {
+		field *[]bool
+		min   int
+		max   int
+		name  string
+	}{
+		{&schedule.minutes, 0, 59, "minute"},
+		{&schedule.hours, 0, 23, "hour"},
+		{&schedule.daysOfMonth, 1, 31, "day of month"},
+		{&schedule.months, 1, 12, "month"},
+		{&schedule.daysOfWeek, 0, 7, "day of week"},
+	}
+	for idx, bound := range bounds {
+		*bound.field, err = parseCronField(fields[idx], bound.min, bound.max)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid %s field (%s) in cron schedule: %s", bound.name, fields[idx], err.Error()))
+		}
+	}
+	// sunday is both 0 and 7
+	if schedule.daysOfWeek[7] {
+		schedule.daysOfWeek[0] = true
+	}
+	return schedule, nil
+}
+
+// set of allowed values indexed by value
+func parseCronField(field string, min int, max int) ([]bool, error) {
+	allowed := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[idx+1:])
+			if err != nil || step <= 0 {
+				return nil, errors.New("invalid step")
+			}
+			rangePart = part[:idx]
+		}
+
+		low, high := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			low, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, errors.New("invalid value")
+			}
+			high = low
+			if len(bounds) == 2 {
+				high, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, errors.New("invalid range")
+				}
+			} else if step > 1 {
+				// n/step runs from n to the maximum
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return nil, errors.New("value out of range")
+		}
+
+		for value := low; value <= high; value += step {
+			allowed[value] = true
+		}
+	}
+	return allowed, nil
+}
+
+// first run strictly after the given time, zero time if none is found within five years
+func (this *CronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !this.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !this.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !this.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !this.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (this *CronSchedule) matchDay(t time.Time) bool {
+	domMatch := this.daysOfMonth[t.Day()]
+	dowMatch := this.daysOfWeek[int(t.Weekday())]
+	if !this.domStar && !this.dowStar {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	tests := []struct {
+		spec  string
+		valid bool
+	}{
+		{"* * * * *", true},
+		{"*/15 9-17 * * 1-5", true},
+		{"0 0 1,15 * 7", true},
+		{"5/10 * * * *", true},
+		{" @daily ", true},
+		{"* * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"a * * * *", false},
+		{"@yearly", false},
+	}
+	for _, test := range tests {
+		_, err := ParseCronSchedule(test.spec)
+		if (err == nil) != test.valid {
+			t.Errorf("ParseCronSchedule(%q) returned error %v, want valid %t", test.spec, err, test.valid)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	tests := []struct {
+		spec  string
+		after string
+		want  string
+	}{
+		{"* * * * *", "2024-03-10 12:30:45", "2024-03-10 12:31:00"},
+		{"@hourly", "2024-03-10 12:00:00", "2024-03-10 13:00:00"},
+		{"@daily", "2024-12-31 23:59:00", "2025-01-01 00:00:00"},
+		{"@monthly", "2024-01-31 10:00:00", "2024-02-01 00:00:00"},
+		{"*/15 9-17 * * 1-5", "2024-03-08 17:45:00", "2024-03-11 09:00:00"},
+		{"5/20 * * * *", "2024-03-10 12:46:00", "2024-03-10 13:05:00"},
+		{"30 2 29 2 *", "2024-03-01 00:00:00", "2028-02-29 02:30:00"},
+		// sunday as 7, and either day field matches when both are restricted
+		{"0 0 * * 7", "2024-03-10 00:00:00", "2024-03-17 00:00:00"},
+		{"0 0 13 * 5", "2024-03-02 00:00:00", "2024-03-08 00:00:00"},
+		{"0 0 31 2 *", "2024-01-01 00:00:00", ""},
+	}
+	for _, test := range tests {
+		schedule, err := ParseCronSchedule(test.spec)
+		if err != nil {
+			t.Errorf("ParseCronSchedule(%q) failed: %s", test.spec, err)
+			continue
+		}
+		next := schedule.Next(at(test.after))
+		if len(test.want) == 0 {
+			if !next.IsZero() {
+				t.Errorf("next run of %q after %s is %s, want none", test.spec, test.after, next)
+			}
+			continue
+		}
+		if !next.Equal(at(test.want)) {
+			t.Errorf("next run of %q after %s is %s, want %s", test.spec, test.after, next, test.want)
+		}
+	}
+}
@@ -32,6 +32,8 @@ type FileInfo struct {
 	IsMaster bool
 	FileStatus int 
 	Version  int
+	Size     int64 // bytes of the replica, refreshed whenever the file server reports metadata
+	Codec    string // compression codec the file was written with by a job, uncompressed if empty
 }
 
 // replica cluster info for a file
@@ -90,6 +92,8 @@ func CompileReports(reports *[]FileServerMetadataReport, blacklist *map[string]b
 				IsMaster: fileInfo.IsMaster,
 				FileStatus: fileInfo.FileStatus,
 				Version: fileInfo.Version,
+				Size: fileInfo.Size,
+				Codec: fileInfo.Codec,
 			}
 
 			_, ok = fileNameToCluster[fileName]
@@ -107,6 +111,8 @@ func CompileReports(reports *[]FileServerMetadataReport, blacklist *map[string]b
 					IsMaster: fileInfo.IsMaster,
 					FileStatus: fileInfo.FileStatus,
 					Version: fileInfo.Version,
+					Size: fileInfo.Size,
+					Codec: fileInfo.Codec,
 				}
 			} else {
 				servants := entry.Servants
@@ -116,6 +122,8 @@ func CompileReports(reports *[]FileServerMetadataReport, blacklist *map[string]b
 					IsMaster: fileInfo.IsMaster,
 					FileStatus: fileInfo.FileStatus,
 					Version: fileInfo.Version,
+					Size: fileInfo.Size,
+					Codec: fileInfo.Codec,
 				})
 				entry.Servants = servants
 			}
@@ -33,6 +33,17 @@ func EmptyFolder(folderPath string) error {
 	return nil
 }
 
+// list names of the files in a folder
+func ListFolder(folderPath string) ([]string, error) {
+	dir, err := os.Open(folderPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	return dir.Readdirnames(-1)
+}
+
 func DeleteFile(filename string, sdfsFolder string) error {
 	filePath := sdfsFolder + filename
 	err := os.Remove(filePath)
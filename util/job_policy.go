@@ -0,0 +1,137 @@
+package util
+
+import (
+	"maple-juice/config"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	// task failure reasons, carried in error messages as [<reason>] since rpc only transmits error strings
+	TASK_FAILURE_WORKER     string = "WORKER_FAILURE"     // worker died, connection lost or SDFS transfer failed
+	TASK_FAILURE_TIMEOUT    string = "TIMEOUT"            // task or executable exceeded its time limit
+	TASK_FAILURE_EXECUTABLE string = "EXECUTABLE_FAILURE" // executable exited non-zero or produced invalid output
+
+	// sandbox limit violations
+	TASK_FAILURE_MEMORY_LIMIT    string = "MEMORY_LIMIT"
+	TASK_FAILURE_CPU_LIMIT       string = "CPU_LIMIT"
+	TASK_FAILURE_FILE_SIZE_LIMIT string = "FILE_SIZE_LIMIT"
+	TASK_FAILURE_PROCESS_LIMIT   string = "PROCESS_LIMIT"
+)
+
+var taskFailureReasonRegex = regexp.MustCompile(`\[([A-Z_]+)\]`)
+
+// per job timeouts and retry policy, unset fields fall back to config defaults
+type JobPolicy struct {
+	JobTimeoutMinutes       int // how long a job submission waits for completion
+	TaskTimeoutMinutes      int // per task attempt, including input fetching and output uploading
+	ExecutionTimeoutMinutes int // wall clock limit for executables run by worker nodes
+	MaxRetryNum             int // negative means unset
+	RetryBackoffBaseMillis  int
+	RetryBackoffMaxMillis   int
+	Limits                  ResourceLimits
+}
+
+// resource limits of each executable run, 0 means unlimited
+type ResourceLimits struct {
+	MemoryLimitMB   int // negative means unset
+	CpuLimitSeconds int
+	FileSizeLimitMB int
+	ProcessLimit    int
+}
+
+func (this *ResourceLimits) IsLimited() bool {
+	return this.MemoryLimitMB > 0 || this.CpuLimitSeconds > 0 || this.FileSizeLimitMB > 0 || this.ProcessLimit > 0
+}
+
+func NewJobPolicy() JobPolicy {
+	return JobPolicy{
+		MaxRetryNum: -1,
+		Limits: ResourceLimits{
+			MemoryLimitMB:   -1,
+			CpuLimitSeconds: -1,
+			FileSizeLimitMB: -1,
+			ProcessLimit:    -1,
+		},
+	}
+}
+
+func (this *JobPolicy) ApplyDefaults(isMaple bool) {
+	if this.JobTimeoutMinutes <= 0 {
+		this.JobTimeoutMinutes = config.JobTimeoutMinutes
+	}
+	if this.TaskTimeoutMinutes <= 0 {
+		if isMaple {
+			this.TaskTimeoutMinutes = config.MapleTaskTimeoutMinutes
+		} else {
+			this.TaskTimeoutMinutes = config.JuiceTaskTimeoutMinutes
+		}
+	}
+	if this.ExecutionTimeoutMinutes <= 0 {
+		this.ExecutionTimeoutMinutes = config.ExecutionTimeoutMinutes
+	}
+	if this.MaxRetryNum < 0 {
+		this.MaxRetryNum = config.TaskMaxRetryNum
+	}
+	if this.RetryBackoffBaseMillis <= 0 {
+		this.RetryBackoffBaseMillis = config.RetryBackoffBaseMillis
+	}
+	if this.RetryBackoffMaxMillis <= 0 {
+		this.RetryBackoffMaxMillis = config.RetryBackoffMaxMillis
+	}
+	this.Limits.ApplyDefaults()
+}
+
+func (this *ResourceLimits) ApplyDefaults() {
+	if this.MemoryLimitMB < 0 {
+		this.MemoryLimitMB = config.TaskMemoryLimitMB
+	}
+	if this.CpuLimitSeconds < 0 {
+		this.CpuLimitSeconds = config.TaskCpuLimitSeconds
+	}
+	if this.FileSizeLimitMB < 0 {
+		this.FileSizeLimitMB = config.TaskFileSizeLimitMB
+	}
+	if this.ProcessLimit < 0 {
+		this.ProcessLimit = config.TaskProcessLimit
+	}
+}
+
+// exponential backoff before the given retry, retryNum starts from 1
+func (this *JobPolicy) RetryBackoff(retryNum int) time.Duration {
+	backoff := this.RetryBackoffBaseMillis
+	for i := 1; i < retryNum && backoff < this.RetryBackoffMaxMillis; i++ {
+		backoff *= 2
+	}
+	if backoff > this.RetryBackoffMaxMillis {
+		backoff = this.RetryBackoffMaxMillis
+	}
+	return time.Duration(backoff) * time.Millisecond
+}
+
+func NewTaskFailure(reason string, msg string) error {
+	return errors.New(fmt.Sprintf("[%s] %s", reason, msg))
+}
+
+// extract failure reason from a task error, errors without a reason are treated as worker failures
+func GetTaskFailureReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	matched := taskFailureReasonRegex.FindStringSubmatch(err.Error())
+	if matched == nil {
+		return TASK_FAILURE_WORKER
+	}
+	return matched[1]
+}
+
+// failures caused by the executable itself will happen again on any worker
+func IsRetryableTaskFailure(err error) bool {
+	switch GetTaskFailureReason(err) {
+	case TASK_FAILURE_EXECUTABLE, TASK_FAILURE_MEMORY_LIMIT, TASK_FAILURE_CPU_LIMIT, TASK_FAILURE_FILE_SIZE_LIMIT, TASK_FAILURE_PROCESS_LIMIT:
+		return false
+	}
+	return true
+}
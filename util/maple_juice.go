@@ -2,37 +2,150 @@ package util
 
 import (
 	"bufio"
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
+	"strings"
 	"sync"
+	"time"
 )
 
-var lineCountFileBuf []byte = make([]byte, 1024)
-
 type JobRequest struct {
+	JobId        int32
 	IsMaple      bool
-	ErrorMsgChan chan error
+	IsIterative  bool // run IterativeJob instead of a single maple or juice job
+	IsSort       bool // run SortJob instead of a single maple or juice job
+	Policy       JobPolicy
+	Hook         CompletionHook
+	ErrorMsgChan chan error `json:"-"`
 	MapleJob     MapleJobRequest
 	JuiceJob     JuiceJobRequest
+	IterativeJob IterativeJobRequest
+	SortJob      SortJobRequest
 }
 
 type MapleJobRequest struct {
 	ExcecutableFileName string
-	TaskNum             int
+	TaskNum             int      // TASK_NUM_AUTO picks the number from the input size
+	SplitSizeMB         int      // target input split size in auto mode, config default if not positive
 	SrcSdfsFileName     string
 	OutputFilePrefix    string
 	PreserveInputHeader bool
+	OutputFileName      string // map-only job: final SDFS output name, juice phase is skipped when set
+	OutputFileNum       int    // map-only job: number of output files, one per maple task
+	CacheFiles          []string // SDFS files shipped to every node running a task of this job
+	InputFormat         string   // record format spec of the input file, newline delimited if empty
+	Compression         string   // codec compressing output files written to SDFS, uncompressed if empty
 }
 
 type JuiceJobRequest struct {
 	ExcecutableFileName string
-	TaskNum             int
+	TaskNum             int      // TASK_NUM_AUTO picks the number from workers and intermediate data size
 	SrcSdfsFilePrefix   string
 	OutputFileName      string
 	DeleteInput         bool
 	IsHashPartition     bool 	// partition by hash or by range
+	CacheFiles          []string // SDFS files shipped to every node running a task of this job
+	Compression         string   // codec compressing output files written to SDFS, uncompressed if empty
+}
+
+// a maple / juice pair run repeatedly, each iteration reads the previous iteration's output
+// MapleJob.SrcSdfsFileName is the input of the first iteration, JuiceJob.OutputFileName receives the final output
+type IterativeJobRequest struct {
+	MapleJob              MapleJobRequest
+	JuiceJob              JuiceJobRequest
+	MaxIterations         int
+	ConvergenceExecutable string // SDFS executable comparing two consecutive outputs, optional
+	ConvergenceCounter    string // user counter checked against ConvergenceThreshold, optional
+	ConvergenceThreshold  int64  // converged once the counter of an iteration is at most the threshold
+	KeepIterations        int    // number of iteration outputs kept in SDFS, 0 keeps all
+	JuicePolicy           JobPolicy // policy of juice phases, the job policy applies to maple phases
+}
+
+// total-order sort of a line based input: records are range partitioned by split points sampled from the input
+// and each range is sorted, outputs <OutputFileName>-part<n> are in global order when concatenated by name
+type SortJobRequest struct {
+	SrcSdfsFileName   string
+	SrcSdfsFilePrefix string // sorts the SDFS files <prefix>-* concatenated in name order instead of SrcSdfsFileName
+	OutputFileName    string
+	OutputFileNum     int
+	HasHeader         bool      // the header line is kept at the top of the first output file
+	Keys              []SortKey // the whole line by string if empty
+	Delimiter         string
+	Limit             int       // only the first Limit records are kept, in the first output file; 0 keeps all
+	SampleSize        int       // number of sort keys sampled to pick the split points
+	MapleTaskNum      int       // TASK_NUM_AUTO picks the number from the input size
+	JuiceTaskNum      int       // ranges are split evenly among juice tasks
+	JuicePolicy       JobPolicy // policy of the juice phase, the job policy applies to the maple phase
+}
+
+// called by the job manager once a job succeeds, fails or is cancelled, both fields are optional
+type CompletionHook struct {
+	Url         string // receives the completion payload as an HTTP POST
+	Command     string // name of a command in the hooks folder of the submitting node, run with the completion payload on stdin
+	SubmitterIp string // set by the leader from the connection the job was submitted on, never by the client
+}
+
+// JSON body delivered to completion hooks
+type JobCompletionPayload struct {
+	JobId        int32            `json:"job_id"`
+	Status       string           `json:"status"`
+	OutputPrefix string           `json:"output_prefix"`
+	Counters     map[string]int64 `json:"counters"`
+	Error        string           `json:"error,omitempty"`
+}
+
+// arguments of running a command hook on the submitting node
+type CompletionHookArg struct {
+	Command string
+	Payload []byte
+}
+
+func (this *CompletionHook) IsSet() bool {
+	return len(this.Url) > 0 || len(this.Command) > 0
+}
+
+// command hooks are plain file names, so that only commands installed in the hooks folder can be run
+func IsValidHookName(name string) bool {
+	return len(name) > 0 && name != "." && name != ".." && !strings.ContainsAny(name, "/\\")
+}
+
+const (
+	// what a schedule does when a run is due while its previous run is still going
+	SCHEDULE_OVERLAP_SKIP  string = "skip"
+	SCHEDULE_OVERLAP_QUEUE string = "queue" // run once more right after the previous run, further runs are skipped
+)
+
+// recurring job submitted by the job manager on a cron schedule
+type ScheduledJob struct {
+	Name    string
+	Cron    string
+	Overlap string
+	Command string // job command as typed by the user
+	Request JobRequest
+}
+
+// state of a schedule reported by the job manager
+type ScheduleStatus struct {
+	Name       string
+	Cron       string
+	Overlap    string
+	Command    string
+	NextRun    time.Time
+	LastRun    time.Time // zero if the schedule never ran since the current leader took over
+	LastJobId  int32
+	LastStatus string
+	Running    bool
+	Queued     bool
+}
+
+// reply of a successful task
+type TaskResult struct {
+	Counters    map[string]int64 // user counters reported by executables
+	InputBytes  int64            // total size of the task input
+	OutputBytes int64            // total size of the files written to SDFS
 }
 
 type SimpleJobQueue struct {
@@ -45,6 +158,16 @@ type MapleTaskArg struct {
 	TransmissionId      string
 	ExcecutableFileName string
 	OutputFilePrefix    string
+	OutputFileName      string // map-only task: concat all outputs into this SDFS file
+	InputFormat         string
+	LeaderId            string // node id of the job manager, job ids restart with every leader
+	JobId               int32
+	TaskNumber          int
+	Attempt             int
+	CacheFiles          []string
+	Compression         string
+	ExecutionTimeoutMinutes int
+	Limits              ResourceLimits
 }
 
 type JuiceTaskArg struct {
@@ -52,6 +175,20 @@ type JuiceTaskArg struct {
 	KeyToFileNames      map[string][]string		// each key might have multiple file partitions
 	ExcecutableFileName string
 	OutputFilePrefix    string
+	LeaderId            string
+	JobId               int32
+	TaskNumber          int
+	Attempt             int
+	CacheFiles          []string
+	Compression         string
+	ExecutionTimeoutMinutes int
+	Limits              ResourceLimits
+}
+
+// job whose distributed cache files and workspaces are released on the worker nodes
+type JobCacheArg struct {
+	LeaderId string
+	JobId    int32
 }
 
 func NewQueue() *SimpleJobQueue {
@@ -78,8 +215,7 @@ func (this *SimpleJobQueue) Pop() *JobRequest {
 	return &ret
 }
 
-func GetFileLineCount(filePath string) (int, error) {
-
+func CountRecords(filePath string, format RecordFormat) (int, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return 0, err
@@ -87,46 +223,132 @@ func GetFileLineCount(filePath string) (int, error) {
 	defer file.Close()
 
 	count := 0
-	lineSep := []byte{'\n'}
-
+	reader := NewRecordReader(file, format)
 	for {
-		c, err := file.Read(lineCountFileBuf)
-		count += bytes.Count(lineCountFileBuf[:c], lineSep)
-
-		switch {
-		case err == io.EOF:
+		_, err := reader.Next()
+		if err == io.EOF {
 			return count, nil
-
-		case err != nil:
+		}
+		if err != nil {
 			return count, err
 		}
+		count += 1
 	}
 }
 
-// caveat: need to resize buffer with lines over 64K
-func PartitionFile(scanner *bufio.Scanner, lineNum int, outputFilePath string, header string) error {
+// write the next recordNum records to a new file, each followed by the format's terminator
+func PartitionRecords(reader RecordReader, recordNum int, outputFilePath string, header []byte, format RecordFormat) error {
 	outputFile, err := os.Create(outputFilePath)
 	if err != nil {
 		return err
 	}
 	defer outputFile.Close()
 
-	if len(header) > 0 {
-		outputFile.WriteString(header + "\n")
+	writer := bufio.NewWriter(outputFile)
+	terminator := format.Terminator()
+
+	if header != nil {
+		writer.Write(header)
+		writer.Write(terminator)
 	}
-	
-	for lineNum > 0 {
-		if !scanner.Scan() {
-			return scanner.Err()
+
+	for recordNum > 0 {
+		record, err := reader.Next()
+		if err != nil {
+			return err
 		}
-		lineNum -= 1
-		line := scanner.Text() + "\n"
-		_, err := outputFile.WriteString(line)
+		recordNum -= 1
+		writer.Write(record)
+		_, err = writer.Write(terminator)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	return writer.Flush()
+}
+
+// split a maple input file into at most taskNum partitions of whole records under outputDir,
+// the header record (if any) is repeated at the top of every partition
+// returns the actual number of partitions created
+func PartitionMapleInput(inputFilePath string, srcFileName string, taskNum int, preserveHeader bool, formatSpec string, outputDir string) (int, error) {
+	format, err := ParseRecordFormat(formatSpec)
+	if err != nil {
+		return 0, err
+	}
+
+	recordCount, err := CountRecords(inputFilePath, format)
+	if err != nil {
+		return 0, err
+	}
+
+	if preserveHeader {
+		// ignore header record
+		log.Printf("Input file carries header")
+		recordCount -= 1
+	}
+
+	if recordCount <= 0 {
+		return 0, errors.New("Maple input file contains zero data records")
+	}
+
+	log.Printf("Maple input file %s contains %d data records", srcFileName, recordCount)
+
+	// this should never happen
+	if recordCount < taskNum {
+		log.Print("WARN: Maple input file contains less records than the number of tasks, auto reducing task number...")
+		taskNum = recordCount
+	}
+
+	recordsPerWorker := recordCount / taskNum
+	remainder := recordCount % taskNum
+	file, err := os.Open(inputFilePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := NewRecordReader(file, format)
+	var header []byte = nil
+
+	if preserveHeader {
+		header, err = reader.Next()
+		if err != nil {
+			return 0, errors.New("Empty input file")
+		}
+	}
+
+	for taskNumber := 0; taskNumber < taskNum; taskNumber++ {
+		recordNum := recordsPerWorker
+		if remainder > 0 {
+			recordNum += 1
+			remainder -= 1
+		}
+		partitionName := FmtMapleInputPartitionName(srcFileName, taskNumber)
+		err := PartitionRecords(reader, recordNum, outputDir+partitionName, header, format)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return taskNum, nil
+}
+
+func (this *MapleJobRequest) IsMapOnly() bool {
+	return len(this.OutputFileName) > 0
+}
+
+// output file of a map-only maple task, files are fetched together with the output name as prefix,
+// zero padded so that name order is task order
+func FmtMapOnlyOutputName(outputFileName string, taskId int) string {
+	return fmt.Sprintf("%s-%05d", outputFileName, taskId)
+}
+
+// maple outputs named side_output_* are uploaded to SDFS under their name, map-only tasks do not
+// combine them into their output file. The name must not match the intermediate files of the job
+const MAPLE_SIDE_OUTPUT_PREFIX string = "side_output_"
+
+func IsMapleSideOutput(fileName string) bool {
+	return strings.HasPrefix(fileName, MAPLE_SIDE_OUTPUT_PREFIX)
 }
 
 func FmtMapleInputPartitionName(fileName string, taskId int) string {
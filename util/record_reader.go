@@ -0,0 +1,265 @@
+package util
+
+// record readers for maple input formats, records can be of any size
+// this file only depends on the standard library: it is also compiled into maple executables
+// of jobs with an input format, which read the format spec from the MJ_RECORD_FORMAT environment variable.
+// There every top-level name is prefixed with mj, see RecordReaderSource
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	RECORD_FORMAT_ENV_VAR string = "MJ_RECORD_FORMAT"
+
+	RECORD_FORMAT_LINES     string = "lines"  // newline delimited text, the default
+	RECORD_FORMAT_CSV       string = "csv"    // RFC 4180, quoted fields may contain newlines
+	RECORD_FORMAT_JSONL     string = "jsonl"  // one JSON value per line, blank lines are skipped
+	RECORD_FORMAT_FIXED     string = "fixed"  // fixed:<length> records of exactly length bytes
+	RECORD_FORMAT_DELIMITED string = "delim"  // delim:<separator> records ending with a custom separator
+)
+
+type RecordReader interface {
+	// next record without its terminator, io.EOF once all records are read
+	Next() ([]byte, error)
+}
+
+type RecordFormat struct {
+	Kind      string
+	Length    int    // fixed length records
+	Delimiter []byte // custom delimiter records
+}
+
+// lines | csv | jsonl | fixed:<length> | delim:<separator>
+// separator accepts Go escapes, e.g. delim:\x1e or delim:\t
+func ParseRecordFormat(spec string) (RecordFormat, error) {
+	kind, arg, hasArg := strings.Cut(spec, ":")
+	switch kind {
+	case "", RECORD_FORMAT_LINES, RECORD_FORMAT_CSV, RECORD_FORMAT_JSONL:
+		if hasArg {
+			return RecordFormat{}, errors.New(fmt.Sprintf("Record format %s takes no argument", kind))
+		}
+		if len(kind) == 0 {
+			kind = RECORD_FORMAT_LINES
+		}
+		return RecordFormat{Kind: kind}, nil
+
+	case RECORD_FORMAT_FIXED:
+		length, err := strconv.Atoi(arg)
+		if err != nil || length <= 0 {
+			return RecordFormat{}, errors.New(fmt.Sprintf("Invalid record length (%s)", arg))
+		}
+		return RecordFormat{Kind: kind, Length: length}, nil
+
+	case RECORD_FORMAT_DELIMITED:
+		delimiter, err := strconv.Unquote("\"" + arg + "\"")
+		if err != nil || len(delimiter) == 0 {
+			return RecordFormat{}, errors.New(fmt.Sprintf("Invalid record delimiter (%s)", arg))
+		}
+		return RecordFormat{Kind: kind, Delimiter: []byte(delimiter)}, nil
+	}
+	return RecordFormat{}, errors.New(fmt.Sprintf("Unsupported record format (%s)", spec))
+}
+
+func (this RecordFormat) String() string {
+	switch this.Kind {
+	case RECORD_FORMAT_FIXED:
+		return fmt.Sprintf("%s:%d", this.Kind, this.Length)
+	case RECORD_FORMAT_DELIMITED:
+		quoted := strconv.Quote(string(this.Delimiter))
+		return fmt.Sprintf("%s:%s", this.Kind, quoted[1:len(quoted)-1])
+	}
+	return this.Kind
+}
+
+// written after every record when records are written back to a file
+func (this RecordFormat) Terminator() []byte {
+	switch this.Kind {
+	case RECORD_FORMAT_FIXED:
+		return nil
+	case RECORD_FORMAT_DELIMITED:
+		return this.Delimiter
+	}
+	return []byte{'\n'}
+}
+
+func NewRecordReader(reader io.Reader, format RecordFormat) RecordReader {
+	bufReader := bufio.NewReader(reader)
+	switch format.Kind {
+	case RECORD_FORMAT_CSV:
+		return &csvRecordReader{reader: bufReader}
+	case RECORD_FORMAT_JSONL:
+		return &jsonLinesRecordReader{reader: bufReader}
+	case RECORD_FORMAT_FIXED:
+		return &fixedLengthRecordReader{reader: bufReader, length: format.Length}
+	case RECORD_FORMAT_DELIMITED:
+		return &delimitedRecordReader{reader: bufReader, delimiter: format.Delimiter}
+	}
+	return &delimitedRecordReader{reader: bufReader, delimiter: []byte{'\n'}, trimCR: true}
+}
+
+// records end with a delimiter, the last record may omit it
+type delimitedRecordReader struct {
+	reader    *bufio.Reader
+	delimiter []byte
+	trimCR    bool // accept \r\n line endings
+}
+
+func (this *delimitedRecordReader) Next() ([]byte, error) {
+	last := this.delimiter[len(this.delimiter)-1]
+	record := make([]byte, 0)
+	for {
+		chunk, err := this.reader.ReadBytes(last)
+		record = append(record, chunk...)
+		if err == io.EOF {
+			if len(record) == 0 {
+				return nil, io.EOF
+			}
+			return record, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasSuffix(record, this.delimiter) {
+			record = record[:len(record)-len(this.delimiter)]
+			if this.trimCR {
+				record = bytes.TrimSuffix(record, []byte{'\r'})
+			}
+			return record, nil
+		}
+	}
+}
+
+// a newline only ends a record outside of quoted fields. As in encoding/csv a quote only opens a quoted
+// field at the start of a field, other quotes are part of the field, and "" inside a quoted field is an
+// escaped quote. A record that does not parse, a quoted field closed by a quote followed by anything but
+// a comma or a line break or one left open at the end of the input, ends at the line break after the
+// error, so that the rows after it are still split. Such records are left to the tasks to report.
+type csvRecordReader struct {
+	reader  *bufio.Reader
+	pending [][]byte // lines read ahead of a record left open at the end of the input
+}
+
+func (this *csvRecordReader) Next() ([]byte, error) {
+	lines := make([][]byte, 0)
+	inQuotes := false
+	fieldStart := true
+	for {
+		line, err := this.nextLine()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+		atEOF := err == io.EOF
+
+		broken := false
+		for idx := 0; idx < len(line) && !broken; idx++ {
+			c := line[idx]
+			if !inQuotes {
+				if c == '"' && fieldStart {
+					inQuotes = true
+				}
+				fieldStart = c == ','
+				continue
+			}
+			if c != '"' {
+				continue
+			}
+			switch {
+			case idx+1 < len(line) && line[idx+1] == '"':
+				idx++
+			case idx+1 == len(line) || line[idx+1] == ',' || line[idx+1] == '\n' || line[idx+1] == '\r':
+				inQuotes = false
+			default:
+				broken = true
+			}
+		}
+		if broken {
+			inQuotes = false
+		}
+
+		if atEOF {
+			if len(lines) == 0 {
+				return nil, io.EOF
+			}
+			if inQuotes && len(lines) > 1 {
+				// unterminated quoted field, the record ends with its first line
+				this.pending = append(lines[1:], this.pending...)
+				return trimLineBreak(lines[0]), nil
+			}
+			return trimLineBreak(bytes.Join(lines, nil)), nil
+		}
+		if !inQuotes {
+			return trimLineBreak(bytes.Join(lines, nil)), nil
+		}
+	}
+}
+
+// next line including its line break, io.EOF with the last line if it has none
+func (this *csvRecordReader) nextLine() ([]byte, error) {
+	if len(this.pending) > 0 {
+		line := this.pending[0]
+		this.pending = this.pending[1:]
+		if len(this.pending) == 0 && !bytes.HasSuffix(line, []byte{'\n'}) {
+			return line, io.EOF
+		}
+		return line, nil
+	}
+	return this.reader.ReadBytes('\n')
+}
+
+func trimLineBreak(record []byte) []byte {
+	record = bytes.TrimSuffix(record, []byte{'\n'})
+	return bytes.TrimSuffix(record, []byte{'\r'})
+}
+
+type jsonLinesRecordReader struct {
+	reader  *bufio.Reader
+	lineNum int
+}
+
+func (this *jsonLinesRecordReader) Next() ([]byte, error) {
+	lineReader := delimitedRecordReader{reader: this.reader, delimiter: []byte{'\n'}, trimCR: true}
+	for {
+		line, err := lineReader.Next()
+		if err != nil {
+			return nil, err
+		}
+		this.lineNum += 1
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return nil, errors.New(fmt.Sprintf("JSON Lines line %d: invalid JSON value", this.lineNum))
+		}
+		return line, nil
+	}
+}
+
+type fixedLengthRecordReader struct {
+	reader *bufio.Reader
+	length int
+}
+
+func (this *fixedLengthRecordReader) Next() ([]byte, error) {
+	record := make([]byte, this.length)
+	n, err := io.ReadFull(this.reader, record)
+	if err == io.ErrUnexpectedEOF {
+		return nil, errors.New(fmt.Sprintf("Trailing partial record of %d bytes, expecting %d", n, this.length))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
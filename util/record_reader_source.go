@@ -0,0 +1,49 @@
+package util
+
+import (
+	"bytes"
+	_ "embed"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+//go:embed record_reader.go
+var recordReaderSource []byte
+
+// prefix of the record reader declarations compiled into maple executables, so that they do not clash with
+// declarations of the executable
+const RECORD_READER_SOURCE_PREFIX string = "mj"
+
+// source of the record readers as part of package main, compiled together with maple executables
+// so they can call mjNewRecordReader with the format from the MJ_RECORD_FORMAT environment variable.
+// Every top-level name is prefixed, e.g. RecordReader becomes mjRecordReader
+func RecordReaderSource() ([]byte, error) {
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "record_reader.go", recordReaderSource, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	file.Name.Name = "main"
+	ast.Inspect(file, func(node ast.Node) bool {
+		ident, ok := node.(*ast.Ident)
+		if ok && ident.Obj != nil && file.Scope.Objects[ident.Name] == ident.Obj {
+			ident.Name = recordReaderSourceName(ident.Name)
+		}
+		return true
+	})
+
+	var source bytes.Buffer
+	err = format.Node(&source, fileSet, file)
+	if err != nil {
+		return nil, err
+	}
+	return source.Bytes(), nil
+}
+
+func recordReaderSourceName(name string) string {
+	return RECORD_READER_SOURCE_PREFIX + strings.ToUpper(name[:1]) + name[1:]
+}
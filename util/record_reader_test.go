@@ -0,0 +1,110 @@
+package util
+
+import (
+	"go/parser"
+	"go/token"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// records of the input, read once at once and once a byte at a time so that delimiters are split across reads
+func readRecords(t *testing.T, input string, spec string) [][]string {
+	format, err := ParseRecordFormat(spec)
+	if err != nil {
+		t.Fatalf("ParseRecordFormat(%q) failed: %s", spec, err)
+	}
+	results := make([][]string, 0)
+	for _, source := range []io.Reader{strings.NewReader(input), iotest.OneByteReader(strings.NewReader(input))} {
+		reader := NewRecordReader(source, format)
+		records := make([]string, 0)
+		for {
+			record, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("reading %q as %s failed: %s", input, spec, err)
+			}
+			records = append(records, string(record))
+		}
+		results = append(results, records)
+	}
+	return results
+}
+
+func TestCsvRecordReader(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"a,b\r\nc,d\n", []string{"a,b", "c,d"}},
+		{"a,\"b\nc\",d\ne,f", []string{"a,\"b\nc\",d", "e,f"}},
+		{"a,\"say \"\"hi\"\"\nnow\",b\n", []string{"a,\"say \"\"hi\"\"\nnow\",b"}},
+		{"a,\"\"\"\"\nb\n", []string{"a,\"\"\"\"", "b"}},
+		{"a,b\"c\nd\n", []string{"a,b\"c", "d"}},
+		{"\"a\"b,c\nd\n", []string{"\"a\"b,c", "d"}},
+		// unterminated quoted fields end with their first line, the lines after are replayed
+		{"a,\"b\nc,d\ne,f", []string{"a,\"b", "c,d", "e,f"}},
+		{"a,\"b\nc,d\ne,f\n", []string{"a,\"b", "c,d", "e,f"}},
+		{"x\na,\"b\nc,d\n", []string{"x", "a,\"b", "c,d"}},
+		// a quote closing the open field followed by other text ends the record at that line
+		{"a,\"b\nc,\"d\ne", []string{"a,\"b\nc,\"d", "e"}},
+		{"a,\"b", []string{"a,\"b"}},
+		{"", []string{}},
+	}
+	for _, test := range tests {
+		for _, records := range readRecords(t, test.input, RECORD_FORMAT_CSV) {
+			if !reflect.DeepEqual(records, test.want) {
+				t.Errorf("csv records of %q are %q, want %q", test.input, records, test.want)
+			}
+		}
+	}
+}
+
+func TestDelimitedRecordReader(t *testing.T) {
+	tests := []struct {
+		input string
+		spec  string
+		want  []string
+	}{
+		{"a\r\nb\nc", RECORD_FORMAT_LINES, []string{"a", "b", "c"}},
+		{"a\n\nb\n", RECORD_FORMAT_LINES, []string{"a", "", "b"}},
+		{"a||b||", "delim:||", []string{"a", "b"}},
+		{"a|b||c|", "delim:||", []string{"a|b", "c|"}},
+		{"x;y;;end;;;", "delim:;;", []string{"x;y", "end", ";"}},
+		{"one<->two<-three<->", "delim:<->", []string{"one", "two<-three"}},
+		{"r1\x1er2", `delim:\x1e`, []string{"r1", "r2"}},
+	}
+	for _, test := range tests {
+		for _, records := range readRecords(t, test.input, test.spec) {
+			if !reflect.DeepEqual(records, test.want) {
+				t.Errorf("%s records of %q are %q, want %q", test.spec, test.input, records, test.want)
+			}
+		}
+	}
+}
+
+func TestRecordReaderSource(t *testing.T) {
+	source, err := RecordReaderSource()
+	if err != nil {
+		t.Fatalf("RecordReaderSource failed: %s", err)
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "", source, 0)
+	if err != nil {
+		t.Fatalf("record reader source does not parse: %s", err)
+	}
+	if file.Name.Name != "main" {
+		t.Errorf("record reader source is package %s, want main", file.Name.Name)
+	}
+	for name := range file.Scope.Objects {
+		if !strings.HasPrefix(name, RECORD_READER_SOURCE_PREFIX) {
+			t.Errorf("record reader source declares %s without the %s prefix", name, RECORD_READER_SOURCE_PREFIX)
+		}
+	}
+	if _, exists := file.Scope.Objects["mjNewRecordReader"]; !exists {
+		t.Errorf("record reader source does not declare mjNewRecordReader")
+	}
+}
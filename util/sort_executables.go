@@ -0,0 +1,46 @@
+package util
+
+import (
+	"maple-juice/config"
+	"encoding/json"
+	"log"
+	"strconv"
+)
+
+const (
+	SORT_MAPLE_TEMPLATE string = "sort_maple_template.go"
+	SORT_JUICE_TEMPLATE string = "sort_juice_template.go"
+)
+
+type SortTemplateData struct {
+	SpecJson string // JSON of SortTaskSpec escaped for a Go string literal
+}
+
+// generate a sort maple or juice executable from its template, the spec is embedded in the source
+func GenerateSortExecutable(templateName string, spec *SortTaskSpec, outputFilePath string) error {
+	templateContent, readErr := readTemplateFile(config.TemplateFileDir + templateName)
+	if readErr != nil {
+		log.Println("Error reading template file", readErr)
+		return readErr
+	}
+
+	specJson, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	quoted := strconv.Quote(string(specJson))
+	templateData := SortTemplateData{SpecJson: quoted[1 : len(quoted)-1]}
+
+	sourceCode, generateErr := generateSourceCode(templateContent, templateData)
+	if generateErr != nil {
+		log.Println("Error generating sort executable")
+		return generateErr
+	}
+
+	writeErr := writeToFile(outputFilePath, sourceCode)
+	if writeErr != nil {
+		log.Println("Error writing to output file:")
+		return writeErr
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package util
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sort keys of total-order sort jobs, the generated sort executables carry a copy of the comparator
+
+const (
+	SORT_TYPE_STRING  string = "string"
+	SORT_TYPE_NUMERIC string = "numeric"
+
+	SORT_MAX_OUTPUT_NUM int = 10000
+
+	// delimiter of RFC 4180 records, quoted fields may hold commas and line breaks
+	SORT_DELIMITER_CSV string = "csv"
+)
+
+// sort key of a sort job, records are ordered by the first key, ties by the next one and so on
+type SortKey struct {
+	Column     string // column name (requires a header) or 0-based column index, the whole line if empty
+	SortType   string // SORT_TYPE_STRING or SORT_TYPE_NUMERIC
+	Descending bool
+}
+
+// sort key resolved against the input header
+type SortKeySpec struct {
+	ColumnIndex int // -1 sorts by the whole line
+	Numeric     bool
+	Descending  bool
+}
+
+// settings of the generated sort maple and juice executables, passed to them as JSON
+type SortTaskSpec struct {
+	Keys         []SortKeySpec
+	Delimiter    string
+	HasHeader    bool       // first line of every maple input partition is the header
+	Header       string
+	HeaderOutput string     // juice output that starts with the header line
+	SplitPoints  [][]string // sort keys of the split points, record goes to the first range whose split point is greater
+	Limit        int        // number of records each maple task and the juice keep, 0 keeps all
+}
+
+func ValidateSortType(sortType string) error {
+	if sortType != SORT_TYPE_STRING && sortType != SORT_TYPE_NUMERIC {
+		return errors.New(fmt.Sprintf("Unsupported sort type (%s), expecting %s or %s", sortType, SORT_TYPE_STRING, SORT_TYPE_NUMERIC))
+	}
+	return nil
+}
+
+// fields of a record split by the delimiter, a CSV record that cannot be parsed is split at every comma
+func SortFields(line string, delimiter string) []string {
+	if delimiter != SORT_DELIMITER_CSV {
+		return strings.Split(line, delimiter)
+	}
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.FieldsPerRecord = -1
+	fields, err := reader.Read()
+	if err == io.EOF {
+		return []string{""}
+	}
+	if err != nil {
+		return strings.Split(line, ",")
+	}
+	return fields
+}
+
+// sort keys of a line, one per key spec, missing fields are empty
+func SortKeysOf(line string, delimiter string, keys []SortKeySpec) []string {
+	fields := SortFields(line, delimiter)
+	values := make([]string, len(keys))
+	for idx, key := range keys {
+		switch {
+		case key.ColumnIndex < 0:
+			values[idx] = line
+		case key.ColumnIndex < len(fields):
+			values[idx] = fields[key.ColumnIndex]
+		}
+	}
+	return values
+}
+
+// records of a sort input are lines, or CSV records with delimiter csv
+func SortRecordFormat(delimiter string) RecordFormat {
+	if delimiter == SORT_DELIMITER_CSV {
+		return RecordFormat{Kind: RECORD_FORMAT_CSV}
+	}
+	return RecordFormat{Kind: RECORD_FORMAT_LINES}
+}
+
+// compare the sort keys of two records key by key
+func CompareSortRecords(a []string, b []string, keys []SortKeySpec) int {
+	for idx, key := range keys {
+		result := CompareSortKeys(a[idx], b[idx], key.Numeric, key.Descending)
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// numeric keys that are not numbers order after all numbers in either order, compared as strings
+func CompareSortKeys(a string, b string, numeric bool, descending bool) int {
+	result := 0
+	if numeric {
+		x, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		y, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		switch {
+		case errA == nil && errB == nil:
+			if x < y {
+				result = -1
+			} else if x > y {
+				result = 1
+			}
+		case errA == nil:
+			// after all numbers in either order
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			result = strings.Compare(a, b)
+		}
+	} else {
+		result = strings.Compare(a, b)
+	}
+
+	if descending {
+		return -result
+	}
+	return result
+}
+
+// resolve a sort column given by name or 0-based index, empty column sorts by the whole line
+func ResolveSortColumn(column string, header string, delimiter string) (int, error) {
+	if len(column) == 0 {
+		return -1, nil
+	}
+	if index, err := strconv.Atoi(column); err == nil {
+		if index < 0 {
+			return 0, errors.New(fmt.Sprintf("Invalid sort column index %d", index))
+		}
+		return index, nil
+	}
+	if len(header) == 0 {
+		return 0, errors.New(fmt.Sprintf("Sort column (%s) given by name requires an input header", column))
+	}
+	for idx, field := range SortFields(header, delimiter) {
+		if strings.TrimSpace(field) == column {
+			return idx, nil
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("Sort column (%s) not found in input header (%s)", column, header))
+}
+
+// resolve the sort keys of a job against the input header
+func ResolveSortKeys(keys []SortKey, header string, delimiter string) ([]SortKeySpec, error) {
+	specs := make([]SortKeySpec, 0, len(keys))
+	for _, key := range keys {
+		err := ValidateSortType(key.SortType)
+		if err != nil {
+			return nil, err
+		}
+		columnIndex, err := ResolveSortColumn(key.Column, header, delimiter)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, SortKeySpec{ColumnIndex: columnIndex, Numeric: key.SortType == SORT_TYPE_NUMERIC, Descending: key.Descending})
+	}
+	return specs, nil
+}
+
+// reservoir sample of the sort keys of an input file, the header line is returned separately
+func SampleSortKeys(inputFilePath string, spec *SortTaskSpec, keys []SortKey, sampleSize int) (string, [][]string, error) {
+	file, err := os.Open(inputFilePath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	records := NewRecordReader(file, SortRecordFormat(spec.Delimiter))
+	header := ""
+	if spec.HasHeader {
+		record, err := records.Next()
+		if err == io.EOF {
+			return "", nil, errors.New("Empty sort input file")
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		header = string(record)
+	}
+	spec.Keys, err = ResolveSortKeys(keys, header, spec.Delimiter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	sample := make([][]string, 0, sampleSize)
+	seen := 0
+	for {
+		record, err := records.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		key := SortKeysOf(string(record), spec.Delimiter, spec.Keys)
+		seen++
+		if len(sample) < sampleSize {
+			sample = append(sample, key)
+		} else if idx := random.Intn(seen); idx < sampleSize {
+			sample[idx] = key
+		}
+	}
+	return header, sample, nil
+}
+
+// up to rangeNum-1 distinct split points at the quantiles of the sampled keys
+func ChooseSplitPoints(sample [][]string, rangeNum int, keys []SortKeySpec) [][]string {
+	sort.Slice(sample, func(i, j int) bool {
+		return CompareSortRecords(sample[i], sample[j], keys) < 0
+	})
+
+	splitPoints := make([][]string, 0)
+	if len(sample) == 0 {
+		return splitPoints
+	}
+	for idx := 1; idx < rangeNum; idx++ {
+		point := sample[idx*len(sample)/rangeNum]
+		if len(splitPoints) > 0 && CompareSortRecords(splitPoints[len(splitPoints)-1], point, keys) >= 0 {
+			continue
+		}
+		splitPoints = append(splitPoints, point)
+	}
+	return splitPoints
+}
+
+// intermediate key of the n-th range, zero padded so that key order is range order
+func FmtSortRangeKey(rangeNumber int) string {
+	return fmt.Sprintf("part%05d", rangeNumber)
+}
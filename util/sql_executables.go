@@ -1,7 +1,9 @@
 package util
 
 import (
+	"encoding/json"
 	"os"
+	"strconv"
 	"text/template"
 	"strings"
 	"log"
@@ -9,12 +11,67 @@ import (
 )
 
 type FilterMapleTemplateData struct {
-	FilterColumn string
-	Regex string
+	PredicateJson string
+	ProjectionJson string
+	GroupJson string
+	TopNJson string
+	MalformedPolicy string
+	ErrorFilePrefix string
 }
 
 type JoinMapleTemplateData struct {
-	JoinColumn string
+	JoinColumnsJson string
+	Side int
+	ProjectionJson string
+	MalformedPolicy string
+	ErrorFilePrefix string
+}
+
+type JoinJuiceTemplateData struct {
+	JoinType string
+	ColumnCountsJson string
+	ProjectionJson string
+}
+
+type BroadcastJoinTemplateData struct {
+	JoinType string
+	SmallSide int
+	SmallFileName string
+	JoinColumnsJson string
+	ProjectionsJson string
+	ColumnCountsJson string
+	ProjectionJson string
+	TopNJson string
+	MalformedPolicy string
+	ErrorFilePrefix string
+}
+
+// input rows that are not valid CSV or whose number of fields differs from the header are malformed
+const (
+	SQL_MALFORMED_SKIP  string = "skip"  // dropped
+	SQL_MALFORMED_FAIL  string = "fail"  // fail the maple task
+	SQL_MALFORMED_ERROR string = "error" // written to side outputs collected into an error file
+)
+
+// what the maple executables of a query do with malformed rows of their input, with SQL_MALFORMED_ERROR
+// each task writes them to the side output <ErrorFilePrefix>-<partition>
+type MalformedRowPolicy struct {
+	Action          string
+	ErrorFilePrefix string
+}
+
+// rows of a side without a match are kept by outer joins, with NULL (empty) columns for the other side
+const (
+	JOIN_INNER string = "inner"
+	JOIN_LEFT  string = "left"
+	JOIN_RIGHT string = "right"
+	JOIN_FULL  string = "full"
+)
+
+// column of a join result, taken from the line emitted by the maple task of dataset Side (0 or 1)
+type JoinOutputColumn struct {
+	Side int
+	Index int
 }
 
 type DemoMapleOneData struct {
@@ -44,6 +101,24 @@ func generateSourceCode(templateContent string, data interface{}) (string, error
 	return generatedCode.String(), nil
 }
 
+// JSON of a projection, predicate or spec embedded in a template, empty if not set
+func templateJson(value interface{}, isSet bool) (string, error) {
+	if !isSet {
+		return "", nil
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return goStringContent(string(content)), nil
+}
+
+// escape a value placed between the double quotes of a Go string literal in a template
+func goStringContent(value string) string {
+	quoted := strconv.Quote(value)
+	return quoted[1 : len(quoted)-1]
+}
+
 func writeToFile(filename, content string) error {
 	file, err := os.Create(filename)
 	if err != nil {
@@ -55,7 +130,10 @@ func writeToFile(filename, content string) error {
 	return err
 }
 
-func GenerateFilterMapleExecutables(filterColumn string, regex string, executableName string) error{
+// predicate is the compiled WHERE clause, nil keeps every line. projection lists the output columns by name,
+// nil for whole lines. With group set, the maple aggregates the kept lines per group instead of writing them,
+// otherwise with topN set it only writes the first lines of its input split
+func GenerateFilterMapleExecutables(predicate *FilterPredicate, projection []string, group *GroupBySpec, topN *TopNSpec, malformed *MalformedRowPolicy, executableName string) error{
 
 	// Read template content from template.go
 	templateContent, readErr := readTemplateFile(config.TemplateFileDir + "filter_maple_template.go")
@@ -64,7 +142,30 @@ func GenerateFilterMapleExecutables(filterColumn string, regex string, executabl
 		return readErr
 	}
 
-	templateData := FilterMapleTemplateData{FilterColumn: filterColumn, Regex: regex}
+	predicateValue, err := templateJson(predicate, predicate != nil)
+	if err != nil {
+		return err
+	}
+	projectionValue, err := templateJson(projection, projection != nil)
+	if err != nil {
+		return err
+	}
+	groupValue, err := templateJson(group, group != nil)
+	if err != nil {
+		return err
+	}
+	topNValue, err := templateJson(topN, topN != nil)
+	if err != nil {
+		return err
+	}
+	templateData := FilterMapleTemplateData{
+		PredicateJson: predicateValue,
+		ProjectionJson: projectionValue,
+		GroupJson: groupValue,
+		TopNJson: topNValue,
+		MalformedPolicy: goStringContent(malformed.Action),
+		ErrorFilePrefix: goStringContent(malformed.ErrorFilePrefix),
+	}
 	sourceCode, generateErr := generateSourceCode(templateContent, templateData)
 	if generateErr != nil {
 		log.Println("Error generating filter maple executable")
@@ -80,7 +181,9 @@ func GenerateFilterMapleExecutables(filterColumn string, regex string, executabl
 	return nil
 }
 
-func GenerateJoinMapleExecutables(joinColumn string, executableName string) error{
+// the join key of a line combines its joinColumns. projection lists the columns of the dataset needed
+// in the result, nil for whole lines
+func GenerateJoinMapleExecutables(joinColumns []string, side int, projection []string, malformed *MalformedRowPolicy, executableName string) error{
 
 	// Read template content from template.go
 	templateContent, readErr := readTemplateFile(config.TemplateFileDir + "join_maple_template.go")
@@ -89,7 +192,21 @@ func GenerateJoinMapleExecutables(joinColumn string, executableName string) erro
 		return readErr
 	}
 
-	templateData := JoinMapleTemplateData{JoinColumn: joinColumn}
+	projectionValue, err := templateJson(projection, projection != nil)
+	if err != nil {
+		return err
+	}
+	joinColumnsValue, err := templateJson(joinColumns, true)
+	if err != nil {
+		return err
+	}
+	templateData := JoinMapleTemplateData{
+		JoinColumnsJson: joinColumnsValue,
+		Side: side,
+		ProjectionJson: projectionValue,
+		MalformedPolicy: goStringContent(malformed.Action),
+		ErrorFilePrefix: goStringContent(malformed.ErrorFilePrefix),
+	}
 	sourceCode, generateErr := generateSourceCode(templateContent, templateData)
 	if generateErr != nil {
 		log.Println("Error generating filter maple executable")
@@ -106,6 +223,97 @@ func GenerateJoinMapleExecutables(joinColumn string, executableName string) erro
 
 }
 
+// projection lists the output columns, nil to concatenate whole lines of both datasets, which have
+// columnCounts columns
+func GenerateJoinJuiceExecutable(joinType string, columnCounts [2]int, projection []JoinOutputColumn, executableName string) error{
+
+	templateContent, readErr := readTemplateFile(config.TemplateFileDir + "join_juice_template.go")
+	if readErr != nil {
+		log.Println("Error reading template file", readErr)
+		return readErr
+	}
+
+	projectionValue, err := templateJson(projection, projection != nil)
+	if err != nil {
+		return err
+	}
+	columnCountsValue, err := templateJson(columnCounts, true)
+	if err != nil {
+		return err
+	}
+	templateData := JoinJuiceTemplateData{JoinType: joinType, ColumnCountsJson: columnCountsValue, ProjectionJson: projectionValue}
+	sourceCode, generateErr := generateSourceCode(templateContent, templateData)
+	if generateErr != nil {
+		log.Println("Error generating join juice executable")
+		return generateErr
+	}
+
+	writeErr := writeToFile(config.LocalFileDir + executableName, sourceCode)
+	if writeErr != nil {
+		log.Println("Error writing to output file:")
+		return writeErr
+	}
+
+	return nil
+}
+
+// map-only join: the maple joins its split of the large input with the SDFS file smallFileName, shipped
+// to every task through the job cache, which is the input at position smallSide of the join. joinColumns,
+// projections and columnCounts describe both inputs as for the join maple and juice executables
+func GenerateBroadcastJoinExecutable(joinType string, smallSide int, smallFileName string, joinColumns [2][]string, projections [2][]string, columnCounts [2]int, projection []JoinOutputColumn, topN *TopNSpec, malformed *MalformedRowPolicy, executableName string) error{
+
+	templateContent, readErr := readTemplateFile(config.TemplateFileDir + "broadcast_join_template.go")
+	if readErr != nil {
+		log.Println("Error reading template file", readErr)
+		return readErr
+	}
+
+	joinColumnsValue, err := templateJson(joinColumns, true)
+	if err != nil {
+		return err
+	}
+	projectionsValue, err := templateJson(projections, projections[0] != nil)
+	if err != nil {
+		return err
+	}
+	columnCountsValue, err := templateJson(columnCounts, true)
+	if err != nil {
+		return err
+	}
+	projectionValue, err := templateJson(projection, projection != nil)
+	if err != nil {
+		return err
+	}
+	topNValue, err := templateJson(topN, topN != nil)
+	if err != nil {
+		return err
+	}
+	templateData := BroadcastJoinTemplateData{
+		JoinType: joinType,
+		SmallSide: smallSide,
+		SmallFileName: goStringContent(smallFileName),
+		JoinColumnsJson: joinColumnsValue,
+		ProjectionsJson: projectionsValue,
+		ColumnCountsJson: columnCountsValue,
+		ProjectionJson: projectionValue,
+		TopNJson: topNValue,
+		MalformedPolicy: goStringContent(malformed.Action),
+		ErrorFilePrefix: goStringContent(malformed.ErrorFilePrefix),
+	}
+	sourceCode, generateErr := generateSourceCode(templateContent, templateData)
+	if generateErr != nil {
+		log.Println("Error generating broadcast join executable")
+		return generateErr
+	}
+
+	writeErr := writeToFile(config.LocalFileDir + executableName, sourceCode)
+	if writeErr != nil {
+		log.Println("Error writing to output file:")
+		return writeErr
+	}
+
+	return nil
+}
 
 func GenerateDemoMapleOneExecutable(interConValue string, executableName string) error{
 
@@ -0,0 +1,38 @@
+package util
+
+// WHERE clause of a filter query compiled for the filter maple, which keeps the lines the predicate is true for.
+// Predicates follow SQL three-valued logic: empty fields are NULL, and a comparison with NULL or of a
+// number with a field that is not numeric is unknown, which filters the line out.
+
+const (
+	FILTER_OP_AND     string = "and"
+	FILTER_OP_OR      string = "or"
+	FILTER_OP_NOT     string = "not"
+	FILTER_OP_COMPARE string = "compare" // Operands[0] Cmp Operands[1]
+	FILTER_OP_IN      string = "in"      // Operands[0] equal to one of Operands[1:]
+	FILTER_OP_BETWEEN string = "between" // Operands[1] <= Operands[0] <= Operands[2]
+	FILTER_OP_IS_NULL string = "is_null" // Operands[0] is NULL
+	FILTER_OP_REGEXP  string = "regexp"  // Regex matches Operands[0], LIKE patterns are compiled to regexes
+)
+
+const (
+	FILTER_OPERAND_COLUMN string = "column" // field of the line under the column named Value
+	FILTER_OPERAND_LINE   string = "line"   // whole line
+	FILTER_OPERAND_STRING string = "string"
+	FILTER_OPERAND_NUMBER string = "number"
+	FILTER_OPERAND_NULL   string = "null"
+)
+
+type FilterPredicate struct {
+	Op       string
+	Cmp      string // =, <>, <, <=, > or >= for compare
+	Regex    string
+	Args     []*FilterPredicate // and, or, not
+	Operands []FilterOperand
+}
+
+type FilterOperand struct {
+	Kind  string
+	Value string // column name or literal, numbers as written in the query
+}
+
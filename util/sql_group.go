@@ -0,0 +1,81 @@
+package util
+
+import (
+	"maple-juice/config"
+	"log"
+)
+
+// GROUP BY queries run as a maple job aggregating the lines of each input split per group, followed by a juice
+// job merging the partial aggregates of each group and applying HAVING. Groups are hashed into Buckets
+// intermediate keys since group values may contain characters not allowed in file names.
+
+const (
+	GROUP_JUICE_TEMPLATE string = "group_juice_template.go"
+)
+
+const (
+	AGGREGATE_COUNT string = "COUNT"
+	AGGREGATE_SUM   string = "SUM"
+	AGGREGATE_AVG   string = "AVG"
+	AGGREGATE_MIN   string = "MIN"
+	AGGREGATE_MAX   string = "MAX"
+)
+
+type GroupBySpec struct {
+	Columns    []string // GROUP BY columns, empty for a single group of all lines
+	Aggregates []AggregateSpec
+	Buckets    int
+	// values of a group are numbered: first the GROUP BY columns, then the aggregates
+	Outputs []int            // values written to the result, in order
+	Having  *FilterPredicate // operands of kind column refer to values by number, nil keeps every group
+}
+
+type AggregateSpec struct {
+	Func     string
+	Column   string // empty for COUNT(*)
+	Distinct bool
+}
+
+type GroupJuiceTemplateData struct {
+	SpecJson string
+	TopNJson string
+}
+
+func IsAggregateFunc(name string) bool {
+	switch name {
+	case AGGREGATE_COUNT, AGGREGATE_SUM, AGGREGATE_AVG, AGGREGATE_MIN, AGGREGATE_MAX:
+		return true
+	}
+	return false
+}
+
+// generate the juice executable of a GROUP BY query, the spec is embedded in the source.
+// With topN set only the first groups of each key are written
+func GenerateGroupJuiceExecutable(spec *GroupBySpec, topN *TopNSpec, executableName string) error {
+	templateContent, readErr := readTemplateFile(config.TemplateFileDir + GROUP_JUICE_TEMPLATE)
+	if readErr != nil {
+		log.Println("Error reading template file", readErr)
+		return readErr
+	}
+
+	specJson, err := templateJson(spec, true)
+	if err != nil {
+		return err
+	}
+	topNJson, err := templateJson(topN, topN != nil)
+	if err != nil {
+		return err
+	}
+	sourceCode, generateErr := generateSourceCode(templateContent, GroupJuiceTemplateData{SpecJson: specJson, TopNJson: topNJson})
+	if generateErr != nil {
+		log.Println("Error generating group juice executable")
+		return generateErr
+	}
+
+	writeErr := writeToFile(config.LocalFileDir+executableName, sourceCode)
+	if writeErr != nil {
+		log.Println("Error writing to output file:")
+		return writeErr
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package util
+
+// ORDER BY and LIMIT of SQL queries run as a sort job over the query result. With a LIMIT, the last task
+// producing result rows (filter maple, join juice or group juice) only writes its first rows under the
+// sort order, so the sort job only sees a few rows per task.
+
+// first rows of the output of a task, keys index into the output columns
+type TopNSpec struct {
+	Keys  []SortKeySpec // empty keeps the first rows in output order
+	Limit int
+}
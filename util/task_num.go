@@ -0,0 +1,66 @@
+package util
+
+import (
+	"maple-juice/config"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// task counts of maple and juice jobs are either given by the user or picked by the job manager
+// with the auto mode:
+//   maple: one task per split of the input file, splits are about MAPLE_SPLIT_SIZE_MB large
+//   juice: one task per JUICE_TASK_INPUT_SIZE_MB of intermediate data, bounded by the free task
+//          slots of alive workers (WORKER_TASK_SLOTS per worker)
+// both counts are still lowered when the input has fewer records or keys than tasks
+
+const (
+	TASK_NUM_AUTO     int    = 0 // config.MapleTaskNum and config.JuiceTaskNum use the same value for auto
+	TASK_NUM_AUTO_ARG string = "auto"
+)
+
+// parse a positive task count or "auto"
+func ParseTaskNum(value string) (int, error) {
+	if value == TASK_NUM_AUTO_ARG {
+		return TASK_NUM_AUTO, nil
+	}
+	num, err := strconv.Atoi(value)
+	if err != nil || num <= 0 {
+		return 0, errors.New(fmt.Sprintf("Invalid task number (%s), expecting a positive number or %s", value, TASK_NUM_AUTO_ARG))
+	}
+	return num, nil
+}
+
+func FmtTaskNum(taskNum int) string {
+	if taskNum == TASK_NUM_AUTO {
+		return TASK_NUM_AUTO_ARG
+	}
+	return strconv.Itoa(taskNum)
+}
+
+// number of maple tasks splitting the input into splits of at most splitSizeMB,
+// config default is used if splitSizeMB is not positive
+func AutoMapleTaskNum(inputBytes int64, splitSizeMB int) int {
+	if splitSizeMB <= 0 {
+		splitSizeMB = config.MapleSplitSizeMB
+	}
+	return ceilDiv(inputBytes, int64(splitSizeMB)*1024*1024)
+}
+
+// number of juice tasks each reading about JUICE_TASK_INPUT_SIZE_MB of intermediate data,
+// at most freeSlots tasks are started so that a job doesn't queue up behind itself
+func AutoJuiceTaskNum(intermediateBytes int64, freeSlots int) int {
+	taskNum := ceilDiv(intermediateBytes, int64(config.JuiceTaskInputSizeMB)*1024*1024)
+	if freeSlots > 0 && taskNum > freeSlots {
+		taskNum = freeSlots
+	}
+	return taskNum
+}
+
+// at least 1
+func ceilDiv(total int64, size int64) int {
+	if size <= 0 || total <= 0 {
+		return 1
+	}
+	return int((total + size - 1) / size)
+}
@@ -129,11 +129,16 @@ func NodeIdToIP(nodeId string) string {
 
 
 func Dial(hostname string, port int) *rpc.Client {
+	return DialPath(hostname, port, rpc.DefaultRPCPath)
+}
+
+// dial an rpc server served on another HTTP path than the default one
+func DialPath(hostname string, port int, path string) *rpc.Client {
 	var err error
 	var c *rpc.Client
 	clientChan := make(chan *rpc.Client, 1)
 	go func() {
-		c, err = rpc.DialHTTP("tcp", fmt.Sprintf("%s:%d", hostname, port))
+		c, err = rpc.DialHTTPPath("tcp", fmt.Sprintf("%s:%d", hostname, port), path)
 		clientChan <- c
 	}()
 